@@ -108,6 +108,12 @@ func run(ctx context.Context, startTime time.Time) error {
 		return nil
 	})
 
+	// Background task: reconcile channels and settings from GITOPS_CONFIG_PATH
+	g.Go(func() error {
+		service.RunGitOpsWithContext(ctx)
+		return nil
+	})
+
 	// Background task: update quota dashboard data
 	g.Go(func() error {
 		model.UpdateQuotaDataWithContext(ctx)
@@ -131,6 +137,12 @@ func run(ctx context.Context, startTime time.Time) error {
 		return nil
 	})
 
+	// Background task: compare channel models with the upstream model lists
+	g.Go(func() error {
+		controller.AutomaticallyDiscoverChannelModelsWithContext(ctx)
+		return nil
+	})
+
 	if common.IsMasterNode && constant.UpdateTask {
 		g.Go(func() error {
 			controller.UpdateMidjourneyTaskBulkWithContext(ctx)
@@ -142,6 +154,24 @@ func run(ctx context.Context, startTime time.Time) error {
 		})
 	}
 
+	// Background task: run /v1/batches jobs, on every node under per-batch leases
+	g.Go(func() error {
+		controller.RunBatchJobsWithContext(ctx)
+		return nil
+	})
+	if common.IsMasterNode {
+		// Background task: remove expired /v1/responses objects
+		g.Go(func() error {
+			service.CleanupStoredResponsesWithContext(ctx)
+			return nil
+		})
+		// Background task: roll channel metrics up into hourly rows
+		g.Go(func() error {
+			model.RollUpChannelMetricsWithContext(ctx)
+			return nil
+		})
+	}
+
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
 		common.SysLog("batch update enabled with interval " + strconv.Itoa(common.BatchUpdateInterval) + "s")
@@ -320,6 +350,22 @@ func InitResources() error {
 		return err
 	}
 
+	if *common.RotateSecretKey {
+		rotated, err := model.RotateSecrets()
+		if err != nil {
+			common.FatalLog("failed to rotate secrets: " + err.Error())
+		}
+		common.SysLog("re-encrypted " + strconv.Itoa(rotated) + " secrets with the current master key")
+		os.Exit(0)
+	}
+
+	if err := model.RefreshChannelKeyLookups(); err != nil {
+		common.SysError("failed to refresh channel key lookups: " + err.Error())
+	}
+	if err := model.RefreshChannelRolloutModes(); err != nil {
+		common.SysError("failed to refresh channel rollout modes: " + err.Error())
+	}
+
 	model.CheckSetup()
 
 	// Initialize options, should after model.InitDB()
@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"errors"
 	"io"
 	"net/http"
 
@@ -12,6 +13,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// ErrNotImplemented is returned by a chat adaptor when it cannot accept a
+// Claude, Gemini or Responses request natively. The relay then falls back to
+// the canonical IR and sends the request through ConvertOpenAIRequest instead.
+var ErrNotImplemented = errors.New("not implemented")
+
+// IsNotImplemented reports whether an adaptor does not support a request
+// format, either as ErrNotImplemented or as its own "not implemented" error.
+func IsNotImplemented(err error) bool {
+	return errors.Is(err, ErrNotImplemented) || (err != nil && err.Error() == ErrNotImplemented.Error())
+}
+
 type Adaptor interface {
 	// Init IsStream bool
 	Init(info *relaycommon.RelayInfo)
@@ -284,6 +284,10 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	// 对冲请求落败时中止上游请求
+	if attempt := common.GetHedgeAttempt(c); attempt != nil {
+		req = req.WithContext(attempt.UpstreamContext())
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.LogError(c, "do request failed: "+err.Error())
@@ -292,6 +296,7 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	service.RecordUpstreamRateLimit(c, info, resp)
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
@@ -2,6 +2,7 @@ package relay
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
 	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/channel"
 	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
 	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
 	"github.com/QuantumNous/lurus-api/internal/biz/service"
@@ -108,6 +110,9 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 	} else {
 		convertedRequest, err := adaptor.ConvertClaudeRequest(c, info, request)
 		if err != nil {
+			if errors.Is(err, channel.ErrNotImplemented) {
+				return adjustedIRHelper(c, info, request)
+			}
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
 		}
 		jsonData, err := common.Marshal(convertedRequest)
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/copier"
+)
+
+// ErrHedgeLost is the cancel cause of an attempt whose competitor answered first.
+var ErrHedgeLost = errors.New("hedged request lost the race")
+
+// HedgeRecord describes a hedged request for the consume log. It is shared by
+// both attempts.
+type HedgeRecord struct {
+	mu       sync.Mutex
+	DelayMs  int
+	Channels []int
+	Winner   int
+}
+
+func (r *HedgeRecord) AddChannel(channelId int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Channels = append(r.Channels, channelId)
+}
+
+func (r *HedgeRecord) SetWinner(channelId int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Winner = channelId
+}
+
+// Hedged reports whether the second attempt was actually sent.
+func (r *HedgeRecord) Hedged() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Channels) > 1
+}
+
+func (r *HedgeRecord) LogInfo() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return map[string]interface{}{
+		"delay_ms": r.DelayMs,
+		"channels": append([]int(nil), r.Channels...),
+		"winner":   r.Winner,
+	}
+}
+
+// HedgeAttempt is one of the racing copies of a hedged request. Its upstream
+// context is only cancelled when the attempt loses, a client disconnect is
+// handled as for any other request.
+type HedgeAttempt struct {
+	Record *HedgeRecord
+
+	lost           atomic.Bool
+	upstreamCtx    context.Context
+	cancelUpstream context.CancelCauseFunc
+	cancelRequest  context.CancelCauseFunc
+}
+
+// NewHedgeAttempt binds a new attempt to c, which must be a copy of the
+// original request context, and returns it.
+func NewHedgeAttempt(c *gin.Context, record *HedgeRecord) *HedgeAttempt {
+	attempt := &HedgeAttempt{Record: record}
+	attempt.upstreamCtx, attempt.cancelUpstream = context.WithCancelCause(context.Background())
+	requestCtx, cancelRequest := context.WithCancelCause(c.Request.Context())
+	attempt.cancelRequest = cancelRequest
+	request := c.Request.WithContext(requestCtx)
+	request.Header = c.Request.Header.Clone()
+	c.Request = request
+	common.SetContextKey(c, constant.ContextKeyHedgeAttempt, attempt)
+	return attempt
+}
+
+// GetHedgeAttempt returns the attempt c belongs to, nil if c is not hedged.
+func GetHedgeAttempt(c *gin.Context) *HedgeAttempt {
+	attempt, _ := common.GetContextKeyType[*HedgeAttempt](c, constant.ContextKeyHedgeAttempt)
+	return attempt
+}
+
+// IsHedgeLoser reports whether c is a hedged attempt that lost the race. A
+// loser must not be billed.
+func IsHedgeLoser(c *gin.Context) bool {
+	attempt := GetHedgeAttempt(c)
+	return attempt != nil && attempt.Lost()
+}
+
+func (a *HedgeAttempt) Lost() bool {
+	return a.lost.Load()
+}
+
+// Lose marks the attempt as lost and aborts it.
+func (a *HedgeAttempt) Lose() {
+	a.lost.Store(true)
+	a.cancelUpstream(ErrHedgeLost)
+	a.cancelRequest(ErrHedgeLost)
+}
+
+// Release frees the contexts of a finished attempt.
+func (a *HedgeAttempt) Release() {
+	a.cancelUpstream(nil)
+	a.cancelRequest(nil)
+}
+
+// UpstreamContext is the context upstream requests of the attempt are made with.
+func (a *HedgeAttempt) UpstreamContext() context.Context {
+	return a.upstreamCtx
+}
+
+// CloneForHedge copies info for a second attempt of the same request. State
+// the relay mutates while handling a response is copied, so both attempts can
+// run at the same time.
+func (info *RelayInfo) CloneForHedge() *RelayInfo {
+	clone := *info
+	if info.PriceData.OtherRatios != nil {
+		clone.PriceData.OtherRatios = make(map[string]float64, len(info.PriceData.OtherRatios))
+		for k, v := range info.PriceData.OtherRatios {
+			clone.PriceData.OtherRatios[k] = v
+		}
+	}
+	if info.ChannelMeta != nil {
+		channelMeta := *info.ChannelMeta
+		clone.ChannelMeta = &channelMeta
+	}
+	if info.ClaudeConvertInfo != nil {
+		claudeConvertInfo := *info.ClaudeConvertInfo
+		if claudeConvertInfo.Usage != nil {
+			usage := *claudeConvertInfo.Usage
+			claudeConvertInfo.Usage = &usage
+		}
+		clone.ClaudeConvertInfo = &claudeConvertInfo
+	}
+	if info.RerankerInfo != nil {
+		rerankerInfo := *info.RerankerInfo
+		clone.RerankerInfo = &rerankerInfo
+	}
+	if info.ResponsesUsageInfo != nil {
+		builtInTools := make(map[string]*BuildInToolInfo, len(info.ResponsesUsageInfo.BuiltInTools))
+		for name, tool := range info.ResponsesUsageInfo.BuiltInTools {
+			toolInfo := *tool
+			builtInTools[name] = &toolInfo
+		}
+		clone.ResponsesUsageInfo = &ResponsesUsageInfo{BuiltInTools: builtInTools}
+	}
+	if info.Request != nil {
+		if request, err := cloneRequest(info.Request); err == nil {
+			clone.Request = request
+		}
+	}
+	return &clone
+}
+
+func cloneRequest(request dto.Request) (dto.Request, error) {
+	t := reflect.TypeOf(request)
+	if t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("unexpected request type %T", request)
+	}
+	clone := reflect.New(t.Elem()).Interface()
+	if err := copier.CopyWithOption(clone, request, copier.Option{DeepCopy: true}); err != nil {
+		return nil, err
+	}
+	return clone.(dto.Request), nil
+}
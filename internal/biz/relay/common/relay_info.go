@@ -357,6 +357,12 @@ func GenRelayInfoGemini(c *gin.Context, request dto.Request) *RelayInfo {
 	return info
 }
 
+func GenRelayInfoOllama(c *gin.Context, request dto.Request) *RelayInfo {
+	info := genBaseRelayInfo(c, request)
+	info.RelayFormat = types.RelayFormatOllama
+	return info
+}
+
 func GenRelayInfoImage(c *gin.Context, request dto.Request) *RelayInfo {
 	info := genBaseRelayInfo(c, request)
 	info.RelayFormat = types.RelayFormatOpenAIImage
@@ -466,6 +472,8 @@ func GenRelayInfo(c *gin.Context, relayFormat types.RelayFormat, request dto.Req
 		return GenRelayInfoGemini(c, request), nil
 	case types.RelayFormatEmbedding:
 		return GenRelayInfoEmbedding(c, request), nil
+	case types.RelayFormatOllama:
+		return GenRelayInfoOllama(c, request), nil
 	case types.RelayFormatOpenAIResponses:
 		if request, ok := request.(*dto.OpenAIResponsesRequest); ok {
 			return GenRelayInfoResponses(c, request), nil
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"sync"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShadowAttempt is a copy of a request mirrored to a channel in shadow mode.
+// Its response is discarded and it is never billed, the usage the upstream
+// reported is kept for the channel statistics instead.
+type ShadowAttempt struct {
+	mu    sync.Mutex
+	usage *dto.Usage
+}
+
+// NewShadowAttempt marks c, a copy of the original request context, as a
+// shadow request and returns its attempt.
+func NewShadowAttempt(c *gin.Context) *ShadowAttempt {
+	attempt := &ShadowAttempt{}
+	common.SetContextKey(c, constant.ContextKeyShadowAttempt, attempt)
+	return attempt
+}
+
+// GetShadowAttempt returns the attempt c belongs to, nil if c is not a shadow
+// request.
+func GetShadowAttempt(c *gin.Context) *ShadowAttempt {
+	attempt, _ := common.GetContextKeyType[*ShadowAttempt](c, constant.ContextKeyShadowAttempt)
+	return attempt
+}
+
+// Usage returns the usage of the shadow request, nil if the upstream reported
+// none.
+func (a *ShadowAttempt) Usage() *dto.Usage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.usage
+}
+
+// SkipBilling reports whether the usage of c must not be billed: a hedged
+// attempt that lost the race or a shadow request, whose usage is recorded on
+// its attempt.
+func SkipBilling(c *gin.Context, usage *dto.Usage) bool {
+	if attempt := GetShadowAttempt(c); attempt != nil {
+		attempt.mu.Lock()
+		attempt.usage = usage
+		attempt.mu.Unlock()
+		return true
+	}
+	return IsHedgeLoser(c)
+}
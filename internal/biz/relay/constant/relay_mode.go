@@ -50,11 +50,15 @@ const (
 	RelayModeRealtime
 
 	RelayModeGemini
+
+	RelayModeCountTokens
 )
 
 func Path2RelayMode(path string) int {
 	relayMode := RelayModeUnknown
-	if strings.HasPrefix(path, "/v1/chat/completions") || strings.HasPrefix(path, "/pg/chat/completions") {
+	if strings.HasPrefix(path, "/v1/messages/count_tokens") || strings.HasSuffix(path, ":countTokens") {
+		relayMode = RelayModeCountTokens
+	} else if strings.HasPrefix(path, "/v1/chat/completions") || strings.HasPrefix(path, "/pg/chat/completions") {
 		relayMode = RelayModeChatCompletions
 	} else if strings.HasPrefix(path, "/v1/completions") {
 		relayMode = RelayModeCompletions
@@ -86,6 +90,13 @@ func Path2RelayMode(path string) int {
 		relayMode = RelayModeGemini
 	} else if strings.HasPrefix(path, "/mj") {
 		relayMode = Path2RelayModeMidjourney(path)
+	} else if strings.HasPrefix(path, "/api/chat") {
+		// ollama
+		relayMode = RelayModeChatCompletions
+	} else if strings.HasPrefix(path, "/api/generate") {
+		relayMode = RelayModeCompletions
+	} else if strings.HasPrefix(path, "/api/embed") {
+		relayMode = RelayModeEmbeddings
 	}
 	return relayMode
 }
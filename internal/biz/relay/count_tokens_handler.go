@@ -0,0 +1,78 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/channel"
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/model_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+)
+
+var ErrCountTokensNotSupported = errors.New("channel has no native token counting endpoint")
+
+// CountTokensPassThrough forwards a count_tokens request to the selected
+// channel when it speaks the client format natively, and returns the upstream
+// body as is. Other channels yield ErrCountTokensNotSupported.
+func CountTokensPassThrough(c *gin.Context, info *relaycommon.RelayInfo) ([]byte, error) {
+	info.InitChannelMeta(c)
+	if err := helper.ModelMappedHelper(c, info, nil); err != nil {
+		return nil, err
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var url string
+	switch {
+	case info.RelayFormat == types.RelayFormatClaude && info.ChannelType == constant.ChannelTypeAnthropic:
+		url = fmt.Sprintf("%s/v1/messages/count_tokens", info.ChannelBaseUrl)
+		body, err = sjson.SetBytes(body, "model", info.UpstreamModelName)
+		if err != nil {
+			return nil, err
+		}
+	case info.RelayFormat == types.RelayFormatGemini && info.ChannelType == constant.ChannelTypeGemini:
+		version := model_setting.GetGeminiVersionSetting(info.UpstreamModelName)
+		url = fmt.Sprintf("%s/%s/models/%s:countTokens", info.ChannelBaseUrl, version, info.UpstreamModelName)
+	default:
+		return nil, ErrCountTokensNotSupported
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return nil, fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	headers := req.Header
+	if err = adaptor.SetupRequestHeader(c, &headers, info); err != nil {
+		return nil, err
+	}
+	resp, err := channel.DoRequest(c, req, info)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned status %d: %s", resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
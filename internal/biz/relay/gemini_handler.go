@@ -2,6 +2,7 @@ package relay
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
 	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
 	"github.com/QuantumNous/lurus-api/internal/biz/relay/channel/gemini"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/channel"
 	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
 	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
 	"github.com/QuantumNous/lurus-api/internal/biz/service"
@@ -147,6 +149,9 @@ func GeminiHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		// 使用 ConvertGeminiRequest 转换请求格式
 		convertedRequest, err := adaptor.ConvertGeminiRequest(c, info, request)
 		if err != nil {
+			if errors.Is(err, channel.ErrNotImplemented) {
+				return adjustedIRHelper(c, info, request)
+			}
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
 		}
 		jsonData, err := common.Marshal(convertedRequest)
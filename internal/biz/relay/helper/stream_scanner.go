@@ -3,11 +3,13 @@ package helper
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
@@ -15,6 +17,7 @@ import (
 	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
 	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
 
@@ -34,11 +37,41 @@ func getScannerBufferSize() int {
 	return DefaultMaxScannerBufferSize
 }
 
-func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, dataHandler func(data string) bool) {
+// getFirstTokenTimeout returns how long to wait for the first data line, the
+// channel setting taking precedence over STREAM_FIRST_TOKEN_TIMEOUT. Zero
+// disables the check.
+func getFirstTokenTimeout(info *relaycommon.RelayInfo) time.Duration {
+	seconds := constant.StreamFirstTokenTimeout
+	if info.ChannelMeta != nil && info.ChannelSetting.FirstTokenTimeout > 0 {
+		seconds = info.ChannelSetting.FirstTokenTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
 
-	if resp == nil || dataHandler == nil {
+// resetEventStreamHeaders drops the stream headers of an attempt that never
+// wrote anything, so a retry or an error response starts clean.
+func resetEventStreamHeaders(c *gin.Context) {
+	if _, exists := c.Get("event_stream_headers_set"); !exists {
 		return
 	}
+	delete(c.Keys, "event_stream_headers_set")
+	for _, header := range []string{"Content-Type", "Cache-Control", "Connection", "Transfer-Encoding", "X-Accel-Buffering"} {
+		c.Writer.Header().Del(header)
+	}
+}
+
+// StreamScannerHandler reads an SSE response line by line and hands each data
+// payload to dataHandler. If the upstream stalls past the first token timeout
+// or ends before sending anything, while no data event has been written to
+// the client yet (pings do not count), the stream is abandoned and a retryable
+// error is returned so the relay can move on to another channel. Pings have
+// already flushed the stream headers then, so the relay reports a final error
+// as an SSE event.
+func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, dataHandler func(data string) bool) *types.NewAPIError {
+
+	if resp == nil || dataHandler == nil {
+		return nil
+	}
 
 	// 确保响应体总是被关闭
 	defer func() {
@@ -56,8 +89,21 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		pingTicker *time.Ticker
 		writeMutex sync.Mutex     // Mutex to protect concurrent writes
 		wg         sync.WaitGroup // 用于等待所有 goroutine 退出
+
+		firstTokenTimer <-chan time.Time
+		receivedData    atomic.Bool // 是否收到过数据
+		receivedDone    atomic.Bool // 是否收到 [DONE]
+		abandoned       atomic.Bool // 已放弃本次流，不再向客户端写入
+		wroteData       atomic.Bool // 是否已向客户端写入数据事件，ping 注释不计
 	)
 
+	firstTokenTimeout := getFirstTokenTimeout(info)
+	if firstTokenTimeout > 0 {
+		timer := time.NewTimer(firstTokenTimeout)
+		defer timer.Stop()
+		firstTokenTimer = timer.C
+	}
+
 	generalSettings := operation_setting.GetGeneralSetting()
 	pingEnabled := generalSettings.PingIntervalEnabled && !info.DisablePing
 	pingInterval := time.Duration(generalSettings.PingIntervalSeconds) * time.Second
@@ -141,6 +187,10 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 					go func() {
 						writeMutex.Lock()
 						defer writeMutex.Unlock()
+						if abandoned.Load() {
+							done <- nil
+							return
+						}
 						done <- PingData(c)
 					}()
 
@@ -180,7 +230,6 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	wg.Add(1)
 	common.RelayCtxGo(ctx, func() {
 		defer func() {
-			wg.Done()
 			if r := recover(); r != nil {
 				logger.LogError(c, fmt.Sprintf("scanner goroutine panic: %v", r))
 			}
@@ -188,6 +237,8 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			if common.DebugEnabled {
 				println("scanner goroutine exited")
 			}
+			// 最后再通知退出，避免主协程关闭 stopChan 后仍向其发送
+			wg.Done()
 		}()
 
 		for scanner.Scan() {
@@ -218,6 +269,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			data = strings.TrimLeft(data, " ")
 			data = strings.TrimSuffix(data, "\r")
 			if !strings.HasPrefix(data, "[DONE]") {
+				receivedData.Store(true)
 				info.SetFirstResponseTime()
 
 				// 使用超时机制防止写操作阻塞
@@ -225,7 +277,16 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 				go func() {
 					writeMutex.Lock()
 					defer writeMutex.Unlock()
-					done <- dataHandler(data)
+					if abandoned.Load() {
+						done <- false
+						return
+					}
+					size := c.Writer.Size()
+					success := dataHandler(data)
+					if c.Writer.Size() != size {
+						wroteData.Store(true)
+					}
+					done <- success
 				}()
 
 				select {
@@ -243,6 +304,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 				}
 			} else {
 				// done, 处理完成标志，直接退出停止读取剩余数据防止出错
+				receivedDone.Store(true)
 				if common.DebugEnabled {
 					println("received [DONE], stopping scanner")
 				}
@@ -250,23 +312,58 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			}
 		}
 
-		if err := scanner.Err(); err != nil {
+		if err := scanner.Err(); err != nil && !abandoned.Load() {
 			if err != io.EOF {
 				logger.LogError(c, "scanner error: "+err.Error())
 			}
 		}
 	})
 
+	// abandon gives the stream up if the client has not seen a data event of it
+	// yet. Pings written meanwhile are only SSE comments, so a retry can still
+	// stream into the same response.
+	abandon := func(reason string) *types.NewAPIError {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		if wroteData.Load() || c.Request.Context().Err() != nil {
+			return nil
+		}
+		abandoned.Store(true)
+		// 关闭响应体以唤醒阻塞中的 scanner
+		resp.Body.Close()
+		resetEventStreamHeaders(c)
+		logger.LogWarn(c, reason+", failing over")
+		return types.NewOpenAIError(errors.New(reason), types.ErrorCodeStreamFirstTokenFailed, http.StatusBadGateway)
+	}
+
 	// 主循环等待完成或超时
-	select {
-	case <-ticker.C:
-		// 超时处理逻辑
-		logger.LogError(c, "streaming timeout")
-	case <-stopChan:
-		// 正常结束
-		logger.LogInfo(c, "streaming finished")
-	case <-c.Request.Context().Done():
-		// 客户端断开连接
-		logger.LogInfo(c, "client disconnected")
+	for {
+		select {
+		case <-ticker.C:
+			// 超时处理逻辑
+			logger.LogError(c, "streaming timeout")
+			return nil
+		case <-firstTokenTimer:
+			firstTokenTimer = nil
+			if receivedData.Load() {
+				continue
+			}
+			if newAPIError := abandon(fmt.Sprintf("no data from upstream within %s", firstTokenTimeout)); newAPIError != nil {
+				return newAPIError
+			}
+		case <-stopChan:
+			if !receivedData.Load() && !receivedDone.Load() {
+				if newAPIError := abandon("upstream stream ended before sending any data"); newAPIError != nil {
+					return newAPIError
+				}
+			}
+			// 正常结束
+			logger.LogInfo(c, "streaming finished")
+			return nil
+		case <-c.Request.Context().Done():
+			// 客户端断开连接
+			logger.LogInfo(c, "client disconnected")
+			return nil
+		}
 	}
 }
@@ -0,0 +1,99 @@
+package helper
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newStreamTestContext(firstTokenTimeout int) (*gin.Context, *httptest.ResponseRecorder, *relaycommon.RelayInfo) {
+	gin.SetMode(gin.TestMode)
+	constant.StreamingTimeout = 300
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := &relaycommon.RelayInfo{
+		StartTime:   time.Now(),
+		ChannelMeta: &relaycommon.ChannelMeta{ChannelSetting: dto.ChannelSettings{FirstTokenTimeout: firstTokenTimeout}},
+	}
+	return c, recorder, info
+}
+
+func TestStreamScannerFailsOverOnStalledUpstream(t *testing.T) {
+	c, recorder, info := newStreamTestContext(1)
+	body, writer := io.Pipe()
+	defer writer.Close()
+
+	start := time.Now()
+	newAPIError := StreamScannerHandler(c, &http.Response{Body: body}, info, func(data string) bool {
+		t.Errorf("unexpected data %q", data)
+		return true
+	})
+	if newAPIError == nil || newAPIError.StatusCode != http.StatusBadGateway {
+		t.Fatalf("error = %v", newAPIError)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("failover took %s", elapsed)
+	}
+	if recorder.Body.Len() != 0 || c.Writer.Header().Get("Content-Type") != "" {
+		t.Errorf("stalled attempt left output behind: %q %v", recorder.Body.String(), c.Writer.Header())
+	}
+}
+
+func TestStreamScannerFailsOverOnEmptyStream(t *testing.T) {
+	c, _, info := newStreamTestContext(0)
+	newAPIError := StreamScannerHandler(c, &http.Response{Body: io.NopCloser(strings.NewReader(": keep-alive\n\n"))}, info, func(data string) bool {
+		return true
+	})
+	if newAPIError == nil {
+		t.Fatal("expected a retryable error for an empty stream")
+	}
+}
+
+func TestStreamScannerKeepsStreamOnceWritten(t *testing.T) {
+	c, recorder, info := newStreamTestContext(1)
+	body := io.NopCloser(strings.NewReader("data: {\"a\":1}\n\ndata: [DONE]\n\n"))
+	newAPIError := StreamScannerHandler(c, &http.Response{Body: body}, info, func(data string) bool {
+		return StringData(c, data) == nil
+	})
+	if newAPIError != nil {
+		t.Fatalf("error = %v", newAPIError)
+	}
+	if !strings.Contains(recorder.Body.String(), `{"a":1}`) {
+		t.Errorf("body = %q", recorder.Body.String())
+	}
+}
+
+func TestStreamScannerFailsOverAfterPings(t *testing.T) {
+	generalSettings := operation_setting.GetGeneralSetting()
+	pingEnabled, pingSeconds := generalSettings.PingIntervalEnabled, generalSettings.PingIntervalSeconds
+	generalSettings.PingIntervalEnabled, generalSettings.PingIntervalSeconds = true, 1
+	defer func() {
+		generalSettings.PingIntervalEnabled, generalSettings.PingIntervalSeconds = pingEnabled, pingSeconds
+	}()
+
+	c, recorder, info := newStreamTestContext(2)
+	body, writer := io.Pipe()
+	defer writer.Close()
+
+	newAPIError := StreamScannerHandler(c, &http.Response{Body: body}, info, func(data string) bool {
+		t.Errorf("unexpected data %q", data)
+		return true
+	})
+	if newAPIError == nil || newAPIError.StatusCode != http.StatusBadGateway {
+		t.Fatalf("error = %v", newAPIError)
+	}
+	if !strings.Contains(recorder.Body.String(), ": PING") {
+		t.Errorf("no ping was written before the failover: %q", recorder.Body.String())
+	}
+}
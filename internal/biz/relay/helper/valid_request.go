@@ -43,6 +43,8 @@ func GetAndValidateRequest(c *gin.Context, format types.RelayFormat) (request dt
 		request, err = GetAndValidateRerankRequest(c)
 	case types.RelayFormatOpenAIAudio:
 		request, err = GetAndValidAudioRequest(c, relayMode)
+	case types.RelayFormatOllama:
+		request, err = GetAndValidateOllamaRequest(c, relayMode)
 	case types.RelayFormatOpenAIRealtime:
 		request = &dto.BaseRequest{}
 	default:
@@ -110,6 +112,43 @@ func GetAndValidateEmbeddingRequest(c *gin.Context, relayMode int) (*dto.Embeddi
 	return embeddingRequest, nil
 }
 
+// GetAndValidateOllamaRequest parses an Ollama request. /api/embed shares its
+// fields with the OpenAI embedding request and is read as one.
+func GetAndValidateOllamaRequest(c *gin.Context, relayMode int) (dto.Request, error) {
+	switch relayMode {
+	case relayconstant.RelayModeEmbeddings:
+		embeddingRequest, err := GetAndValidateEmbeddingRequest(c, relayMode)
+		if err != nil {
+			return nil, err
+		}
+		if embeddingRequest.Model == "" {
+			return nil, errors.New("field model is required")
+		}
+		return embeddingRequest, nil
+	case relayconstant.RelayModeCompletions:
+		request := &dto.OllamaGenerateRequest{}
+		if err := common.UnmarshalBodyReusable(c, request); err != nil {
+			return nil, err
+		}
+		if request.Model == "" {
+			return nil, errors.New("field model is required")
+		}
+		return request, nil
+	default:
+		request := &dto.OllamaChatRequest{}
+		if err := common.UnmarshalBodyReusable(c, request); err != nil {
+			return nil, err
+		}
+		if request.Model == "" {
+			return nil, errors.New("field model is required")
+		}
+		if len(request.Messages) == 0 {
+			return nil, errors.New("field messages is required")
+		}
+		return request, nil
+	}
+}
+
 func GetAndValidateResponsesRequest(c *gin.Context) (*dto.OpenAIResponsesRequest, error) {
 	request := &dto.OpenAIResponsesRequest{}
 	err := common.UnmarshalBodyReusable(c, request)
@@ -308,6 +347,28 @@ func GetAndValidateGeminiRequest(c *gin.Context) (*dto.GeminiChatRequest, error)
 	return request, nil
 }
 
+// GetAndValidateGeminiCountTokensRequest parses a :countTokens body, which holds
+// either the contents alone or a whole generateContentRequest.
+func GetAndValidateGeminiCountTokensRequest(c *gin.Context) (*dto.GeminiChatRequest, error) {
+	var wrapper struct {
+		GenerateContentRequest *dto.GeminiChatRequest `json:"generateContentRequest"`
+	}
+	if err := common.UnmarshalBodyReusable(c, &wrapper); err != nil {
+		return nil, err
+	}
+	request := wrapper.GenerateContentRequest
+	if request == nil {
+		request = &dto.GeminiChatRequest{}
+		if err := common.UnmarshalBodyReusable(c, request); err != nil {
+			return nil, err
+		}
+	}
+	if len(request.Contents) == 0 {
+		return nil, errors.New("contents is required")
+	}
+	return request, nil
+}
+
 func GetAndValidateGeminiEmbeddingRequest(c *gin.Context) (*dto.GeminiEmbeddingRequest, error) {
 	request := &dto.GeminiEmbeddingRequest{}
 	err := common.UnmarshalBodyReusable(c, request)
@@ -0,0 +1,159 @@
+// Package ir holds the canonical request/response representation used when an
+// inbound format and a channel adaptor have no direct conversion between them.
+//
+// Inbound Claude, Gemini, Responses and Ollama requests are lifted into
+// Request and lowered to an OpenAI chat completion, which every adaptor accepts
+// through ConvertOpenAIRequest. The OpenAI shaped answer is then lifted into Response
+// (or a sequence of StreamEvent) and rendered back in the client format.
+//
+// The OpenAI chat completion is the only hub: a Claude client reaching a
+// Gemini channel goes Claude -> IR -> OpenAI -> Gemini, so whatever the chat
+// completion cannot express (Claude cache control and citations, Gemini
+// safety settings and grounding, Responses built-in tools and stored state)
+// is lost on the way, and adaptors that only speak their own native format
+// are not reachable this way.
+package ir
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/dto"
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
+)
+
+type PartType string
+
+const (
+	PartText       PartType = "text"
+	PartImage      PartType = "image"
+	PartFile       PartType = "file"
+	PartReasoning  PartType = "reasoning"
+	PartToolCall   PartType = "tool_call"
+	PartToolResult PartType = "tool_result"
+)
+
+// Part is a single piece of message content. Only the fields relevant to Type
+// are set.
+type Part struct {
+	Type PartType
+	// Text holds the text, the reasoning content or the tool result
+	Text string
+	// Signature is the opaque reasoning signature some providers require back
+	Signature string
+	// MediaURL is either a remote URL or a data: URL for images and files
+	MediaURL string
+	MimeType string
+	Detail   string
+	FileName string
+	// ToolCallID links a tool call with its result
+	ToolCallID string
+	ToolName   string
+	// Arguments is the JSON encoded tool call input
+	Arguments string
+	IsError   bool
+}
+
+type Message struct {
+	Role  Role
+	Parts []Part
+}
+
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  any
+}
+
+const (
+	ToolChoiceAuto     = "auto"
+	ToolChoiceNone     = "none"
+	ToolChoiceRequired = "required"
+	ToolChoiceFunction = "function"
+)
+
+type ToolChoice struct {
+	Mode string
+	// Name is only set when Mode is ToolChoiceFunction
+	Name string
+}
+
+type Reasoning struct {
+	Effort       string
+	BudgetTokens int
+}
+
+type Request struct {
+	Model       string
+	System      string
+	Messages    []Message
+	Tools       []Tool
+	ToolChoice  *ToolChoice
+	MaxTokens   uint
+	Temperature *float64
+	TopP        float64
+	TopK        int
+	Stop        []string
+	Stream      bool
+	Reasoning   *Reasoning
+	User        string
+	// ResponseFormat is passed through for formats with structured output
+	ResponseFormat *dto.ResponseFormat
+}
+
+type FinishReason string
+
+const (
+	FinishStop          FinishReason = "stop"
+	FinishLength        FinishReason = "length"
+	FinishToolCalls     FinishReason = "tool_calls"
+	FinishContentFilter FinishReason = "content_filter"
+)
+
+type Usage struct {
+	InputTokens     int
+	OutputTokens    int
+	CachedTokens    int
+	ReasoningTokens int
+}
+
+func (u Usage) TotalTokens() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+type Response struct {
+	ID           string
+	Model        string
+	Created      int64
+	Parts        []Part
+	FinishReason FinishReason
+	Usage        Usage
+}
+
+type StreamEventType int
+
+const (
+	EventStart StreamEventType = iota
+	EventTextDelta
+	EventReasoningDelta
+	EventToolCallStart
+	EventToolCallDelta
+	EventFinish
+	EventUsage
+)
+
+// StreamEvent is one step of a streamed response. Index identifies the tool
+// call a EventToolCallStart/EventToolCallDelta belongs to.
+type StreamEvent struct {
+	Type         StreamEventType
+	ID           string
+	Model        string
+	Index        int
+	Text         string
+	ToolCallID   string
+	ToolName     string
+	FinishReason FinishReason
+	Usage        *Usage
+}
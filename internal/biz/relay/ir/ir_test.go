@@ -0,0 +1,200 @@
+package ir
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClaudeRequestToOpenAI(t *testing.T) {
+	var req dto.ClaudeRequest
+	body := `{
+		"model": "claude-sonnet",
+		"system": [{"type": "text", "text": "be brief"}],
+		"max_tokens": 256,
+		"stop_sequences": ["END"],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "any"},
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "weather?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAA"}}
+			]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"},
+				{"type": "text", "text": "thanks"}
+			]}
+		]
+	}`
+	if err := common.UnmarshalJsonStr(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := FromClaude(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := canonical.ToOpenAI()
+
+	if got.Stop != "END" || got.MaxTokens != 256 || got.ToolChoice != ToolChoiceRequired {
+		t.Errorf("stop=%v max_tokens=%d tool_choice=%v", got.Stop, got.MaxTokens, got.ToolChoice)
+	}
+	if len(got.Tools) != 1 || got.Tools[0].Function.Name != "get_weather" {
+		t.Errorf("Tools = %+v", got.Tools)
+	}
+	roles := make([]string, 0, len(got.Messages))
+	for _, message := range got.Messages {
+		roles = append(roles, message.Role)
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,tool,user" {
+		t.Fatalf("roles = %v", roles)
+	}
+	if contents := got.Messages[1].ParseContent(); len(contents) != 2 || contents[1].GetImageMedia().Url != "data:image/png;base64,AAA" {
+		t.Errorf("user content = %+v", contents)
+	}
+	toolCalls := got.Messages[2].ParseToolCalls()
+	if len(toolCalls) != 1 || toolCalls[0].ID != "toolu_1" || toolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool calls = %+v", toolCalls)
+	}
+	if tool := got.Messages[3]; tool.ToolCallId != "toolu_1" || tool.StringContent() != "sunny" || tool.Name == nil || *tool.Name != "get_weather" {
+		t.Errorf("tool message = %+v", tool)
+	}
+}
+
+var sseEventPattern = regexp.MustCompile(`(?m)^event: (\S+)$`)
+
+func TestWriterStreamsClaudeEvents(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writer := NewWriter(c.Writer, types.RelayFormatClaude, "")
+	writer.Header().Set("Content-Type", "text/event-stream")
+
+	chunks := []string{
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":""}}]}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3,"total_tokens":10}}`,
+		`[DONE]`,
+	}
+	for _, chunk := range chunks {
+		// split writes must be reassembled into lines
+		line := "data: " + chunk + "\n\n"
+		_, _ = writer.WriteString(line[:10])
+		_, _ = writer.WriteString(line[10:])
+	}
+	writer.Finish()
+
+	output := recorder.Body.String()
+	var events []string
+	for _, match := range sseEventPattern.FindAllStringSubmatch(output, -1) {
+		events = append(events, match[1])
+	}
+	want := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop," +
+		"content_block_start,content_block_delta,content_block_stop,message_delta,message_stop"
+	if strings.Join(events, ",") != want {
+		t.Fatalf("events = %v", events)
+	}
+	if !strings.Contains(output, `"stop_reason":"tool_use"`) || !strings.Contains(output, `"output_tokens":3`) {
+		t.Errorf("message_delta missing stop reason or usage: %s", output)
+	}
+}
+
+func TestWriterConvertsBufferedResponse(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writer := NewWriter(c.Writer, types.RelayFormatGemini, "")
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Length", "999")
+	writer.WriteHeader(200)
+	_, _ = writer.Write([]byte(`{"id":"chatcmpl-1","model":"gpt","created":1,"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"length"}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`))
+	if recorder.Body.Len() != 0 {
+		t.Fatalf("body written before Finish: %s", recorder.Body.String())
+	}
+	writer.Finish()
+
+	var resp dto.GeminiChatResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Candidates) != 1 || resp.Candidates[0].Content.Parts[0].Text != "hi" || *resp.Candidates[0].FinishReason != "MAX_TOKENS" {
+		t.Errorf("candidates = %+v", resp.Candidates)
+	}
+	if resp.UsageMetadata.TotalTokenCount != 7 {
+		t.Errorf("usage = %+v", resp.UsageMetadata)
+	}
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Errorf("stale Content-Length kept")
+	}
+}
+
+func TestOllamaChatToolResultsGetCallIds(t *testing.T) {
+	var req dto.OllamaChatRequest
+	err := common.UnmarshalJsonStr(`{"model":"m","messages":[
+		{"role":"system","content":"be brief"},
+		{"role":"user","content":"weather?"},
+		{"role":"assistant","content":"","tool_calls":[{"function":{"name":"weather","arguments":{"city":"Paris"}}}]},
+		{"role":"tool","tool_name":"weather","content":"sunny"}
+	],"options":{"num_predict":64},"format":"json"}`, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := FromOllamaChat(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openAI := request.ToOpenAI()
+	if !openAI.Stream || openAI.MaxTokens != 64 || openAI.ResponseFormat == nil || openAI.ResponseFormat.Type != "json_object" {
+		t.Errorf("request = %+v", openAI)
+	}
+	if len(openAI.Messages) != 4 {
+		t.Fatalf("messages = %+v", openAI.Messages)
+	}
+	toolCalls := openAI.Messages[2].ParseToolCalls()
+	if len(toolCalls) != 1 || toolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool calls = %+v", toolCalls)
+	}
+	if openAI.Messages[3].Role != "tool" || openAI.Messages[3].ToolCallId != toolCalls[0].ID {
+		t.Errorf("tool result = %+v", openAI.Messages[3])
+	}
+}
+
+func TestWriterStreamsOllamaNDJSON(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writer := NewOllamaWriter(c.Writer, "llama3", false)
+	writer.Header().Set("Content-Type", "text/event-stream")
+
+	for _, chunk := range []string{
+		`{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`,
+		`{"id":"chatcmpl-1","model":"gpt","choices":[],"usage":{"prompt_tokens":4,"completion_tokens":1,"total_tokens":5}}`,
+		`[DONE]`,
+	} {
+		_, _ = writer.WriteString("data: " + chunk + "\n\n")
+	}
+	writer.Finish()
+
+	if recorder.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("content type = %s", recorder.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q", lines)
+	}
+	var first, last dto.OllamaChatResponse
+	_ = common.UnmarshalJsonStr(lines[0], &first)
+	_ = common.UnmarshalJsonStr(lines[1], &last)
+	if first.Model != "llama3" || first.Message.Content != "Hi" || first.Done {
+		t.Errorf("first = %+v", first)
+	}
+	if !last.Done || last.DoneReason != "stop" || last.PromptEvalCount != 4 || last.EvalCount != 1 {
+		t.Errorf("last = %+v", last)
+	}
+}
@@ -0,0 +1,356 @@
+package ir
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+)
+
+// ollamaImageURL turns the bare base64 images Ollama clients send into data
+// URLs, sniffing the mime type from the first bytes.
+func ollamaImageURL(image string) string {
+	if strings.HasPrefix(image, "data:") || strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+		return image
+	}
+	prefix := image
+	if len(prefix) > 64 {
+		prefix = prefix[:64]
+	}
+	mimeType := "image/png"
+	if head, err := base64.StdEncoding.DecodeString(prefix[:len(prefix)/4*4]); err == nil {
+		if detected := http.DetectContentType(head); strings.HasPrefix(detected, "image/") {
+			mimeType = detected
+		}
+	}
+	return dataURL(mimeType, image)
+}
+
+func ollamaImageParts(images []string) []Part {
+	parts := make([]Part, 0, len(images))
+	for _, image := range images {
+		parts = append(parts, Part{Type: PartImage, MediaURL: ollamaImageURL(image)})
+	}
+	return parts
+}
+
+func (r *Request) applyOllamaOptions(options *dto.OllamaOptions, format json.RawMessage, think json.RawMessage) {
+	if options != nil {
+		r.Temperature = options.Temperature
+		r.TopP = options.TopP
+		r.TopK = options.TopK
+		r.Stop = options.Stop
+		// num_predict -1 means unlimited
+		if options.NumPredict > 0 {
+			r.MaxTokens = uint(options.NumPredict)
+		}
+	}
+
+	switch common.GetJsonType(format) {
+	case "string":
+		var mode string
+		_ = common.Unmarshal(format, &mode)
+		if mode == "json" {
+			r.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+		}
+	case "object":
+		schema, err := common.Marshal(map[string]any{"name": "response", "schema": format})
+		if err == nil {
+			r.ResponseFormat = &dto.ResponseFormat{Type: "json_schema", JsonSchema: schema}
+		}
+	}
+
+	// think is either a boolean or one of low/medium/high
+	switch common.GetJsonType(think) {
+	case "boolean":
+		var enabled bool
+		_ = common.Unmarshal(think, &enabled)
+		if enabled {
+			r.Reasoning = &Reasoning{Effort: "medium"}
+		}
+	case "string":
+		var effort string
+		_ = common.Unmarshal(think, &effort)
+		if effort != "" {
+			r.Reasoning = &Reasoning{Effort: effort}
+		}
+	}
+}
+
+// FromOllamaChat lifts an Ollama /api/chat request.
+func FromOllamaChat(req *dto.OllamaChatRequest) (*Request, error) {
+	out := &Request{
+		Model:  req.Model,
+		Stream: req.IsStream(nil),
+	}
+	out.applyOllamaOptions(req.Options, req.Format, req.Think)
+
+	// Ollama tool calls carry no id and results only name the tool, so ids
+	// are synthesized and handed out to the results in call order.
+	systems := make([]string, 0)
+	pendingCalls := make(map[string][]string)
+	callCount := 0
+	for _, message := range req.Messages {
+		switch message.Role {
+		case "system":
+			if message.Content != "" {
+				systems = append(systems, message.Content)
+			}
+		case "assistant":
+			parts := make([]Part, 0, len(message.ToolCalls)+2)
+			if message.Thinking != "" {
+				parts = append(parts, Part{Type: PartReasoning, Text: message.Thinking})
+			}
+			if message.Content != "" {
+				parts = append(parts, Part{Type: PartText, Text: message.Content})
+			}
+			for _, toolCall := range message.ToolCalls {
+				callCount++
+				id := fmt.Sprintf("call_%d", callCount)
+				name := toolCall.Function.Name
+				pendingCalls[name] = append(pendingCalls[name], id)
+				parts = append(parts, Part{
+					Type:       PartToolCall,
+					ToolCallID: id,
+					ToolName:   name,
+					Arguments:  jsonString(toolCall.Function.Arguments),
+				})
+			}
+			out.Messages = appendMessage(out.Messages, RoleAssistant, parts...)
+		case "tool":
+			id := ""
+			if ids := pendingCalls[message.ToolName]; len(ids) > 0 {
+				id, pendingCalls[message.ToolName] = ids[0], ids[1:]
+			} else {
+				callCount++
+				id = fmt.Sprintf("call_%d", callCount)
+			}
+			out.Messages = appendMessage(out.Messages, RoleTool, Part{
+				Type:       PartToolResult,
+				ToolCallID: id,
+				ToolName:   message.ToolName,
+				Text:       message.Content,
+			})
+		default:
+			parts := make([]Part, 0, len(message.Images)+1)
+			if message.Content != "" {
+				parts = append(parts, Part{Type: PartText, Text: message.Content})
+			}
+			parts = append(parts, ollamaImageParts(message.Images)...)
+			out.Messages = appendMessage(out.Messages, RoleUser, parts...)
+		}
+	}
+	out.System = strings.Join(systems, "\n")
+
+	for _, tool := range req.Tools {
+		out.Tools = append(out.Tools, Tool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+	return out, nil
+}
+
+// FromOllamaGenerate lifts an Ollama /api/generate request into a single user
+// turn. Ollama specific fields such as suffix, raw and context are ignored.
+func FromOllamaGenerate(req *dto.OllamaGenerateRequest) (*Request, error) {
+	if req.Prompt == "" && len(req.Images) == 0 {
+		return nil, fmt.Errorf("prompt is required")
+	}
+	out := &Request{
+		Model:  req.Model,
+		System: req.System,
+		Stream: req.IsStream(nil),
+	}
+	out.applyOllamaOptions(req.Options, req.Format, req.Think)
+
+	parts := make([]Part, 0, len(req.Images)+1)
+	if req.Prompt != "" {
+		parts = append(parts, Part{Type: PartText, Text: req.Prompt})
+	}
+	parts = append(parts, ollamaImageParts(req.Images)...)
+	out.Messages = appendMessage(out.Messages, RoleUser, parts...)
+	return out, nil
+}
+
+func ollamaTimestamp() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+func ollamaStats(reason FinishReason, usage Usage, start time.Time) dto.OllamaResponseStats {
+	doneReason := "stop"
+	if reason == FinishLength {
+		doneReason = "length"
+	}
+	return dto.OllamaResponseStats{
+		Done:            true,
+		DoneReason:      doneReason,
+		TotalDuration:   time.Since(start).Nanoseconds(),
+		PromptEvalCount: usage.InputTokens,
+		EvalCount:       usage.OutputTokens,
+	}
+}
+
+func ollamaToolCalls(parts []Part) []dto.OllamaToolCall {
+	var toolCalls []dto.OllamaToolCall
+	for _, part := range parts {
+		if part.Type != PartToolCall {
+			continue
+		}
+		toolCalls = append(toolCalls, dto.OllamaToolCall{
+			Function: dto.OllamaToolCallFunction{
+				Index:     len(toolCalls),
+				Name:      part.ToolName,
+				Arguments: toolArguments(part.Arguments),
+			},
+		})
+	}
+	return toolCalls
+}
+
+func ollamaModel(model string, fallback string) string {
+	if model != "" {
+		return model
+	}
+	return fallback
+}
+
+// ToOllamaChat renders the response as a non-streaming /api/chat response.
+// model is the name the client asked for, start the time the request came in.
+func (r *Response) ToOllamaChat(model string, start time.Time) *dto.OllamaChatResponse {
+	var reasoning, text []string
+	for _, part := range r.Parts {
+		switch part.Type {
+		case PartReasoning:
+			reasoning = append(reasoning, part.Text)
+		case PartText:
+			text = append(text, part.Text)
+		}
+	}
+	return &dto.OllamaChatResponse{
+		Model:     ollamaModel(model, r.Model),
+		CreatedAt: ollamaTimestamp(),
+		Message: dto.OllamaMessage{
+			Role:      "assistant",
+			Content:   strings.Join(text, ""),
+			Thinking:  strings.Join(reasoning, ""),
+			ToolCalls: ollamaToolCalls(r.Parts),
+		},
+		OllamaResponseStats: ollamaStats(r.FinishReason, r.Usage, start),
+	}
+}
+
+// ToOllamaGenerate renders the response as a non-streaming /api/generate
+// response.
+func (r *Response) ToOllamaGenerate(model string, start time.Time) *dto.OllamaGenerateResponse {
+	var reasoning, text []string
+	for _, part := range r.Parts {
+		switch part.Type {
+		case PartReasoning:
+			reasoning = append(reasoning, part.Text)
+		case PartText:
+			text = append(text, part.Text)
+		}
+	}
+	return &dto.OllamaGenerateResponse{
+		Model:               ollamaModel(model, r.Model),
+		CreatedAt:           ollamaTimestamp(),
+		Response:            strings.Join(text, ""),
+		Thinking:            strings.Join(reasoning, ""),
+		OllamaResponseStats: ollamaStats(r.FinishReason, r.Usage, start),
+	}
+}
+
+func writeNDJSON(buf *bytes.Buffer, data any) {
+	jsonData, err := common.Marshal(data)
+	if err != nil {
+		common.SysError("error marshalling stream event: " + err.Error())
+		return
+	}
+	buf.Write(jsonData)
+	buf.WriteByte('\n')
+}
+
+type ollamaStreamEncoder struct {
+	streamState
+	generate  bool
+	start     time.Time
+	toolCalls []*Part
+	toolIndex map[int]*Part
+}
+
+// NewOllamaStreamEncoder renders /api/chat, or /api/generate when generate is
+// set, as newline delimited JSON. Like Ollama itself, tool calls are sent
+// whole in one message before the final done line.
+func NewOllamaStreamEncoder(model string, generate bool, start time.Time) StreamEncoder {
+	return &ollamaStreamEncoder{
+		streamState: streamState{model: model},
+		generate:    generate,
+		start:       start,
+		toolIndex:   make(map[int]*Part),
+	}
+}
+
+func (e *ollamaStreamEncoder) chunk(buf *bytes.Buffer, message dto.OllamaMessage, stats dto.OllamaResponseStats) {
+	if e.generate {
+		writeNDJSON(buf, dto.OllamaGenerateResponse{
+			Model:               e.model,
+			CreatedAt:           ollamaTimestamp(),
+			Response:            message.Content,
+			Thinking:            message.Thinking,
+			OllamaResponseStats: stats,
+		})
+		return
+	}
+	message.Role = "assistant"
+	writeNDJSON(buf, dto.OllamaChatResponse{
+		Model:               e.model,
+		CreatedAt:           ollamaTimestamp(),
+		Message:             message,
+		OllamaResponseStats: stats,
+	})
+}
+
+func (e *ollamaStreamEncoder) Encode(event StreamEvent) []byte {
+	e.update(event)
+	var buf bytes.Buffer
+	switch event.Type {
+	case EventReasoningDelta:
+		e.chunk(&buf, dto.OllamaMessage{Thinking: event.Text}, dto.OllamaResponseStats{})
+	case EventTextDelta:
+		e.chunk(&buf, dto.OllamaMessage{Content: event.Text}, dto.OllamaResponseStats{})
+	case EventToolCallStart:
+		part := &Part{Type: PartToolCall, ToolCallID: event.ToolCallID, ToolName: event.ToolName}
+		e.toolIndex[event.Index] = part
+		e.toolCalls = append(e.toolCalls, part)
+	case EventToolCallDelta:
+		if part, ok := e.toolIndex[event.Index]; ok {
+			part.Arguments += event.Text
+		}
+	}
+	return buf.Bytes()
+}
+
+func (e *ollamaStreamEncoder) Close() []byte {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	var buf bytes.Buffer
+	if len(e.toolCalls) > 0 && !e.generate {
+		parts := make([]Part, 0, len(e.toolCalls))
+		for _, part := range e.toolCalls {
+			parts = append(parts, *part)
+		}
+		e.chunk(&buf, dto.OllamaMessage{ToolCalls: ollamaToolCalls(parts)}, dto.OllamaResponseStats{})
+	}
+	e.chunk(&buf, dto.OllamaMessage{}, ollamaStats(e.finishReason, e.usage, e.start))
+	return buf.Bytes()
+}
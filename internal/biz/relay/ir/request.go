@@ -0,0 +1,612 @@
+package ir
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+)
+
+func dataURL(mimeType string, data string) string {
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, data)
+}
+
+func jsonString(v any) string {
+	if v == nil {
+		return "{}"
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := common.Marshal(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// appendMessage merges consecutive parts of the same role, which keeps tool
+// calls of a single assistant turn in one message.
+func appendMessage(messages []Message, role Role, parts ...Part) []Message {
+	if len(parts) == 0 {
+		return messages
+	}
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		messages[n-1].Parts = append(messages[n-1].Parts, parts...)
+		return messages
+	}
+	return append(messages, Message{Role: role, Parts: parts})
+}
+
+// FromClaude lifts a Claude Messages API request.
+func FromClaude(req *dto.ClaudeRequest) (*Request, error) {
+	out := &Request{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		TopK:        req.TopK,
+		Stop:        req.StopSequences,
+		Stream:      req.Stream,
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = req.MaxTokensToSample
+	}
+
+	if req.IsStringSystem() {
+		out.System = req.GetStringSystem()
+	} else if req.System != nil {
+		texts := make([]string, 0)
+		for _, system := range req.ParseSystem() {
+			if system.Type == dto.ContentTypeText && system.GetText() != "" {
+				texts = append(texts, system.GetText())
+			}
+		}
+		out.System = strings.Join(texts, "\n")
+	}
+
+	for _, message := range req.Messages {
+		role := RoleUser
+		if message.Role == string(RoleAssistant) {
+			role = RoleAssistant
+		}
+		if message.IsStringContent() {
+			out.Messages = appendMessage(out.Messages, role, Part{Type: PartText, Text: message.GetStringContent()})
+			continue
+		}
+		contents, err := message.ParseContent()
+		if err != nil {
+			return nil, err
+		}
+		parts := make([]Part, 0, len(contents))
+		for _, content := range contents {
+			switch content.Type {
+			case "text":
+				parts = append(parts, Part{Type: PartText, Text: content.GetText()})
+			case "image", "document":
+				if content.Source == nil {
+					continue
+				}
+				part := Part{Type: PartImage, MimeType: content.Source.MediaType, MediaURL: content.Source.Url}
+				if content.Type == "document" {
+					part.Type = PartFile
+				}
+				if content.Source.Type == "base64" {
+					part.MediaURL = dataURL(content.Source.MediaType, common.Interface2String(content.Source.Data))
+				}
+				parts = append(parts, part)
+			case "thinking":
+				if content.Thinking != nil {
+					parts = append(parts, Part{Type: PartReasoning, Text: *content.Thinking, Signature: content.Signature})
+				}
+			case "tool_use":
+				parts = append(parts, Part{
+					Type:       PartToolCall,
+					ToolCallID: content.Id,
+					ToolName:   content.Name,
+					Arguments:  jsonString(content.Input),
+				})
+			case "tool_result":
+				result := Part{
+					Type:       PartToolResult,
+					ToolCallID: content.ToolUseId,
+					ToolName:   req.SearchToolNameByToolCallId(content.ToolUseId),
+				}
+				if content.IsStringContent() || content.Content == nil {
+					result.Text = content.GetStringContent()
+				} else {
+					result.Text = jsonString(content.ParseMediaContent())
+				}
+				parts = append(parts, result)
+			}
+		}
+		out.Messages = appendMessage(out.Messages, role, parts...)
+	}
+
+	if tools, err := common.Any2Type[[]dto.Tool](req.Tools); err == nil {
+		for _, tool := range tools {
+			// server tools such as web_search carry no input schema
+			if tool.Name == "" || tool.InputSchema == nil {
+				continue
+			}
+			out.Tools = append(out.Tools, Tool{Name: tool.Name, Description: tool.Description, Parameters: tool.InputSchema})
+		}
+	}
+	if req.ToolChoice != nil {
+		if choice, err := common.Any2Type[dto.ClaudeToolChoice](req.ToolChoice); err == nil {
+			switch choice.Type {
+			case "auto":
+				out.ToolChoice = &ToolChoice{Mode: ToolChoiceAuto}
+			case "any":
+				out.ToolChoice = &ToolChoice{Mode: ToolChoiceRequired}
+			case "none":
+				out.ToolChoice = &ToolChoice{Mode: ToolChoiceNone}
+			case "tool":
+				out.ToolChoice = &ToolChoice{Mode: ToolChoiceFunction, Name: choice.Name}
+			}
+		}
+	}
+
+	if req.Thinking != nil && req.Thinking.Type == "enabled" {
+		out.Reasoning = &Reasoning{BudgetTokens: req.Thinking.GetBudgetTokens()}
+	}
+	if len(req.Metadata) > 0 {
+		var metadata dto.ClaudeMetadata
+		if err := common.Unmarshal(req.Metadata, &metadata); err == nil {
+			out.User = metadata.UserId
+		}
+	}
+	return out, nil
+}
+
+type geminiFunctionDeclaration struct {
+	Name                 string `json:"name"`
+	Description          string `json:"description,omitempty"`
+	Parameters           any    `json:"parameters,omitempty"`
+	ParametersJsonSchema any    `json:"parametersJsonSchema,omitempty"`
+}
+
+// FromGemini lifts a Gemini generateContent request. The model and the stream
+// flag live in the URL for this format, so the caller passes them in.
+func FromGemini(req *dto.GeminiChatRequest, model string, stream bool) (*Request, error) {
+	config := req.GenerationConfig
+	out := &Request{
+		Model:       model,
+		MaxTokens:   config.MaxOutputTokens,
+		Temperature: config.Temperature,
+		TopP:        config.TopP,
+		TopK:        int(config.TopK),
+		Stop:        config.StopSequences,
+		Stream:      stream,
+	}
+
+	if req.SystemInstructions != nil {
+		texts := make([]string, 0, len(req.SystemInstructions.Parts))
+		for _, part := range req.SystemInstructions.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		out.System = strings.Join(texts, "\n")
+	}
+
+	// Gemini pairs calls and results by function name only, so synthesize ids
+	// and hand them out to the results in call order.
+	pendingCalls := make(map[string][]string)
+	callCount := 0
+	for _, content := range req.Contents {
+		role := RoleUser
+		if content.Role == "model" {
+			role = RoleAssistant
+		}
+		parts := make([]Part, 0, len(content.Parts))
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				callCount++
+				id := fmt.Sprintf("call_%d", callCount)
+				pendingCalls[part.FunctionCall.FunctionName] = append(pendingCalls[part.FunctionCall.FunctionName], id)
+				parts = append(parts, Part{
+					Type:       PartToolCall,
+					ToolCallID: id,
+					ToolName:   part.FunctionCall.FunctionName,
+					Arguments:  jsonString(part.FunctionCall.Arguments),
+				})
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				id := ""
+				if ids := pendingCalls[name]; len(ids) > 0 {
+					id, pendingCalls[name] = ids[0], ids[1:]
+				} else {
+					callCount++
+					id = fmt.Sprintf("call_%d", callCount)
+				}
+				parts = append(parts, Part{
+					Type:       PartToolResult,
+					ToolCallID: id,
+					ToolName:   name,
+					Text:       jsonString(part.FunctionResponse.Response),
+				})
+			case part.InlineData != nil:
+				partType := PartFile
+				if strings.HasPrefix(part.InlineData.MimeType, "image/") {
+					partType = PartImage
+				}
+				parts = append(parts, Part{
+					Type:     partType,
+					MimeType: part.InlineData.MimeType,
+					MediaURL: dataURL(part.InlineData.MimeType, part.InlineData.Data),
+				})
+			case part.FileData != nil:
+				partType := PartFile
+				if strings.HasPrefix(part.FileData.MimeType, "image/") {
+					partType = PartImage
+				}
+				parts = append(parts, Part{Type: partType, MimeType: part.FileData.MimeType, MediaURL: part.FileData.FileUri})
+			case part.Thought:
+				var signature string
+				_ = common.Unmarshal(part.ThoughtSignature, &signature)
+				parts = append(parts, Part{Type: PartReasoning, Text: part.Text, Signature: signature})
+			case part.Text != "":
+				parts = append(parts, Part{Type: PartText, Text: part.Text})
+			}
+		}
+		out.Messages = appendMessage(out.Messages, role, parts...)
+	}
+
+	if len(req.Tools) > 0 {
+		var tools []dto.GeminiChatTool
+		if err := common.Unmarshal(req.Tools, &tools); err != nil {
+			return nil, fmt.Errorf("invalid tools: %w", err)
+		}
+		for _, tool := range tools {
+			if tool.FunctionDeclarations == nil {
+				continue
+			}
+			declarations, err := common.Any2Type[[]geminiFunctionDeclaration](tool.FunctionDeclarations)
+			if err != nil {
+				return nil, fmt.Errorf("invalid function declarations: %w", err)
+			}
+			for _, declaration := range declarations {
+				parameters := declaration.Parameters
+				if parameters == nil {
+					parameters = declaration.ParametersJsonSchema
+				}
+				out.Tools = append(out.Tools, Tool{Name: declaration.Name, Description: declaration.Description, Parameters: parameters})
+			}
+		}
+	}
+	if req.ToolConfig != nil && req.ToolConfig.FunctionCallingConfig != nil {
+		callingConfig := req.ToolConfig.FunctionCallingConfig
+		switch callingConfig.Mode {
+		case "AUTO":
+			out.ToolChoice = &ToolChoice{Mode: ToolChoiceAuto}
+		case "NONE":
+			out.ToolChoice = &ToolChoice{Mode: ToolChoiceNone}
+		case "ANY":
+			out.ToolChoice = &ToolChoice{Mode: ToolChoiceRequired}
+			if len(callingConfig.AllowedFunctionNames) == 1 {
+				out.ToolChoice = &ToolChoice{Mode: ToolChoiceFunction, Name: callingConfig.AllowedFunctionNames[0]}
+			}
+		}
+	}
+
+	if thinking := config.ThinkingConfig; thinking != nil {
+		if thinking.ThinkingLevel != "" {
+			out.Reasoning = &Reasoning{Effort: strings.ToLower(thinking.ThinkingLevel)}
+		} else if thinking.ThinkingBudget != nil && *thinking.ThinkingBudget > 0 {
+			out.Reasoning = &Reasoning{BudgetTokens: *thinking.ThinkingBudget}
+		}
+	}
+	return out, nil
+}
+
+type responsesInputItem struct {
+	Type      string          `json:"type"`
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	CallID    string          `json:"call_id"`
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"`
+	Output    json.RawMessage `json:"output"`
+}
+
+type responsesInputContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL any    `json:"image_url"`
+	Detail   string `json:"detail"`
+	FileData string `json:"file_data"`
+	FileURL  string `json:"file_url"`
+	Filename string `json:"filename"`
+}
+
+func parseResponsesContent(raw json.RawMessage) []Part {
+	if len(raw) == 0 {
+		return nil
+	}
+	if common.GetJsonType(raw) == "string" {
+		var text string
+		_ = common.Unmarshal(raw, &text)
+		return []Part{{Type: PartText, Text: text}}
+	}
+	var contents []responsesInputContent
+	if err := common.Unmarshal(raw, &contents); err != nil {
+		return nil
+	}
+	parts := make([]Part, 0, len(contents))
+	for _, content := range contents {
+		switch content.Type {
+		case "input_text", "output_text", "text":
+			parts = append(parts, Part{Type: PartText, Text: content.Text})
+		case "input_image":
+			url := ""
+			switch v := content.ImageURL.(type) {
+			case string:
+				url = v
+			case map[string]any:
+				url, _ = v["url"].(string)
+			}
+			if url != "" {
+				parts = append(parts, Part{Type: PartImage, MediaURL: url, Detail: content.Detail})
+			}
+		case "input_file":
+			url := content.FileData
+			if url == "" {
+				url = content.FileURL
+			}
+			if url != "" {
+				parts = append(parts, Part{Type: PartFile, MediaURL: url, FileName: content.Filename})
+			}
+		}
+	}
+	return parts
+}
+
+func joinTextParts(parts []Part) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == PartText && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// FromResponses lifts an OpenAI Responses API request.
+func FromResponses(req *dto.OpenAIResponsesRequest) (*Request, error) {
+	out := &Request{
+		Model:     req.Model,
+		MaxTokens: req.MaxOutputTokens,
+		TopP:      req.TopP,
+		Stream:    req.Stream,
+		User:      req.User,
+	}
+	if req.Temperature != 0 {
+		temperature := req.Temperature
+		out.Temperature = &temperature
+	}
+	if req.Reasoning != nil && req.Reasoning.Effort != "" {
+		out.Reasoning = &Reasoning{Effort: req.Reasoning.Effort}
+	}
+
+	systems := make([]string, 0)
+	if len(req.Instructions) > 0 && common.GetJsonType(req.Instructions) == "string" {
+		var instructions string
+		_ = common.Unmarshal(req.Instructions, &instructions)
+		if instructions != "" {
+			systems = append(systems, instructions)
+		}
+	}
+
+	switch common.GetJsonType(req.Input) {
+	case "string":
+		out.Messages = appendMessage(out.Messages, RoleUser, parseResponsesContent(req.Input)...)
+	case "array":
+		var items []responsesInputItem
+		if err := common.Unmarshal(req.Input, &items); err != nil {
+			return nil, fmt.Errorf("invalid input: %w", err)
+		}
+		for _, item := range items {
+			switch item.Type {
+			case "", "message":
+				switch item.Role {
+				case "system", "developer":
+					if text := joinTextParts(parseResponsesContent(item.Content)); text != "" {
+						systems = append(systems, text)
+					}
+				case "assistant":
+					out.Messages = appendMessage(out.Messages, RoleAssistant, parseResponsesContent(item.Content)...)
+				default:
+					out.Messages = appendMessage(out.Messages, RoleUser, parseResponsesContent(item.Content)...)
+				}
+			case "function_call":
+				out.Messages = appendMessage(out.Messages, RoleAssistant, Part{
+					Type:       PartToolCall,
+					ToolCallID: item.CallID,
+					ToolName:   item.Name,
+					Arguments:  item.Arguments,
+				})
+			case "function_call_output":
+				result := Part{Type: PartToolResult, ToolCallID: item.CallID}
+				if common.GetJsonType(item.Output) == "string" {
+					_ = common.Unmarshal(item.Output, &result.Text)
+				} else {
+					result.Text = joinTextParts(parseResponsesContent(item.Output))
+				}
+				out.Messages = appendMessage(out.Messages, RoleTool, result)
+			}
+		}
+	}
+	out.System = strings.Join(systems, "\n")
+
+	for _, tool := range req.GetToolsMap() {
+		if tool["type"] != "function" {
+			continue
+		}
+		name, _ := tool["name"].(string)
+		description, _ := tool["description"].(string)
+		out.Tools = append(out.Tools, Tool{Name: name, Description: description, Parameters: tool["parameters"]})
+	}
+	if len(req.ToolChoice) > 0 {
+		if common.GetJsonType(req.ToolChoice) == "string" {
+			var mode string
+			_ = common.Unmarshal(req.ToolChoice, &mode)
+			out.ToolChoice = &ToolChoice{Mode: mode}
+		} else {
+			var choice struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			}
+			if err := common.Unmarshal(req.ToolChoice, &choice); err == nil && choice.Type == "function" {
+				out.ToolChoice = &ToolChoice{Mode: ToolChoiceFunction, Name: choice.Name}
+			}
+		}
+	}
+	return out, nil
+}
+
+// reasoningEffort maps a thinking budget onto the effort levels understood by
+// OpenAI compatible upstreams.
+func (r *Reasoning) reasoningEffort() string {
+	if r.Effort != "" {
+		return r.Effort
+	}
+	switch {
+	case r.BudgetTokens <= 0:
+		return ""
+	case r.BudgetTokens <= 2048:
+		return "low"
+	case r.BudgetTokens <= 8192:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// ToOpenAI lowers the request to an OpenAI chat completion request.
+func (r *Request) ToOpenAI() *dto.GeneralOpenAIRequest {
+	out := &dto.GeneralOpenAIRequest{
+		Model:       r.Model,
+		MaxTokens:   r.MaxTokens,
+		Temperature: r.Temperature,
+		TopP:        r.TopP,
+		TopK:        r.TopK,
+		Stream:      r.Stream,
+		User:        r.User,
+
+		ResponseFormat: r.ResponseFormat,
+	}
+	if r.Stream {
+		out.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+	if len(r.Stop) == 1 {
+		out.Stop = r.Stop[0]
+	} else if len(r.Stop) > 1 {
+		out.Stop = r.Stop
+	}
+	if r.Reasoning != nil {
+		out.ReasoningEffort = r.Reasoning.reasoningEffort()
+	}
+
+	messages := make([]dto.Message, 0, len(r.Messages)+1)
+	if r.System != "" {
+		system := dto.Message{Role: "system"}
+		system.SetStringContent(r.System)
+		messages = append(messages, system)
+	}
+	for _, message := range r.Messages {
+		contents := make([]dto.MediaContent, 0, len(message.Parts))
+		toolCalls := make([]dto.ToolCallRequest, 0)
+		textOnly := true
+		for _, part := range message.Parts {
+			switch part.Type {
+			case PartText:
+				contents = append(contents, dto.MediaContent{Type: dto.ContentTypeText, Text: part.Text})
+			case PartImage:
+				detail := part.Detail
+				if detail == "" {
+					detail = "auto"
+				}
+				textOnly = false
+				contents = append(contents, dto.MediaContent{
+					Type:     dto.ContentTypeImageURL,
+					ImageUrl: &dto.MessageImageUrl{Url: part.MediaURL, Detail: detail},
+				})
+			case PartFile:
+				textOnly = false
+				contents = append(contents, dto.MediaContent{
+					Type: dto.ContentTypeFile,
+					File: &dto.MessageFile{FileName: part.FileName, FileData: part.MediaURL},
+				})
+			case PartToolCall:
+				toolCalls = append(toolCalls, dto.ToolCallRequest{
+					ID:   part.ToolCallID,
+					Type: "function",
+					Function: dto.FunctionRequest{
+						Name:      part.ToolName,
+						Arguments: part.Arguments,
+					},
+				})
+			case PartToolResult:
+				// tool results always become standalone tool messages
+				toolMessage := dto.Message{Role: "tool", ToolCallId: part.ToolCallID}
+				if part.ToolName != "" {
+					name := part.ToolName
+					toolMessage.Name = &name
+				}
+				toolMessage.SetStringContent(part.Text)
+				messages = append(messages, toolMessage)
+			}
+		}
+		if len(contents) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		role := string(message.Role)
+		if message.Role == RoleTool {
+			role = string(RoleUser)
+		}
+		openAIMessage := dto.Message{Role: role}
+		if textOnly {
+			texts := make([]string, 0, len(contents))
+			for _, content := range contents {
+				texts = append(texts, content.Text)
+			}
+			if len(texts) > 0 {
+				openAIMessage.SetStringContent(strings.Join(texts, "\n"))
+			}
+		} else {
+			openAIMessage.SetMediaContent(contents)
+		}
+		if len(toolCalls) > 0 {
+			openAIMessage.SetToolCalls(toolCalls)
+		}
+		messages = append(messages, openAIMessage)
+	}
+	out.Messages = messages
+
+	for _, tool := range r.Tools {
+		out.Tools = append(out.Tools, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	if r.ToolChoice != nil {
+		switch r.ToolChoice.Mode {
+		case ToolChoiceFunction:
+			out.ToolChoice = map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": r.ToolChoice.Name},
+			}
+		case ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired:
+			out.ToolChoice = r.ToolChoice.Mode
+		}
+	}
+	return out
+}
@@ -0,0 +1,299 @@
+package ir
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+)
+
+func finishReasonFromOpenAI(reason string) FinishReason {
+	switch reason {
+	case "length", "max_tokens":
+		return FinishLength
+	case "tool_calls", "function_call":
+		return FinishToolCalls
+	case "content_filter":
+		return FinishContentFilter
+	default:
+		return FinishStop
+	}
+}
+
+func usageFromOpenAI(usage *dto.Usage) Usage {
+	return Usage{
+		InputTokens:     usage.PromptTokens,
+		OutputTokens:    usage.CompletionTokens,
+		CachedTokens:    usage.PromptTokensDetails.CachedTokens,
+		ReasoningTokens: usage.CompletionTokenDetails.ReasoningTokens,
+	}
+}
+
+func createdTimestamp(created any) int64 {
+	switch v := created.(type) {
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	}
+	return common.GetTimestamp()
+}
+
+// ResponseFromOpenAI lifts a non-streaming chat completion. Only the first
+// choice is kept since none of the target formats support n > 1.
+func ResponseFromOpenAI(resp *dto.OpenAITextResponse) *Response {
+	out := &Response{
+		ID:           resp.Id,
+		Model:        resp.Model,
+		Created:      createdTimestamp(resp.Created),
+		FinishReason: FinishStop,
+		Usage:        usageFromOpenAI(&resp.Usage),
+	}
+	if len(resp.Choices) == 0 {
+		return out
+	}
+	choice := resp.Choices[0]
+	out.FinishReason = finishReasonFromOpenAI(choice.FinishReason)
+	reasoning := choice.Message.ReasoningContent
+	if reasoning == "" {
+		reasoning = choice.Message.Reasoning
+	}
+	if reasoning != "" {
+		out.Parts = append(out.Parts, Part{Type: PartReasoning, Text: reasoning})
+	}
+	if text := choice.Message.StringContent(); text != "" {
+		out.Parts = append(out.Parts, Part{Type: PartText, Text: text})
+	}
+	for _, toolCall := range choice.Message.ParseToolCalls() {
+		out.Parts = append(out.Parts, Part{
+			Type:       PartToolCall,
+			ToolCallID: toolCall.ID,
+			ToolName:   toolCall.Function.Name,
+			Arguments:  toolCall.Function.Arguments,
+		})
+	}
+	return out
+}
+
+// toolArguments decodes the JSON arguments of a tool call, falling back to an
+// empty object since Claude and Gemini both require an object here.
+func toolArguments(arguments string) map[string]any {
+	args := make(map[string]any)
+	if arguments != "" {
+		_ = common.UnmarshalJsonStr(arguments, &args)
+	}
+	return args
+}
+
+func claudeStopReason(reason FinishReason) string {
+	switch reason {
+	case FinishLength:
+		return "max_tokens"
+	case FinishToolCalls:
+		return "tool_use"
+	case FinishContentFilter:
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+func (u Usage) toClaude() *dto.ClaudeUsage {
+	return &dto.ClaudeUsage{
+		InputTokens:          u.InputTokens - u.CachedTokens,
+		CacheReadInputTokens: u.CachedTokens,
+		OutputTokens:         u.OutputTokens,
+	}
+}
+
+// ToClaude renders the response as a Claude Messages API response.
+func (r *Response) ToClaude() *dto.ClaudeResponse {
+	content := make([]dto.ClaudeMediaMessage, 0, len(r.Parts))
+	for _, part := range r.Parts {
+		switch part.Type {
+		case PartReasoning:
+			thinking := part.Text
+			content = append(content, dto.ClaudeMediaMessage{Type: "thinking", Thinking: &thinking, Signature: part.Signature})
+		case PartText:
+			block := dto.ClaudeMediaMessage{Type: "text"}
+			block.SetText(part.Text)
+			content = append(content, block)
+		case PartToolCall:
+			content = append(content, dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    part.ToolCallID,
+				Name:  part.ToolName,
+				Input: toolArguments(part.Arguments),
+			})
+		}
+	}
+	return &dto.ClaudeResponse{
+		Id:         r.ID,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      r.Model,
+		Content:    content,
+		StopReason: claudeStopReason(r.FinishReason),
+		Usage:      r.Usage.toClaude(),
+	}
+}
+
+func geminiFinishReason(reason FinishReason) string {
+	switch reason {
+	case FinishLength:
+		return "MAX_TOKENS"
+	case FinishContentFilter:
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func (u Usage) toGemini() dto.GeminiUsageMetadata {
+	return dto.GeminiUsageMetadata{
+		PromptTokenCount:     u.InputTokens,
+		CandidatesTokenCount: u.OutputTokens - u.ReasoningTokens,
+		ThoughtsTokenCount:   u.ReasoningTokens,
+		TotalTokenCount:      u.TotalTokens(),
+	}
+}
+
+func geminiParts(parts []Part) []dto.GeminiPart {
+	out := make([]dto.GeminiPart, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case PartReasoning:
+			out = append(out, dto.GeminiPart{Text: part.Text, Thought: true})
+		case PartText:
+			out = append(out, dto.GeminiPart{Text: part.Text})
+		case PartToolCall:
+			out = append(out, dto.GeminiPart{FunctionCall: &dto.FunctionCall{
+				FunctionName: part.ToolName,
+				Arguments:    toolArguments(part.Arguments),
+			}})
+		}
+	}
+	return out
+}
+
+// ToGemini renders the response as a Gemini generateContent response.
+func (r *Response) ToGemini() *dto.GeminiChatResponse {
+	finishReason := geminiFinishReason(r.FinishReason)
+	return &dto.GeminiChatResponse{
+		Candidates: []dto.GeminiChatCandidate{{
+			Content:       dto.GeminiChatContent{Role: "model", Parts: geminiParts(r.Parts)},
+			FinishReason:  &finishReason,
+			SafetyRatings: []dto.GeminiChatSafetyRating{},
+		}},
+		UsageMetadata: r.Usage.toGemini(),
+	}
+}
+
+// ResponsesResponse is the Responses API response object. Output items are
+// kept as plain maps since message, function_call and reasoning items share
+// no common shape.
+type ResponsesResponse struct {
+	ID                string           `json:"id"`
+	Object            string           `json:"object"`
+	CreatedAt         int64            `json:"created_at"`
+	Status            string           `json:"status"`
+	IncompleteDetails map[string]any   `json:"incomplete_details"`
+	Model             string           `json:"model"`
+	Output            []map[string]any `json:"output"`
+	Usage             *dto.Usage       `json:"usage,omitempty"`
+}
+
+func (u Usage) toResponses() *dto.Usage {
+	return &dto.Usage{
+		InputTokens:            u.InputTokens,
+		OutputTokens:           u.OutputTokens,
+		TotalTokens:            u.TotalTokens(),
+		InputTokensDetails:     &dto.InputTokenDetails{CachedTokens: u.CachedTokens},
+		CompletionTokenDetails: dto.OutputTokenDetails{ReasoningTokens: u.ReasoningTokens},
+	}
+}
+
+func responsesStatus(reason FinishReason) (string, map[string]any) {
+	switch reason {
+	case FinishLength:
+		return "incomplete", map[string]any{"reason": "max_output_tokens"}
+	case FinishContentFilter:
+		return "incomplete", map[string]any{"reason": "content_filter"}
+	default:
+		return "completed", nil
+	}
+}
+
+func responsesItemID(prefix string, responseID string, index int) string {
+	return fmt.Sprintf("%s_%s_%d", prefix, strings.TrimPrefix(responseID, "resp_"), index)
+}
+
+func responsesMessageItem(id string, status string, text string) map[string]any {
+	content := make([]map[string]any, 0, 1)
+	if status == "completed" {
+		content = append(content, responsesTextPart(text))
+	}
+	return map[string]any{
+		"type":    "message",
+		"id":      id,
+		"status":  status,
+		"role":    "assistant",
+		"content": content,
+	}
+}
+
+func responsesTextPart(text string) map[string]any {
+	return map[string]any{"type": "output_text", "text": text, "annotations": []any{}}
+}
+
+func responsesFunctionCallItem(id string, status string, callID string, name string, arguments string) map[string]any {
+	return map[string]any{
+		"type":      "function_call",
+		"id":        id,
+		"status":    status,
+		"call_id":   callID,
+		"name":      name,
+		"arguments": arguments,
+	}
+}
+
+func responsesReasoningItem(id string, text string) map[string]any {
+	summary := make([]map[string]any, 0, 1)
+	if text != "" {
+		summary = append(summary, map[string]any{"type": "summary_text", "text": text})
+	}
+	return map[string]any{"type": "reasoning", "id": id, "summary": summary}
+}
+
+// ToResponses renders the response as a Responses API response object.
+func (r *Response) ToResponses(id string) *ResponsesResponse {
+	status, incomplete := responsesStatus(r.FinishReason)
+	out := &ResponsesResponse{
+		ID:                id,
+		Object:            "response",
+		CreatedAt:         r.Created,
+		Status:            status,
+		IncompleteDetails: incomplete,
+		Model:             r.Model,
+		Output:            make([]map[string]any, 0, len(r.Parts)),
+		Usage:             r.Usage.toResponses(),
+	}
+	for i, part := range r.Parts {
+		switch part.Type {
+		case PartReasoning:
+			out.Output = append(out.Output, responsesReasoningItem(responsesItemID("rs", id, i), part.Text))
+		case PartText:
+			out.Output = append(out.Output, responsesMessageItem(responsesItemID("msg", id, i), "completed", part.Text))
+		case PartToolCall:
+			out.Output = append(out.Output, responsesFunctionCallItem(responsesItemID("fc", id, i), "completed", part.ToolCallID, part.ToolName, part.Arguments))
+		}
+	}
+	return out
+}
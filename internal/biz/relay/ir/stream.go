@@ -0,0 +1,447 @@
+package ir
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+)
+
+// OpenAIStreamDecoder turns chat completion chunks into stream events.
+type OpenAIStreamDecoder struct {
+	started   bool
+	toolCalls map[int]bool
+}
+
+func NewOpenAIStreamDecoder() *OpenAIStreamDecoder {
+	return &OpenAIStreamDecoder{toolCalls: make(map[int]bool)}
+}
+
+func (d *OpenAIStreamDecoder) Decode(chunk *dto.ChatCompletionsStreamResponse) []StreamEvent {
+	events := make([]StreamEvent, 0, 2)
+	if !d.started {
+		d.started = true
+		events = append(events, StreamEvent{Type: EventStart, ID: chunk.Id, Model: chunk.Model})
+	}
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+			events = append(events, StreamEvent{Type: EventReasoningDelta, Text: reasoning})
+		}
+		if content := choice.Delta.GetContentString(); content != "" {
+			events = append(events, StreamEvent{Type: EventTextDelta, Text: content})
+		}
+		for i, toolCall := range choice.Delta.ToolCalls {
+			index := i
+			if toolCall.Index != nil {
+				index = *toolCall.Index
+			}
+			if !d.toolCalls[index] {
+				d.toolCalls[index] = true
+				events = append(events, StreamEvent{
+					Type:       EventToolCallStart,
+					Index:      index,
+					ToolCallID: toolCall.ID,
+					ToolName:   toolCall.Function.Name,
+				})
+			}
+			if toolCall.Function.Arguments != "" {
+				events = append(events, StreamEvent{Type: EventToolCallDelta, Index: index, Text: toolCall.Function.Arguments})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			events = append(events, StreamEvent{Type: EventFinish, FinishReason: finishReasonFromOpenAI(*choice.FinishReason)})
+		}
+	}
+	if chunk.Usage != nil && (chunk.Usage.PromptTokens > 0 || chunk.Usage.CompletionTokens > 0) {
+		usage := usageFromOpenAI(chunk.Usage)
+		events = append(events, StreamEvent{Type: EventUsage, Usage: &usage})
+	}
+	return events
+}
+
+// StreamEncoder renders stream events as server-sent events of a client
+// format. Finish and usage are held back until Close because OpenAI reports
+// usage in a chunk of its own after the finish reason.
+type StreamEncoder interface {
+	Encode(event StreamEvent) []byte
+	Close() []byte
+}
+
+func writeSSE(buf *bytes.Buffer, event string, data any) {
+	jsonData, err := common.Marshal(data)
+	if err != nil {
+		common.SysError("error marshalling stream event: " + err.Error())
+		return
+	}
+	if event != "" {
+		fmt.Fprintf(buf, "event: %s\n", event)
+	}
+	fmt.Fprintf(buf, "data: %s\n\n", jsonData)
+}
+
+// streamState tracks what every encoder needs to know about the response.
+type streamState struct {
+	id           string
+	model        string
+	finishReason FinishReason
+	usage        Usage
+	closed       bool
+}
+
+func (s *streamState) update(event StreamEvent) {
+	switch event.Type {
+	case EventStart:
+		if s.id == "" {
+			s.id = event.ID
+		}
+		if s.model == "" {
+			s.model = event.Model
+		}
+	case EventFinish:
+		s.finishReason = event.FinishReason
+	case EventUsage:
+		s.usage = *event.Usage
+	}
+}
+
+type claudeStreamEncoder struct {
+	streamState
+	index     int
+	openBlock PartType
+	blocks    int
+}
+
+// NewClaudeStreamEncoder renders Claude Messages API stream events.
+func NewClaudeStreamEncoder(id string, model string) StreamEncoder {
+	return &claudeStreamEncoder{streamState: streamState{id: id, model: model}}
+}
+
+func (e *claudeStreamEncoder) startBlock(buf *bytes.Buffer, partType PartType, block *dto.ClaudeMediaMessage) {
+	e.stopBlock(buf)
+	e.openBlock = partType
+	resp := dto.ClaudeResponse{Type: "content_block_start", ContentBlock: block}
+	resp.SetIndex(e.index)
+	writeSSE(buf, resp.Type, resp)
+}
+
+func (e *claudeStreamEncoder) stopBlock(buf *bytes.Buffer) {
+	if e.openBlock == "" {
+		return
+	}
+	resp := dto.ClaudeResponse{Type: "content_block_stop"}
+	resp.SetIndex(e.index)
+	writeSSE(buf, resp.Type, resp)
+	e.openBlock = ""
+	e.index++
+}
+
+func (e *claudeStreamEncoder) delta(buf *bytes.Buffer, delta *dto.ClaudeMediaMessage) {
+	resp := dto.ClaudeResponse{Type: "content_block_delta", Delta: delta}
+	resp.SetIndex(e.index)
+	writeSSE(buf, resp.Type, resp)
+}
+
+func (e *claudeStreamEncoder) Encode(event StreamEvent) []byte {
+	e.update(event)
+	var buf bytes.Buffer
+	switch event.Type {
+	case EventStart:
+		writeSSE(&buf, "message_start", dto.ClaudeResponse{
+			Type: "message_start",
+			Message: &dto.ClaudeMediaMessage{
+				Id:    e.id,
+				Type:  "message",
+				Role:  "assistant",
+				Model: e.model,
+				Usage: &dto.ClaudeUsage{},
+			},
+		})
+	case EventReasoningDelta:
+		if e.openBlock != PartReasoning {
+			empty := ""
+			e.startBlock(&buf, PartReasoning, &dto.ClaudeMediaMessage{Type: "thinking", Thinking: &empty})
+		}
+		thinking := event.Text
+		e.delta(&buf, &dto.ClaudeMediaMessage{Type: "thinking_delta", Thinking: &thinking})
+	case EventTextDelta:
+		if e.openBlock != PartText {
+			block := &dto.ClaudeMediaMessage{Type: "text"}
+			block.SetText("")
+			e.startBlock(&buf, PartText, block)
+		}
+		delta := &dto.ClaudeMediaMessage{Type: "text_delta"}
+		delta.SetText(event.Text)
+		e.delta(&buf, delta)
+	case EventToolCallStart:
+		e.startBlock(&buf, PartToolCall, &dto.ClaudeMediaMessage{
+			Type:  "tool_use",
+			Id:    event.ToolCallID,
+			Name:  event.ToolName,
+			Input: map[string]any{},
+		})
+	case EventToolCallDelta:
+		if e.openBlock == PartToolCall {
+			arguments := event.Text
+			e.delta(&buf, &dto.ClaudeMediaMessage{Type: "input_json_delta", PartialJson: &arguments})
+		}
+	}
+	return buf.Bytes()
+}
+
+func (e *claudeStreamEncoder) Close() []byte {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	var buf bytes.Buffer
+	e.stopBlock(&buf)
+	stopReason := claudeStopReason(e.finishReason)
+	writeSSE(&buf, "message_delta", dto.ClaudeResponse{
+		Type:  "message_delta",
+		Delta: &dto.ClaudeMediaMessage{StopReason: &stopReason},
+		Usage: e.usage.toClaude(),
+	})
+	writeSSE(&buf, "message_stop", dto.ClaudeResponse{Type: "message_stop"})
+	return buf.Bytes()
+}
+
+type geminiStreamEncoder struct {
+	streamState
+	toolCalls []*Part
+	toolIndex map[int]*Part
+}
+
+// NewGeminiStreamEncoder renders streamGenerateContent chunks. Function calls
+// are emitted whole in the final chunk as Gemini has no partial arguments.
+func NewGeminiStreamEncoder() StreamEncoder {
+	return &geminiStreamEncoder{toolIndex: make(map[int]*Part)}
+}
+
+func (e *geminiStreamEncoder) chunk(buf *bytes.Buffer, parts []dto.GeminiPart, finishReason *string, usage dto.GeminiUsageMetadata) {
+	writeSSE(buf, "", dto.GeminiChatResponse{
+		Candidates: []dto.GeminiChatCandidate{{
+			Content:       dto.GeminiChatContent{Role: "model", Parts: parts},
+			FinishReason:  finishReason,
+			SafetyRatings: []dto.GeminiChatSafetyRating{},
+		}},
+		UsageMetadata: usage,
+	})
+}
+
+func (e *geminiStreamEncoder) Encode(event StreamEvent) []byte {
+	e.update(event)
+	var buf bytes.Buffer
+	switch event.Type {
+	case EventReasoningDelta:
+		e.chunk(&buf, []dto.GeminiPart{{Text: event.Text, Thought: true}}, nil, dto.GeminiUsageMetadata{})
+	case EventTextDelta:
+		e.chunk(&buf, []dto.GeminiPart{{Text: event.Text}}, nil, dto.GeminiUsageMetadata{})
+	case EventToolCallStart:
+		part := &Part{Type: PartToolCall, ToolCallID: event.ToolCallID, ToolName: event.ToolName}
+		e.toolIndex[event.Index] = part
+		e.toolCalls = append(e.toolCalls, part)
+	case EventToolCallDelta:
+		if part, ok := e.toolIndex[event.Index]; ok {
+			part.Arguments += event.Text
+		}
+	}
+	return buf.Bytes()
+}
+
+func (e *geminiStreamEncoder) Close() []byte {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	parts := make([]Part, 0, len(e.toolCalls))
+	for _, part := range e.toolCalls {
+		parts = append(parts, *part)
+	}
+	var buf bytes.Buffer
+	finishReason := geminiFinishReason(e.finishReason)
+	e.chunk(&buf, geminiParts(parts), &finishReason, e.usage.toGemini())
+	return buf.Bytes()
+}
+
+type responsesStreamEncoder struct {
+	streamState
+	created  int64
+	output   []map[string]any
+	openItem map[string]any
+	openType PartType
+	text     string
+	// tool call items by upstream index
+	toolItems map[int]map[string]any
+}
+
+// NewResponsesStreamEncoder renders Responses API stream events.
+func NewResponsesStreamEncoder(id string, model string) StreamEncoder {
+	return &responsesStreamEncoder{
+		streamState: streamState{id: id, model: model},
+		created:     common.GetTimestamp(),
+		toolItems:   make(map[int]map[string]any),
+	}
+}
+
+func (e *responsesStreamEncoder) response(status string) *ResponsesResponse {
+	resp := &ResponsesResponse{
+		ID:        e.id,
+		Object:    "response",
+		CreatedAt: e.created,
+		Status:    status,
+		Model:     e.model,
+		Output:    e.output,
+	}
+	if resp.Output == nil {
+		resp.Output = make([]map[string]any, 0)
+	}
+	if status != "in_progress" {
+		resp.Status, resp.IncompleteDetails = responsesStatus(e.finishReason)
+		resp.Usage = e.usage.toResponses()
+	}
+	return resp
+}
+
+func (e *responsesStreamEncoder) startItem(buf *bytes.Buffer, partType PartType, item map[string]any) {
+	e.stopItem(buf)
+	e.openType = partType
+	e.openItem = item
+	e.text = ""
+	writeSSE(buf, "response.output_item.added", map[string]any{
+		"type":         "response.output_item.added",
+		"output_index": len(e.output),
+		"item":         item,
+	})
+	if partType == PartText {
+		writeSSE(buf, "response.content_part.added", map[string]any{
+			"type":          "response.content_part.added",
+			"item_id":       item["id"],
+			"output_index":  len(e.output),
+			"content_index": 0,
+			"part":          responsesTextPart(""),
+		})
+	}
+}
+
+func (e *responsesStreamEncoder) stopItem(buf *bytes.Buffer) {
+	if e.openItem == nil {
+		return
+	}
+	item := e.openItem
+	outputIndex := len(e.output)
+	switch e.openType {
+	case PartText:
+		writeSSE(buf, "response.output_text.done", map[string]any{
+			"type":          "response.output_text.done",
+			"item_id":       item["id"],
+			"output_index":  outputIndex,
+			"content_index": 0,
+			"text":          e.text,
+		})
+		writeSSE(buf, "response.content_part.done", map[string]any{
+			"type":          "response.content_part.done",
+			"item_id":       item["id"],
+			"output_index":  outputIndex,
+			"content_index": 0,
+			"part":          responsesTextPart(e.text),
+		})
+		item = responsesMessageItem(item["id"].(string), "completed", e.text)
+	case PartReasoning:
+		item = responsesReasoningItem(item["id"].(string), e.text)
+	case PartToolCall:
+		writeSSE(buf, "response.function_call_arguments.done", map[string]any{
+			"type":         "response.function_call_arguments.done",
+			"item_id":      item["id"],
+			"output_index": outputIndex,
+			"arguments":    item["arguments"],
+		})
+		item["status"] = "completed"
+	}
+	writeSSE(buf, "response.output_item.done", map[string]any{
+		"type":         "response.output_item.done",
+		"output_index": outputIndex,
+		"item":         item,
+	})
+	e.output = append(e.output, item)
+	e.openItem = nil
+	e.openType = ""
+}
+
+func (e *responsesStreamEncoder) Encode(event StreamEvent) []byte {
+	e.update(event)
+	var buf bytes.Buffer
+	itemID := func(prefix string) string {
+		return responsesItemID(prefix, e.id, len(e.output))
+	}
+	switch event.Type {
+	case EventStart:
+		writeSSE(&buf, "response.created", map[string]any{
+			"type":     "response.created",
+			"response": e.response("in_progress"),
+		})
+	case EventReasoningDelta:
+		if e.openType != PartReasoning {
+			e.startItem(&buf, PartReasoning, responsesReasoningItem(itemID("rs"), ""))
+		}
+		e.text += event.Text
+		writeSSE(&buf, "response.reasoning_summary_text.delta", map[string]any{
+			"type":          "response.reasoning_summary_text.delta",
+			"item_id":       e.openItem["id"],
+			"output_index":  len(e.output),
+			"summary_index": 0,
+			"delta":         event.Text,
+		})
+	case EventTextDelta:
+		if e.openType != PartText {
+			e.startItem(&buf, PartText, responsesMessageItem(itemID("msg"), "in_progress", ""))
+		}
+		e.text += event.Text
+		writeSSE(&buf, "response.output_text.delta", map[string]any{
+			"type":          "response.output_text.delta",
+			"item_id":       e.openItem["id"],
+			"output_index":  len(e.output),
+			"content_index": 0,
+			"delta":         event.Text,
+		})
+	case EventToolCallStart:
+		item := responsesFunctionCallItem(itemID("fc"), "in_progress", event.ToolCallID, event.ToolName, "")
+		e.startItem(&buf, PartToolCall, item)
+		e.toolItems[event.Index] = item
+	case EventToolCallDelta:
+		item, ok := e.toolItems[event.Index]
+		if !ok || e.openType != PartToolCall || e.openItem["id"] != item["id"] {
+			break
+		}
+		item["arguments"] = item["arguments"].(string) + event.Text
+		writeSSE(&buf, "response.function_call_arguments.delta", map[string]any{
+			"type":         "response.function_call_arguments.delta",
+			"item_id":      item["id"],
+			"output_index": len(e.output),
+			"delta":        event.Text,
+		})
+	}
+	return buf.Bytes()
+}
+
+func (e *responsesStreamEncoder) Close() []byte {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	var buf bytes.Buffer
+	e.stopItem(&buf)
+	resp := e.response("completed")
+	eventType := "response.completed"
+	if resp.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	writeSSE(&buf, eventType, map[string]any{
+		"type":     eventType,
+		"response": resp,
+	})
+	return buf.Bytes()
+}
@@ -0,0 +1,195 @@
+package ir
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Writer sits in front of the client connection while an OpenAI shaped
+// response is produced and renders it in the client's relay format.
+// Streams are converted line by line, other bodies are buffered until Finish.
+type Writer struct {
+	gin.ResponseWriter
+	format     types.RelayFormat
+	responseID string
+
+	// the Ollama format answers with the requested model and timings
+	model    string
+	generate bool
+	start    time.Time
+
+	streamKnown bool
+	stream      bool
+	body        bytes.Buffer
+	pending     []byte
+	decoder     *OpenAIStreamDecoder
+	encoder     StreamEncoder
+}
+
+// NewWriter wraps w. responseID is only used by the Responses format, the other
+// formats reuse the upstream id.
+func NewWriter(w gin.ResponseWriter, format types.RelayFormat, responseID string) *Writer {
+	return &Writer{
+		ResponseWriter: w,
+		format:         format,
+		responseID:     responseID,
+		decoder:        NewOpenAIStreamDecoder(),
+	}
+}
+
+// NewOllamaWriter wraps w for an Ollama client. generate selects the
+// /api/generate response shape instead of /api/chat.
+func NewOllamaWriter(w gin.ResponseWriter, model string, generate bool) *Writer {
+	writer := NewWriter(w, types.RelayFormatOllama, "")
+	writer.model = model
+	writer.generate = generate
+	writer.start = time.Now()
+	return writer
+}
+
+func (w *Writer) newEncoder() StreamEncoder {
+	switch w.format {
+	case types.RelayFormatOllama:
+		return NewOllamaStreamEncoder(w.model, w.generate, w.start)
+	case types.RelayFormatClaude:
+		return NewClaudeStreamEncoder("", "")
+	case types.RelayFormatGemini:
+		return NewGeminiStreamEncoder()
+	default:
+		return NewResponsesStreamEncoder(w.responseID, "")
+	}
+}
+
+func (w *Writer) detectStream() {
+	if w.streamKnown {
+		return
+	}
+	w.streamKnown = true
+	w.stream = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+	if w.stream {
+		w.encoder = w.newEncoder()
+		if w.format == types.RelayFormatOllama {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+	}
+}
+
+func (w *Writer) WriteHeader(code int) {
+	// the converted body never has the upstream length
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	w.detectStream()
+	if !w.stream {
+		return w.body.Write(data)
+	}
+	w.pending = append(w.pending, data...)
+	for {
+		idx := bytes.IndexByte(w.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.pending[:idx]))
+		w.pending = w.pending[idx+1:]
+		if err := w.writeStreamLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *Writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *Writer) writeStreamLine(line string) error {
+	if !strings.HasPrefix(line, "data:") {
+		return nil
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "[DONE]" {
+		return w.closeStream()
+	}
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := common.UnmarshalJsonStr(data, &chunk); err != nil {
+		return nil
+	}
+	var out []byte
+	for _, event := range w.decoder.Decode(&chunk) {
+		out = append(out, w.encoder.Encode(event)...)
+	}
+	return w.writeRaw(out)
+}
+
+func (w *Writer) closeStream() error {
+	if !w.decoder.started {
+		return nil
+	}
+	return w.writeRaw(w.encoder.Close())
+}
+
+func (w *Writer) writeRaw(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(data)
+	return err
+}
+
+func (w *Writer) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// Finish terminates a stream that ended without [DONE] and writes a buffered
+// body in the client format. Bodies that are not chat completions, such as
+// upstream errors, are passed through untouched.
+func (w *Writer) Finish() {
+	if w.stream {
+		_ = w.closeStream()
+		w.ResponseWriter.Flush()
+		return
+	}
+	if w.body.Len() == 0 {
+		return
+	}
+	var resp dto.OpenAITextResponse
+	if err := common.Unmarshal(w.body.Bytes(), &resp); err != nil || len(resp.Choices) == 0 {
+		_ = w.writeRaw(w.body.Bytes())
+		return
+	}
+	canonical := ResponseFromOpenAI(&resp)
+	var converted any
+	switch w.format {
+	case types.RelayFormatClaude:
+		converted = canonical.ToClaude()
+	case types.RelayFormatGemini:
+		converted = canonical.ToGemini()
+	case types.RelayFormatOllama:
+		if w.generate {
+			converted = canonical.ToOllamaGenerate(w.model, w.start)
+		} else {
+			converted = canonical.ToOllamaChat(w.model, w.start)
+		}
+	default:
+		converted = canonical.ToResponses(w.responseID)
+	}
+	jsonData, err := common.Marshal(converted)
+	if err != nil {
+		_ = w.writeRaw(w.body.Bytes())
+		return
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	_ = w.writeRaw(jsonData)
+}
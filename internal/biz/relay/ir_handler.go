@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/channel"
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	relayconstant "github.com/QuantumNous/lurus-api/internal/biz/relay/constant"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/ir"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/model_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IRHelper relays a Claude, Gemini or Responses request to a channel whose
+// adaptor returned channel.ErrNotImplemented for that format. The request is
+// lowered to a chat completion through the canonical IR and sent through
+// TextHelper, while the client still receives its own format. Ollama chat and
+// generate requests always take this path. It relays info.Request, handlers
+// that adjusted a copy of the request swap the copy in first, and fails when
+// pass-through is enabled since the request has to be converted.
+func IRHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types.NewAPIError) {
+	if newAPIError = checkConvertible(info); newAPIError != nil {
+		return newAPIError
+	}
+
+	var (
+		request *ir.Request
+		err     error
+	)
+	switch req := info.Request.(type) {
+	case *dto.ClaudeRequest:
+		request, err = ir.FromClaude(req)
+	case *dto.GeminiChatRequest:
+		request, err = ir.FromGemini(req, info.OriginModelName, info.IsStream)
+	case *dto.OpenAIResponsesRequest:
+		request, err = ir.FromResponses(req)
+	case *dto.OllamaChatRequest:
+		request, err = ir.FromOllamaChat(req)
+	case *dto.OllamaGenerateRequest:
+		request, err = ir.FromOllamaGenerate(req)
+	default:
+		return types.NewErrorWithStatusCode(fmt.Errorf("request type %T cannot be relayed through ir: %w", info.Request, channel.ErrNotImplemented), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	writer := ir.NewWriter(c.Writer, info.RelayFormat, "resp_"+c.GetString(common.RequestIdKey))
+	if info.RelayFormat == types.RelayFormatOllama {
+		_, generate := info.Request.(*dto.OllamaGenerateRequest)
+		writer = ir.NewOllamaWriter(c.Writer, info.OriginModelName, generate)
+	}
+	originRequest, originFormat, originMode, originPath := info.Request, info.RelayFormat, info.RelayMode, info.RequestURLPath
+	c.Writer = writer
+	info.Request = request.ToOpenAI()
+	info.RelayFormat = types.RelayFormatOpenAI
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RequestURLPath = "/v1/chat/completions"
+	defer func() {
+		c.Writer = writer.ResponseWriter
+		info.Request = originRequest
+		info.RelayFormat = originFormat
+		info.RelayMode = originMode
+		info.RequestURLPath = originPath
+	}()
+
+	newAPIError = TextHelper(c, info)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	writer.Finish()
+	return nil
+}
+
+// checkConvertible fails requests that have to be converted for the channel
+// while pass-through is enabled, instead of sending the client body as is to
+// an endpoint expecting another format.
+func checkConvertible(info *relaycommon.RelayInfo) *types.NewAPIError {
+	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+		return types.NewErrorWithStatusCode(fmt.Errorf("%s requests must be converted for this channel and cannot be passed through", info.RelayFormat), types.ErrorCodeConvertRequestFailed, http.StatusBadRequest)
+	}
+	return nil
+}
+
+// adjustedIRHelper relays through IRHelper the copy of the request a handler
+// adjusted for the channel instead of the original. The copy already carries
+// the channel system prompt, so TextHelper must not add it a second time.
+func adjustedIRHelper(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) *types.NewAPIError {
+	originRequest, systemPrompt := info.Request, info.ChannelSetting.SystemPrompt
+	info.Request, info.ChannelSetting.SystemPrompt = request, ""
+	defer func() {
+		info.Request, info.ChannelSetting.SystemPrompt = originRequest, systemPrompt
+	}()
+	return IRHelper(c, info)
+}
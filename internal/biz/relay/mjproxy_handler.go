@@ -220,17 +220,19 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 			logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", priceData.ModelPrice, priceData.GroupRatioInfo.GroupRatio, constant.MjActionSwapFace)
 			other := service.GenerateMjOtherInfo(info, priceData)
 			model.RecordConsumeLog(c, info.UserId, model.RecordConsumeLogParams{
-				ChannelId: info.ChannelId,
-				ModelName: modelName,
-				TokenName: tokenName,
-				Quota:     priceData.Quota,
-				Content:   logContent,
-				TokenId:   info.TokenId,
-				Group:     info.UsingGroup,
-				Other:     other,
+				ChannelId:    info.ChannelId,
+				ModelName:    modelName,
+				TokenName:    tokenName,
+				Quota:        priceData.Quota,
+				UpstreamCost: service.UpstreamModelCost(c, modelName, 0, 0, priceData.Quota, priceData.GroupRatioInfo.GroupRatio),
+				Content:      logContent,
+				TokenId:      info.TokenId,
+				Group:        info.UsingGroup,
+				Other:        other,
 			})
 			model.UpdateUserUsedQuotaAndRequestCount(info.UserId, priceData.Quota)
 			model.UpdateChannelUsedQuota(info.ChannelId, priceData.Quota)
+			service.RecordChannelKeyUsage(info, priceData.Quota)
 		}
 	}()
 	midjResponse := &mjResp.Response
@@ -520,17 +522,19 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 			logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s，ID %s", priceData.ModelPrice, priceData.GroupRatioInfo.GroupRatio, midjRequest.Action, midjResponse.Result)
 			other := service.GenerateMjOtherInfo(relayInfo, priceData)
 			model.RecordConsumeLog(c, relayInfo.UserId, model.RecordConsumeLogParams{
-				ChannelId: relayInfo.ChannelId,
-				ModelName: modelName,
-				TokenName: tokenName,
-				Quota:     priceData.Quota,
-				Content:   logContent,
-				TokenId:   relayInfo.TokenId,
-				Group:     relayInfo.UsingGroup,
-				Other:     other,
+				ChannelId:    relayInfo.ChannelId,
+				ModelName:    modelName,
+				TokenName:    tokenName,
+				Quota:        priceData.Quota,
+				UpstreamCost: service.UpstreamModelCost(c, modelName, 0, 0, priceData.Quota, priceData.GroupRatioInfo.GroupRatio),
+				Content:      logContent,
+				TokenId:      relayInfo.TokenId,
+				Group:        relayInfo.UsingGroup,
+				Other:        other,
 			})
 			model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, priceData.Quota)
 			model.UpdateChannelUsedQuota(relayInfo.ChannelId, priceData.Quota)
+			service.RecordChannelKeyUsage(relayInfo, priceData.Quota)
 		}
 	}()
 
@@ -0,0 +1,88 @@
+package relay
+
+import (
+	"bytes"
+	"time"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OllamaHelper relays an inbound Ollama request. Chat and generate go through
+// the canonical IR, /api/embed is sent as an OpenAI embedding request and its
+// answer rewritten.
+func OllamaHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	if _, ok := info.Request.(*dto.EmbeddingRequest); !ok {
+		return IRHelper(c, info)
+	}
+	if newAPIError := checkConvertible(info); newAPIError != nil {
+		return newAPIError
+	}
+
+	writer := &ollamaEmbeddingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	info.RelayFormat = types.RelayFormatEmbedding
+	defer func() {
+		c.Writer = writer.ResponseWriter
+		info.RelayFormat = types.RelayFormatOllama
+	}()
+
+	if newAPIError := EmbeddingHelper(c, info); newAPIError != nil {
+		return newAPIError
+	}
+	writer.finish(info.OriginModelName, info.StartTime)
+	return nil
+}
+
+// ollamaEmbeddingWriter holds back the OpenAI embedding response until it can
+// be rendered as an /api/embed response.
+type ollamaEmbeddingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *ollamaEmbeddingWriter) WriteHeader(code int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ollamaEmbeddingWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *ollamaEmbeddingWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *ollamaEmbeddingWriter) finish(model string, start time.Time) {
+	var resp dto.EmbeddingResponse
+	if err := common.Unmarshal(w.body.Bytes(), &resp); err != nil || len(resp.Data) == 0 {
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+	embeddings := make([][]float64, len(resp.Data))
+	for i, item := range resp.Data {
+		index := item.Index
+		if index < 0 || index >= len(embeddings) {
+			index = i
+		}
+		embeddings[index] = item.Embedding
+	}
+	jsonData, err := common.Marshal(dto.OllamaEmbedResponse{
+		Model:           model,
+		Embeddings:      embeddings,
+		TotalDuration:   time.Since(start).Nanoseconds(),
+		PromptEvalCount: resp.PromptTokens,
+	})
+	if err != nil {
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.ResponseWriter.Write(jsonData)
+}
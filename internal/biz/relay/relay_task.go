@@ -152,13 +152,12 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 
 	// 预扣
 	groupRatio := ratio_setting.GetGroupRatio(info.UsingGroup)
-	var ratio float64
 	userGroupRatio, hasUserGroupRatio := ratio_setting.GetGroupGroupRatio(info.UserGroup, info.UsingGroup)
+	billedGroupRatio := groupRatio
 	if hasUserGroupRatio {
-		ratio = modelPrice * userGroupRatio
-	} else {
-		ratio = modelPrice * groupRatio
+		billedGroupRatio = userGroupRatio
 	}
+	ratio := modelPrice * billedGroupRatio
 	// FIXME: 临时修补，支持任务仅按次计费
 	if !common.StringsContains(constant.TaskPricePatches, modelName) {
 		if len(info.PriceData.OtherRatios) > 0 {
@@ -241,17 +240,19 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 					other["user_group_ratio"] = userGroupRatio
 				}
 				model.RecordConsumeLog(c, info.UserId, model.RecordConsumeLogParams{
-					ChannelId: info.ChannelId,
-					ModelName: modelName,
-					TokenName: tokenName,
-					Quota:     quota,
-					Content:   logContent,
-					TokenId:   info.TokenId,
-					Group:     info.UsingGroup,
-					Other:     other,
+					ChannelId:    info.ChannelId,
+					ModelName:    modelName,
+					TokenName:    tokenName,
+					Quota:        quota,
+					UpstreamCost: service.UpstreamModelCost(c, modelName, 0, 0, quota, billedGroupRatio),
+					Content:      logContent,
+					TokenId:      info.TokenId,
+					Group:        info.UsingGroup,
+					Other:        other,
 				})
 				model.UpdateUserUsedQuotaAndRequestCount(info.UserId, quota)
 				model.UpdateChannelUsedQuota(info.ChannelId, quota)
+				service.RecordChannelKeyUsage(info, quota)
 			}
 		}
 	}()
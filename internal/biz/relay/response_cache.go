@@ -0,0 +1,195 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseCacheRecorder tees what the adaptor writes to the client, so the
+// response can be stored once the request completed.
+type responseCacheRecorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *responseCacheRecorder) record(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > w.limit {
+		w.overflow = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *responseCacheRecorder) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCacheRecorder) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+type responseCacheLookup struct {
+	key    string
+	scope  string
+	vector []float64
+}
+
+// responseCacheStore records the response of an attempt for its lookup.
+type responseCacheStore struct {
+	*responseCacheLookup
+	recorder *responseCacheRecorder
+}
+
+// ServeResponseCache answers the request from the response cache when the
+// token or group opted in, before a channel is picked and a slot taken for it.
+// Chat completions also match semantically, with the prompt embedded by embed.
+// On a miss the lookup is kept for the handler to record the response and
+// store it. Clients can skip the lookup with Cache-Control: no-cache and the
+// store with no-store.
+func ServeResponseCache(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request, embed service.ResponseCacheEmbedder) bool {
+	// only the handlers of chat completions and embeddings record responses
+	semantic := false
+	switch request.(type) {
+	case *dto.GeneralOpenAIRequest:
+		if info.RelayFormat != types.RelayFormatOpenAI {
+			return false
+		}
+		semantic = true
+	case *dto.EmbeddingRequest:
+		if info.RelayFormat != types.RelayFormatEmbedding {
+			return false
+		}
+	default:
+		return false
+	}
+	if !service.ResponseCacheEnabled(c, info.UsingGroup) {
+		return false
+	}
+	key, scope, err := service.ResponseCacheKeys(info.UserId, request)
+	if err != nil {
+		logger.LogWarn(c, "failed to build response cache key: "+err.Error())
+		return false
+	}
+	setting := operation_setting.GetResponseCacheSetting()
+	cacheControl := strings.ToLower(c.GetHeader("Cache-Control"))
+	lookup := &responseCacheLookup{key: key, scope: scope}
+
+	if !strings.Contains(cacheControl, "no-cache") {
+		if entry := service.GetResponseCache(key); entry != nil {
+			replayResponseCache(c, info, entry, "exact")
+			return true
+		}
+		if semantic && setting.SemanticEnabled {
+			entry, vector, err := service.FindSemanticResponseCache(c, info.UsingGroup, scope, request.GetTokenCountMeta().CombineText, embed)
+			if err != nil {
+				logger.LogWarn(c, "semantic response cache lookup failed: "+err.Error())
+			}
+			if entry != nil {
+				replayResponseCache(c, info, entry, "semantic")
+				return true
+			}
+			lookup.vector = vector
+		}
+	}
+	common.SetContextKey(c, constant.ContextKeyResponseCacheLookup, lookup)
+	return false
+}
+
+// recordResponseCache records the response of the attempt when
+// ServeResponseCache missed, and returns the store to keep it with.
+func recordResponseCache(c *gin.Context) *responseCacheStore {
+	lookup, ok := common.GetContextKeyType[*responseCacheLookup](c, constant.ContextKeyResponseCacheLookup)
+	if !ok || lookup == nil || strings.Contains(strings.ToLower(c.GetHeader("Cache-Control")), "no-store") {
+		return nil
+	}
+	limit := operation_setting.GetResponseCacheSetting().MaxBodyKB * 1024
+	store := &responseCacheStore{responseCacheLookup: lookup, recorder: &responseCacheRecorder{ResponseWriter: c.Writer, limit: limit}}
+	c.Writer = store.recorder
+	return store
+}
+
+// release puts the original writer back.
+func (l *responseCacheStore) release(c *gin.Context) {
+	if l == nil || l.recorder == nil {
+		return
+	}
+	c.Writer = l.recorder.ResponseWriter
+}
+
+// store keeps the recorded response if it was sent in full. Streams must have
+// reached [DONE], anything cut short by the client is dropped.
+func (l *responseCacheStore) store(c *gin.Context, info *relaycommon.RelayInfo, usage *dto.Usage) {
+	if l == nil || l.recorder == nil {
+		return
+	}
+	recorder := l.recorder
+	if recorder.overflow || recorder.Status() != http.StatusOK || c.Request.Context().Err() != nil {
+		return
+	}
+	if usage == nil || usage.PromptTokens+usage.CompletionTokens == 0 {
+		return
+	}
+	body := bytes.Clone(recorder.body.Bytes())
+	if info.IsStream && !bytes.Contains(body, []byte("data: [DONE]")) {
+		return
+	}
+	entry := &service.ResponseCacheEntry{
+		Stream:    info.IsStream,
+		Body:      body,
+		Usage:     *usage,
+		CreatedAt: common.GetTimestamp(),
+	}
+	if err := service.SetResponseCache(l.key, entry); err != nil {
+		logger.LogWarn(c, "failed to store response cache: "+err.Error())
+		return
+	}
+	if l.vector != nil {
+		service.AddSemanticResponseCache(l.scope, l.key, l.vector)
+	}
+}
+
+// replayResponseCache sends a cached response and bills the original usage at
+// the cache hit ratio.
+func replayResponseCache(c *gin.Context, info *relaycommon.RelayInfo, entry *service.ResponseCacheEntry, match string) {
+	info.SetFirstResponseTime()
+	if entry.Stream {
+		helper.SetEventStreamHeaders(c)
+		_, _ = c.Writer.Write(entry.Body)
+		_ = helper.FlushWriter(c)
+	} else {
+		c.Data(http.StatusOK, "application/json", entry.Body)
+	}
+	logger.LogInfo(c, fmt.Sprintf("response served from cache (%s match)", match))
+
+	common.SetContextKey(c, constant.ContextKeyResponseCacheHit, match)
+	// the response was served before any channel was picked
+	info.ChannelMeta = &relaycommon.ChannelMeta{}
+	if info.PriceData.OtherRatios == nil {
+		info.PriceData.OtherRatios = map[string]float64{}
+	}
+	info.PriceData.OtherRatios["response_cache_hit"] = operation_setting.GetResponseCacheSetting().HitRatio
+	usage := entry.Usage
+	postConsumeQuota(c, info, &usage)
+}
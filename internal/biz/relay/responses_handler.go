@@ -2,6 +2,7 @@ package relay
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/channel"
 	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
 	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
 	"github.com/QuantumNous/lurus-api/internal/biz/service"
@@ -41,16 +43,46 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		return types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
 	}
 	adaptor.Init(info)
+
+	if newAPIError = expandPreviousResponse(info, request); newAPIError != nil {
+		return newAPIError
+	}
+	// the response of a shadow attempt never reaches the client, it is not
+	// stored for it to continue from
+	if shouldStoreResponse(responsesReq) && relaycommon.GetShadowAttempt(c) == nil {
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		defer func() {
+			c.Writer = recorder.ResponseWriter
+			if newAPIError == nil {
+				storeResponse(c, info, responsesReq, recorder)
+			}
+		}()
+	}
+
 	var requestBody io.Reader
 	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
 		}
+		if responsesReq.PreviousResponseID != "" && request.PreviousResponseID == "" {
+			// the upstream cannot resolve the id, send the expanded history
+			if body, err = expandPassThroughBody(body, request); err != nil {
+				return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+			}
+		}
 		requestBody = bytes.NewBuffer(body)
 	} else {
 		convertedRequest, err := adaptor.ConvertOpenAIResponsesRequest(c, info, *request)
 		if err != nil {
+			if errors.Is(err, channel.ErrNotImplemented) {
+				// IRHelper reads info.Request, hand it the expanded history
+				info.Request = request
+				newAPIError = IRHelper(c, info)
+				info.Request = responsesReq
+				return newAPIError
+			}
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
 		}
 		jsonData, err := common.Marshal(convertedRequest)
@@ -0,0 +1,172 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+)
+
+func shouldStoreResponse(request *dto.OpenAIResponsesRequest) bool {
+	return constant.ResponseStoreEnabled && strings.TrimSpace(string(request.Store)) != "false"
+}
+
+// supportsPreviousResponse reports whether the channel can resolve a response
+// id by itself, which only holds for the OpenAI upstream that produced it.
+func supportsPreviousResponse(info *relaycommon.RelayInfo, previous *model.StoredResponse) bool {
+	if previous.ChannelId != info.ChannelId {
+		return false
+	}
+	return info.ChannelType == constant.ChannelTypeOpenAI || info.ChannelType == constant.ChannelTypeAzure
+}
+
+// expandPreviousResponse replaces previous_response_id with the stored
+// conversation when the channel cannot resolve it. Unknown ids are left for
+// the upstream to resolve.
+func expandPreviousResponse(info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest) *types.NewAPIError {
+	if request.PreviousResponseID == "" {
+		return nil
+	}
+	previous, err := model.GetStoredResponse(info.UserId, request.PreviousResponseID)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if previous == nil || supportsPreviousResponse(info, previous) {
+		return nil
+	}
+	history, err := service.ExpandResponseHistory(previous)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	input, err := service.NormalizeResponsesInput(request.Input)
+	if err != nil {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid input: %w", err), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	request.Input, err = common.Marshal(append(history, input...))
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	request.PreviousResponseID = ""
+	return nil
+}
+
+// expandPassThroughBody puts the history expandPreviousResponse built into a
+// body that is passed through as is, leaving the other fields untouched.
+func expandPassThroughBody(body []byte, request *dto.OpenAIResponsesRequest) ([]byte, error) {
+	body, err := sjson.SetRawBytes(body, "input", request.Input)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.DeleteBytes(body, "previous_response_id")
+}
+
+// responseRecorder keeps a copy of what is written to the client, up to the
+// store size limit, so the response object can be stored afterwards.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.record(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.record([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *responseRecorder) record(data []byte) {
+	if r.overflow {
+		return
+	}
+	if r.body.Len()+len(data) > constant.ResponseStoreMaxKB<<10 {
+		r.overflow = true
+		r.body.Reset()
+		return
+	}
+	r.body.Write(data)
+}
+
+// responseObject extracts the final response object from a JSON body or from
+// the response.completed event of a stream.
+func (r *responseRecorder) responseObject() json.RawMessage {
+	body := bytes.TrimSpace(r.body.Bytes())
+	if len(body) == 0 {
+		return nil
+	}
+	if body[0] == '{' {
+		return body
+	}
+	var object json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64<<10), len(body)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var event struct {
+			Type     string          `json:"type"`
+			Response json.RawMessage `json:"response"`
+		}
+		if err := common.UnmarshalJsonStr(strings.TrimSpace(strings.TrimPrefix(line, "data:")), &event); err != nil {
+			continue
+		}
+		if event.Type == "response.completed" || event.Type == "response.incomplete" {
+			object = event.Response
+		}
+	}
+	return object
+}
+
+func storeResponse(c *gin.Context, info *relaycommon.RelayInfo, origin *dto.OpenAIResponsesRequest, recorder *responseRecorder) {
+	if recorder.overflow {
+		logger.LogWarn(c, "response not stored: "+service.ErrStoredResponseTooLarge.Error())
+		return
+	}
+	object := recorder.responseObject()
+	var response struct {
+		ID string `json:"id"`
+	}
+	if object == nil || common.Unmarshal(object, &response) != nil || response.ID == "" {
+		return
+	}
+	input, err := service.NormalizeResponsesInput(origin.Input)
+	if err != nil {
+		return
+	}
+	stored := &model.StoredResponse{
+		ResponseId:         response.ID,
+		PreviousResponseId: origin.PreviousResponseID,
+		UserId:             info.UserId,
+		TokenId:            info.TokenId,
+		ChannelId:          info.ChannelId,
+		Model:              info.OriginModelName,
+	}
+	err = service.SaveStoredResponse(stored, &service.StoredResponseContent{Input: input, Response: object})
+	if err != nil {
+		if errors.Is(err, service.ErrStoredResponseTooLarge) {
+			logger.LogWarn(c, "response not stored: "+err.Error())
+			return
+		}
+		logger.LogError(c, fmt.Sprintf("failed to store response %s: %s", response.ID, err.Error()))
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"net/http"
 	"strings"
 
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
 	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
 	"github.com/QuantumNous/lurus-api/internal/data/model"
 	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
 	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
 )
 
 func formatNotifyType(channelId int, status int) string {
@@ -110,3 +114,32 @@ func ShouldEnableChannel(newAPIError *types.NewAPIError, status int) bool {
 	}
 	return true
 }
+
+// RecordChannelKeyUsage counts a billed request against the key used on a
+// multi-key channel and disables the key once it reaches its spend cap.
+func RecordChannelKeyUsage(relayInfo *relaycommon.RelayInfo, quota int) {
+	if relayInfo.ChannelMeta == nil || !relayInfo.ChannelIsMultiKey {
+		return
+	}
+	channelId, keyIndex, key := relayInfo.ChannelId, relayInfo.ChannelMultiKeyIndex, relayInfo.ApiKey
+	gopool.Go(func() {
+		usedQuota, err := model.AddChannelKeyUsage(channelId, key, quota)
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to record channel key usage: channel_id=%d, key_index=%d, error=%v", channelId, keyIndex, err))
+			return
+		}
+		channel, err := model.CacheGetChannel(channelId)
+		if err != nil {
+			return
+		}
+		limit := channel.ChannelInfo.MultiKeyQuotaLimits[keyIndex]
+		if limit <= 0 || usedQuota < int64(limit) {
+			return
+		}
+		reason := fmt.Sprintf("密钥 #%d 已用额度 %s 达到上限 %s", keyIndex, logger.FormatQuota(int(usedQuota)), logger.FormatQuota(limit))
+		if model.UpdateChannelStatus(channelId, key, common.ChannelStatusAutoDisabled, reason) {
+			subject := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被禁用", channel.Name, channelId, keyIndex)
+			NotifyRootUser(formatNotifyType(channelId, common.ChannelStatusAutoDisabled), subject, reason)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+)
+
+var (
+	ErrChannelQueueFull    = errors.New("channel wait queue is full")
+	ErrChannelQueueTimeout = errors.New("timed out waiting for a channel slot")
+)
+
+// slots released on other nodes are only noticed by polling
+const channelQueuePollInterval = 200 * time.Millisecond
+
+var (
+	channelQueues    = make(map[string][]chan struct{})
+	channelQueueLock sync.Mutex
+)
+
+func isChannelQueueHead(queueKey string, ticket chan struct{}) bool {
+	channelQueueLock.Lock()
+	defer channelQueueLock.Unlock()
+	queue := channelQueues[queueKey]
+	return len(queue) > 0 && queue[0] == ticket
+}
+
+func leaveChannelQueue(queueKey string, ticket chan struct{}) {
+	channelQueueLock.Lock()
+	defer channelQueueLock.Unlock()
+	queue := channelQueues[queueKey]
+	for i, t := range queue {
+		if t != ticket {
+			continue
+		}
+		queue = append(queue[:i:i], queue[i+1:]...)
+		if len(queue) == 0 {
+			delete(channelQueues, queueKey)
+			return
+		}
+		channelQueues[queueKey] = queue
+		if i == 0 {
+			// wake up the new head
+			select {
+			case queue[0] <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+}
+
+// WaitForChannelSlot queues the request behind the others waiting on the same
+// key and calls acquire whenever it is at the head of the queue and a slot may
+// have been freed, until acquire succeeds, the wait times out or ctx is done.
+func WaitForChannelSlot(ctx context.Context, queueKey string, acquire func() bool) error {
+	setting := operation_setting.GetChannelConcurrencySetting()
+	ticket := make(chan struct{}, 1)
+	channelQueueLock.Lock()
+	if len(channelQueues[queueKey]) >= setting.QueueSize {
+		channelQueueLock.Unlock()
+		return ErrChannelQueueFull
+	}
+	channelQueues[queueKey] = append(channelQueues[queueKey], ticket)
+	channelQueueLock.Unlock()
+	defer leaveChannelQueue(queueKey, ticket)
+
+	timeout := time.NewTimer(time.Duration(setting.QueueTimeoutSeconds) * time.Second)
+	defer timeout.Stop()
+	poll := time.NewTicker(channelQueuePollInterval)
+	defer poll.Stop()
+	for {
+		released := model.ChannelSlotReleased()
+		if isChannelQueueHead(queueKey, ticket) && acquire() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return ErrChannelQueueTimeout
+		case <-ticket:
+		case <-released:
+		case <-poll.C:
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+)
+
+// TestWaitForChannelSlot tests that waiters get freed slots in arrival order
+// and that the queue is bounded
+func TestWaitForChannelSlot(t *testing.T) {
+	setting := operation_setting.GetChannelConcurrencySetting()
+	original := *setting
+	*setting = operation_setting.ChannelConcurrencySetting{QueueSize: 3, QueueTimeoutSeconds: 5}
+	defer func() {
+		*setting = original
+	}()
+
+	var lock sync.Mutex
+	free := 0
+	var order []int
+	acquire := func(i int) func() bool {
+		return func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			if free == 0 {
+				return false
+			}
+			free--
+			order = append(order, i)
+			return true
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := WaitForChannelSlot(context.Background(), "test", acquire(i)); err != nil {
+				t.Errorf("waiter %d: %v", i, err)
+			}
+		}(i)
+		// make sure the waiters enqueue in order
+		for {
+			channelQueueLock.Lock()
+			n := len(channelQueues["test"])
+			channelQueueLock.Unlock()
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if err := WaitForChannelSlot(context.Background(), "test", acquire(3)); err != ErrChannelQueueFull {
+		t.Errorf("err = %v, want ErrChannelQueueFull", err)
+	}
+
+	lock.Lock()
+	free = 3
+	lock.Unlock()
+	wg.Wait()
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("order = %v", order)
+	}
+}
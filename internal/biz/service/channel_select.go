@@ -96,6 +96,7 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 		// startGroupIndex: 开始搜索的分组索引
 		startGroupIndex := 0
 		crossGroupRetry := common.GetContextKeyBool(param.Ctx, constant.ContextKeyTokenCrossGroupRetry)
+		var lastWindowErr error
 
 		if lastGroupIndex, exists := common.GetContextKey(param.Ctx, constant.ContextKeyAutoGroupIndex); exists {
 			if idx, ok := lastGroupIndex.(int); ok {
@@ -115,8 +116,13 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			}
 			logger.LogDebug(param.Ctx, "Auto selecting group: %s, priorityRetry: %d", autoGroup, priorityRetry)
 
-			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, param.ModelName, priorityRetry)
+			channel, err = model.GetAffinitySatisfiedChannel(autoGroup, param.ModelName, priorityRetry, channelDemand(param.Ctx, autoGroup))
 			if channel == nil {
+				var windowErr *model.ContextWindowError
+				if errors.As(err, &windowErr) {
+					// reported when no group has a channel the request fits
+					lastWindowErr = err
+				}
 				// Current group has no available channel for this model, try next group
 				// 当前分组没有该模型的可用渠道，尝试下一个分组
 				logger.LogDebug(param.Ctx, "No available channel in group %s for model %s at priorityRetry %d, trying next group", autoGroup, param.ModelName, priorityRetry)
@@ -152,8 +158,11 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			}
 			break
 		}
+		if channel == nil && lastWindowErr != nil {
+			return nil, selectGroup, lastWindowErr
+		}
 	} else {
-		channel, err = model.GetRandomSatisfiedChannel(param.TokenGroup, param.ModelName, param.GetRetry())
+		channel, err = model.GetAffinitySatisfiedChannel(param.TokenGroup, param.ModelName, param.GetRetry(), channelDemand(param.Ctx, param.TokenGroup))
 		if err != nil {
 			return nil, param.TokenGroup, err
 		}
@@ -0,0 +1,397 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContextCompactionHeader tells the client how its request was compacted.
+const ContextCompactionHeader = "X-Context-Compaction"
+
+const contextSummaryPrompt = "Summarize the earlier part of a conversation between a user and an AI assistant so that the assistant can continue it without the original messages. " +
+	"Keep facts, decisions, open tasks, tool results that are still relevant, file names and identifiers. Reply with the summary only."
+
+const contextSummaryPrefix = "Summary of the earlier conversation:\n"
+
+// ContextCompaction records how a request was compacted to fit the context
+// window of its model.
+type ContextCompaction struct {
+	Mode                 string `json:"mode"`
+	PromptTokens         int    `json:"prompt_tokens"`    // estimated before the compaction
+	CompactedTokens      int    `json:"compacted_tokens"` // estimated after the compaction
+	DroppedMessages      int    `json:"dropped_messages,omitempty"`
+	TruncatedToolOutputs int    `json:"truncated_tool_outputs,omitempty"`
+	SummaryModel         string `json:"summary_model,omitempty"`
+	SummaryError         string `json:"summary_error,omitempty"` // the summary failed and the turns were dropped
+}
+
+// ContextSummarizer summarizes the transcript of the turns a compaction drops
+// with the summary model in group, as a request of its own that is priced,
+// retried and logged like any other.
+type ContextSummarizer func(c *gin.Context, group string, transcript string) (string, error)
+
+// HeaderValue returns the value of the ContextCompactionHeader response header.
+func (r *ContextCompaction) HeaderValue() string {
+	parts := []string{
+		"mode=" + r.Mode,
+		fmt.Sprintf("tokens=%d->%d", r.PromptTokens, r.CompactedTokens),
+	}
+	if r.DroppedMessages > 0 {
+		parts = append(parts, fmt.Sprintf("dropped_messages=%d", r.DroppedMessages))
+	}
+	if r.TruncatedToolOutputs > 0 {
+		parts = append(parts, fmt.Sprintf("truncated_tool_outputs=%d", r.TruncatedToolOutputs))
+	}
+	if r.SummaryModel != "" {
+		parts = append(parts, "summary_model="+r.SummaryModel)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ContextCompactionMode returns the compaction mode the token of the request
+// opted in to, empty when it did not.
+func ContextCompactionMode(c *gin.Context) string {
+	return common.GetContextKeyString(c, constant.ContextKeyTokenContextCompaction)
+}
+
+func SetContextCompaction(c *gin.Context, compaction *ContextCompaction) {
+	common.SetContextKey(c, constant.ContextKeyContextCompaction, compaction)
+}
+
+// GetContextCompaction returns how the request was compacted, nil if it was
+// not.
+func GetContextCompaction(c *gin.Context) *ContextCompaction {
+	compaction, _ := common.GetContextKeyType[*ContextCompaction](c, constant.ContextKeyContextCompaction)
+	return compaction
+}
+
+// compactionConversation is the message list of a request as context
+// compaction sees it. Messages are indexed oldest first.
+type compactionConversation interface {
+	len() int
+	// text returns the transcript of a message, used to count its tokens and
+	// to summarize it
+	text(i int) string
+	// pinned reports whether the message is always kept, like system messages
+	pinned(i int) bool
+	// turnStart reports whether a user turn starts at the message, the only
+	// places the history may be cut without orphaning tool results
+	turnStart(i int) bool
+	// truncateToolOutputs truncates the tool outputs of the message longer
+	// than maxTokens and returns how many it truncated
+	truncateToolOutputs(i int, maxTokens int, modelName string) int
+	// cut drops the unpinned messages before from and puts summary in their
+	// place when it is not empty
+	cut(from int, summary string)
+}
+
+func newCompactionConversation(request dto.Request) compactionConversation {
+	switch r := request.(type) {
+	case *dto.GeneralOpenAIRequest:
+		if len(r.Messages) > 0 {
+			return &openAICompaction{request: r}
+		}
+	case *dto.ClaudeRequest:
+		if len(r.Messages) > 0 {
+			return &claudeCompaction{request: r}
+		}
+	}
+	return nil
+}
+
+// truncateCompactionText cuts text down to about maxTokens of its tokens.
+func truncateCompactionText(text string, tokens int, maxTokens int) string {
+	runes := []rune(text)
+	keep := len(runes) * maxTokens / tokens
+	return string(runes[:keep]) + fmt.Sprintf("\n...[truncated %d of %d tokens]", tokens-maxTokens, tokens)
+}
+
+type openAICompaction struct {
+	request *dto.GeneralOpenAIRequest
+}
+
+func (o *openAICompaction) len() int {
+	return len(o.request.Messages)
+}
+
+func (o *openAICompaction) text(i int) string {
+	message := &o.request.Messages[i]
+	text := message.Role + ": " + message.StringContent()
+	if len(message.ToolCalls) > 0 {
+		text += "\n" + string(message.ToolCalls)
+	}
+	return text
+}
+
+func (o *openAICompaction) pinned(i int) bool {
+	role := o.request.Messages[i].Role
+	return role == "system" || role == "developer"
+}
+
+func (o *openAICompaction) turnStart(i int) bool {
+	return o.request.Messages[i].Role == "user"
+}
+
+func (o *openAICompaction) truncateToolOutputs(i int, maxTokens int, modelName string) int {
+	message := &o.request.Messages[i]
+	if message.Role != "tool" {
+		return 0
+	}
+	content := message.StringContent()
+	if tokens := CountTextToken(content, modelName); tokens > maxTokens {
+		message.SetStringContent(truncateCompactionText(content, tokens, maxTokens))
+		return 1
+	}
+	return 0
+}
+
+func (o *openAICompaction) cut(from int, summary string) {
+	messages := make([]dto.Message, 0, len(o.request.Messages)-from+2)
+	for i := 0; i < from; i++ {
+		if o.pinned(i) {
+			messages = append(messages, o.request.Messages[i])
+		}
+	}
+	if summary != "" {
+		message := dto.Message{Role: "system"}
+		message.SetStringContent(contextSummaryPrefix + summary)
+		messages = append(messages, message)
+	}
+	o.request.Messages = append(messages, o.request.Messages[from:]...)
+}
+
+type claudeCompaction struct {
+	request *dto.ClaudeRequest
+}
+
+func (o *claudeCompaction) len() int {
+	return len(o.request.Messages)
+}
+
+// blocks returns the content blocks of a message, a string content as a text
+// block.
+func (o *claudeCompaction) blocks(i int) []dto.ClaudeMediaMessage {
+	message := &o.request.Messages[i]
+	if message.IsStringContent() {
+		block := dto.ClaudeMediaMessage{Type: dto.ContentTypeText}
+		block.SetText(message.GetStringContent())
+		return []dto.ClaudeMediaMessage{block}
+	}
+	blocks, _ := message.ParseContent()
+	return blocks
+}
+
+func (o *claudeCompaction) text(i int) string {
+	var b strings.Builder
+	b.WriteString(o.request.Messages[i].Role + ":")
+	for _, block := range o.blocks(i) {
+		b.WriteString("\n")
+		switch block.Type {
+		case "tool_use":
+			input, _ := common.Marshal(block.Input)
+			b.WriteString(block.Name + " " + string(input))
+		case "tool_result":
+			b.WriteString(block.GetStringContent())
+		default:
+			b.WriteString(block.GetText())
+		}
+	}
+	return b.String()
+}
+
+func (o *claudeCompaction) pinned(i int) bool {
+	return false
+}
+
+func (o *claudeCompaction) turnStart(i int) bool {
+	if o.request.Messages[i].Role != "user" {
+		return false
+	}
+	for _, block := range o.blocks(i) {
+		if block.Type == "tool_result" {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *claudeCompaction) truncateToolOutputs(i int, maxTokens int, modelName string) int {
+	if o.request.Messages[i].Role != "user" || o.request.Messages[i].IsStringContent() {
+		return 0
+	}
+	blocks := o.blocks(i)
+	truncated := 0
+	for j := range blocks {
+		if blocks[j].Type != "tool_result" {
+			continue
+		}
+		content := blocks[j].GetStringContent()
+		if tokens := CountTextToken(content, modelName); tokens > maxTokens {
+			blocks[j].SetContent(truncateCompactionText(content, tokens, maxTokens))
+			truncated++
+		}
+	}
+	if truncated > 0 {
+		o.request.Messages[i].SetContent(blocks)
+	}
+	return truncated
+}
+
+func (o *claudeCompaction) cut(from int, summary string) {
+	o.request.Messages = o.request.Messages[from:]
+	if summary != "" {
+		// the conversation has to start with the user, the summary leads the
+		// first kept user turn
+		block := dto.ClaudeMediaMessage{Type: dto.ContentTypeText}
+		block.SetText(contextSummaryPrefix + summary)
+		o.request.Messages[0].SetContent(append([]dto.ClaudeMediaMessage{block}, o.blocks(0)...))
+	}
+}
+
+// CompactContext compacts the conversation of request in place so that its
+// estimated prompt of tokens tokens takes at most budget tokens, in the way
+// mode tells. Tool outputs are truncated oldest first; dropping and
+// summarizing cut the history at the earliest user turn that fits and keep at
+// least the last one. A summary is made in group by summarize. It returns nil
+// when the request cannot be compacted.
+func CompactContext(c *gin.Context, request dto.Request, modelName string, mode string, tokens int, budget int, group string, summarize ContextSummarizer) (*ContextCompaction, error) {
+	conversation := newCompactionConversation(request)
+	if conversation == nil {
+		return nil, nil
+	}
+	setting := operation_setting.GetContextCompactionSetting()
+
+	messageTokens := make([]int, conversation.len())
+	sum := 0
+	for i := range messageTokens {
+		messageTokens[i] = CountTextToken(conversation.text(i), modelName)
+		sum += messageTokens[i]
+	}
+	// tools, system prompts and the like that compaction leaves alone
+	fixed := max(tokens-sum, 0)
+
+	result := &ContextCompaction{Mode: mode, PromptTokens: tokens}
+	switch mode {
+	case operation_setting.ContextCompactionTruncateTools:
+		total := tokens
+		for i := 0; i < conversation.len() && total > budget; i++ {
+			if conversation.truncateToolOutputs(i, setting.ToolOutputMaxTokens, modelName) == 0 {
+				continue
+			}
+			result.TruncatedToolOutputs++
+			truncated := CountTextToken(conversation.text(i), modelName)
+			total -= messageTokens[i] - truncated
+			messageTokens[i] = truncated
+		}
+		if result.TruncatedToolOutputs == 0 {
+			return nil, nil
+		}
+		result.CompactedTokens = total
+		return result, nil
+	case operation_setting.ContextCompactionDropOldest, operation_setting.ContextCompactionSummarize:
+	default:
+		return nil, fmt.Errorf("unknown context compaction mode %q", mode)
+	}
+
+	summaryTokens := 0
+	if mode == operation_setting.ContextCompactionSummarize {
+		summaryTokens = setting.SummaryMaxTokens
+	}
+	// kept[i] is what the conversation takes when it is cut at message i
+	kept := make([]int, conversation.len()+1)
+	for i := conversation.len() - 1; i >= 0; i-- {
+		kept[i] = kept[i+1] + messageTokens[i]
+	}
+	pinnedBefore := 0
+	cut := -1
+	for i := 0; i < conversation.len(); i++ {
+		if i > 0 && conversation.turnStart(i) && !conversation.pinned(i) {
+			cut = i
+			if fixed+pinnedBefore+kept[i]+summaryTokens <= budget {
+				break
+			}
+		}
+		if conversation.pinned(i) {
+			pinnedBefore += messageTokens[i]
+		}
+	}
+	if cut < 0 {
+		return nil, nil
+	}
+
+	var dropped []string
+	var droppedMessageTokens []int
+	droppedTokens := 0
+	for i := 0; i < cut; i++ {
+		if !conversation.pinned(i) {
+			dropped = append(dropped, conversation.text(i))
+			droppedMessageTokens = append(droppedMessageTokens, messageTokens[i])
+			droppedTokens += messageTokens[i]
+		}
+	}
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+	result.DroppedMessages = len(dropped)
+
+	summary := ""
+	if mode == operation_setting.ContextCompactionSummarize {
+		result.SummaryModel = setting.SummaryModel
+		// the summary model sees the newest of the dropped messages that fit
+		for droppedTokens > setting.SummaryInputTokens && len(dropped) > 1 {
+			droppedTokens -= droppedMessageTokens[0]
+			dropped, droppedMessageTokens = dropped[1:], droppedMessageTokens[1:]
+		}
+		var err error
+		summary, err = summarize(c, group, strings.Join(dropped, "\n\n"))
+		if err != nil {
+			result.SummaryError = err.Error()
+			summary = ""
+		}
+	}
+	conversation.cut(cut, summary)
+	result.CompactedTokens = fixed + pinnedBefore + kept[cut]
+	if summary != "" {
+		result.CompactedTokens += CountTextToken(contextSummaryPrefix+summary, modelName)
+	}
+	return result, nil
+}
+
+// NewContextSummaryRequest returns the request that summarizes transcript with
+// the summary model.
+func NewContextSummaryRequest(transcript string) *dto.GeneralOpenAIRequest {
+	setting := operation_setting.GetContextCompactionSetting()
+	system := dto.Message{Role: "system"}
+	system.SetStringContent(contextSummaryPrompt)
+	user := dto.Message{Role: "user"}
+	user.SetStringContent(transcript)
+	return &dto.GeneralOpenAIRequest{
+		Model:     setting.SummaryModel,
+		Messages:  []dto.Message{system, user},
+		MaxTokens: uint(setting.SummaryMaxTokens),
+	}
+}
+
+// ParseContextSummary returns the summary of a chat completion response body.
+func ParseContextSummary(body []byte) (string, error) {
+	var completion dto.OpenAITextResponse
+	if err := common.Unmarshal(body, &completion); err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 {
+		return "", errors.New("summary response has no choices")
+	}
+	summary := strings.TrimSpace(completion.Choices[0].Message.StringContent())
+	if summary == "" {
+		return "", errors.New("summary response is empty")
+	}
+	return summary, nil
+}
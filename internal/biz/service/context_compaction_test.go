@@ -0,0 +1,112 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func compactionTestRequest() *dto.GeneralOpenAIRequest {
+	long := strings.Repeat("lorem ipsum dolor sit amet ", 400)
+	request := &dto.GeneralOpenAIRequest{Model: "claude-sonnet-4"}
+	for _, m := range []struct{ role, content string }{
+		{"system", "be brief"},
+		{"user", "first question " + long},
+		{"assistant", "first answer"},
+		{"user", "second question " + long},
+		{"assistant", ""},
+		{"tool", "tool output " + long},
+		{"user", "third question"},
+	} {
+		message := dto.Message{Role: m.role}
+		message.SetStringContent(m.content)
+		request.Messages = append(request.Messages, message)
+	}
+	return request
+}
+
+func compactionTestTokens(request *dto.GeneralOpenAIRequest) int {
+	conversation := &openAICompaction{request: request}
+	tokens := 0
+	for i := 0; i < conversation.len(); i++ {
+		tokens += CountTextToken(conversation.text(i), request.Model)
+	}
+	return tokens
+}
+
+func TestCompactContextDropOldest(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	request := compactionTestRequest()
+	tokens := compactionTestTokens(request)
+
+	// only the last turn fits, the system message stays
+	compaction, err := CompactContext(c, request, request.Model, operation_setting.ContextCompactionDropOldest, tokens, 100, "default", nil)
+	if err != nil || compaction == nil {
+		t.Fatalf("compaction = %v, %v", compaction, err)
+	}
+	if compaction.DroppedMessages != 5 {
+		t.Errorf("dropped %d messages, want 5", compaction.DroppedMessages)
+	}
+	if len(request.Messages) != 2 || request.Messages[0].Role != "system" || request.Messages[1].StringContent() != "third question" {
+		t.Errorf("kept %v", request.Messages)
+	}
+	if compaction.CompactedTokens >= compaction.PromptTokens {
+		t.Errorf("compacted %d of %d tokens", compaction.CompactedTokens, compaction.PromptTokens)
+	}
+}
+
+func TestCompactContextTruncateTools(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	request := compactionTestRequest()
+	tokens := compactionTestTokens(request)
+
+	compaction, err := CompactContext(c, request, request.Model, operation_setting.ContextCompactionTruncateTools, tokens, tokens-100, "default", nil)
+	if err != nil || compaction == nil {
+		t.Fatalf("compaction = %v, %v", compaction, err)
+	}
+	if compaction.TruncatedToolOutputs != 1 || len(request.Messages) != 7 {
+		t.Errorf("truncated %d tool outputs of %d messages", compaction.TruncatedToolOutputs, len(request.Messages))
+	}
+	if content := request.Messages[5].StringContent(); !strings.Contains(content, "[truncated") {
+		t.Errorf("tool output not truncated: %.80s", content)
+	}
+}
+
+func TestCompactContextSummarize(t *testing.T) {
+	var transcript string
+	summarize := func(c *gin.Context, group string, text string) (string, error) {
+		transcript = text
+		return "they asked two questions", nil
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	request := compactionTestRequest()
+	tokens := compactionTestTokens(request)
+
+	compaction, err := CompactContext(c, request, request.Model, operation_setting.ContextCompactionSummarize, tokens, 2000, "default", summarize)
+	if err != nil || compaction == nil {
+		t.Fatalf("compaction = %v, %v", compaction, err)
+	}
+	if !strings.Contains(transcript, "first question") || strings.Contains(transcript, "third question") {
+		t.Errorf("summarized %.80s", transcript)
+	}
+	if len(request.Messages) != 3 || request.Messages[1].Role != "system" || !strings.HasSuffix(request.Messages[1].StringContent(), "they asked two questions") {
+		t.Errorf("kept %v", request.Messages)
+	}
+}
+
+func TestParseContextSummary(t *testing.T) {
+	summary, err := ParseContextSummary([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":" the summary "}}]}`))
+	if err != nil || summary != "the summary" {
+		t.Errorf("summary = %q, %v", summary, err)
+	}
+	if _, err := ParseContextSummary([]byte(`{"choices":[]}`)); err == nil {
+		t.Error("a response without choices parsed")
+	}
+}
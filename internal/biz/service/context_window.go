@@ -0,0 +1,33 @@
+package service
+
+import (
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetContextWindowDemand keeps the estimated prompt tokens of the request and
+// the output tokens it asks for: from then on channels are only selected for
+// it when their context window fits both.
+func SetContextWindowDemand(c *gin.Context, promptTokens int, maxTokens int) {
+	common.SetContextKey(c, constant.ContextKeyContextWindowDemand, model.ChannelDemand{
+		PromptTokens: promptTokens,
+		MaxTokens:    maxTokens,
+	})
+}
+
+// GetContextWindowDemand returns the demand kept by SetContextWindowDemand,
+// the zero demand fits every channel.
+func GetContextWindowDemand(c *gin.Context) model.ChannelDemand {
+	demand, _ := common.GetContextKeyType[model.ChannelDemand](c, constant.ContextKeyContextWindowDemand)
+	return demand
+}
+
+// channelDemand returns what the request asks of a channel selected in group.
+func channelDemand(c *gin.Context, group string) model.ChannelDemand {
+	demand := GetContextWindowDemand(c)
+	demand.AffinityKey = GetSessionAffinityKey(c, group)
+	return demand
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+)
+
+var ErrFileTooLarge = errors.New("file exceeds the maximum upload size")
+
+// size of the chunks stored files are split into, kept well below the packet
+// limits of the databases
+const fileChunkSize = 1 << 20
+
+func NewFileId() string {
+	return "file-" + common.GetRandomString(24)
+}
+
+func NewBatchId() string {
+	return "batch_" + common.GetRandomString(24)
+}
+
+// SaveStoredFile stores the content of reader in the database, replacing
+// any previous content of the file, and returns the size written. A positive
+// maxBytes limits the size.
+func SaveStoredFile(fileId string, reader io.Reader, maxBytes int64) (int64, error) {
+	if err := model.DeleteUserFileChunks(fileId); err != nil {
+		return 0, err
+	}
+	var size int64
+	buf := make([]byte, fileChunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			size += int64(n)
+			if maxBytes > 0 && size > maxBytes {
+				RemoveStoredFile(fileId)
+				return 0, ErrFileTooLarge
+			}
+			chunk := &model.UserFileChunk{FileId: fileId, Seq: seq, Data: append([]byte(nil), buf[:n]...)}
+			if insertErr := model.InsertUserFileChunk(chunk); insertErr != nil {
+				RemoveStoredFile(fileId)
+				return 0, insertErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, nil
+		}
+		if err != nil {
+			RemoveStoredFile(fileId)
+			return 0, err
+		}
+	}
+}
+
+// storedFileReader reads a stored file one chunk at a time.
+type storedFileReader struct {
+	fileId string
+	seq    int
+	buf    []byte
+	eof    bool
+}
+
+func (r *storedFileReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		chunk, err := model.GetUserFileChunk(r.fileId, r.seq)
+		if err != nil {
+			return 0, err
+		}
+		if chunk == nil {
+			r.eof = true
+			continue
+		}
+		r.seq++
+		r.buf = chunk.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// OpenStoredFile returns a reader of the content of a stored file, empty when
+// the file has none.
+func OpenStoredFile(fileId string) io.Reader {
+	return &storedFileReader{fileId: fileId}
+}
+
+func RemoveStoredFile(fileId string) {
+	if err := model.DeleteUserFileChunks(fileId); err != nil {
+		common.SysError(fmt.Sprintf("failed to remove stored file %s: %s", fileId, err.Error()))
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+)
+
+// TestStoredFileChunks tests that a stored file spanning several chunks reads
+// back whole and that the size limit leaves nothing behind
+func TestStoredFileChunks(t *testing.T) {
+	setupTestDB(t, &model.UserFileChunk{})
+	content := bytes.Repeat([]byte("0123456789abcdef"), fileChunkSize/16*2+100)
+
+	size, err := SaveStoredFile("file-test", bytes.NewReader(content), 0)
+	if err != nil || size != int64(len(content)) {
+		t.Fatalf("size = %d, %v", size, err)
+	}
+	data, err := io.ReadAll(OpenStoredFile("file-test"))
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("read %d bytes, %v, want %d", len(data), err, len(content))
+	}
+
+	if _, err := SaveStoredFile("file-test", bytes.NewReader(content), fileChunkSize); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("err = %v, want ErrFileTooLarge", err)
+	}
+	if data, _ := io.ReadAll(OpenStoredFile("file-test")); len(data) != 0 {
+		t.Errorf("%d bytes left after a failed save", len(data))
+	}
+}
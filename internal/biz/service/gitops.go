@@ -0,0 +1,684 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+
+	"gopkg.in/yaml.v3"
+)
+
+// options the configuration file can manage, see GitOpsConfig
+const (
+	gitOpsOptionGroups            = "UserUsableGroups"
+	gitOpsOptionGroupRatios       = "GroupRatio"
+	gitOpsOptionModelRatios       = "ModelRatio"
+	gitOpsOptionAutoGroups        = "AutoGroups"
+	gitOpsOptionSubscriptionPlans = "SubscriptionPlans"
+)
+
+// GitOpsConfig is the declarative configuration of a gateway, read from the
+// YAML or JSON file or directory in GITOPS_CONFIG_PATH. A section that is
+// left out is not managed; a section that is present replaces the whole
+// setting, so an empty list or map clears it.
+type GitOpsConfig struct {
+	Channels          []GitOpsChannel          `json:"channels"`
+	Groups            map[string]string        `json:"groups"` // 用户可选分组 -> 描述
+	GroupRatios       map[string]float64       `json:"group_ratios"`
+	ModelRatios       map[string]float64       `json:"model_ratios"`
+	AutoGroups        []string                 `json:"auto_groups"`
+	SubscriptionPlans []model.SubscriptionPlan `json:"subscription_plans"`
+}
+
+// GitOpsChannel is a channel of the configuration file, identified by its
+// name. Keys are never written in the file but read from an environment
+// variable, one key per line for multi-key channels.
+type GitOpsChannel struct {
+	Name           string            `json:"name"`
+	Type           int               `json:"type"`
+	KeyEnv         string            `json:"key_env"`
+	MultiKey       bool              `json:"multi_key"`
+	MultiKeyMode   string            `json:"multi_key_mode"`
+	BaseURL        string            `json:"base_url"`
+	Models         []string          `json:"models"`
+	Groups         []string          `json:"groups"`
+	Priority       int64             `json:"priority"`
+	Weight         uint              `json:"weight"`
+	Tag            string            `json:"tag"`
+	Remark         string            `json:"remark"`
+	TestModel      string            `json:"test_model"`
+	Enabled        *bool             `json:"enabled"`  // 不设置时不改变渠道状态，新建的渠道默认启用
+	AutoBan        *bool             `json:"auto_ban"` // 默认开启
+	ModelMapping   map[string]string `json:"model_mapping"`
+	Setting        map[string]any    `json:"setting"`  // dto.ChannelSettings
+	Settings       map[string]any    `json:"settings"` // dto.ChannelOtherSettings
+	ParamOverride  map[string]any    `json:"param_override"`
+	HeaderOverride map[string]any    `json:"header_override"`
+}
+
+// GitOpsChange is one difference between the configuration file and the
+// database.
+type GitOpsChange struct {
+	Kind   string   `json:"kind"` // channel 或 option
+	Name   string   `json:"name"`
+	Action string   `json:"action"` // create、update、adopt、delete、conflict 或 error
+	Fields []string `json:"fields,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// GitOpsStatus is the state of the reconciler shown in the admin API.
+type GitOpsStatus struct {
+	Enabled        bool           `json:"enabled"`
+	Path           string         `json:"path"`
+	AdoptExisting  bool           `json:"adopt_existing"`
+	ManagedOptions []string       `json:"managed_options"`
+	LastAppliedAt  int64          `json:"last_applied_at"`
+	LastChanges    []GitOpsChange `json:"last_changes"`
+	LastError      string         `json:"last_error,omitempty"`
+}
+
+var (
+	gitOpsLock   sync.Mutex
+	gitOpsStatus GitOpsStatus
+	// keys of the options the loaded configuration manages
+	gitOpsManagedOptions = make(map[string]bool)
+	gitOpsOptionsLock    sync.RWMutex
+)
+
+func gitOpsPath() string {
+	return os.Getenv("GITOPS_CONFIG_PATH")
+}
+
+func gitOpsAdoptExisting() bool {
+	return common.GetEnvOrDefaultBool("GITOPS_ADOPT_EXISTING", false)
+}
+
+// IsGitOpsManagedOption reports whether an option is set by the configuration
+// file and read-only in the admin API.
+func IsGitOpsManagedOption(key string) bool {
+	gitOpsOptionsLock.RLock()
+	defer gitOpsOptionsLock.RUnlock()
+	return gitOpsManagedOptions[key]
+}
+
+func GetGitOpsStatus() GitOpsStatus {
+	gitOpsLock.Lock()
+	defer gitOpsLock.Unlock()
+	status := gitOpsStatus
+	status.Path = gitOpsPath()
+	status.Enabled = status.Path != ""
+	status.AdoptExisting = gitOpsAdoptExisting()
+	return status
+}
+
+// gitOpsConfigFiles lists the configuration files at path, which is a file or
+// a directory of .yaml, .yml and .json files read in name order.
+func gitOpsConfigFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// gitOpsConfigVersion changes whenever a configuration file changes.
+func gitOpsConfigVersion(path string) string {
+	files, err := gitOpsConfigFiles(path)
+	if err != nil {
+		return "error:" + err.Error()
+	}
+	var version strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&version, "%s:%d:%d;", file, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return version.String()
+}
+
+// LoadGitOpsConfig reads the configuration at path. Files of a directory are
+// merged: channels, auto groups and plans are concatenated, maps are merged
+// with later files winning.
+func LoadGitOpsConfig(path string) (*GitOpsConfig, error) {
+	files, err := gitOpsConfigFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	merged := &GitOpsConfig{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// YAML is a superset of JSON; go through JSON to reuse the json tags
+		var raw any
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if raw == nil {
+			continue
+		}
+		jsonData, err := common.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		var config GitOpsConfig
+		if err := common.Unmarshal(jsonData, &config); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		merged.merge(&config)
+	}
+	return merged, nil
+}
+
+func mergeGitOpsMap[V any](dst map[string]V, src map[string]V) map[string]V {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]V, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+func (config *GitOpsConfig) merge(other *GitOpsConfig) {
+	if other.Channels != nil {
+		config.Channels = append(append([]GitOpsChannel{}, config.Channels...), other.Channels...)
+	}
+	config.Groups = mergeGitOpsMap(config.Groups, other.Groups)
+	config.GroupRatios = mergeGitOpsMap(config.GroupRatios, other.GroupRatios)
+	config.ModelRatios = mergeGitOpsMap(config.ModelRatios, other.ModelRatios)
+	if other.AutoGroups != nil {
+		config.AutoGroups = append(append([]string{}, config.AutoGroups...), other.AutoGroups...)
+	}
+	if other.SubscriptionPlans != nil {
+		config.SubscriptionPlans = append(append([]model.SubscriptionPlan{}, config.SubscriptionPlans...), other.SubscriptionPlans...)
+	}
+}
+
+// options returns the managed options with their desired JSON values.
+func (config *GitOpsConfig) options() (map[string]string, error) {
+	options := make(map[string]string)
+	set := func(key string, value any) error {
+		data, err := common.Marshal(value)
+		if err != nil {
+			return err
+		}
+		options[key] = string(data)
+		return nil
+	}
+	var err error
+	if config.Groups != nil {
+		err = errors.Join(err, set(gitOpsOptionGroups, config.Groups))
+	}
+	if config.GroupRatios != nil {
+		err = errors.Join(err, set(gitOpsOptionGroupRatios, config.GroupRatios))
+	}
+	if config.ModelRatios != nil {
+		err = errors.Join(err, set(gitOpsOptionModelRatios, config.ModelRatios))
+	}
+	if config.AutoGroups != nil {
+		err = errors.Join(err, set(gitOpsOptionAutoGroups, config.AutoGroups))
+	}
+	if config.SubscriptionPlans != nil {
+		err = errors.Join(err, set(gitOpsOptionSubscriptionPlans, config.SubscriptionPlans))
+	}
+	return options, err
+}
+
+func jsonString(value any) *string {
+	if value == nil || reflect.ValueOf(value).Len() == 0 {
+		return common.GetPointer("")
+	}
+	data, err := common.Marshal(value)
+	if err != nil {
+		return common.GetPointer("")
+	}
+	return common.GetPointer(string(data))
+}
+
+// channel builds the channel the spec describes.
+func (spec *GitOpsChannel) channel() (*model.Channel, error) {
+	if spec.Name == "" {
+		return nil, errors.New("channel name is required")
+	}
+	if len(spec.Models) == 0 {
+		return nil, errors.New("models is required")
+	}
+	if spec.KeyEnv == "" {
+		return nil, errors.New("key_env is required")
+	}
+	key := strings.TrimSpace(os.Getenv(spec.KeyEnv))
+	if key == "" {
+		return nil, fmt.Errorf("environment variable %s is empty", spec.KeyEnv)
+	}
+	groups := spec.Groups
+	if len(groups) == 0 {
+		groups = []string{"default"}
+	}
+	autoBan := 1
+	if spec.AutoBan != nil && !*spec.AutoBan {
+		autoBan = 0
+	}
+	channel := &model.Channel{
+		Name:           spec.Name,
+		Type:           spec.Type,
+		Key:            key,
+		BaseURL:        common.GetPointer(spec.BaseURL),
+		Models:         strings.Join(spec.Models, ","),
+		Group:          strings.Join(groups, ","),
+		Priority:       common.GetPointer(spec.Priority),
+		Weight:         common.GetPointer(spec.Weight),
+		Tag:            common.GetPointer(spec.Tag),
+		Remark:         common.GetPointer(spec.Remark),
+		TestModel:      common.GetPointer(spec.TestModel),
+		AutoBan:        common.GetPointer(autoBan),
+		ModelMapping:   jsonString(spec.ModelMapping),
+		Setting:        jsonString(spec.Setting),
+		ParamOverride:  jsonString(spec.ParamOverride),
+		HeaderOverride: jsonString(spec.HeaderOverride),
+		ManagedBy:      model.ChannelManagedByGitOps,
+	}
+	// an empty OtherSettings would be skipped by Channel.Update
+	channel.OtherSettings = "{}"
+	if settings := jsonString(spec.Settings); *settings != "" {
+		channel.OtherSettings = *settings
+	}
+	if spec.Enabled != nil {
+		channel.Status = common.ChannelStatusEnabled
+		if !*spec.Enabled {
+			channel.Status = common.ChannelStatusManuallyDisabled
+		}
+	}
+	if spec.MultiKey {
+		mode := constant.MultiKeyMode(spec.MultiKeyMode)
+		if mode == "" {
+			mode = constant.MultiKeyModeRandom
+		}
+		channel.ChannelInfo.IsMultiKey = true
+		channel.ChannelInfo.MultiKeyMode = mode
+		channel.ChannelInfo.MultiKeySize = len(strings.Split(key, "\n"))
+	}
+	return channel, nil
+}
+
+// gitOpsJSONEqual compares two JSON documents, an empty string being an
+// empty object.
+func gitOpsJSONEqual(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	if a == "" {
+		a = "{}"
+	}
+	if b == "" {
+		b = "{}"
+	}
+	var va, vb any
+	if common.UnmarshalJsonStr(a, &va) != nil || common.UnmarshalJsonStr(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// diffGitOpsChannel lists the fields of current that differ from desired.
+func diffGitOpsChannel(current *model.Channel, desired *model.Channel) []string {
+	var fields []string
+	diff := func(field string, changed bool) {
+		if changed {
+			fields = append(fields, field)
+		}
+	}
+	diff("type", current.Type != desired.Type)
+	diff("key", current.Key != desired.Key)
+	diff("base_url", stringValue(current.BaseURL) != stringValue(desired.BaseURL))
+	diff("models", current.Models != desired.Models)
+	diff("group", current.Group != desired.Group)
+	diff("priority", current.GetPriority() != *desired.Priority)
+	diff("weight", current.GetWeight() != int(*desired.Weight))
+	diff("tag", current.GetTag() != *desired.Tag)
+	diff("remark", stringValue(current.Remark) != *desired.Remark)
+	diff("test_model", stringValue(current.TestModel) != *desired.TestModel)
+	diff("auto_ban", current.GetAutoBan() != (*desired.AutoBan == 1))
+	diff("model_mapping", !gitOpsJSONEqual(stringValue(current.ModelMapping), *desired.ModelMapping))
+	diff("setting", !gitOpsJSONEqual(stringValue(current.Setting), *desired.Setting))
+	diff("settings", !gitOpsJSONEqual(current.OtherSettings, desired.OtherSettings))
+	diff("param_override", !gitOpsJSONEqual(stringValue(current.ParamOverride), *desired.ParamOverride))
+	diff("header_override", !gitOpsJSONEqual(stringValue(current.HeaderOverride), *desired.HeaderOverride))
+	diff("status", desired.Status != 0 && current.Status != desired.Status)
+	diff("multi_key", current.ChannelInfo.IsMultiKey != desired.ChannelInfo.IsMultiKey ||
+		(desired.ChannelInfo.IsMultiKey && current.ChannelInfo.MultiKeyMode != desired.ChannelInfo.MultiKeyMode))
+	return fields
+}
+
+// applyGitOpsChannel copies the managed fields of desired onto current,
+// keeping its runtime state.
+func applyGitOpsChannel(current *model.Channel, desired *model.Channel) {
+	current.Name = desired.Name
+	current.Type = desired.Type
+	current.Key = desired.Key
+	current.BaseURL = desired.BaseURL
+	current.Models = desired.Models
+	current.Group = desired.Group
+	current.Priority = desired.Priority
+	current.Weight = desired.Weight
+	current.Tag = desired.Tag
+	current.Remark = desired.Remark
+	current.TestModel = desired.TestModel
+	current.AutoBan = desired.AutoBan
+	current.ModelMapping = desired.ModelMapping
+	current.Setting = desired.Setting
+	current.OtherSettings = desired.OtherSettings
+	current.ParamOverride = desired.ParamOverride
+	current.HeaderOverride = desired.HeaderOverride
+	if desired.Status != 0 {
+		current.Status = desired.Status
+	}
+	current.ChannelInfo.IsMultiKey = desired.ChannelInfo.IsMultiKey
+	current.ChannelInfo.MultiKeyMode = desired.ChannelInfo.MultiKeyMode
+	current.ManagedBy = model.ChannelManagedByGitOps
+}
+
+// reconcileGitOpsChannels plans, and unless dryRun applies, the channel
+// changes. Channels with the name of a configured one that are not managed
+// yet are only taken over in adopt mode. Managed channels missing from specs
+// are deleted, unless a spec has an error: a broken or half-written file
+// must not delete the channels it failed to describe.
+func reconcileGitOpsChannels(specs []GitOpsChannel, adopt bool, dryRun bool) ([]GitOpsChange, error) {
+	existing, err := model.GetAllChannels(0, 0, true, true)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string][]*model.Channel)
+	for _, channel := range existing {
+		byName[channel.Name] = append(byName[channel.Name], channel)
+	}
+
+	var changes []GitOpsChange
+	var errs []error
+	record := func(change GitOpsChange, applyErr error) {
+		if applyErr != nil {
+			change.Error = applyErr.Error()
+			errs = append(errs, fmt.Errorf("channel %s: %w", change.Name, applyErr))
+		}
+		changes = append(changes, change)
+	}
+	seen := make(map[string]bool)
+	kept := make(map[int]bool)
+	specErr := false
+	keepManaged := func(name string) {
+		for _, channel := range byName[name] {
+			if channel.ManagedBy == model.ChannelManagedByGitOps {
+				kept[channel.Id] = true
+			}
+		}
+	}
+	// channels as they were before being changed, for their history
+	revised := make(map[int]*model.Channel)
+	var created []model.Channel
+	for i := range specs {
+		spec := &specs[i]
+		change := GitOpsChange{Kind: "channel", Name: spec.Name}
+		if seen[spec.Name] {
+			specErr = true
+			change.Action = "error"
+			record(change, errors.New("duplicate channel name"))
+			continue
+		}
+		seen[spec.Name] = true
+		desired, err := spec.channel()
+		if err != nil {
+			specErr = true
+			keepManaged(spec.Name)
+			change.Action = "error"
+			record(change, err)
+			continue
+		}
+
+		var current *model.Channel
+		for _, channel := range byName[spec.Name] {
+			if channel.ManagedBy == model.ChannelManagedByGitOps {
+				current = channel
+				break
+			}
+		}
+		if current == nil && len(byName[spec.Name]) > 0 {
+			if !adopt {
+				change.Action = "conflict"
+				record(change, errors.New("an unmanaged channel with this name exists, enable adopt mode to take it over"))
+				continue
+			}
+			current = byName[spec.Name][0]
+			change.Action = "adopt"
+		}
+
+		if current == nil {
+			change.Action = "create"
+			if !dryRun {
+				desired.CreatedTime = common.GetTimestamp()
+				if desired.Status == 0 {
+					desired.Status = common.ChannelStatusEnabled
+				}
+				err = desired.Insert()
+				if err == nil {
+					created = append(created, *desired)
+				}
+			}
+			record(change, err)
+			continue
+		}
+		kept[current.Id] = true
+		change.Fields = diffGitOpsChannel(current, desired)
+		if change.Action == "" {
+			if len(change.Fields) == 0 {
+				continue
+			}
+			change.Action = "update"
+		}
+		if !dryRun {
+			previous := *current
+			revised[current.Id] = &previous
+			applyGitOpsChannel(current, desired)
+			err = current.Update()
+		}
+		record(change, err)
+	}
+
+	for _, channel := range existing {
+		if specErr || channel.ManagedBy != model.ChannelManagedByGitOps || kept[channel.Id] {
+			continue
+		}
+		change := GitOpsChange{Kind: "channel", Name: channel.Name, Action: "delete"}
+		var err error
+		if !dryRun {
+			revised[channel.Id] = channel
+			err = channel.Delete()
+		}
+		record(change, err)
+	}
+	if err := model.RecordChannelRevisions(revised, model.ChannelRevisionActionGitOps, 0, "gitops"); err != nil {
+		common.SysError("failed to record channel revisions: " + err.Error())
+	}
+	if err := model.RecordChannelCreations(created, 0, "gitops"); err != nil {
+		common.SysError("failed to record channel revisions: " + err.Error())
+	}
+	return changes, errors.Join(errs...)
+}
+
+func currentGitOpsOption(key string) string {
+	if key == gitOpsOptionSubscriptionPlans {
+		data, _ := common.Marshal(model.GetAllSubscriptionPlans())
+		return string(data)
+	}
+	common.OptionMapRWMutex.RLock()
+	defer common.OptionMapRWMutex.RUnlock()
+	return common.OptionMap[key]
+}
+
+// reconcileGitOpsOptions plans, and unless dryRun applies, the option changes.
+func reconcileGitOpsOptions(options map[string]string, dryRun bool) ([]GitOpsChange, error) {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var changes []GitOpsChange
+	var errs []error
+	for _, key := range keys {
+		if gitOpsJSONEqual(currentGitOpsOption(key), options[key]) {
+			continue
+		}
+		change := GitOpsChange{Kind: "option", Name: key, Action: "update"}
+		if !dryRun {
+			var err error
+			if key == gitOpsOptionSubscriptionPlans {
+				var plans []model.SubscriptionPlan
+				if err = common.UnmarshalJsonStr(options[key], &plans); err == nil {
+					err = model.UpdateSubscriptionPlans(plans)
+				}
+			} else {
+				err = model.UpdateOption(key, options[key])
+			}
+			if err != nil {
+				change.Error = err.Error()
+				errs = append(errs, fmt.Errorf("option %s: %w", key, err))
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, errors.Join(errs...)
+}
+
+// ReconcileGitOps diffs the configuration file against the database and,
+// unless dryRun, applies the creates, updates and deletes. Changes that fail
+// are reported without stopping the others.
+func ReconcileGitOps(adopt bool, dryRun bool) ([]GitOpsChange, error) {
+	path := gitOpsPath()
+	if path == "" {
+		return nil, errors.New("GITOPS_CONFIG_PATH is not set")
+	}
+	config, err := LoadGitOpsConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	options, err := config.options()
+	if err != nil {
+		return nil, err
+	}
+
+	gitOpsLock.Lock()
+	defer gitOpsLock.Unlock()
+	var channelChanges []GitOpsChange
+	var channelErr error
+	if config.Channels != nil {
+		channelChanges, channelErr = reconcileGitOpsChannels(config.Channels, adopt, dryRun)
+	}
+	optionChanges, optionErr := reconcileGitOpsOptions(options, dryRun)
+	changes := append(channelChanges, optionChanges...)
+	err = errors.Join(channelErr, optionErr)
+	if dryRun {
+		return changes, err
+	}
+
+	if len(channelChanges) > 0 {
+		model.InitChannelCache()
+		ResetProxyClientCache()
+	}
+	setGitOpsManagedOptions(options)
+	gitOpsStatus.LastAppliedAt = common.GetTimestamp()
+	gitOpsStatus.LastChanges = changes
+	gitOpsStatus.LastError = ""
+	if err != nil {
+		gitOpsStatus.LastError = err.Error()
+	}
+	return changes, err
+}
+
+func setGitOpsManagedOptions(options map[string]string) {
+	managed := make(map[string]bool, len(options))
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		managed[key] = true
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	gitOpsOptionsLock.Lock()
+	gitOpsManagedOptions = managed
+	gitOpsOptionsLock.Unlock()
+	gitOpsStatus.ManagedOptions = keys
+}
+
+// RunGitOpsWithContext reconciles the configuration file at startup and
+// whenever it changes. Only the master node writes to the database; the
+// others just load the file to know which options are read-only.
+func RunGitOpsWithContext(ctx context.Context) {
+	path := gitOpsPath()
+	if path == "" {
+		return
+	}
+	interval := time.Duration(common.GetEnvOrDefault("GITOPS_POLL_INTERVAL", 10)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastVersion := ""
+	for {
+		if version := gitOpsConfigVersion(path); version != lastVersion {
+			lastVersion = version
+			if common.IsMasterNode {
+				changes, err := ReconcileGitOps(gitOpsAdoptExisting(), false)
+				if err != nil {
+					common.SysError("gitops reconcile failed: " + err.Error())
+				}
+				common.SysLog(fmt.Sprintf("gitops reconciled %s: %d changes", path, len(changes)))
+			} else if config, err := LoadGitOpsConfig(path); err == nil {
+				if options, err := config.options(); err == nil {
+					gitOpsLock.Lock()
+					setGitOpsManagedOptions(options)
+					gitOpsLock.Unlock()
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
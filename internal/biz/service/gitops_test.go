@@ -0,0 +1,150 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+)
+
+func TestLoadGitOpsConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"10-channels.yaml": `
+channels:
+  - name: openai
+    type: 1
+    key_env: GITOPS_TEST_KEY
+    models: [gpt-4o, gpt-4o-mini]
+    model_mapping: {gpt-4: gpt-4o}
+group_ratios:
+  default: 1
+  vip: 0.5
+`,
+		"20-ratios.json": `{"group_ratios": {"vip": 0.8}, "auto_groups": ["default"]}`,
+		"notes.txt":      "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := LoadGitOpsConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadGitOpsConfig: %v", err)
+	}
+	if len(config.Channels) != 1 || config.Channels[0].Name != "openai" {
+		t.Fatalf("channels = %+v", config.Channels)
+	}
+	if config.GroupRatios["vip"] != 0.8 || config.GroupRatios["default"] != 1 {
+		t.Fatalf("group ratios = %v, later files should win", config.GroupRatios)
+	}
+
+	options, err := config.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := options[gitOpsOptionModelRatios]; ok {
+		t.Fatal("model ratios are not configured and must stay unmanaged")
+	}
+	if options[gitOpsOptionAutoGroups] != `["default"]` {
+		t.Fatalf("auto groups = %s", options[gitOpsOptionAutoGroups])
+	}
+
+	if _, err := config.Channels[0].channel(); err == nil {
+		t.Fatal("expected an error for an empty key environment variable")
+	}
+	t.Setenv("GITOPS_TEST_KEY", "sk-test")
+	desired, err := config.Channels[0].channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desired.Models != "gpt-4o,gpt-4o-mini" || desired.Group != "default" || desired.Key != "sk-test" {
+		t.Fatalf("channel = %+v", desired)
+	}
+
+	current := *desired
+	current.ModelMapping = new(string)
+	*current.ModelMapping = `{ "gpt-4": "gpt-4o" }`
+	if fields := diffGitOpsChannel(&current, desired); len(fields) != 0 {
+		t.Fatalf("equivalent channel differs in %v", fields)
+	}
+	current.Models = "gpt-4o"
+	if fields := diffGitOpsChannel(&current, desired); len(fields) != 1 || fields[0] != "models" {
+		t.Fatalf("fields = %v, want [models]", fields)
+	}
+}
+
+func setupGitOpsTestDB(t *testing.T) {
+	t.Helper()
+	db := setupTestDB(t, &model.Channel{})
+
+	for _, name := range []string{"openai", "claude"} {
+		channel := &model.Channel{Name: name, Key: "sk-" + name, Models: "gpt-4o", Group: "default", ManagedBy: model.ChannelManagedByGitOps}
+		if err := db.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func gitOpsDeletes(changes []GitOpsChange) []string {
+	var deletes []string
+	for _, change := range changes {
+		if change.Action == "delete" {
+			deletes = append(deletes, change.Name)
+		}
+	}
+	return deletes
+}
+
+// TestReconcileGitOpsChannelsSpecError tests that a spec with an error does
+// not delete the managed channels
+func TestReconcileGitOpsChannelsSpecError(t *testing.T) {
+	setupGitOpsTestDB(t)
+	t.Setenv("GITOPS_TEST_KEY", "sk-openai")
+	openai := GitOpsChannel{Name: "openai", KeyEnv: "GITOPS_TEST_KEY", Models: []string{"gpt-4o"}}
+
+	changes, err := reconcileGitOpsChannels([]GitOpsChannel{openai}, false, true)
+	if err != nil || len(gitOpsDeletes(changes)) != 1 || gitOpsDeletes(changes)[0] != "claude" {
+		t.Fatalf("changes = %+v, %v, want claude deleted", changes, err)
+	}
+
+	claude := GitOpsChannel{Name: "claude", Models: []string{"gpt-4o"}} // key_env is missing
+	changes, err = reconcileGitOpsChannels([]GitOpsChannel{openai, claude}, false, true)
+	if err == nil || len(gitOpsDeletes(changes)) != 0 {
+		t.Fatalf("changes = %+v, %v, want an error and no delete", changes, err)
+	}
+
+	changes, err = reconcileGitOpsChannels([]GitOpsChannel{openai, openai}, false, true)
+	if err == nil || len(gitOpsDeletes(changes)) != 0 {
+		t.Fatalf("changes = %+v, %v, want an error and no delete", changes, err)
+	}
+}
+
+// TestReconcileGitOpsWithoutChannels tests that a file without a channels
+// section leaves the managed channels alone
+func TestReconcileGitOpsWithoutChannels(t *testing.T) {
+	setupGitOpsTestDB(t)
+	for _, content := range []string{"", "group_ratios: {default: 1}\n", "channels:\n"} {
+		path := filepath.Join(t.TempDir(), "gitops.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("GITOPS_CONFIG_PATH", path)
+		changes, err := ReconcileGitOps(false, true)
+		if err != nil || len(gitOpsDeletes(changes)) != 0 {
+			t.Fatalf("%q: changes = %+v, %v, want no delete", content, changes, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "gitops.yaml")
+	if err := os.WriteFile(path, []byte("channels: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITOPS_CONFIG_PATH", path)
+	if changes, err := ReconcileGitOps(false, true); err != nil || len(gitOpsDeletes(changes)) != 2 {
+		t.Fatalf("changes = %+v, %v, an empty channels list deletes them all", changes, err)
+	}
+}
@@ -55,6 +55,23 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_system_prompt_overwritten"] = true
 	}
 
+	if match := common.GetContextKeyString(ctx, constant.ContextKeyResponseCacheHit); match != "" {
+		other["response_cache_hit"] = true
+		other["response_cache_match"] = match
+	}
+
+	if route := GetModelAliasRoute(ctx); route != nil {
+		other["model_alias"] = route.Alias
+	}
+
+	if compaction := GetContextCompaction(ctx); compaction != nil {
+		other["context_compaction"] = compaction
+	}
+
+	if attempt := relaycommon.GetHedgeAttempt(ctx); attempt != nil && attempt.Record.Hedged() {
+		other["hedge"] = attempt.Record.LogInfo()
+	}
+
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	isMultiKey := common.GetContextKeyBool(ctx, constant.ContextKeyChannelIsMultiKey)
@@ -63,6 +80,10 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		adminInfo["multi_key_index"] = common.GetContextKeyInt(ctx, constant.ContextKeyChannelMultiKeyIndex)
 	}
 
+	if GetSelectedGroupAffinityKey(ctx) != "" {
+		adminInfo["session_affinity"] = true
+	}
+
 	isLocalCountTokens := common.GetContextKeyBool(ctx, constant.ContextKeyLocalCountTokens)
 	if isLocalCountTokens {
 		adminInfo["local_count_tokens"] = isLocalCountTokens
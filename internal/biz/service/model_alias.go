@@ -0,0 +1,71 @@
+package service
+
+import (
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelAliasRoute is the way of a request for a virtual model through the
+// targets of the model, see model_setting.ModelAlias.
+type ModelAliasRoute struct {
+	Alias   string
+	Group   string // the group the request was made in
+	Targets []model_setting.ModelAliasTarget
+	Index   int // the target the request is served by, -1 before the first
+}
+
+// NewModelAliasRoute starts the route of the request made in group for the
+// virtual model alias.
+func NewModelAliasRoute(c *gin.Context, alias *model_setting.ModelAlias, group string) *ModelAliasRoute {
+	route := &ModelAliasRoute{
+		Alias:   alias.Name,
+		Group:   group,
+		Targets: append([]model_setting.ModelAliasTarget(nil), alias.Targets...),
+		Index:   -1,
+	}
+	common.SetContextKey(c, constant.ContextKeyModelAliasRoute, route)
+	return route
+}
+
+// GetModelAliasRoute returns the route of the request, nil if it was not
+// made for a virtual model.
+func GetModelAliasRoute(c *gin.Context) *ModelAliasRoute {
+	route, _ := common.GetContextKeyType[*ModelAliasRoute](c, constant.ContextKeyModelAliasRoute)
+	return route
+}
+
+// Model returns the model of the current target.
+func (r *ModelAliasRoute) Model() string {
+	if r.Index < 0 || r.Index >= len(r.Targets) {
+		return ""
+	}
+	return r.Targets[r.Index].Model
+}
+
+// Next moves the request to the next target of the route: channels are
+// selected for its model and in its group from then on, starting over at the
+// highest priority. It returns false once the targets are exhausted.
+func (r *ModelAliasRoute) Next(c *gin.Context, param *RetryParam) bool {
+	if r.Index+1 >= len(r.Targets) {
+		return false
+	}
+	r.Index++
+	target := r.Targets[r.Index]
+	group := r.Group
+	if target.Group != "" {
+		group = target.Group
+	}
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, group)
+	common.SetContextKey(c, constant.ContextKeyAutoGroupIndex, 0)
+	if _, ok := common.GetContextKey(c, constant.ContextKeyAutoGroup); ok && group != "auto" {
+		// the group ratio follows the auto group once one was selected
+		common.SetContextKey(c, constant.ContextKeyAutoGroup, group)
+	}
+	param.ModelName = target.Model
+	param.TokenGroup = group
+	param.SetRetry(0)
+	return true
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestModelAliasRoute(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	alias := &model_setting.ModelAlias{
+		Name: "smart",
+		Targets: []model_setting.ModelAliasTarget{
+			{Model: "gpt-4o"},
+			{Model: "claude-sonnet-4", Group: "vip"},
+		},
+	}
+	route := NewModelAliasRoute(c, alias, "default")
+	if GetModelAliasRoute(c) != route {
+		t.Fatal("route not kept on the context")
+	}
+	if route.Model() != "" {
+		t.Errorf("model %q before the first target", route.Model())
+	}
+
+	param := &RetryParam{Ctx: c, Retry: common.GetPointer(2)}
+	if !route.Next(c, param) {
+		t.Fatal("no first target")
+	}
+	if param.ModelName != "gpt-4o" || param.TokenGroup != "default" || param.GetRetry() != 0 {
+		t.Errorf("first target selects %s in %s at retry %d", param.ModelName, param.TokenGroup, param.GetRetry())
+	}
+
+	param.SetRetry(3)
+	if !route.Next(c, param) {
+		t.Fatal("no second target")
+	}
+	if param.ModelName != "claude-sonnet-4" || param.TokenGroup != "vip" || param.GetRetry() != 0 {
+		t.Errorf("second target selects %s in %s at retry %d", param.ModelName, param.TokenGroup, param.GetRetry())
+	}
+	if group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup); group != "vip" {
+		t.Errorf("using group %q, want vip", group)
+	}
+
+	if route.Next(c, param) {
+		t.Error("targets not exhausted")
+	}
+	if route.Model() != "claude-sonnet-4" {
+		t.Errorf("exhausted route serves %q", route.Model())
+	}
+}
@@ -211,6 +211,7 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		RecordChannelKeyUsage(relayInfo, quota)
 	}
 
 	logModel := modelName
@@ -226,6 +227,7 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 		ModelName:        logModel,
 		TokenName:        tokenName,
 		Quota:            quota,
+		UpstreamCost:     UpstreamCost(ctx, relayInfo, usage.InputTokens, usage.OutputTokens, quota, groupRatio),
 		Content:          logContent,
 		TokenId:          relayInfo.TokenId,
 		UseTimeSeconds:   int(useTimeSeconds),
@@ -235,7 +237,20 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	})
 }
 
+// RecordChannelUsage adds the tokens of a billed request to the metrics of
+// the channel that served it. Responses served from the cache are skipped.
+func RecordChannelUsage(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) {
+	if usage == nil || relayInfo.ChannelMeta == nil || common.GetContextKeyString(ctx, constant.ContextKeyResponseCacheHit) != "" {
+		return
+	}
+	model.RecordChannelMetricUsage(relayInfo.ChannelId, relayInfo.OriginModelName, usage.PromptTokens, usage.CompletionTokens)
+}
+
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) {
+	if relaycommon.SkipBilling(ctx, usage) {
+		return
+	}
+	RecordChannelUsage(ctx, relayInfo, usage)
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
@@ -305,6 +320,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		RecordChannelKeyUsage(relayInfo, quota)
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -343,6 +359,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		ModelName:        modelName,
 		TokenName:        tokenName,
 		Quota:            quota,
+		UpstreamCost:     UpstreamCost(ctx, relayInfo, promptTokens, completionTokens, quota, groupRatio),
 		Content:          logContent,
 		TokenId:          relayInfo.TokenId,
 		UseTimeSeconds:   int(useTimeSeconds),
@@ -375,6 +392,10 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData types.PriceData)
 }
 
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	if relaycommon.SkipBilling(ctx, usage) {
+		return
+	}
+	RecordChannelUsage(ctx, relayInfo, usage)
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
@@ -430,6 +451,7 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		RecordChannelKeyUsage(relayInfo, quota)
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -468,6 +490,7 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 		ModelName:        logModel,
 		TokenName:        tokenName,
 		Quota:            quota,
+		UpstreamCost:     UpstreamCost(ctx, relayInfo, usage.PromptTokens, usage.CompletionTokens, quota, groupRatio),
 		Content:          logContent,
 		TokenId:          relayInfo.TokenId,
 		UseTimeSeconds:   int(useTimeSeconds),
@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseCacheEntry is a relayed response kept for replay. Body holds the
+// bytes exactly as they were sent to the client, an SSE stream when Stream is
+// set, and Usage what the original request was billed for.
+type ResponseCacheEntry struct {
+	Stream      bool      `json:"stream"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	Usage       dto.Usage `json:"usage"`
+	CreatedAt   int64     `json:"created_at"`
+}
+
+// ResponseCacheEmbedder embeds the prompt of a request with the semantic
+// model in group, as a request of its own that is priced, retried and logged
+// like any other.
+type ResponseCacheEmbedder func(c *gin.Context, group string, text string) ([]float64, error)
+
+// fields that never change what the model answers
+var responseCacheIgnoredFields = []string{"user", "metadata"}
+
+// fields holding the prompt, left out of the semantic scope
+var responseCachePromptFields = []string{"messages", "prompt", "input"}
+
+const (
+	responseCacheMemoryMaxEntries = 10000
+	semanticIndexScopeMaxEntries  = 1000
+)
+
+type memoryResponseCacheEntry struct {
+	entry    *ResponseCacheEntry
+	expireAt time.Time
+}
+
+type semanticIndexEntry struct {
+	Key      string    `json:"key"`
+	Vector   []float64 `json:"vector"`
+	ExpireAt int64     `json:"expire_at"`
+}
+
+var (
+	responseCacheMemory     = make(map[string]memoryResponseCacheEntry)
+	responseCacheMemoryLock sync.Mutex
+	responseCacheCleanup    sync.Once
+
+	// with Redis enabled the semantic index is a list per scope next to the
+	// entries, shared by all nodes
+	semanticIndex     = make(map[string][]semanticIndexEntry)
+	semanticIndexLock sync.Mutex
+)
+
+// ResponseCacheEnabled reports whether responses may be served from and stored
+// in the cache, which needs the global switch and either the token or the
+// group to opt in.
+func ResponseCacheEnabled(c *gin.Context, group string) bool {
+	setting := operation_setting.GetResponseCacheSetting()
+	if !setting.Enabled {
+		return false
+	}
+	if common.GetContextKeyBool(c, constant.ContextKeyTokenResponseCache) {
+		return true
+	}
+	return slices.Contains(setting.Groups, group)
+}
+
+func responseCacheTTL() time.Duration {
+	ttl := operation_setting.GetResponseCacheSetting().TTLSeconds
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+func hashResponseCacheFields(userId int, fields map[string]any) (string, error) {
+	jsonData, err := common.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(common.Sha256Raw(append([]byte(strconv.Itoa(userId)+":"), jsonData...))), nil
+}
+
+// ResponseCacheKeys derives the exact cache key of a request and its semantic
+// scope, the key of everything but the prompt. The request is re-encoded with
+// sorted keys and without fields that do not affect the answer, so equivalent
+// requests hash the same. Keys are per user.
+func ResponseCacheKeys(userId int, request any) (key string, scope string, err error) {
+	jsonData, err := common.Marshal(request)
+	if err != nil {
+		return "", "", err
+	}
+	var fields map[string]any
+	if err = common.Unmarshal(jsonData, &fields); err != nil {
+		return "", "", err
+	}
+	for _, field := range responseCacheIgnoredFields {
+		delete(fields, field)
+	}
+	if key, err = hashResponseCacheFields(userId, fields); err != nil {
+		return "", "", err
+	}
+	for _, field := range responseCachePromptFields {
+		delete(fields, field)
+	}
+	if scope, err = hashResponseCacheFields(userId, fields); err != nil {
+		return "", "", err
+	}
+	return key, scope, nil
+}
+
+func startResponseCacheCleanup() {
+	gopool.Go(func() {
+		for {
+			time.Sleep(10 * time.Minute)
+			now := time.Now()
+			responseCacheMemoryLock.Lock()
+			for key, item := range responseCacheMemory {
+				if now.After(item.expireAt) {
+					delete(responseCacheMemory, key)
+				}
+			}
+			responseCacheMemoryLock.Unlock()
+
+			semanticIndexLock.Lock()
+			for scope, entries := range semanticIndex {
+				entries = slices.DeleteFunc(entries, func(e semanticIndexEntry) bool {
+					return now.Unix() >= e.ExpireAt
+				})
+				if len(entries) == 0 {
+					delete(semanticIndex, scope)
+				} else {
+					semanticIndex[scope] = entries
+				}
+			}
+			semanticIndexLock.Unlock()
+		}
+	})
+}
+
+// GetResponseCache returns the cached response for key, or nil.
+func GetResponseCache(key string) *ResponseCacheEntry {
+	if common.RedisEnabled {
+		value, err := common.RedisGet("response_cache:" + key)
+		if err != nil || value == "" {
+			return nil
+		}
+		var entry ResponseCacheEntry
+		if err = common.UnmarshalJsonStr(value, &entry); err != nil {
+			return nil
+		}
+		return &entry
+	}
+	responseCacheMemoryLock.Lock()
+	defer responseCacheMemoryLock.Unlock()
+	item, ok := responseCacheMemory[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(item.expireAt) {
+		delete(responseCacheMemory, key)
+		return nil
+	}
+	return item.entry
+}
+
+// SetResponseCache stores entry under key for the configured TTL.
+func SetResponseCache(key string, entry *ResponseCacheEntry) error {
+	ttl := responseCacheTTL()
+	if common.RedisEnabled {
+		jsonData, err := common.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return common.RedisSet("response_cache:"+key, string(jsonData), ttl)
+	}
+	responseCacheCleanup.Do(startResponseCacheCleanup)
+	responseCacheMemoryLock.Lock()
+	defer responseCacheMemoryLock.Unlock()
+	if _, ok := responseCacheMemory[key]; !ok && len(responseCacheMemory) >= responseCacheMemoryMaxEntries {
+		evictResponseCache()
+	}
+	responseCacheMemory[key] = memoryResponseCacheEntry{entry: entry, expireAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// evictResponseCache makes room in the full memory cache by dropping the
+// expired entries, or the oldest one when none has expired. The caller holds
+// responseCacheMemoryLock.
+func evictResponseCache() {
+	now := time.Now()
+	oldestKey := ""
+	var oldest time.Time
+	for key, item := range responseCacheMemory {
+		if now.After(item.expireAt) {
+			delete(responseCacheMemory, key)
+			continue
+		}
+		if oldestKey == "" || item.expireAt.Before(oldest) {
+			oldestKey, oldest = key, item.expireAt
+		}
+	}
+	if len(responseCacheMemory) >= responseCacheMemoryMaxEntries {
+		delete(responseCacheMemory, oldestKey)
+	}
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// FindSemanticResponseCache embeds text in group with embed and looks for a
+// cached response in scope whose prompt is close enough to it. The vector is
+// returned even on a miss so the response can be indexed once it is stored.
+func FindSemanticResponseCache(c *gin.Context, group string, scope string, text string, embed ResponseCacheEmbedder) (*ResponseCacheEntry, []float64, error) {
+	vector, err := embed(c, group, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	threshold := operation_setting.GetResponseCacheSetting().SemanticThreshold
+	now := time.Now().Unix()
+
+	bestKey, bestScore := "", threshold
+	for _, e := range getSemanticIndex(scope) {
+		if now >= e.ExpireAt {
+			continue
+		}
+		if score := cosineSimilarity(vector, e.Vector); score >= bestScore {
+			bestKey, bestScore = e.Key, score
+		}
+	}
+
+	if bestKey == "" {
+		return nil, vector, nil
+	}
+	return GetResponseCache(bestKey), vector, nil
+}
+
+func getSemanticIndex(scope string) []semanticIndexEntry {
+	if common.RedisEnabled {
+		values, err := common.RDB.LRange(context.Background(), "response_cache_semantic:"+scope, 0, -1).Result()
+		if err != nil {
+			return nil
+		}
+		entries := make([]semanticIndexEntry, 0, len(values))
+		for _, value := range values {
+			var e semanticIndexEntry
+			if common.UnmarshalJsonStr(value, &e) == nil {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	}
+	semanticIndexLock.Lock()
+	defer semanticIndexLock.Unlock()
+	return slices.Clone(semanticIndex[scope])
+}
+
+// AddSemanticResponseCache indexes the response stored under key by the
+// embedding of its prompt.
+func AddSemanticResponseCache(scope string, key string, vector []float64) {
+	ttl := responseCacheTTL()
+	e := semanticIndexEntry{Key: key, Vector: vector, ExpireAt: time.Now().Add(ttl).Unix()}
+	if common.RedisEnabled {
+		jsonData, err := common.Marshal(e)
+		if err != nil {
+			return
+		}
+		// the list lives as long as its newest entry, expired ones are skipped
+		// on lookup and trimmed with the oldest
+		ctx := context.Background()
+		redisKey := "response_cache_semantic:" + scope
+		pipe := common.RDB.TxPipeline()
+		pipe.RPush(ctx, redisKey, string(jsonData))
+		pipe.LTrim(ctx, redisKey, -semanticIndexScopeMaxEntries, -1)
+		pipe.Expire(ctx, redisKey, ttl)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysError("failed to index response cache entry: " + err.Error())
+		}
+		return
+	}
+	responseCacheCleanup.Do(startResponseCacheCleanup)
+	semanticIndexLock.Lock()
+	defer semanticIndexLock.Unlock()
+	entries := append(semanticIndex[scope], e)
+	if len(entries) > semanticIndexScopeMaxEntries {
+		entries = entries[len(entries)-semanticIndexScopeMaxEntries:]
+	}
+	semanticIndex[scope] = entries
+}
+
+// NewResponseCacheEmbeddingRequest returns the request that embeds the prompt
+// of a request with the semantic model.
+func NewResponseCacheEmbeddingRequest(text string) *dto.EmbeddingRequest {
+	return &dto.EmbeddingRequest{Model: operation_setting.GetResponseCacheSetting().SemanticModel, Input: text}
+}
+
+// ParseResponseCacheEmbedding returns the vector of an embedding response body.
+func ParseResponseCacheEmbedding(body []byte) ([]float64, error) {
+	var embedding dto.EmbeddingResponse
+	if err := common.Unmarshal(body, &embedding); err != nil {
+		return nil, err
+	}
+	if len(embedding.Data) == 0 {
+		return nil, fmt.Errorf("embedding response has no data")
+	}
+	return embedding.Data[0].Embedding, nil
+}
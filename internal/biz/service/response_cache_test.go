@@ -0,0 +1,102 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseCacheKeysIgnoreVolatileFields(t *testing.T) {
+	a := map[string]any{"model": "gpt-4o", "temperature": 1.0, "user": "a", "messages": []any{map[string]any{"role": "user", "content": "hi"}}}
+	b := map[string]any{"messages": []any{map[string]any{"content": "hi", "role": "user"}}, "temperature": 1, "model": "gpt-4o", "metadata": map[string]any{"run": 2}}
+	keyA, scopeA, err := ResponseCacheKeys(1, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyB, scopeB, _ := ResponseCacheKeys(1, b)
+	if keyA != keyB || scopeA != scopeB {
+		t.Errorf("equivalent requests got different keys")
+	}
+	if keyC, _, _ := ResponseCacheKeys(2, a); keyC == keyA {
+		t.Errorf("keys are shared between users")
+	}
+	b["messages"] = []any{map[string]any{"role": "user", "content": "hello"}}
+	keyB, scopeB, _ = ResponseCacheKeys(1, b)
+	if keyA == keyB || scopeA != scopeB {
+		t.Errorf("prompt change should change the key but not the scope")
+	}
+}
+
+func TestSemanticResponseCache(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	defer func() { common.RedisEnabled = redisEnabled }()
+	embed := func(c *gin.Context, group string, text string) ([]float64, error) {
+		if text == "far" {
+			return []float64{0, 1}, nil
+		}
+		return []float64{1, 0.01}, nil
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	entry := &ResponseCacheEntry{Body: []byte(`{}`), Usage: dto.Usage{PromptTokens: 3}}
+	if err := SetResponseCache("k1", entry); err != nil {
+		t.Fatal(err)
+	}
+	AddSemanticResponseCache("scope", "k1", []float64{1, 0})
+
+	if got, _, _ := FindSemanticResponseCache(c, "default", "scope", "near", embed); got != entry {
+		t.Errorf("near prompt missed the cache")
+	}
+	if got, vector, _ := FindSemanticResponseCache(c, "default", "scope", "far", embed); got != nil || vector == nil {
+		t.Errorf("far prompt got %v, vector %v", got, vector)
+	}
+	if got, _, _ := FindSemanticResponseCache(c, "default", "other", "near", embed); got != nil {
+		t.Errorf("lookup crossed scopes")
+	}
+}
+
+func TestResponseCacheEvictsWhenFull(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	responseCacheMemoryLock.Lock()
+	memory := responseCacheMemory
+	responseCacheMemory = make(map[string]memoryResponseCacheEntry)
+	now := time.Now()
+	for i := 0; i < responseCacheMemoryMaxEntries; i++ {
+		responseCacheMemory[strconv.Itoa(i)] = memoryResponseCacheEntry{entry: &ResponseCacheEntry{}, expireAt: now.Add(time.Duration(i+1) * time.Second)}
+	}
+	responseCacheMemoryLock.Unlock()
+	defer func() {
+		common.RedisEnabled = redisEnabled
+		responseCacheMemoryLock.Lock()
+		responseCacheMemory = memory
+		responseCacheMemoryLock.Unlock()
+	}()
+
+	if err := SetResponseCache("new", &ResponseCacheEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	if GetResponseCache("new") == nil {
+		t.Error("new entry was not stored")
+	}
+	if GetResponseCache("0") != nil || GetResponseCache("1") == nil {
+		t.Error("the oldest entry was not the one evicted")
+	}
+}
+
+func TestParseResponseCacheEmbedding(t *testing.T) {
+	vector, err := ParseResponseCacheEmbedding([]byte(`{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.5,0.25]}]}`))
+	if err != nil || len(vector) != 2 || vector[0] != 0.5 {
+		t.Errorf("vector = %v, %v", vector, err)
+	}
+	if _, err := ParseResponseCacheEmbedding([]byte(`{"data":[]}`)); err == nil {
+		t.Error("a response without data parsed")
+	}
+}
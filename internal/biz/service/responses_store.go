@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+)
+
+// maxResponseChainDepth bounds how many previous responses are expanded into
+// the history of a single request.
+const maxResponseChainDepth = 256
+
+var ErrStoredResponseTooLarge = errors.New("response exceeds the maximum stored size")
+
+// StoredResponseContent is what the gateway keeps for one /v1/responses call:
+// the input items of that turn only, and the response object returned.
+type StoredResponseContent struct {
+	Input    []json.RawMessage `json:"input"`
+	Response json.RawMessage   `json:"response"`
+}
+
+// SaveStoredResponse stores the content with its index row.
+func SaveStoredResponse(stored *model.StoredResponse, content *StoredResponseContent) error {
+	data, err := common.Marshal(content)
+	if err != nil {
+		return err
+	}
+	if len(data) > constant.ResponseStoreMaxKB<<10 {
+		return ErrStoredResponseTooLarge
+	}
+	stored.Content = data
+	stored.Bytes = int64(len(data))
+	stored.CreatedAt = common.GetTimestamp()
+	stored.ExpiresAt = stored.CreatedAt + int64(constant.ResponseStoreTTLHours)*3600
+	return stored.Insert()
+}
+
+func LoadStoredResponse(stored *model.StoredResponse) (*StoredResponseContent, error) {
+	var content StoredResponseContent
+	if err := common.Unmarshal(stored.Content, &content); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+func DeleteStoredResponse(stored *model.StoredResponse) error {
+	return stored.Delete()
+}
+
+// NormalizeResponsesInput turns the `input` field of a Responses request into
+// a list of items, a plain string becoming a single user message.
+func NormalizeResponsesInput(input json.RawMessage) ([]json.RawMessage, error) {
+	switch common.GetJsonType(input) {
+	case "string":
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, err
+		}
+		item, err := common.Marshal(map[string]any{
+			"type":    "message",
+			"role":    "user",
+			"content": []map[string]any{{"type": "input_text", "text": text}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []json.RawMessage{item}, nil
+	case "array":
+		var items []json.RawMessage
+		if err := common.Unmarshal(input, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+	return nil, nil
+}
+
+// responseOutputAsInput converts the output items of a stored response into
+// input items. Reasoning items are dropped and ids removed, since both only
+// resolve on the upstream that produced them.
+func responseOutputAsInput(response json.RawMessage) []json.RawMessage {
+	var resp struct {
+		Output []map[string]any `json:"output"`
+	}
+	if err := common.Unmarshal(response, &resp); err != nil {
+		return nil
+	}
+	items := make([]json.RawMessage, 0, len(resp.Output))
+	for _, output := range resp.Output {
+		var item any
+		switch output["type"] {
+		case "message":
+			texts := make([]string, 0)
+			contents, _ := output["content"].([]any)
+			for _, content := range contents {
+				if part, ok := content.(map[string]any); ok {
+					if text, ok := part["text"].(string); ok {
+						texts = append(texts, text)
+					}
+				}
+			}
+			item = map[string]any{"role": "assistant", "content": strings.Join(texts, "")}
+		case "function_call":
+			item = map[string]any{
+				"type":      "function_call",
+				"call_id":   output["call_id"],
+				"name":      output["name"],
+				"arguments": output["arguments"],
+			}
+		default:
+			continue
+		}
+		data, err := common.Marshal(item)
+		if err == nil {
+			items = append(items, data)
+		}
+	}
+	return items
+}
+
+// ExpandResponseHistory rebuilds the conversation that ends with stored,
+// oldest turn first, as a list of input items.
+func ExpandResponseHistory(stored *model.StoredResponse) ([]json.RawMessage, error) {
+	turns := make([]*StoredResponseContent, 0)
+	for depth := 0; stored != nil; depth++ {
+		if depth >= maxResponseChainDepth {
+			return nil, fmt.Errorf("conversation is longer than %d responses", maxResponseChainDepth)
+		}
+		content, err := LoadStoredResponse(stored)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load response %s: %w", stored.ResponseId, err)
+		}
+		turns = append(turns, content)
+		if stored.PreviousResponseId == "" {
+			break
+		}
+		stored, err = model.GetStoredResponse(stored.UserId, stored.PreviousResponseId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	history := make([]json.RawMessage, 0)
+	for i := len(turns) - 1; i >= 0; i-- {
+		history = append(history, turns[i].Input...)
+		history = append(history, responseOutputAsInput(turns[i].Response)...)
+	}
+	return history, nil
+}
+
+// CleanupStoredResponsesWithContext removes expired responses once an hour.
+func CleanupStoredResponsesWithContext(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				expired, err := model.GetExpiredStoredResponses(500)
+				if err != nil {
+					common.SysError("failed to load expired responses: " + err.Error())
+					break
+				}
+				failed := false
+				for _, stored := range expired {
+					if err := DeleteStoredResponse(stored); err != nil {
+						common.SysError(fmt.Sprintf("failed to delete response %s: %s", stored.ResponseId, err.Error()))
+						failed = true
+					}
+				}
+				if len(expired) < 500 || failed || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
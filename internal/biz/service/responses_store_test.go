@@ -0,0 +1,79 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+)
+
+func TestNormalizeResponsesInput(t *testing.T) {
+	items, err := NormalizeResponsesInput(json.RawMessage(`"hello"`))
+	if err != nil || len(items) != 1 {
+		t.Fatalf("items = %s, err = %v", items, err)
+	}
+	want := `{"content":[{"text":"hello","type":"input_text"}],"role":"user","type":"message"}`
+	if string(items[0]) != want {
+		t.Errorf("item = %s, want %s", items[0], want)
+	}
+
+	items, err = NormalizeResponsesInput(json.RawMessage(`[{"role":"user","content":"a"},{"type":"function_call_output","call_id":"c1","output":"ok"}]`))
+	if err != nil || len(items) != 2 {
+		t.Fatalf("items = %s, err = %v", items, err)
+	}
+}
+
+func TestResponseOutputAsInputDropsReasoningAndIds(t *testing.T) {
+	response := json.RawMessage(`{"id":"resp_1","output":[
+		{"type":"reasoning","id":"rs_1","summary":[]},
+		{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"Hi"},{"type":"output_text","text":" there"}]},
+		{"type":"function_call","id":"fc_1","call_id":"call_1","name":"f","arguments":"{}"}
+	]}`)
+	items := responseOutputAsInput(response)
+	if len(items) != 2 {
+		t.Fatalf("items = %s", items)
+	}
+	if string(items[0]) != `{"content":"Hi there","role":"assistant"}` {
+		t.Errorf("message item = %s", items[0])
+	}
+	if string(items[1]) != `{"arguments":"{}","call_id":"call_1","name":"f","type":"function_call"}` {
+		t.Errorf("function_call item = %s", items[1])
+	}
+}
+
+// TestExpandStoredResponseHistory tests that a chain of stored responses is
+// read back from the database oldest turn first
+func TestExpandStoredResponseHistory(t *testing.T) {
+	setupTestDB(t, &model.StoredResponse{})
+	prevTTL, prevMaxKB := constant.ResponseStoreTTLHours, constant.ResponseStoreMaxKB
+	constant.ResponseStoreTTLHours, constant.ResponseStoreMaxKB = 1, 64
+	defer func() { constant.ResponseStoreTTLHours, constant.ResponseStoreMaxKB = prevTTL, prevMaxKB }()
+
+	turns := []struct{ id, previous, input, output string }{
+		{"resp_1", "", "first", "one"},
+		{"resp_2", "resp_1", "second", "two"},
+	}
+	for _, turn := range turns {
+		stored := &model.StoredResponse{ResponseId: turn.id, PreviousResponseId: turn.previous, UserId: 1}
+		content := &StoredResponseContent{
+			Input:    []json.RawMessage{json.RawMessage(`{"role":"user","content":"` + turn.input + `"}`)},
+			Response: json.RawMessage(`{"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"` + turn.output + `"}]}]}`),
+		}
+		if err := SaveStoredResponse(stored, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stored, err := model.GetStoredResponse(1, "resp_2")
+	if err != nil || stored == nil {
+		t.Fatalf("stored = %v, %v", stored, err)
+	}
+	history, err := ExpandResponseHistory(stored)
+	if err != nil || len(history) != 4 {
+		t.Fatalf("history = %s, %v", history, err)
+	}
+	if string(history[0]) != `{"role":"user","content":"first"}` || string(history[3]) != `{"content":"two","role":"assistant"}` {
+		t.Errorf("history = %s", history)
+	}
+}
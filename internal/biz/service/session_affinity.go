@@ -0,0 +1,152 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionAffinityRequest holds the fields identifying the conversation of a
+// request in the OpenAI, Claude and Gemini formats.
+type sessionAffinityRequest struct {
+	PromptCacheKey string          `json:"prompt_cache_key"`
+	User           json.RawMessage `json:"user"`
+	Metadata       struct {
+		UserId json.RawMessage `json:"user_id"`
+	} `json:"metadata"`
+
+	System                 json.RawMessage   `json:"system"`
+	Instructions           json.RawMessage   `json:"instructions"`
+	SystemInstruction      json.RawMessage   `json:"systemInstruction"`
+	SystemInstructionSnake json.RawMessage   `json:"system_instruction"`
+	Messages               []json.RawMessage `json:"messages"`
+	Contents               []json.RawMessage `json:"contents"`
+	Input                  json.RawMessage   `json:"input"`
+}
+
+// affinityString returns raw if it is a non-empty JSON string.
+func affinityString(raw json.RawMessage) string {
+	var s string
+	if len(raw) == 0 || common.Unmarshal(raw, &s) != nil {
+		return ""
+	}
+	return strings.TrimSpace(s)
+}
+
+// conversationPrefix returns the messages up to and including the first user
+// message, which every later turn of the conversation repeats.
+func conversationPrefix(messages []json.RawMessage) []json.RawMessage {
+	for i, message := range messages {
+		var m struct {
+			Role string `json:"role"`
+		}
+		if common.Unmarshal(message, &m) == nil && m.Role == "user" {
+			return messages[:i+1]
+		}
+	}
+	return messages
+}
+
+// conversationSource returns what identifies the conversation of the request
+// body, from the most to the least explicit: the prompt cache key, the end
+// user and the start of the conversation.
+func conversationSource(body []byte) string {
+	var request sessionAffinityRequest
+	if common.Unmarshal(body, &request) != nil {
+		return ""
+	}
+	if key := strings.TrimSpace(request.PromptCacheKey); key != "" {
+		return "prompt_cache_key:" + key
+	}
+	if user := affinityString(request.User); user != "" {
+		return "user:" + user
+	}
+	if user := affinityString(request.Metadata.UserId); user != "" {
+		return "user:" + user
+	}
+
+	parts := []json.RawMessage{request.System, request.Instructions, request.SystemInstruction, request.SystemInstructionSnake}
+	switch {
+	case len(request.Messages) > 0:
+		parts = append(parts, conversationPrefix(request.Messages)...)
+	case len(request.Contents) > 0:
+		parts = append(parts, conversationPrefix(request.Contents)...)
+	case len(request.Input) > 0:
+		var items []json.RawMessage
+		if common.Unmarshal(request.Input, &items) == nil {
+			parts = append(parts, conversationPrefix(items)...)
+		} else {
+			parts = append(parts, request.Input)
+		}
+	default:
+		return ""
+	}
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return "prefix:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// SetupSessionAffinity derives the session affinity key of the request when
+// a group is in the affinity mode. The key comes from the configured header,
+// else from the request body, and is scoped to the user so that conversations
+// of different users never share one.
+func SetupSessionAffinity(c *gin.Context) {
+	selectSetting := operation_setting.GetChannelSelectSetting()
+	if !selectSetting.HasAffinity() {
+		return
+	}
+	source := ""
+	if selectSetting.AffinityHeader != "" {
+		if value := strings.TrimSpace(c.GetHeader(selectSetting.AffinityHeader)); value != "" {
+			source = "header:" + value
+		}
+	}
+	if source == "" && strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return
+		}
+		source = conversationSource(body)
+	}
+	if source == "" {
+		return
+	}
+	sum := sha256.Sum256([]byte(strconv.Itoa(common.GetContextKeyInt(c, constant.ContextKeyUserId)) + "\n" + source))
+	common.SetContextKey(c, constant.ContextKeySessionAffinityKey, hex.EncodeToString(sum[:16]))
+}
+
+// GetSessionAffinityKey returns the session affinity key of the request if
+// group is in the affinity mode.
+func GetSessionAffinityKey(c *gin.Context, group string) string {
+	if !operation_setting.GetChannelSelectSetting().IsAffinity(group) {
+		return ""
+	}
+	return common.GetContextKeyString(c, constant.ContextKeySessionAffinityKey)
+}
+
+// GetSelectedGroupAffinityKey is GetSessionAffinityKey for the group the
+// channel of the request was selected in.
+func GetSelectedGroupAffinityKey(c *gin.Context) string {
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	if group == "auto" {
+		group = common.GetContextKeyString(c, constant.ContextKeyAutoGroup)
+	}
+	return GetSessionAffinityKey(c, group)
+}
+
+// ClearSessionAffinity lets the remaining attempts of the request draw their
+// channel and key at random, once the ones of its conversation failed.
+func ClearSessionAffinity(c *gin.Context) {
+	common.SetContextKey(c, constant.ContextKeySessionAffinityKey, "")
+}
@@ -0,0 +1,30 @@
+package service
+
+import "testing"
+
+func TestConversationSource(t *testing.T) {
+	first := `{"model":"claude-sonnet-4","system":"be brief","messages":[{"role":"user","content":"hi"}]}`
+	second := `{"model":"claude-sonnet-4","system":"be brief","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"how are you"}]}`
+	other := `{"model":"claude-sonnet-4","system":"be brief","messages":[{"role":"user","content":"bye"}]}`
+
+	source := conversationSource([]byte(first))
+	if source == "" {
+		t.Fatal("no source for a conversation")
+	}
+	if got := conversationSource([]byte(second)); got != source {
+		t.Errorf("second turn source %q, want %q", got, source)
+	}
+	if got := conversationSource([]byte(other)); got == source {
+		t.Error("different conversations share a source")
+	}
+
+	if got := conversationSource([]byte(`{"user":"u-1","messages":[{"role":"user","content":"hi"}]}`)); got != "user:u-1" {
+		t.Errorf("source %q, want the user", got)
+	}
+	if got := conversationSource([]byte(`{"prompt_cache_key":"k","user":"u-1"}`)); got != "prompt_cache_key:k" {
+		t.Errorf("source %q, want the prompt cache key", got)
+	}
+	if got := conversationSource([]byte(`{"model":"text-embedding-3-small"}`)); got != "" {
+		t.Errorf("source %q for a request without a conversation", got)
+	}
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupTestDB points model.DB at an in-memory SQLite database with the given
+// tables for the duration of the test.
+func setupTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevSQLite := model.DB, common.UsingSQLite
+	model.DB, common.UsingSQLite = db, true
+	t.Cleanup(func() { model.DB, common.UsingSQLite = prevDB, prevSQLite })
+	return db
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"math"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpstreamCost returns, in quota units, what the upstream of the channel the
+// request was relayed to charged for it, priced by the model the upstream was
+// asked for after model mapping, see UpstreamModelCost.
+func UpstreamCost(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, completionTokens int, quota int, groupRatio float64) int {
+	modelName := info.OriginModelName
+	if info.ChannelMeta != nil && info.UpstreamModelName != "" {
+		modelName = info.UpstreamModelName
+	}
+	return UpstreamModelCost(c, modelName, promptTokens, completionTokens, quota, groupRatio)
+}
+
+// UpstreamModelCost returns, in quota units, what the upstream charged for a
+// request for modelName. A price set for the model wins over the channel's
+// cost ratio, which applies to the billed quota without the group ratio. It is
+// 0 when the channel has no upstream prices and when the response came from
+// the response cache.
+func UpstreamModelCost(c *gin.Context, modelName string, promptTokens int, completionTokens int, quota int, groupRatio float64) int {
+	if common.GetContextKeyString(c, constant.ContextKeyResponseCacheHit) != "" {
+		return 0
+	}
+	setting, ok := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting)
+	if !ok {
+		return 0
+	}
+	return upstreamCost(setting, modelName, promptTokens, completionTokens, quota, groupRatio)
+}
+
+func upstreamCost(setting dto.ChannelSettings, modelName string, promptTokens int, completionTokens int, quota int, groupRatio float64) int {
+	if price, ok := setting.UpstreamModelPrices[modelName]; ok {
+		usd := (float64(promptTokens)*price.InputPrice+float64(completionTokens)*price.OutputPrice)/1e6 + price.RequestPrice
+		return int(math.Round(usd * common.QuotaPerUnit))
+	}
+	// a free group bills nothing to derive the cost from
+	if setting.UpstreamCostRatio <= 0 || groupRatio <= 0 {
+		return 0
+	}
+	return int(math.Round(float64(quota) / groupRatio * setting.UpstreamCostRatio))
+}
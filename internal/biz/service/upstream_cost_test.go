@@ -0,0 +1,60 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestUpstreamCost tests that model prices win over the cost ratio, which
+// applies to the quota billed without the group ratio
+func TestUpstreamCost(t *testing.T) {
+	setting := dto.ChannelSettings{
+		UpstreamCostRatio: 0.8,
+		UpstreamModelPrices: map[string]dto.UpstreamModelPrice{
+			"gpt-4o": {InputPrice: 2.5, OutputPrice: 10, RequestPrice: 0.001},
+		},
+	}
+	want := int((1000*2.5+500*10)/1e6*common.QuotaPerUnit + 0.001*common.QuotaPerUnit + 0.5)
+	if cost := upstreamCost(setting, "gpt-4o", 1000, 500, 99999, 2); cost != want {
+		t.Errorf("model price cost = %d, want %d", cost, want)
+	}
+	if cost := upstreamCost(setting, "claude", 1000, 500, 3000, 1.5); cost != 1600 {
+		t.Errorf("ratio cost = %d, want 1600", cost)
+	}
+	if cost := upstreamCost(setting, "claude", 1000, 500, 0, 0); cost != 0 {
+		t.Errorf("free group cost = %d, want 0", cost)
+	}
+	if cost := upstreamCost(dto.ChannelSettings{}, "claude", 1000, 500, 3000, 1); cost != 0 {
+		t.Errorf("cost without prices = %d, want 0", cost)
+	}
+}
+
+// TestUpstreamCostOfRelay tests that the cost is priced by the mapped model
+// and is nothing for a response cache hit
+func TestUpstreamCostOfRelay(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	common.SetContextKey(c, constant.ContextKeyChannelSetting, dto.ChannelSettings{
+		UpstreamModelPrices: map[string]dto.UpstreamModelPrice{
+			"gpt-4o": {RequestPrice: 0.01},
+		},
+	})
+	info := &relaycommon.RelayInfo{
+		OriginModelName: "my-gpt",
+		ChannelMeta:     &relaycommon.ChannelMeta{UpstreamModelName: "gpt-4o"},
+	}
+	want := int(0.01 * common.QuotaPerUnit)
+	if cost := UpstreamCost(c, info, 1000, 500, 3000, 1); cost != want {
+		t.Errorf("mapped model cost = %d, want %d", cost, want)
+	}
+	common.SetContextKey(c, constant.ContextKeyResponseCacheHit, "exact")
+	if cost := UpstreamCost(c, info, 1000, 500, 3000, 1); cost != 0 {
+		t.Errorf("cache hit cost = %d, want 0", cost)
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// budgets that come without a reset time are trusted for this long
+const defaultRateLimitReset = time.Minute
+
+type rateLimitHeaders struct {
+	limit     string
+	remaining string
+	reset     string
+}
+
+var (
+	// https://platform.openai.com/docs/guides/rate-limits#rate-limits-in-headers
+	openAIRequestHeaders = rateLimitHeaders{"x-ratelimit-limit-requests", "x-ratelimit-remaining-requests", "x-ratelimit-reset-requests"}
+	openAITokenHeaders   = rateLimitHeaders{"x-ratelimit-limit-tokens", "x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"}
+	// https://docs.anthropic.com/en/api/rate-limits#response-headers
+	anthropicRequestHeaders = rateLimitHeaders{"anthropic-ratelimit-requests-limit", "anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset"}
+	anthropicTokenHeaders   = rateLimitHeaders{"anthropic-ratelimit-tokens-limit", "anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset"}
+)
+
+// parseRateLimitReset accepts the durations OpenAI sends ("1s", "6m0s"), the
+// RFC 3339 timestamps Anthropic sends and plain seconds.
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), true
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second))), true
+	}
+	return time.Time{}, false
+}
+
+// parseBudget reads one limit/remaining/reset triple. ok is false when the
+// remaining count is missing.
+func parseBudget(header http.Header, names rateLimitHeaders, now time.Time) (limit int64, remaining int64, resetAt int64, ok bool) {
+	remaining, err := strconv.ParseInt(strings.TrimSpace(header.Get(names.remaining)), 10, 64)
+	if err != nil {
+		return 0, -1, 0, false
+	}
+	limit, _ = strconv.ParseInt(strings.TrimSpace(header.Get(names.limit)), 10, 64)
+	reset, hasReset := parseRateLimitReset(header.Get(names.reset), now)
+	if !hasReset {
+		reset = now.Add(defaultRateLimitReset)
+	}
+	return limit, remaining, reset.UnixMilli(), true
+}
+
+// parseRetryAfter reads retry-after-ms, or Retry-After in seconds or as an
+// HTTP date.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(header.Get("retry-after-ms")), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// ParseUpstreamRateLimit reads the rate limit budget and the wait the upstream
+// asked for from the response headers. ok is false when there are none.
+func ParseUpstreamRateLimit(header http.Header, now time.Time) (limit model.ChannelRateLimit, retryAfter time.Duration, ok bool) {
+	limit = model.ChannelRateLimit{RemainingRequests: -1, RemainingTokens: -1}
+	for _, names := range []rateLimitHeaders{openAIRequestHeaders, anthropicRequestHeaders} {
+		if l, r, reset, found := parseBudget(header, names, now); found {
+			limit.LimitRequests, limit.RemainingRequests, limit.RequestsResetAt = l, r, reset
+			ok = true
+			break
+		}
+	}
+	for _, names := range []rateLimitHeaders{openAITokenHeaders, anthropicTokenHeaders} {
+		if l, r, reset, found := parseBudget(header, names, now); found {
+			limit.LimitTokens, limit.RemainingTokens, limit.TokensResetAt = l, r, reset
+			ok = true
+			break
+		}
+	}
+	retryAfter = parseRetryAfter(header, now)
+	return limit, retryAfter, ok || retryAfter > 0
+}
+
+// RecordUpstreamRateLimit feeds the rate limit headers of an upstream response
+// into the budget of the channel key that was used. A 429 cools the key down
+// for the advertised time, or until its exhausted budget resets. Nothing is
+// recorded while the feature is disabled, so such 429s are handled as usual.
+func RecordUpstreamRateLimit(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) {
+	common.SetContextKey(c, constant.ContextKeyUpstreamCooldown, false)
+	if !operation_setting.GetUpstreamRateLimitSetting().Enabled || info.ChannelMeta == nil {
+		return
+	}
+	now := time.Now()
+	limit, retryAfter, ok := ParseUpstreamRateLimit(resp.Header, now)
+	if !ok {
+		return
+	}
+	keyIndex := -1
+	if info.ChannelIsMultiKey {
+		keyIndex = info.ChannelMultiKeyIndex
+	}
+	cooling := false
+	if resp.StatusCode == http.StatusTooManyRequests {
+		cooldown := retryAfter
+		if cooldown <= 0 {
+			if limit.RemainingRequests == 0 {
+				cooldown = time.UnixMilli(limit.RequestsResetAt).Sub(now)
+			}
+			if limit.RemainingTokens == 0 && time.UnixMilli(limit.TokensResetAt).Sub(now) > cooldown {
+				cooldown = time.UnixMilli(limit.TokensResetAt).Sub(now)
+			}
+		}
+		maxCooldown := time.Duration(operation_setting.GetUpstreamRateLimitSetting().MaxCooldownSeconds) * time.Second
+		if cooldown > maxCooldown {
+			cooldown = maxCooldown
+		}
+		if cooldown > 0 {
+			limit.CooldownUntil = now.Add(cooldown).UnixMilli()
+			cooling = true
+			logger.LogWarn(c, fmt.Sprintf("channel #%d (key %d) is rate limited upstream, cooling down for %s", info.ChannelId, keyIndex, cooldown))
+		}
+	}
+	model.UpdateChannelRateLimit(info.ChannelId, keyIndex, limit)
+	if cooling {
+		common.SetContextKey(c, constant.ContextKeyUpstreamCooldown, true)
+	}
+}
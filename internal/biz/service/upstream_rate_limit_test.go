@@ -0,0 +1,52 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestParseUpstreamRateLimit tests the OpenAI, Anthropic and Retry-After header formats
+func TestParseUpstreamRateLimit(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	openai := http.Header{}
+	openai.Set("x-ratelimit-limit-requests", "500")
+	openai.Set("x-ratelimit-remaining-requests", "3")
+	openai.Set("x-ratelimit-reset-requests", "6m0s")
+	openai.Set("x-ratelimit-limit-tokens", "30000")
+	openai.Set("x-ratelimit-remaining-tokens", "0")
+	openai.Set("x-ratelimit-reset-tokens", "20ms")
+	limit, retryAfter, ok := ParseUpstreamRateLimit(openai, now)
+	if !ok || retryAfter != 0 {
+		t.Fatalf("ok = %v, retryAfter = %s", ok, retryAfter)
+	}
+	if limit.LimitRequests != 500 || limit.RemainingRequests != 3 || limit.RequestsResetAt != now.Add(6*time.Minute).UnixMilli() {
+		t.Errorf("requests budget = %+v", limit)
+	}
+	if limit.RemainingTokens != 0 || limit.TokensResetAt != now.Add(20*time.Millisecond).UnixMilli() {
+		t.Errorf("tokens budget = %+v", limit)
+	}
+
+	anthropic := http.Header{}
+	anthropic.Set("anthropic-ratelimit-requests-limit", "50")
+	anthropic.Set("anthropic-ratelimit-requests-remaining", "0")
+	anthropic.Set("anthropic-ratelimit-requests-reset", "2025-01-01T00:00:30Z")
+	anthropic.Set("Retry-After", "30")
+	limit, retryAfter, ok = ParseUpstreamRateLimit(anthropic, now)
+	if !ok || retryAfter != 30*time.Second {
+		t.Fatalf("ok = %v, retryAfter = %s", ok, retryAfter)
+	}
+	if limit.RemainingRequests != 0 || limit.RequestsResetAt != now.Add(30*time.Second).UnixMilli() || limit.RemainingTokens != -1 {
+		t.Errorf("anthropic budget = %+v", limit)
+	}
+
+	retryOnly := http.Header{}
+	retryOnly.Set("Retry-After", now.Add(time.Minute).Format(http.TimeFormat))
+	if _, retryAfter, ok = ParseUpstreamRateLimit(retryOnly, now); !ok || retryAfter != time.Minute {
+		t.Errorf("ok = %v, retryAfter = %s", ok, retryAfter)
+	}
+	if _, _, ok = ParseUpstreamRateLimit(http.Header{}, now); ok {
+		t.Error("empty headers reported a rate limit")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"sync"
 
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
 
 	"github.com/samber/lo"
 	"gorm.io/gorm"
@@ -58,15 +60,20 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
-
+// getPriorities returns the distinct priorities of the abilities serving
+// model in group, highest first.
+func getPriorities(group string, model string) ([]int, error) {
 	var priorities []int
-	err := DB.Model(&Ability{}).
+	err := excludeDisabledModels(excludeRolloutChannels(DB.Model(&Ability{})), model).
 		Select("DISTINCT(priority)").
 		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
 		Order("priority DESC").              // 按优先级降序排序
 		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
+	return priorities, err
+}
 
+func getPriority(group string, model string, retry int) (int, error) {
+	priorities, err := getPriorities(group, model)
 	if err != nil {
 		// 处理错误
 		return 0, err
@@ -89,7 +96,8 @@ func getPriority(group string, model string, retry int) (int, error) {
 }
 
 func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
+	// channels in canary or shadow mode are drawn by GetCanaryChannel only
+	maxPrioritySubQuery := excludeDisabledModels(excludeRolloutChannels(DB.Model(&Ability{})), model).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
 	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, true, maxPrioritySubQuery)
 	if retry != 0 {
 		priority, err := getPriority(group, model, retry)
@@ -100,27 +108,143 @@ func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
 		}
 	}
 
-	return channelQuery, nil
+	return excludeDisabledModels(excludeRolloutChannels(channelQuery), model), nil
 }
 
-func GetChannel(group string, model string, retry int) (*Channel, error) {
-	var abilities []Ability
-
-	var err error = nil
-	channelQuery, err := getChannelQuery(group, model, retry)
-	if err != nil {
-		return nil, err
+// filterAbilities keeps the abilities whose channels pass filter.
+func filterAbilities(abilities []Ability, filter func(channels []*Channel) ([]*Channel, error)) ([]Ability, error) {
+	if len(abilities) == 0 {
+		return abilities, nil
 	}
-	if common.UsingSQLite || common.UsingPostgreSQL {
-		err = channelQuery.Order("weight DESC").Find(&abilities).Error
-	} else {
-		err = channelQuery.Order("weight DESC").Find(&abilities).Error
+	ids := make([]int, len(abilities))
+	for i, ability_ := range abilities {
+		ids[i] = ability_.ChannelId
 	}
+	var channels []*Channel
+	if err := DB.Where("id IN ?", ids).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	kept, err := filter(channels)
 	if err != nil {
 		return nil, err
 	}
+	keptIds := make(map[int]bool, len(kept))
+	for _, channel := range kept {
+		keptIds[channel.Id] = true
+	}
+	filtered := make([]Ability, 0, len(kept))
+	for _, ability_ := range abilities {
+		if keptIds[ability_.ChannelId] {
+			filtered = append(filtered, ability_)
+		}
+	}
+	return filtered, nil
+}
+
+// filterAbilitiesByContextWindow drops the abilities of channels whose
+// context window cannot fit the request, see filterChannelsByContextWindow.
+func filterAbilitiesByContextWindow(abilities []Ability, model string, modelLimit dto.ModelContextLimit, demand ChannelDemand) ([]Ability, error) {
+	if demand.PromptTokens <= 0 {
+		return abilities, nil
+	}
+	return filterAbilities(abilities, func(channels []*Channel) ([]*Channel, error) {
+		fitting, windowErr := filterChannelsByContextWindow(channels, model, modelLimit, demand)
+		if windowErr != nil {
+			return nil, windowErr
+		}
+		return fitting, nil
+	})
+}
+
+// filterAvailableAbilities drops the abilities of channels whose upstream
+// budget is exhausted or that are at their max concurrency, see
+// filterRateLimitedChannels and filterSaturatedChannels.
+func filterAvailableAbilities(abilities []Ability) ([]Ability, error) {
+	return filterAbilities(abilities, func(channels []*Channel) ([]*Channel, error) {
+		return filterSaturatedChannels(filterRateLimitedChannels(channels)), nil
+	})
+}
+
+func GetChannel(group string, model string, retry int, demand ChannelDemand) (*Channel, error) {
+	var abilities []Ability
+
+	var modelLimit dto.ModelContextLimit
+	if demand.PromptTokens > 0 {
+		modelLimit = GetModelContextLimit(model)
+	}
+	for {
+		channelQuery, err := getChannelQuery(group, model, retry)
+		if err != nil {
+			return nil, err
+		}
+		if common.UsingSQLite || common.UsingPostgreSQL {
+			err = channelQuery.Order("weight DESC").Find(&abilities).Error
+		} else {
+			err = channelQuery.Order("weight DESC").Find(&abilities).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+		abilities, err = filterAbilitiesByContextWindow(abilities, model, modelLimit, demand)
+		var windowErr *ContextWindowError
+		if errors.As(err, &windowErr) {
+			priorities, priorityErr := getPriorities(group, model)
+			if priorityErr == nil && retry < len(priorities)-1 {
+				// 该优先级的渠道上下文窗口均不足，尝试下一优先级
+				retry++
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		admitted, probe := filterAbilitiesByCircuitBreaker(abilities)
+		if probe != nil {
+			abilities = []Ability{*probe}
+			break
+		}
+		if len(admitted) == 0 && len(abilities) > 0 {
+			priorities, priorityErr := getPriorities(group, model)
+			if priorityErr == nil && retry < len(priorities)-1 {
+				// 该优先级的渠道均已熔断，尝试下一优先级
+				retry++
+				continue
+			}
+		}
+		// 最后一个优先级的渠道也全部熔断时，仍在其中选择
+		if len(admitted) > 0 {
+			abilities = admitted
+		}
+		available, err := filterAvailableAbilities(abilities)
+		if err != nil {
+			return nil, err
+		}
+		if len(available) == 0 && len(abilities) > 0 {
+			priorities, priorityErr := getPriorities(group, model)
+			if priorityErr == nil && retry < len(priorities)-1 {
+				// 该优先级的渠道均被上游限流或并发已满，尝试下一优先级
+				retry++
+				continue
+			}
+		}
+		// 全部不可用时仍在其中选择，并发已满时由调用方排队等待
+		if len(available) > 0 {
+			abilities = available
+		}
+		break
+	}
 	channel := Channel{}
-	if len(abilities) > 0 {
+	if len(abilities) > 0 && demand.AffinityKey != "" {
+		ids := make([]int, len(abilities))
+		weights := make([]float64, len(abilities))
+		for i, ability_ := range abilities {
+			ids[i] = ability_.ChannelId
+			weights[i] = float64(ability_.Weight)
+		}
+		channel.Id = ids[pickAffinity(demand.AffinityKey, ids, weights)]
+	} else if len(abilities) > 0 && operation_setting.GetChannelSelectSetting().IsAdaptive(group) {
+		channel.Id = pickAdaptiveAbility(abilities)
+	} else if len(abilities) > 0 {
 		// Randomly choose one
 		weightSum := uint(0)
 		for _, ability_ := range abilities {
@@ -139,7 +263,7 @@ func GetChannel(group string, model string, retry int) (*Channel, error) {
 	} else {
 		return nil, nil
 	}
-	err = DB.First(&channel, "id = ?", channel.Id).Error
+	err := DB.First(&channel, "id = ?", channel.Id).Error
 	return &channel, err
 }
 
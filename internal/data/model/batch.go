@@ -0,0 +1,309 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BatchJob is an OpenAI compatible batch created through /v1/batches.
+// Every line of the input file is relayed as an individual request on
+// behalf of TokenId, so billing and channel selection work as usual.
+type BatchJob struct {
+	Id               int    `json:"id" gorm:"primaryKey"`
+	BatchId          string `json:"batch_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	ClientIp         string `json:"-" gorm:"type:varchar(64)"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(20);index"`
+	Errors           string `json:"errors" gorm:"type:text"`
+	Metadata         string `json:"metadata" gorm:"type:text"`
+	RequestTotal     int    `json:"request_total"`
+	RequestCompleted int    `json:"request_completed"`
+	RequestFailed    int    `json:"request_failed"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+	// the node driving the batch, see AcquireBatchJobLease
+	LeaseOwner     string `json:"-" gorm:"type:varchar(64)"`
+	LeaseExpiresAt int64  `json:"-" gorm:"bigint;index"`
+}
+
+func (BatchJob) TableName() string {
+	return "batch_jobs"
+}
+
+func (b *BatchJob) Insert() error {
+	return DB.Create(b).Error
+}
+
+func (b *BatchJob) Update() error {
+	return DB.Save(b).Error
+}
+
+// Processed returns how many input lines have already been handled.
+func (b *BatchJob) Processed() int {
+	return b.RequestCompleted + b.RequestFailed
+}
+
+func (b *BatchJob) IsFinished() bool {
+	switch b.Status {
+	case dto.BatchStatusCompleted, dto.BatchStatusFailed, dto.BatchStatusExpired, dto.BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (b *BatchJob) SetErrors(errs []dto.OpenAIBatchError) {
+	if len(errs) == 0 {
+		b.Errors = ""
+		return
+	}
+	data, _ := common.Marshal(dto.OpenAIBatchErrors{Object: "list", Data: errs})
+	b.Errors = string(data)
+}
+
+func (b *BatchJob) ToOpenAIBatch() *dto.OpenAIBatch {
+	batch := &dto.OpenAIBatch{
+		ID:               b.BatchId,
+		Object:           "batch",
+		Endpoint:         b.Endpoint,
+		InputFileID:      b.InputFileId,
+		CompletionWindow: b.CompletionWindow,
+		Status:           b.Status,
+		CreatedAt:        b.CreatedAt,
+		InProgressAt:     timePointer(b.InProgressAt),
+		ExpiresAt:        timePointer(b.ExpiresAt),
+		FinalizingAt:     timePointer(b.FinalizingAt),
+		CompletedAt:      timePointer(b.CompletedAt),
+		FailedAt:         timePointer(b.FailedAt),
+		ExpiredAt:        timePointer(b.ExpiredAt),
+		CancellingAt:     timePointer(b.CancellingAt),
+		CancelledAt:      timePointer(b.CancelledAt),
+		RequestCounts: dto.OpenAIBatchRequestCounts{
+			Total:     b.RequestTotal,
+			Completed: b.RequestCompleted,
+			Failed:    b.RequestFailed,
+		},
+	}
+	// result files are only readable once the batch reached a terminal state
+	if b.IsFinished() {
+		if b.OutputFileId != "" && b.RequestCompleted > 0 {
+			batch.OutputFileID = &b.OutputFileId
+		}
+		if b.ErrorFileId != "" && b.RequestFailed > 0 {
+			batch.ErrorFileID = &b.ErrorFileId
+		}
+	}
+	if b.Errors != "" {
+		var errs dto.OpenAIBatchErrors
+		if err := common.UnmarshalJsonStr(b.Errors, &errs); err == nil {
+			batch.Errors = &errs
+		}
+	}
+	if b.Metadata != "" {
+		_ = common.UnmarshalJsonStr(b.Metadata, &batch.Metadata)
+	}
+	return batch
+}
+
+func timePointer(t int64) *int64 {
+	if t == 0 {
+		return nil
+	}
+	return &t
+}
+
+func GetBatchJobById(id int) (*BatchJob, error) {
+	var batch BatchJob
+	err := DB.First(&batch, "id = ?", id).Error
+	return &batch, err
+}
+
+func GetBatchJobByBatchId(userId int, batchId string) (*BatchJob, error) {
+	var batch BatchJob
+	err := DB.Where("user_id = ? AND batch_id = ?", userId, batchId).First(&batch).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetBatchJobStatus reloads only the status column, used by the worker to
+// notice cancellation requests while a batch is running.
+func GetBatchJobStatus(id int) (string, error) {
+	var status string
+	err := DB.Model(&BatchJob{}).Where("id = ?", id).Select("status").Scan(&status).Error
+	return status, err
+}
+
+func GetUserBatchJobs(userId int, afterId int, limit int) ([]*BatchJob, error) {
+	var batches []*BatchJob
+	query := DB.Where("user_id = ?", userId)
+	if afterId > 0 {
+		query = query.Where("id < ?", afterId)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetUnfinishedBatchJobs returns batches the worker still has to drive that
+// no other node holds the lease of.
+func GetUnfinishedBatchJobs(owner string, limit int) ([]*BatchJob, error) {
+	var batches []*BatchJob
+	err := DB.Where("status IN ?", []string{
+		dto.BatchStatusValidating,
+		dto.BatchStatusInProgress,
+		dto.BatchStatusFinalizing,
+		dto.BatchStatusCancelling,
+	}).Where("lease_owner = ? OR lease_expires_at < ?", owner, common.GetTimestamp()).
+		Order("id asc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// AcquireBatchJobLease takes or renews the lease of a batch for owner, only
+// one node drives a batch at a time. It reports false when another node holds
+// an unexpired lease.
+func AcquireBatchJobLease(id int, owner string, seconds int64) (bool, error) {
+	now := common.GetTimestamp()
+	result := DB.Model(&BatchJob{}).
+		Where("id = ? AND (lease_owner = ? OR lease_expires_at < ?)", id, owner, now).
+		Updates(map[string]any{"lease_owner": owner, "lease_expires_at": now + seconds})
+	return result.RowsAffected > 0, result.Error
+}
+
+func ReleaseBatchJobLease(id int, owner string) error {
+	return DB.Model(&BatchJob{}).Where("id = ? AND lease_owner = ?", id, owner).
+		Update("lease_expires_at", 0).Error
+}
+
+// UpdateBatchJobStatusIf switches status only when the current status is one
+// of the expected values, so user cancellation and the worker don't race.
+func UpdateBatchJobStatusIf(id int, expected []string, status string, fields map[string]any) (bool, error) {
+	updates := map[string]any{"status": status}
+	for k, v := range fields {
+		updates[k] = v
+	}
+	result := DB.Model(&BatchJob{}).Where("id = ? AND status IN ?", id, expected).Updates(updates)
+	return result.RowsAffected > 0, result.Error
+}
+
+// statuses of a BatchResult
+const (
+	BatchResultPending   = "pending"
+	BatchResultCompleted = "completed"
+	BatchResultFailed    = "failed"
+)
+
+// BatchResult is the outcome of one input line of a batch. A line is claimed
+// by inserting a pending result before it is relayed, so it is relayed and
+// billed at most once even when a worker dies and another one resumes the
+// batch. The result files are built from these rows when the batch finishes.
+type BatchResult struct {
+	Id         int    `json:"id" gorm:"primaryKey"`
+	BatchJobId int    `json:"batch_job_id" gorm:"uniqueIndex:idx_batch_result_custom_id,priority:1;index:idx_batch_result_line,priority:1"`
+	CustomId   string `json:"custom_id" gorm:"type:varchar(255);uniqueIndex:idx_batch_result_custom_id,priority:2"`
+	Line       int    `json:"line" gorm:"index:idx_batch_result_line,priority:2"`
+	Status     string `json:"status" gorm:"type:varchar(16)"`
+	Data       []byte `json:"-"` // the line of the output or error file
+}
+
+func (BatchResult) TableName() string {
+	return "batch_results"
+}
+
+// ClaimBatchResult inserts a pending result, it reports false when the line
+// was already claimed.
+func ClaimBatchResult(result *BatchResult) (bool, error) {
+	result.Status = BatchResultPending
+	tx := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(result)
+	return tx.RowsAffected > 0, tx.Error
+}
+
+// CompleteBatchResult stores the outcome of a claimed line.
+func CompleteBatchResult(id int, status string, data []byte) error {
+	return DB.Model(&BatchResult{}).Where("id = ? AND status = ?", id, BatchResultPending).
+		Updates(map[string]any{"status": status, "data": data}).Error
+}
+
+// GetBatchResultCustomIds returns the custom ids of the lines already claimed.
+func GetBatchResultCustomIds(batchJobId int) (map[string]bool, error) {
+	var customIds []string
+	if err := DB.Model(&BatchResult{}).Where("batch_job_id = ?", batchJobId).Pluck("custom_id", &customIds).Error; err != nil {
+		return nil, err
+	}
+	claimed := make(map[string]bool, len(customIds))
+	for _, customId := range customIds {
+		claimed[customId] = true
+	}
+	return claimed, nil
+}
+
+func GetPendingBatchResults(batchJobId int) ([]*BatchResult, error) {
+	var results []*BatchResult
+	err := DB.Where("batch_job_id = ? AND status = ?", batchJobId, BatchResultPending).Find(&results).Error
+	return results, err
+}
+
+// CountBatchResults returns how many lines completed and failed.
+func CountBatchResults(batchJobId int) (int, int, error) {
+	var counts []struct {
+		Status string
+		Count  int
+	}
+	err := DB.Model(&BatchResult{}).Select("status, count(*) as count").
+		Where("batch_job_id = ?", batchJobId).Group("status").Scan(&counts).Error
+	completed, failed := 0, 0
+	for _, count := range counts {
+		switch count.Status {
+		case BatchResultCompleted:
+			completed = count.Count
+		case BatchResultFailed:
+			failed = count.Count
+		}
+	}
+	return completed, failed, err
+}
+
+// ForEachBatchResult calls fn with the results of a status in line order.
+func ForEachBatchResult(batchJobId int, status string, fn func(result *BatchResult) error) error {
+	line := 0
+	for {
+		var results []*BatchResult
+		err := DB.Where("batch_job_id = ? AND status = ? AND line > ?", batchJobId, status, line).
+			Order("line asc").Limit(500).Find(&results).Error
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			if err := fn(result); err != nil {
+				return err
+			}
+			line = result.Line
+		}
+		if len(results) < 500 {
+			return nil
+		}
+	}
+}
+
+func DeleteBatchResults(batchJobId int) error {
+	return DB.Where("batch_job_id = ?", batchJobId).Delete(&BatchResult{}).Error
+}
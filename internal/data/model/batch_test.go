@@ -0,0 +1,132 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+)
+
+// TestBatchJobToOpenAIBatchHidesFilesUntilFinished checks that result files are
+// only exposed once the batch reached a terminal state
+func TestBatchJobToOpenAIBatchHidesFilesUntilFinished(t *testing.T) {
+	batch := &BatchJob{
+		BatchId:          "batch_test",
+		Status:           dto.BatchStatusInProgress,
+		OutputFileId:     "file-out",
+		ErrorFileId:      "file-err",
+		RequestTotal:     3,
+		RequestCompleted: 2,
+		RequestFailed:    1,
+		Metadata:         `{"job":"nightly"}`,
+	}
+	got := batch.ToOpenAIBatch()
+	if got.OutputFileID != nil || got.ErrorFileID != nil {
+		t.Fatalf("result files exposed while in progress: %v %v", got.OutputFileID, got.ErrorFileID)
+	}
+	if got.Metadata["job"] != "nightly" {
+		t.Errorf("Metadata = %v, want job=nightly", got.Metadata)
+	}
+	if got.InProgressAt != nil {
+		t.Errorf("InProgressAt = %v, want nil for zero timestamp", *got.InProgressAt)
+	}
+
+	batch.Status = dto.BatchStatusCompleted
+	got = batch.ToOpenAIBatch()
+	if got.OutputFileID == nil || *got.OutputFileID != "file-out" {
+		t.Errorf("OutputFileID = %v, want file-out", got.OutputFileID)
+	}
+	if got.ErrorFileID == nil || *got.ErrorFileID != "file-err" {
+		t.Errorf("ErrorFileID = %v, want file-err", got.ErrorFileID)
+	}
+
+	batch.RequestFailed = 0
+	got = batch.ToOpenAIBatch()
+	if got.ErrorFileID != nil {
+		t.Errorf("ErrorFileID = %v, want nil when no request failed", *got.ErrorFileID)
+	}
+}
+
+func TestBatchJobSetErrors(t *testing.T) {
+	batch := &BatchJob{Status: dto.BatchStatusFailed}
+	batch.SetErrors([]dto.OpenAIBatchError{{Code: "empty_file", Message: "input file contains no requests"}})
+	got := batch.ToOpenAIBatch()
+	if got.Errors == nil || len(got.Errors.Data) != 1 || got.Errors.Data[0].Code != "empty_file" {
+		t.Fatalf("Errors = %+v, want one empty_file error", got.Errors)
+	}
+	batch.SetErrors(nil)
+	if batch.Errors != "" {
+		t.Errorf("Errors = %q, want empty after reset", batch.Errors)
+	}
+}
+
+// TestBatchJobLease tests that only one node drives a batch until its lease
+// expires
+func TestBatchJobLease(t *testing.T) {
+	db := setupTestDB(t, &BatchJob{})
+	batch := &BatchJob{BatchId: "batch_lease", Status: dto.BatchStatusInProgress}
+	if err := db.Create(batch).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := AcquireBatchJobLease(batch.Id, "node-a", 60); err != nil || !ok {
+		t.Fatalf("node-a acquire = %v, %v", ok, err)
+	}
+	if ok, _ := AcquireBatchJobLease(batch.Id, "node-b", 60); ok {
+		t.Fatal("node-b took a held lease")
+	}
+	if batches, _ := GetUnfinishedBatchJobs("node-b", 10); len(batches) != 0 {
+		t.Fatalf("node-b sees %d leased batches", len(batches))
+	}
+	if ok, _ := AcquireBatchJobLease(batch.Id, "node-a", 60); !ok {
+		t.Fatal("node-a could not renew its lease")
+	}
+
+	if err := ReleaseBatchJobLease(batch.Id, "node-a"); err != nil {
+		t.Fatal(err)
+	}
+	if batches, _ := GetUnfinishedBatchJobs("node-b", 10); len(batches) != 1 {
+		t.Fatalf("node-b sees %d released batches", len(batches))
+	}
+	if ok, _ := AcquireBatchJobLease(batch.Id, "node-b", 60); !ok {
+		t.Fatal("node-b could not take a released lease")
+	}
+}
+
+// TestBatchResultClaims tests that a line is claimed once and that results
+// are read back in line order
+func TestBatchResultClaims(t *testing.T) {
+	setupTestDB(t, &BatchResult{})
+	claims := []*BatchResult{
+		{BatchJobId: 1, CustomId: "b", Line: 2},
+		{BatchJobId: 1, CustomId: "a", Line: 1},
+		{BatchJobId: 1, CustomId: "c", Line: 3},
+	}
+	for _, claim := range claims {
+		if ok, err := ClaimBatchResult(claim); err != nil || !ok {
+			t.Fatalf("claim %s = %v, %v", claim.CustomId, ok, err)
+		}
+	}
+	if ok, err := ClaimBatchResult(&BatchResult{BatchJobId: 1, CustomId: "a", Line: 1}); err != nil || ok {
+		t.Fatalf("second claim of a = %v, %v", ok, err)
+	}
+
+	_ = CompleteBatchResult(claims[0].Id, BatchResultCompleted, []byte("b\n"))
+	_ = CompleteBatchResult(claims[1].Id, BatchResultCompleted, []byte("a\n"))
+	// a line is completed once, a late writer does not overwrite it
+	_ = CompleteBatchResult(claims[1].Id, BatchResultFailed, []byte("late\n"))
+
+	if completed, failed, err := CountBatchResults(1); err != nil || completed != 2 || failed != 0 {
+		t.Fatalf("counts = %d, %d, %v", completed, failed, err)
+	}
+	if pending, _ := GetPendingBatchResults(1); len(pending) != 1 || pending[0].CustomId != "c" {
+		t.Fatalf("pending = %v", pending)
+	}
+	var output []byte
+	err := ForEachBatchResult(1, BatchResultCompleted, func(result *BatchResult) error {
+		output = append(output, result.Data...)
+		return nil
+	})
+	if err != nil || string(output) != "a\nb\n" {
+		t.Fatalf("output = %q, %v", output, err)
+	}
+}
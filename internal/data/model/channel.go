@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
 	"strings"
 	"sync"
 
@@ -18,10 +17,13 @@ import (
 	"gorm.io/gorm"
 )
 
+// ChannelManagedByGitOps marks channels reconciled from the configuration file
+const ChannelManagedByGitOps = "gitops"
+
 type Channel struct {
 	Id                 int     `json:"id"`
 	Type               int     `json:"type" gorm:"default:0"`
-	Key                string  `json:"key" gorm:"not null"`
+	Key                string  `json:"key" gorm:"not null;serializer:secret"`
 	OpenAIOrganization *string `json:"openai_organization"`
 	TestModel          *string `json:"test_model"`
 	Status             int     `json:"status" gorm:"default:1"`
@@ -51,10 +53,17 @@ type Channel struct {
 	// add after v0.8.5
 	ChannelInfo ChannelInfo `json:"channel_info" gorm:"type:json"`
 
-	OtherSettings string `json:"settings" gorm:"column:settings"` // 其他设置，存储azure版本等不需要检索的信息，详见dto.ChannelOtherSettings
+	OtherSettings string `json:"settings" gorm:"column:settings"`               // 其他设置，存储azure版本等不需要检索的信息，详见dto.ChannelOtherSettings
+	ManagedBy     string `json:"managed_by" gorm:"type:varchar(32);default:''"` // 非空表示渠道由配置文件管理，管理后台中只读
+	KeyLookup     string `json:"-" gorm:"type:varchar(64);index"`               // 密钥的 HMAC，密钥加密存储时用于按密钥搜索
+	RolloutMode   string `json:"-" gorm:"type:varchar(16);default:'';index"`    // 设置中的灰度模式，供不使用内存缓存时选择渠道
 
 	// cache info
 	Keys []string `json:"-" gorm:"-"`
+
+	CircuitBreakers []CircuitBreakerStatus `json:"circuit_breakers,omitempty" gorm:"-"` // 非默认状态的熔断器，仅用于展示
+	RateLimits      []ChannelRateLimit     `json:"rate_limits,omitempty" gorm:"-"`      // 上游返回的限流额度，仅用于展示
+	KeyUsages       []ChannelKeyUsage      `json:"key_usages,omitempty" gorm:"-"`       // 多Key渠道每个Key的用量，仅用于展示
 }
 
 type ChannelInfo struct {
@@ -65,6 +74,16 @@ type ChannelInfo struct {
 	MultiKeyDisabledTime   map[int]int64         `json:"multi_key_disabled_time,omitempty"`   // key禁用时间列表，key index -> time
 	MultiKeyPollingIndex   int                   `json:"multi_key_polling_index"`             // 多Key模式下轮询的key索引
 	MultiKeyMode           constant.MultiKeyMode `json:"multi_key_mode"`
+	MultiKeyWeights        map[int]int           `json:"multi_key_weights,omitempty"`      // key权重，随机模式下按权重选择，key index -> weight，缺省为 1
+	MultiKeyQuotaLimits    map[int]int           `json:"multi_key_quota_limits,omitempty"` // key消费上限，已用额度达到后自动禁用，key index -> quota
+}
+
+// KeyWeight returns the weight of a key of a multi-key channel.
+func (info *ChannelInfo) KeyWeight(idx int) int {
+	if weight, ok := info.MultiKeyWeights[idx]; ok && weight > 0 {
+		return weight
+	}
+	return 1
 }
 
 // Value implements driver.Valuer interface
@@ -103,6 +122,14 @@ func (channel *Channel) GetKeys() []string {
 }
 
 func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
+	return channel.GetAffinityEnabledKey("")
+}
+
+// GetAffinityEnabledKey is GetNextEnabledKey for a request with a session
+// affinity key, which picks the key of a multi-key channel among the
+// available ones regardless of the multi-key mode. An empty affinity key
+// follows the multi-key mode.
+func (channel *Channel) GetAffinityEnabledKey(affinityKey string) (string, int, *types.NewAPIError) {
 	// If not in multi-key mode, return the original key string directly.
 	if !channel.ChannelInfo.IsMultiKey {
 		return channel.Key, 0, nil
@@ -145,10 +172,53 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		return "", 0, types.NewError(errors.New("no enabled keys"), types.ErrorCodeChannelNoAvailableKey)
 	}
 
+	// skip keys whose circuit breaker is open, unless no other key is left
+	admittedIdx, probeIdx := filterKeysByCircuitBreaker(channel.Id, enabledIdx)
+	if probeIdx >= 0 {
+		return keys[probeIdx], probeIdx, nil
+	}
+	restrictTo := func(allowedIdx []int) {
+		allowed := make(map[int]bool, len(allowedIdx))
+		for _, idx := range allowedIdx {
+			allowed[idx] = true
+		}
+		enabledIdx = allowedIdx
+		statusOf := getStatus
+		getStatus = func(idx int) int {
+			if !allowed[idx] {
+				return common.ChannelStatusAutoDisabled
+			}
+			return statusOf(idx)
+		}
+	}
+	if len(admittedIdx) > 0 && len(admittedIdx) < len(enabledIdx) {
+		restrictTo(admittedIdx)
+	}
+	// skip keys that are rate limited upstream or at their max concurrency,
+	// unless all of them are
+	if availableIdx := filterSaturatedKeys(channel, filterRateLimitedKeys(channel.Id, enabledIdx)); len(availableIdx) > 0 && len(availableIdx) < len(enabledIdx) {
+		restrictTo(availableIdx)
+	}
+
+	if affinityKey != "" {
+		weights := make([]float64, len(enabledIdx))
+		for i, idx := range enabledIdx {
+			weights[i] = float64(channel.ChannelInfo.KeyWeight(idx))
+		}
+		// scoped to the channel, so that the keys of a conversation do not line
+		// up with its channel draw
+		selectedIdx := enabledIdx[pickAffinity(fmt.Sprintf("%s:%d", affinityKey, channel.Id), enabledIdx, weights)]
+		return keys[selectedIdx], selectedIdx, nil
+	}
+
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
-		// Randomly pick one enabled key
-		selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
+		// Randomly pick one enabled key, by weight
+		weights := make([]float64, len(enabledIdx))
+		for i, idx := range enabledIdx {
+			weights[i] = float64(channel.ChannelInfo.KeyWeight(idx))
+		}
+		selectedIdx := enabledIdx[pickWeighted(weights)]
 		return keys[selectedIdx], selectedIdx, nil
 	case constant.MultiKeyModePolling:
 		// Use channel-specific lock to ensure thread-safe polling
@@ -200,6 +270,15 @@ func (channel *Channel) GetModels() []string {
 	return strings.Split(strings.Trim(channel.Models, ","), ",")
 }
 
+// UpdateModels replaces the models of a channel and rebuilds its abilities.
+func (channel *Channel) UpdateModels(models []string) error {
+	channel.Models = strings.Join(models, ",")
+	if err := DB.Model(channel).Update("models", channel.Models).Error; err != nil {
+		return err
+	}
+	return channel.UpdateAbilities(nil)
+}
+
 func (channel *Channel) GetGroups() []string {
 	if channel.Group == "" {
 		return []string{}
@@ -323,11 +402,11 @@ func SearchChannels(keyword string, group string, model string, idSort bool) ([]
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR key_lookup = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", common.SecretLookupHash(keyword), "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR key_lookup = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", common.SecretLookupHash(keyword), "%"+keyword+"%", "%"+model+"%")
 	}
 
 	// 执行查询
@@ -369,11 +448,13 @@ func BatchInsertChannels(channels []Channel) error {
 		}
 	}()
 
-	for _, chunk := range lo.Chunk(channels, 50) {
+	for i, chunk := range lo.Chunk(channels, 50) {
 		if err := tx.Create(&chunk).Error; err != nil {
 			tx.Rollback()
 			return err
 		}
+		// the chunks are copies, hand the generated ids back to the caller
+		copy(channels[i*50:], chunk)
 		for _, channel_ := range chunk {
 			if err := channel_.AddAbilities(tx); err != nil {
 				tx.Rollback()
@@ -445,6 +526,21 @@ func (channel *Channel) GetStatusCodeMapping() string {
 	return *channel.StatusCodeMapping
 }
 
+// GetManagedChannelNames returns the names of the channels with the given ids,
+// or the given tag when ids is empty, that are managed by the configuration
+// file.
+func GetManagedChannelNames(ids []int, tag string) ([]string, error) {
+	var names []string
+	tx := DB.Model(&Channel{}).Where("managed_by <> ?", "")
+	if len(ids) > 0 {
+		tx = tx.Where("id IN ?", ids)
+	} else {
+		tx = tx.Where("tag = ?", tag)
+	}
+	err := tx.Pluck("name", &names).Error
+	return names, err
+}
+
 func (channel *Channel) Insert() error {
 	var err error
 	err = DB.Create(channel).Error
@@ -530,6 +626,15 @@ func (channel *Channel) Delete() error {
 	if err != nil {
 		return err
 	}
+	if err = DB.Where("channel_id = ?", channel.Id).Delete(&ChannelKeyUsage{}).Error; err != nil {
+		return err
+	}
+	if err = DB.Where("channel_id = ?", channel.Id).Delete(&DisabledChannelModel{}).Error; err != nil {
+		return err
+	}
+	if err = DB.Where("channel_id = ?", channel.Id).Delete(&ModelDiscoveryNotice{}).Error; err != nil {
+		return err
+	}
 	err = channel.DeleteAbilities()
 	return err
 }
@@ -774,7 +879,7 @@ func DeleteChannelByStatus(status int64) (int64, error) {
 }
 
 func DeleteDisabledChannel() (int64, error) {
-	result := DB.Where("status = ? or status = ?", common.ChannelStatusAutoDisabled, common.ChannelStatusManuallyDisabled).Delete(&Channel{})
+	result := DB.Where("(status = ? or status = ?) and managed_by = ?", common.ChannelStatusAutoDisabled, common.ChannelStatusManuallyDisabled, "").Delete(&Channel{})
 	return result.RowsAffected, result.Error
 }
 
@@ -818,11 +923,11 @@ func SearchTags(keyword string, group string, model string, idSort bool) ([]*str
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR key_lookup = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", common.SecretLookupHash(keyword), "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR key_lookup = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", common.SecretLookupHash(keyword), "%"+keyword+"%", "%"+model+"%")
 	}
 
 	subQuery := baseQuery.Where(whereClause, args...).
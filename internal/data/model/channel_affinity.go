@@ -0,0 +1,78 @@
+package model
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+)
+
+// Requests of a conversation carry an affinity key, see
+// service.GetSessionAffinityKey. In the affinity mode the key, instead of a
+// random draw, picks the channel among the available ones of a priority and
+// the key of a multi-key channel, so consecutive turns reach the same upstream
+// account and hit its prompt cache. The pick is a weighted rendezvous hash:
+// when a channel becomes unavailable only the conversations pinned to it move,
+// and they come back once it recovers.
+
+// affinityHash maps key and the id of a candidate to a uniform 64-bit value.
+func affinityHash(key string, id int) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(strconv.Itoa(id)))
+	// fnv alone barely spreads ids that differ in their last digit
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// pickAffinity returns the index of the candidate key is pinned to among ids,
+// each candidate getting a share of the keys proportional to its weight.
+// Candidates with no weight are only picked when none has one.
+func pickAffinity(key string, ids []int, weights []float64) int {
+	allZero := true
+	for _, weight := range weights {
+		if weight > 0 {
+			allZero = false
+			break
+		}
+	}
+	best, bestScore := 0, math.Inf(-1)
+	for i, id := range ids {
+		weight := weights[i]
+		if allZero {
+			weight = 1
+		}
+		if weight <= 0 {
+			continue
+		}
+		// u in (0, 1), the score -weight/ln(u) is the weighted rendezvous score
+		u := (float64(affinityHash(key, id)>>11) + 0.5) / (1 << 53)
+		score := -weight / math.Log(u)
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// affinityPercent maps key to a stable value in [0, 100), so a conversation
+// is either always or never drawn for a canary channel.
+func affinityPercent(key string) float64 {
+	return float64(affinityHash(key, -1)>>11) / (1 << 53) * 100
+}
+
+// pickAffinityChannel is pickAffinity over the channels of a priority tier.
+func pickAffinityChannel(key string, channels []*Channel) *Channel {
+	ids := make([]int, len(channels))
+	weights := make([]float64, len(channels))
+	for i, channel := range channels {
+		ids[i] = channel.Id
+		weights[i] = float64(channel.GetWeight())
+	}
+	return channels[pickAffinity(key, ids, weights)]
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestPickAffinity tests that a key keeps its channel, that keys spread by
+// weight and that removing a channel only moves the keys pinned to it
+func TestPickAffinity(t *testing.T) {
+	ids := []int{11, 12, 13}
+	weights := []float64{1, 1, 2}
+	counts := make([]int, len(ids))
+	pinned := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("conversation-%d", i)
+		picked := ids[pickAffinity(key, ids, weights)]
+		if again := ids[pickAffinity(key, ids, weights)]; again != picked {
+			t.Fatalf("key %s moved from %d to %d", key, picked, again)
+		}
+		pinned[key] = picked
+		for j, id := range ids {
+			if id == picked {
+				counts[j]++
+			}
+		}
+	}
+	if counts[2] < counts[0]*3/2 || counts[2] < counts[1]*3/2 {
+		t.Errorf("weighted channel got %v of the keys", counts)
+	}
+
+	for key, id := range pinned {
+		picked := []int{11, 13}[pickAffinity(key, []int{11, 13}, []float64{1, 2})]
+		if id != 12 && picked != id {
+			t.Fatalf("key %s moved from %d to %d though its channel is still there", key, id, picked)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	CircuitBreakerClosed   = "closed"
+	CircuitBreakerOpen     = "open"
+	CircuitBreakerHalfOpen = "half_open"
+)
+
+// redis hash holding all breakers, fields are "<channel>:<key index>:<name>"
+const circuitBreakerRedisKey = "circuit_breaker"
+
+// CircuitBreakerStatus is the state of the circuit breaker of a channel, or of
+// one key of a multi-key channel.
+type CircuitBreakerStatus struct {
+	KeyIndex  int    `json:"key_index"` // -1 表示渠道整体
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`
+	Successes int    `json:"successes"`
+	OpenedAt  int64  `json:"opened_at"`
+}
+
+type circuitBreakerKey struct {
+	channelId int
+	keyIndex  int
+}
+
+func (k circuitBreakerKey) field(name string) string {
+	return fmt.Sprintf("%d:%d:%s", k.channelId, k.keyIndex, name)
+}
+
+type circuitBreaker struct {
+	failures  int
+	successes int
+	openedAt  int64 // 0 while closed
+}
+
+// state derives the breaker state. An open breaker turns half-open once its
+// cooldown is over.
+func (b *circuitBreaker) state(now int64) string {
+	if b.openedAt == 0 {
+		return CircuitBreakerClosed
+	}
+	if now < b.openedAt+int64(operation_setting.GetCircuitBreakerSetting().CooldownSeconds) {
+		return CircuitBreakerOpen
+	}
+	return CircuitBreakerHalfOpen
+}
+
+var (
+	// with Redis enabled this is a copy of the shared state, synced every second
+	circuitBreakers     = make(map[circuitBreakerKey]*circuitBreaker)
+	circuitBreakerProbe = make(map[circuitBreakerKey]int64)
+	circuitBreakerLock  sync.Mutex
+	circuitBreakerSync  sync.Once
+)
+
+func circuitBreakerEnabled() bool {
+	if !operation_setting.GetCircuitBreakerSetting().Enabled {
+		return false
+	}
+	if common.RedisEnabled {
+		circuitBreakerSync.Do(startCircuitBreakerSync)
+	}
+	return true
+}
+
+func startCircuitBreakerSync() {
+	gopool.Go(func() {
+		for {
+			syncCircuitBreakers()
+			time.Sleep(time.Second)
+		}
+	})
+}
+
+func syncCircuitBreakers() {
+	fields, err := common.RDB.HGetAll(context.Background(), circuitBreakerRedisKey).Result()
+	if err != nil {
+		common.SysLog("failed to sync circuit breakers: " + err.Error())
+		return
+	}
+	breakers := make(map[circuitBreakerKey]*circuitBreaker)
+	for field, value := range fields {
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 {
+			continue
+		}
+		channelId, err1 := strconv.Atoi(parts[0])
+		keyIndex, err2 := strconv.Atoi(parts[1])
+		n, err3 := strconv.ParseInt(value, 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		key := circuitBreakerKey{channelId: channelId, keyIndex: keyIndex}
+		b, ok := breakers[key]
+		if !ok {
+			b = &circuitBreaker{}
+			breakers[key] = b
+		}
+		switch parts[2] {
+		case "failures":
+			b.failures = int(n)
+		case "successes":
+			b.successes = int(n)
+		case "opened_at":
+			b.openedAt = n
+		}
+	}
+	circuitBreakerLock.Lock()
+	circuitBreakers = breakers
+	circuitBreakerLock.Unlock()
+}
+
+func circuitBreakerKeys(channelId int, keyIndex int) []circuitBreakerKey {
+	keys := []circuitBreakerKey{{channelId: channelId, keyIndex: -1}}
+	if keyIndex >= 0 {
+		keys = append(keys, circuitBreakerKey{channelId: channelId, keyIndex: keyIndex})
+	}
+	return keys
+}
+
+func getCircuitBreakerState(key circuitBreakerKey, now int64) string {
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	b, ok := circuitBreakers[key]
+	if !ok {
+		return CircuitBreakerClosed
+	}
+	return b.state(now)
+}
+
+// setCircuitBreaker stores the new counters of a breaker, dropping breakers
+// that are back to their initial state.
+func setCircuitBreaker(key circuitBreakerKey, b circuitBreaker) {
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	if b == (circuitBreaker{}) {
+		delete(circuitBreakers, key)
+		return
+	}
+	circuitBreakers[key] = &b
+}
+
+func openCircuitBreaker(key circuitBreakerKey, now int64) {
+	if common.RedisEnabled {
+		err := common.RDB.HSet(context.Background(), circuitBreakerRedisKey,
+			key.field("opened_at"), now, key.field("failures"), 0, key.field("successes"), 0).Err()
+		if err != nil {
+			common.SysLog("failed to open circuit breaker: " + err.Error())
+		}
+	}
+	setCircuitBreaker(key, circuitBreaker{openedAt: now})
+	common.SysLog(fmt.Sprintf("渠道 #%d（key %d）熔断，%d 秒后进入半开状态", key.channelId, key.keyIndex, operation_setting.GetCircuitBreakerSetting().CooldownSeconds))
+}
+
+func closeCircuitBreaker(key circuitBreakerKey) {
+	if common.RedisEnabled {
+		err := common.RDB.HDel(context.Background(), circuitBreakerRedisKey,
+			key.field("opened_at"), key.field("failures"), key.field("successes")).Err()
+		if err != nil {
+			common.SysLog("failed to close circuit breaker: " + err.Error())
+		}
+	}
+	setCircuitBreaker(key, circuitBreaker{})
+}
+
+// incrCircuitBreaker adds one to a counter of the breaker and returns the new
+// value, which is shared by all nodes when Redis is enabled.
+func incrCircuitBreaker(key circuitBreakerKey, name string) (int, error) {
+	if common.RedisEnabled {
+		n, err := common.RDB.HIncrBy(context.Background(), circuitBreakerRedisKey, key.field(name), 1).Result()
+		if err != nil {
+			return 0, err
+		}
+		circuitBreakerLock.Lock()
+		b, ok := circuitBreakers[key]
+		if !ok {
+			b = &circuitBreaker{}
+			circuitBreakers[key] = b
+		}
+		if name == "failures" {
+			b.failures = int(n)
+		} else {
+			b.successes = int(n)
+		}
+		circuitBreakerLock.Unlock()
+		return int(n), nil
+	}
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	b, ok := circuitBreakers[key]
+	if !ok {
+		b = &circuitBreaker{}
+		circuitBreakers[key] = b
+	}
+	if name == "failures" {
+		b.failures++
+		return b.failures, nil
+	}
+	b.successes++
+	return b.successes, nil
+}
+
+// RecordCircuitBreakerFailure counts a failed request against the breakers of
+// the channel and of the key used. keyIndex is -1 for single-key channels.
+func RecordCircuitBreakerFailure(channelId int, keyIndex int) {
+	if !circuitBreakerEnabled() {
+		return
+	}
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now().Unix()
+	for _, key := range circuitBreakerKeys(channelId, keyIndex) {
+		if getCircuitBreakerState(key, now) != CircuitBreakerClosed {
+			// a failed probe, or a request let through because nothing else was left
+			openCircuitBreaker(key, now)
+			continue
+		}
+		failures, err := incrCircuitBreaker(key, "failures")
+		if err != nil {
+			common.SysLog("failed to record circuit breaker failure: " + err.Error())
+			continue
+		}
+		if failures >= setting.FailureThreshold {
+			openCircuitBreaker(key, now)
+		}
+	}
+}
+
+// RecordCircuitBreakerSuccess resets the failure count of a closed breaker and
+// closes a half-open one after enough successful probes.
+func RecordCircuitBreakerSuccess(channelId int, keyIndex int) {
+	if !circuitBreakerEnabled() {
+		return
+	}
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now().Unix()
+	for _, key := range circuitBreakerKeys(channelId, keyIndex) {
+		circuitBreakerLock.Lock()
+		var b circuitBreaker
+		current, ok := circuitBreakers[key]
+		if ok {
+			b = *current
+		}
+		circuitBreakerLock.Unlock()
+		if !ok {
+			continue
+		}
+		if b.state(now) == CircuitBreakerClosed {
+			if b.failures > 0 {
+				closeCircuitBreaker(key)
+			}
+			continue
+		}
+		successes, err := incrCircuitBreaker(key, "successes")
+		if err != nil {
+			common.SysLog("failed to record circuit breaker success: " + err.Error())
+			continue
+		}
+		if successes >= setting.RecoverySuccesses {
+			closeCircuitBreaker(key)
+			common.SysLog(fmt.Sprintf("渠道 #%d（key %d）熔断恢复", key.channelId, key.keyIndex))
+		}
+	}
+}
+
+// claimCircuitBreakerProbe lets one request per probe interval through a
+// half-open breaker, across all nodes when Redis is enabled.
+func claimCircuitBreakerProbe(key circuitBreakerKey, now int64) bool {
+	interval := operation_setting.GetCircuitBreakerSetting().ProbeIntervalSeconds
+	if interval <= 0 {
+		interval = 1
+	}
+	if common.RedisEnabled {
+		ok, err := common.RDB.SetNX(context.Background(), "circuit_breaker_probe:"+key.field("probe"), 1, time.Duration(interval)*time.Second).Result()
+		return err == nil && ok
+	}
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	if now-circuitBreakerProbe[key] < int64(interval) {
+		return false
+	}
+	circuitBreakerProbe[key] = now
+	return true
+}
+
+// circuitBreakerAdmits reports whether the breaker lets a request through now.
+// probe is set when the request is the half-open probe, which should be sent
+// to this target rather than to any other candidate.
+func circuitBreakerAdmits(key circuitBreakerKey, now int64) (admitted bool, probe bool) {
+	switch getCircuitBreakerState(key, now) {
+	case CircuitBreakerClosed:
+		return true, false
+	case CircuitBreakerHalfOpen:
+		if claimCircuitBreakerProbe(key, now) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// filterChannelsByCircuitBreaker drops channels whose breaker is open. A
+// channel whose half-open probe was claimed is returned on its own.
+func filterChannelsByCircuitBreaker(channels []*Channel) ([]*Channel, *Channel) {
+	if !circuitBreakerEnabled() {
+		return channels, nil
+	}
+	now := time.Now().Unix()
+	admitted := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		ok, probe := circuitBreakerAdmits(circuitBreakerKey{channelId: channel.Id, keyIndex: -1}, now)
+		if probe {
+			return nil, channel
+		}
+		if ok {
+			admitted = append(admitted, channel)
+		}
+	}
+	return admitted, nil
+}
+
+// filterAbilitiesByCircuitBreaker is filterChannelsByCircuitBreaker for the
+// database lookup.
+func filterAbilitiesByCircuitBreaker(abilities []Ability) ([]Ability, *Ability) {
+	if len(abilities) == 0 || !circuitBreakerEnabled() {
+		return abilities, nil
+	}
+	now := time.Now().Unix()
+	admitted := make([]Ability, 0, len(abilities))
+	for i, ability := range abilities {
+		ok, probe := circuitBreakerAdmits(circuitBreakerKey{channelId: ability.ChannelId, keyIndex: -1}, now)
+		if probe {
+			return nil, &abilities[i]
+		}
+		if ok {
+			admitted = append(admitted, ability)
+		}
+	}
+	return admitted, nil
+}
+
+// filterKeysByCircuitBreaker is filterChannelsByCircuitBreaker for the keys of
+// a multi-key channel. probeIdx is -1 when no probe was claimed.
+func filterKeysByCircuitBreaker(channelId int, keyIndexes []int) (admitted []int, probeIdx int) {
+	if !circuitBreakerEnabled() {
+		return keyIndexes, -1
+	}
+	now := time.Now().Unix()
+	admitted = make([]int, 0, len(keyIndexes))
+	for _, idx := range keyIndexes {
+		ok, probe := circuitBreakerAdmits(circuitBreakerKey{channelId: channelId, keyIndex: idx}, now)
+		if probe {
+			return nil, idx
+		}
+		if ok {
+			admitted = append(admitted, idx)
+		}
+	}
+	return admitted, -1
+}
+
+// GetCircuitBreakers returns the breakers of a channel that are not in their
+// initial state, the channel's own first.
+func GetCircuitBreakers(channelId int) []CircuitBreakerStatus {
+	now := time.Now().Unix()
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	var statuses []CircuitBreakerStatus
+	for key, b := range circuitBreakers {
+		if key.channelId != channelId {
+			continue
+		}
+		statuses = append(statuses, CircuitBreakerStatus{
+			KeyIndex:  key.keyIndex,
+			State:     b.state(now),
+			Failures:  b.failures,
+			Successes: b.successes,
+			OpenedAt:  b.openedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].KeyIndex < statuses[j].KeyIndex
+	})
+	return statuses
+}
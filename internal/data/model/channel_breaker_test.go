@@ -0,0 +1,100 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+)
+
+// TestCircuitBreakerLifecycle tests closed -> open -> half-open -> closed
+func TestCircuitBreakerLifecycle(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	setting := operation_setting.GetCircuitBreakerSetting()
+	original := *setting
+	*setting = operation_setting.CircuitBreakerSetting{
+		Enabled:              true,
+		FailureThreshold:     2,
+		CooldownSeconds:      60,
+		ProbeIntervalSeconds: 60,
+		RecoverySuccesses:    2,
+	}
+	defer func() {
+		common.RedisEnabled = redisEnabled
+		*setting = original
+	}()
+
+	channels := []*Channel{{Id: 101}, {Id: 102}}
+	RecordCircuitBreakerFailure(101, -1)
+	if admitted, _ := filterChannelsByCircuitBreaker(channels); len(admitted) != 2 {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	RecordCircuitBreakerFailure(101, -1)
+	admitted, probe := filterChannelsByCircuitBreaker(channels)
+	if probe != nil || len(admitted) != 1 || admitted[0].Id != 102 {
+		t.Fatalf("open channel was admitted: %v %v", admitted, probe)
+	}
+
+	// let the cooldown pass
+	circuitBreakerLock.Lock()
+	circuitBreakers[circuitBreakerKey{channelId: 101, keyIndex: -1}].openedAt -= 120
+	circuitBreakerLock.Unlock()
+	if _, probe = filterChannelsByCircuitBreaker(channels); probe == nil || probe.Id != 101 {
+		t.Fatal("half-open channel did not get a probe")
+	}
+	if admitted, probe = filterChannelsByCircuitBreaker(channels); probe != nil || len(admitted) != 1 {
+		t.Fatal("half-open channel got a second probe within the interval")
+	}
+
+	RecordCircuitBreakerSuccess(101, -1)
+	if statuses := GetCircuitBreakers(101); len(statuses) != 1 || statuses[0].State != CircuitBreakerHalfOpen {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	RecordCircuitBreakerSuccess(101, -1)
+	if statuses := GetCircuitBreakers(101); len(statuses) != 0 {
+		t.Errorf("breaker did not close: %+v", statuses)
+	}
+}
+
+// TestGetChannelSkipsOpenTier tests that the selection without the memory
+// cache moves to the next priority when every breaker of a tier is open
+func TestGetChannelSkipsOpenTier(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{}, &DisabledChannelModel{})
+	memoryCacheEnabled := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = false
+	setting := operation_setting.GetCircuitBreakerSetting()
+	original := *setting
+	*setting = operation_setting.CircuitBreakerSetting{
+		Enabled:              true,
+		FailureThreshold:     1,
+		CooldownSeconds:      60,
+		ProbeIntervalSeconds: 60,
+		RecoverySuccesses:    1,
+	}
+	defer func() {
+		common.MemoryCacheEnabled = memoryCacheEnabled
+		*setting = original
+		circuitBreakerLock.Lock()
+		delete(circuitBreakers, circuitBreakerKey{channelId: 111, keyIndex: -1})
+		circuitBreakerLock.Unlock()
+	}()
+
+	high, low := int64(10), int64(0)
+	for _, channel := range []*Channel{
+		{Id: 111, Name: "primary", Key: "sk-primary", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high},
+		{Id: 112, Name: "backup", Key: "sk-backup", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &low},
+	} {
+		if err := DB.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := channel.AddAbilities(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	RecordCircuitBreakerFailure(111, -1)
+	if channel, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{}); err != nil || channel == nil || channel.Id != 112 {
+		t.Fatalf("request went to %v, %v, want the backup channel", channel, err)
+	}
+}
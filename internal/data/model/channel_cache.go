@@ -12,6 +12,8 @@ import (
 
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
 	"github.com/QuantumNous/lurus-api/internal/pkg/setting/ratio_setting"
 )
 
@@ -36,21 +38,36 @@ func InitChannelCache() {
 		groups[ability.Group] = true
 	}
 	newGroup2model2channels := make(map[string]map[string][]int)
+	newGroup2model2rollouts := make(map[string]map[string][]int)
 	for group := range groups {
 		newGroup2model2channels[group] = make(map[string][]int)
+		newGroup2model2rollouts[group] = make(map[string][]int)
+	}
+	// models of enabled channels disabled on their own, e.g. by a failing test
+	disabledModels, err := getAllDisabledChannelModels()
+	if err != nil {
+		common.SysError("failed to load disabled channel models: " + err.Error())
 	}
 	for _, channel := range channels {
 		if channel.Status != common.ChannelStatusEnabled {
 			continue // skip disabled channels
 		}
+		group2model := newGroup2model2channels
+		if channel.GetSetting().RolloutMode != "" {
+			// canary and shadow channels are only drawn by their rollout percentage
+			group2model = newGroup2model2rollouts
+		}
 		groups := strings.Split(channel.Group, ",")
 		for _, group := range groups {
 			models := strings.Split(channel.Models, ",")
 			for _, model := range models {
-				if _, ok := newGroup2model2channels[group][model]; !ok {
-					newGroup2model2channels[group][model] = make([]int, 0)
+				if disabledModels[channel.Id][model] {
+					continue
+				}
+				if _, ok := group2model[group][model]; !ok {
+					group2model[group][model] = make([]int, 0)
 				}
-				newGroup2model2channels[group][model] = append(newGroup2model2channels[group][model], channel.Id)
+				group2model[group][model] = append(group2model[group][model], channel.Id)
 			}
 		}
 	}
@@ -67,6 +84,7 @@ func InitChannelCache() {
 
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
+	group2model2rollouts = newGroup2model2rollouts
 	//channelsIDM = newChannelId2channel
 	for i, channel := range newChannelId2channel {
 		if channel.ChannelInfo.IsMultiKey {
@@ -112,9 +130,31 @@ func SyncChannelCacheWithContext(ctx context.Context, frequency int) {
 }
 
 func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+	return GetAffinitySatisfiedChannel(group, model, retry, ChannelDemand{})
+}
+
+// GetAffinitySatisfiedChannel is GetRandomSatisfiedChannel for a request with
+// a demand: a session affinity key instead of a random draw picks the channel
+// among the available ones of the priority, and channels whose context window
+// cannot fit the prompt are skipped. A *ContextWindowError is returned when no
+// channel fits.
+func GetAffinitySatisfiedChannel(group string, model string, retry int, demand ChannelDemand) (*Channel, error) {
+	var modelLimit dto.ModelContextLimit
+	if demand.PromptTokens > 0 {
+		modelLimit = GetModelContextLimit(model)
+	}
+
+	// canary channels take their share of the first attempts, retries go to
+	// the regular channels
+	if retry == 0 {
+		if canary := GetCanaryChannel(group, model, demand.AffinityKey); canary != nil && demand.Fits(canary, model) == nil {
+			return canary, nil
+		}
+	}
+
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetChannel(group, model, retry)
+		return GetChannel(group, model, retry, demand)
 	}
 
 	channelSyncLock.RLock()
@@ -135,6 +175,9 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	if len(channels) == 1 {
 		if channel, ok := channelsIDM[channels[0]]; ok {
+			if _, windowErr := filterChannelsByContextWindow([]*Channel{channel}, model, modelLimit, demand); windowErr != nil {
+				return nil, windowErr
+			}
 			return channel, nil
 		}
 		return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channels[0])
@@ -157,24 +200,85 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	if retry >= len(uniquePriorities) {
 		retry = len(uniquePriorities) - 1
 	}
-	targetPriority := int64(sortedUniquePriorities[retry])
 
-	// get the priority for the given retry number
 	var sumWeight = 0
 	var targetChannels []*Channel
-	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			if channel.GetPriority() == targetPriority {
+	for {
+		targetPriority := int64(sortedUniquePriorities[retry])
+
+		// get the priority for the given retry number
+		sumWeight = 0
+		targetChannels = nil
+		for _, channelId := range channels {
+			if channel, ok := channelsIDM[channelId]; ok {
+				if channel.GetPriority() == targetPriority {
+					sumWeight += channel.GetWeight()
+					targetChannels = append(targetChannels, channel)
+				}
+			} else {
+				return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+			}
+		}
+
+		if len(targetChannels) == 0 {
+			return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
+		}
+
+		fitting, windowErr := filterChannelsByContextWindow(targetChannels, model, modelLimit, demand)
+		if windowErr != nil {
+			if retry < len(sortedUniquePriorities)-1 {
+				// 该优先级的渠道上下文窗口均不足，尝试下一优先级
+				retry++
+				continue
+			}
+			return nil, windowErr
+		}
+		if len(fitting) < len(targetChannels) {
+			targetChannels = fitting
+			sumWeight = 0
+			for _, channel := range targetChannels {
 				sumWeight += channel.GetWeight()
-				targetChannels = append(targetChannels, channel)
 			}
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
 		}
+
+		admitted, probe := filterChannelsByCircuitBreaker(targetChannels)
+		if probe != nil {
+			return probe, nil
+		}
+		if len(admitted) == 0 && retry < len(sortedUniquePriorities)-1 {
+			// 该优先级的渠道均已熔断，尝试下一优先级
+			retry++
+			continue
+		}
+		// 最后一个优先级的渠道也全部熔断时，仍在其中选择
+		if len(admitted) == 0 {
+			admitted = targetChannels
+		}
+		available := filterSaturatedChannels(filterRateLimitedChannels(admitted))
+		if len(available) == 0 && retry < len(sortedUniquePriorities)-1 {
+			// 该优先级的渠道均被上游限流或并发已满，尝试下一优先级
+			retry++
+			continue
+		}
+		// 全部不可用时仍返回其中一个，并发已满时由调用方排队等待
+		if len(available) > 0 {
+			admitted = available
+		}
+		if len(admitted) < len(targetChannels) {
+			targetChannels = admitted
+			sumWeight = 0
+			for _, channel := range targetChannels {
+				sumWeight += channel.GetWeight()
+			}
+		}
+		break
 	}
 
-	if len(targetChannels) == 0 {
-		return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
+	if demand.AffinityKey != "" {
+		return pickAffinityChannel(demand.AffinityKey, targetChannels), nil
+	}
+	if operation_setting.GetChannelSelectSetting().IsAdaptive(group) {
+		return pickAdaptiveChannel(targetChannels, sumWeight), nil
 	}
 
 	// smoothing factor and adjustment
@@ -0,0 +1,221 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+)
+
+// in-flight counters are kept in Redis as "channel_inflight:<channel>:<key index>"
+// so limits hold across nodes. The TTL, refreshed on every change, only
+// matters when a node dies holding slots.
+const (
+	channelInFlightRedisPrefix = "channel_inflight:"
+	channelInFlightTTL         = 30 * time.Minute
+)
+
+type channelSlotKey struct {
+	channelId int
+	keyIndex  int // -1 for the channel itself
+}
+
+func (k channelSlotKey) redisKey() string {
+	return fmt.Sprintf("%s%d:%d", channelInFlightRedisPrefix, k.channelId, k.keyIndex)
+}
+
+var (
+	channelInFlight     = make(map[channelSlotKey]int64)
+	channelInFlightLock sync.Mutex
+
+	// closed and replaced every time a slot is released
+	channelSlotReleased     = make(chan struct{})
+	channelSlotReleasedLock sync.Mutex
+)
+
+// ChannelSlotReleased returns a channel that is closed the next time a slot is
+// released on this node. Releases on other nodes are not signalled.
+func ChannelSlotReleased() <-chan struct{} {
+	channelSlotReleasedLock.Lock()
+	defer channelSlotReleasedLock.Unlock()
+	return channelSlotReleased
+}
+
+func notifyChannelSlotReleased() {
+	channelSlotReleasedLock.Lock()
+	defer channelSlotReleasedLock.Unlock()
+	close(channelSlotReleased)
+	channelSlotReleased = make(chan struct{})
+}
+
+// incrChannelInFlight adds delta to the in-flight counter and returns the new
+// value. Redis errors are logged and reported as 0 so that limits fail open.
+func incrChannelInFlight(key channelSlotKey, delta int64) int64 {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		pipe := common.RDB.TxPipeline()
+		incr := pipe.IncrBy(ctx, key.redisKey(), delta)
+		pipe.Expire(ctx, key.redisKey(), channelInFlightTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysLog("failed to update channel in-flight counter: " + err.Error())
+			return 0
+		}
+		n := incr.Val()
+		if n < 0 {
+			// the counter expired while requests were still running
+			common.RDB.IncrBy(ctx, key.redisKey(), -n)
+			n = 0
+		}
+		return n
+	}
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	n := channelInFlight[key] + delta
+	if n <= 0 {
+		delete(channelInFlight, key)
+		return 0
+	}
+	channelInFlight[key] = n
+	return n
+}
+
+func getChannelInFlight(keys []channelSlotKey) []int64 {
+	counts := make([]int64, len(keys))
+	if len(keys) == 0 {
+		return counts
+	}
+	if common.RedisEnabled {
+		redisKeys := make([]string, len(keys))
+		for i, key := range keys {
+			redisKeys[i] = key.redisKey()
+		}
+		values, err := common.RDB.MGet(context.Background(), redisKeys...).Result()
+		if err != nil {
+			common.SysLog("failed to get channel in-flight counters: " + err.Error())
+			return counts
+		}
+		for i, value := range values {
+			if s, ok := value.(string); ok {
+				counts[i], _ = strconv.ParseInt(s, 10, 64)
+			}
+		}
+		return counts
+	}
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	for i, key := range keys {
+		counts[i] = channelInFlight[key]
+	}
+	return counts
+}
+
+// ChannelSlot is a concurrency slot held by a request on a channel and, for
+// multi-key channels, on the key it uses. A nil slot holds nothing.
+type ChannelSlot struct {
+	keys []channelSlotKey
+	once sync.Once
+}
+
+// Release gives the slot back. It is safe to call more than once.
+func (s *ChannelSlot) Release() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		for _, key := range s.keys {
+			incrChannelInFlight(key, -1)
+		}
+		if len(s.keys) > 0 {
+			notifyChannelSlotReleased()
+		}
+	})
+}
+
+// AcquireChannelSlot takes a slot on the channel, and on the key for multi-key
+// channels, if neither is at its max concurrency. keyIndex is -1 for
+// single-key channels. Channels without limits always admit the request.
+func AcquireChannelSlot(channelId int, keyIndex int) (*ChannelSlot, bool) {
+	channel, err := CacheGetChannel(channelId)
+	if err != nil {
+		return nil, true
+	}
+	setting := channel.GetSetting()
+	type limit struct {
+		key            channelSlotKey
+		maxConcurrency int64
+	}
+	var limits []limit
+	if setting.MaxConcurrency > 0 {
+		limits = append(limits, limit{channelSlotKey{channelId: channelId, keyIndex: -1}, int64(setting.MaxConcurrency)})
+	}
+	if keyIndex >= 0 && channel.ChannelInfo.IsMultiKey && setting.KeyMaxConcurrency > 0 {
+		limits = append(limits, limit{channelSlotKey{channelId: channelId, keyIndex: keyIndex}, int64(setting.KeyMaxConcurrency)})
+	}
+	if len(limits) == 0 {
+		return nil, true
+	}
+	slot := &ChannelSlot{}
+	for _, l := range limits {
+		if incrChannelInFlight(l.key, 1) > l.maxConcurrency {
+			incrChannelInFlight(l.key, -1)
+			slot.Release()
+			return nil, false
+		}
+		slot.keys = append(slot.keys, l.key)
+	}
+	return slot, true
+}
+
+// filterSaturatedChannels drops channels that are at their max concurrency.
+func filterSaturatedChannels(channels []*Channel) []*Channel {
+	var keys []channelSlotKey
+	var limits []int64
+	for _, channel := range channels {
+		if maxConcurrency := channel.GetSetting().MaxConcurrency; maxConcurrency > 0 {
+			keys = append(keys, channelSlotKey{channelId: channel.Id, keyIndex: -1})
+			limits = append(limits, int64(maxConcurrency))
+		}
+	}
+	if len(keys) == 0 {
+		return channels
+	}
+	saturated := make(map[int]bool)
+	for i, n := range getChannelInFlight(keys) {
+		if n >= limits[i] {
+			saturated[keys[i].channelId] = true
+		}
+	}
+	if len(saturated) == 0 {
+		return channels
+	}
+	available := make([]*Channel, 0, len(channels)-len(saturated))
+	for _, channel := range channels {
+		if !saturated[channel.Id] {
+			available = append(available, channel)
+		}
+	}
+	return available
+}
+
+// filterSaturatedKeys is filterSaturatedChannels for the keys of a multi-key
+// channel.
+func filterSaturatedKeys(channel *Channel, keyIndexes []int) []int {
+	maxConcurrency := int64(channel.GetSetting().KeyMaxConcurrency)
+	if maxConcurrency <= 0 {
+		return keyIndexes
+	}
+	keys := make([]channelSlotKey, len(keyIndexes))
+	for i, idx := range keyIndexes {
+		keys[i] = channelSlotKey{channelId: channel.Id, keyIndex: idx}
+	}
+	available := make([]int, 0, len(keyIndexes))
+	for i, n := range getChannelInFlight(keys) {
+		if n < maxConcurrency {
+			available = append(available, keyIndexes[i])
+		}
+	}
+	return available
+}
@@ -0,0 +1,100 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+)
+
+// TestChannelSlots tests that channel and key limits are enforced and that
+// saturated channels and keys are skipped during selection
+func TestChannelSlots(t *testing.T) {
+	redisEnabled, memoryCacheEnabled := common.RedisEnabled, common.MemoryCacheEnabled
+	common.RedisEnabled, common.MemoryCacheEnabled = false, true
+	setting := `{"max_concurrency":2,"key_max_concurrency":1}`
+	limited := &Channel{Id: 201, Setting: &setting, ChannelInfo: ChannelInfo{IsMultiKey: true}}
+	unlimited := &Channel{Id: 202}
+	channelSyncLock.Lock()
+	ids := channelsIDM
+	channelsIDM = map[int]*Channel{201: limited, 202: unlimited}
+	channelSyncLock.Unlock()
+	defer func() {
+		common.RedisEnabled, common.MemoryCacheEnabled = redisEnabled, memoryCacheEnabled
+		channelSyncLock.Lock()
+		channelsIDM = ids
+		channelSyncLock.Unlock()
+	}()
+
+	first, ok := AcquireChannelSlot(201, 0)
+	if !ok {
+		t.Fatal("first slot was refused")
+	}
+	if _, ok := AcquireChannelSlot(201, 0); ok {
+		t.Fatal("key limit was not enforced")
+	}
+	if available := filterSaturatedKeys(limited, []int{0, 1}); len(available) != 1 || available[0] != 1 {
+		t.Errorf("available keys = %v", available)
+	}
+	second, ok := AcquireChannelSlot(201, 1)
+	if !ok {
+		t.Fatal("slot on a free key was refused")
+	}
+	if _, ok := AcquireChannelSlot(201, 2); ok {
+		t.Fatal("channel limit was not enforced")
+	}
+	if available := filterSaturatedChannels([]*Channel{limited, unlimited}); len(available) != 1 || available[0].Id != 202 {
+		t.Errorf("available channels = %v", available)
+	}
+	if _, ok := AcquireChannelSlot(202, -1); !ok {
+		t.Error("channel without limit refused a request")
+	}
+
+	released := ChannelSlotReleased()
+	first.Release()
+	first.Release()
+	select {
+	case <-released:
+	default:
+		t.Error("release was not signalled")
+	}
+	if _, ok := AcquireChannelSlot(201, 0); !ok {
+		t.Error("released slot was not reusable")
+	}
+	if _, ok := AcquireChannelSlot(201, 2); ok {
+		t.Error("double release freed a second slot")
+	}
+	second.Release()
+}
+
+// TestGetChannelSkipsSaturated tests that the selection without the memory
+// cache skips channels at their max concurrency, falling back to a lower
+// priority
+func TestGetChannelSkipsSaturated(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{}, &DisabledChannelModel{})
+	memoryCacheEnabled := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = false
+	defer func() { common.MemoryCacheEnabled = memoryCacheEnabled }()
+
+	setting := `{"max_concurrency":1}`
+	high, low := int64(10), int64(0)
+	for _, channel := range []*Channel{
+		{Id: 211, Name: "primary", Key: "sk-primary", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high, Setting: &setting},
+		{Id: 212, Name: "backup", Key: "sk-backup", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &low},
+	} {
+		if err := DB.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := channel.AddAbilities(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	slot, ok := AcquireChannelSlot(211, -1)
+	if !ok {
+		t.Fatal("slot was refused")
+	}
+	defer slot.Release()
+	if channel, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{}); err != nil || channel == nil || channel.Id != 212 {
+		t.Fatalf("request went to %v, %v, want the backup channel", channel, err)
+	}
+}
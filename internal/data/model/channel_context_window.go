@@ -0,0 +1,83 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+)
+
+// ChannelDemand is what a request asks of the channel selected for it.
+type ChannelDemand struct {
+	AffinityKey  string // session affinity key, empty draws at random
+	PromptTokens int    // estimated prompt tokens, 0 when they were not counted
+	MaxTokens    int    // output tokens the request asks for, 0 when unset
+}
+
+// ContextWindowError tells that a request does not fit the context window of
+// a model.
+type ContextWindowError struct {
+	Model        string
+	PromptTokens int
+	MaxTokens    int
+	Limit        dto.ModelContextLimit
+}
+
+func (e *ContextWindowError) Error() string {
+	if e.Limit.MaxOutputTokens > 0 && e.MaxTokens > e.Limit.MaxOutputTokens {
+		return fmt.Sprintf("max_tokens %d exceeds the max output of %d tokens of model %s", e.MaxTokens, e.Limit.MaxOutputTokens, e.Model)
+	}
+	return fmt.Sprintf("prompt of %d tokens plus max_tokens %d exceeds the context window of %d tokens of model %s", e.PromptTokens, e.MaxTokens, e.Limit.ContextLength, e.Model)
+}
+
+// GetContextLimit returns the context window of the model on the channel: the
+// model meta overridden by the channel setting.
+func (channel *Channel) GetContextLimit(modelName string) dto.ModelContextLimit {
+	return channel.contextLimit(modelName, GetModelContextLimit(modelName))
+}
+
+func (channel *Channel) contextLimit(modelName string, modelLimit dto.ModelContextLimit) dto.ModelContextLimit {
+	if override, ok := channel.GetSetting().ModelContextLimits[modelName]; ok {
+		return modelLimit.Override(override)
+	}
+	return modelLimit
+}
+
+// Fits reports whether the request fits the context window of the model on
+// the channel.
+func (d ChannelDemand) Fits(channel *Channel, modelName string) *ContextWindowError {
+	if d.PromptTokens <= 0 || channel == nil {
+		return nil
+	}
+	return d.fits(channel.GetContextLimit(modelName), modelName)
+}
+
+func (d ChannelDemand) fits(limit dto.ModelContextLimit, modelName string) *ContextWindowError {
+	if (limit.ContextLength > 0 && d.PromptTokens+d.MaxTokens > limit.ContextLength) ||
+		(limit.MaxOutputTokens > 0 && d.MaxTokens > limit.MaxOutputTokens) {
+		return &ContextWindowError{Model: modelName, PromptTokens: d.PromptTokens, MaxTokens: d.MaxTokens, Limit: limit}
+	}
+	return nil
+}
+
+// filterChannelsByContextWindow drops channels whose context window cannot
+// fit the request. When none is left it returns the error of the channel with
+// the largest window.
+func filterChannelsByContextWindow(channels []*Channel, modelName string, modelLimit dto.ModelContextLimit, demand ChannelDemand) ([]*Channel, *ContextWindowError) {
+	if demand.PromptTokens <= 0 {
+		return channels, nil
+	}
+	fitting := make([]*Channel, 0, len(channels))
+	var largest *ContextWindowError
+	for _, channel := range channels {
+		windowErr := demand.fits(channel.contextLimit(modelName, modelLimit), modelName)
+		if windowErr == nil {
+			fitting = append(fitting, channel)
+		} else if largest == nil || windowErr.Limit.ContextLength > largest.Limit.ContextLength {
+			largest = windowErr
+		}
+	}
+	if len(fitting) == 0 {
+		return nil, largest
+	}
+	return fitting, nil
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+)
+
+// TestFilterChannelsByContextWindow tests that channels are skipped by the
+// context window of the model meta or their own override
+func TestFilterChannelsByContextWindow(t *testing.T) {
+	largeSetting := `{"model_context_limits":{"gpt-4o":{"context_length":200000}}}`
+	smallSetting := `{"model_context_limits":{"gpt-4o":{"context_length":8000,"max_output_tokens":1000}}}`
+	regular := &Channel{Id: 401}
+	large := &Channel{Id: 402, Setting: &largeSetting}
+	small := &Channel{Id: 403, Setting: &smallSetting}
+	channels := []*Channel{regular, large, small}
+	modelLimit := dto.ModelContextLimit{ContextLength: 128000, MaxOutputTokens: 16000}
+
+	if fitting, err := filterChannelsByContextWindow(channels, "gpt-4o", modelLimit, ChannelDemand{}); err != nil || len(fitting) != 3 {
+		t.Fatalf("uncounted request fits %d channels, %v", len(fitting), err)
+	}
+	if fitting, err := filterChannelsByContextWindow(channels, "gpt-4o", modelLimit, ChannelDemand{PromptTokens: 6000, MaxTokens: 4000}); err != nil || len(fitting) != 2 || fitting[0].Id != 401 || fitting[1].Id != 402 {
+		t.Fatalf("request fits %v, %v, want the regular and large channels", fitting, err)
+	}
+	if fitting, err := filterChannelsByContextWindow(channels, "gpt-4o", modelLimit, ChannelDemand{PromptTokens: 150000}); err != nil || len(fitting) != 1 || fitting[0].Id != 402 {
+		t.Fatalf("long request fits %v, %v, want the large channel", fitting, err)
+	}
+
+	fitting, err := filterChannelsByContextWindow(channels, "gpt-4o", modelLimit, ChannelDemand{PromptTokens: 250000})
+	if len(fitting) != 0 || err == nil {
+		t.Fatalf("oversized request fits %v", fitting)
+	}
+	if err.Limit.ContextLength != 200000 {
+		t.Errorf("error names the limit %d, want the largest window", err.Limit.ContextLength)
+	}
+
+	if err := (ChannelDemand{PromptTokens: 100, MaxTokens: 32000}).fits(modelLimit, "gpt-4o"); err == nil {
+		t.Error("max_tokens above the max output fits")
+	}
+}
+
+// TestGetChannelByContextWindow tests that the selection without the memory
+// cache draws among the channels that fit the request, falling back to a
+// lower priority when none of the highest does
+func TestGetChannelByContextWindow(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{}, &DisabledChannelModel{})
+	memoryCacheEnabled := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = false
+	defer func() { common.MemoryCacheEnabled = memoryCacheEnabled }()
+
+	smallSetting := `{"model_context_limits":{"gpt-4o":{"context_length":8000}}}`
+	regularSetting := `{"model_context_limits":{"gpt-4o":{"context_length":32000}}}`
+	largeSetting := `{"model_context_limits":{"gpt-4o":{"context_length":200000}}}`
+	high, low := int64(10), int64(0)
+	for _, channel := range []*Channel{
+		{Id: 411, Name: "small", Key: "sk-small", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high, Setting: &smallSetting},
+		{Id: 412, Name: "regular", Key: "sk-regular", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high, Setting: &regularSetting},
+		{Id: 413, Name: "large", Key: "sk-large", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &low, Setting: &largeSetting},
+	} {
+		if err := DB.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := channel.AddAbilities(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		if channel, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{PromptTokens: 10000}); err != nil || channel == nil || channel.Id != 412 {
+			t.Fatalf("request went to %v, %v, want the regular channel", channel, err)
+		}
+	}
+	if channel, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{PromptTokens: 150000}); err != nil || channel == nil || channel.Id != 413 {
+		t.Fatalf("long request went to %v, %v, want the large channel", channel, err)
+	}
+	var windowErr *ContextWindowError
+	if channel, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{PromptTokens: 250000}); channel != nil || !errors.As(err, &windowErr) {
+		t.Fatalf("oversized request went to %v, %v", channel, err)
+	}
+}
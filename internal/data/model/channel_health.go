@@ -0,0 +1,229 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+)
+
+// ChannelHealth is the live health of a channel, or of one key of a multi-key
+// channel, as observed on real traffic. Latencies are exponentially weighted
+// moving averages in milliseconds, FirstToken only covers streams. Statistics
+// are kept in memory per node.
+type ChannelHealth struct {
+	ChannelId  int     `json:"channel_id"`
+	KeyIndex   int     `json:"key_index"` // -1 表示渠道整体
+	Latency    float64 `json:"latency"`
+	FirstToken float64 `json:"first_token"`
+	ErrorRate  float64 `json:"error_rate"`
+	Requests   int64   `json:"requests"`
+	UpdatedAt  int64   `json:"updated_at"`
+}
+
+type channelHealthKey struct {
+	channelId int
+	keyIndex  int
+}
+
+type channelHealthStat struct {
+	latency    float64
+	firstToken float64
+	errorRate  float64
+	requests   int64
+	updatedAt  time.Time
+}
+
+var (
+	channelHealthStats = make(map[channelHealthKey]*channelHealthStat)
+	channelHealthLock  sync.RWMutex
+)
+
+// freshness is 1 for statistics updated just now and halves every half-life,
+// so channels that get little traffic drift back to neutral.
+func (s *channelHealthStat) freshness(now time.Time) float64 {
+	halfLife := operation_setting.GetChannelSelectSetting().HalfLifeSeconds
+	if halfLife <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, now.Sub(s.updatedAt).Seconds()/float64(halfLife))
+}
+
+func ewma(current float64, sample float64, alpha float64) float64 {
+	if current == 0 {
+		return sample
+	}
+	return current + alpha*(sample-current)
+}
+
+func updateChannelHealth(key channelHealthKey, update func(stat *channelHealthStat, alpha float64)) {
+	alpha := operation_setting.GetChannelSelectSetting().EWMAAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.2
+	}
+	now := time.Now()
+	stat, ok := channelHealthStats[key]
+	if !ok {
+		stat = &channelHealthStat{updatedAt: now}
+		channelHealthStats[key] = stat
+	}
+	stat.errorRate *= stat.freshness(now)
+	update(stat, alpha)
+	stat.requests++
+	stat.updatedAt = now
+}
+
+func recordChannelHealth(channelId int, keyIndex int, update func(stat *channelHealthStat, alpha float64)) {
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	updateChannelHealth(channelHealthKey{channelId: channelId, keyIndex: -1}, update)
+	if keyIndex >= 0 {
+		updateChannelHealth(channelHealthKey{channelId: channelId, keyIndex: keyIndex}, update)
+	}
+}
+
+// RecordChannelSuccess records a request the channel answered. keyIndex is the
+// index of the key used on a multi-key channel, -1 otherwise; firstToken is 0
+// when the response was not streamed.
+func RecordChannelSuccess(channelId int, keyIndex int, latency time.Duration, firstToken time.Duration) {
+	recordChannelHealth(channelId, keyIndex, func(stat *channelHealthStat, alpha float64) {
+		stat.latency = ewma(stat.latency, float64(latency.Milliseconds()), alpha)
+		if firstToken > 0 {
+			stat.firstToken = ewma(stat.firstToken, float64(firstToken.Milliseconds()), alpha)
+		}
+		stat.errorRate = stat.errorRate * (1 - alpha)
+	})
+}
+
+// RecordChannelFailure records a request that failed because of the channel.
+func RecordChannelFailure(channelId int, keyIndex int) {
+	recordChannelHealth(channelId, keyIndex, func(stat *channelHealthStat, alpha float64) {
+		stat.errorRate = stat.errorRate + alpha*(1-stat.errorRate)
+	})
+}
+
+// GetChannelHealthStats returns the statistics of all channels and keys, with
+// the error rate decayed to now.
+func GetChannelHealthStats() []ChannelHealth {
+	now := time.Now()
+	channelHealthLock.RLock()
+	defer channelHealthLock.RUnlock()
+	stats := make([]ChannelHealth, 0, len(channelHealthStats))
+	for key, stat := range channelHealthStats {
+		stats = append(stats, ChannelHealth{
+			ChannelId:  key.channelId,
+			KeyIndex:   key.keyIndex,
+			Latency:    stat.latency,
+			FirstToken: stat.firstToken,
+			ErrorRate:  stat.errorRate * stat.freshness(now),
+			Requests:   stat.requests,
+			UpdatedAt:  stat.updatedAt.Unix(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].ChannelId != stats[j].ChannelId {
+			return stats[i].ChannelId < stats[j].ChannelId
+		}
+		return stats[i].KeyIndex < stats[j].KeyIndex
+	})
+	return stats
+}
+
+// channelHealthFactors returns a multiplier in (0, 1] for the weight of each
+// channel. Error rate counts quadratically, latency relative to the fastest
+// channel. Both fade out as the statistics age, and the factor never drops
+// below the configured minimum so unhealthy channels keep being probed.
+func channelHealthFactors(channelIds []int) []float64 {
+	minFactor := operation_setting.GetChannelSelectSetting().MinWeightPercent / 100
+	if minFactor <= 0 {
+		minFactor = 0.01
+	}
+	now := time.Now()
+	scores := make([]float64, len(channelIds))
+	freshness := make([]float64, len(channelIds))
+	errorRates := make([]float64, len(channelIds))
+	fastest := 0.0
+
+	channelHealthLock.RLock()
+	for i, channelId := range channelIds {
+		stat, ok := channelHealthStats[channelHealthKey{channelId: channelId, keyIndex: -1}]
+		if !ok {
+			continue
+		}
+		freshness[i] = stat.freshness(now)
+		errorRates[i] = stat.errorRate * freshness[i]
+		scores[i] = stat.firstToken
+		if scores[i] == 0 {
+			scores[i] = stat.latency
+		}
+		if scores[i] > 0 && (fastest == 0 || scores[i] < fastest) {
+			fastest = scores[i]
+		}
+	}
+	channelHealthLock.RUnlock()
+
+	factors := make([]float64, len(channelIds))
+	for i := range channelIds {
+		factor := (1 - errorRates[i]) * (1 - errorRates[i])
+		if scores[i] > 0 {
+			factor *= 1 - freshness[i]*(1-fastest/scores[i])
+		}
+		factors[i] = math.Max(factor, minFactor)
+	}
+	return factors
+}
+
+func pickWeighted(weights []float64) int {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total <= 0 {
+		return rand.Intn(len(weights))
+	}
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		r -= weight
+		if r < 0 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
+// pickAdaptiveChannel draws one of the channels of a priority tier with its
+// weight scaled by its health factor.
+func pickAdaptiveChannel(channels []*Channel, sumWeight int) *Channel {
+	channelIds := make([]int, len(channels))
+	for i, channel := range channels {
+		channelIds[i] = channel.Id
+	}
+	factors := channelHealthFactors(channelIds)
+	weights := make([]float64, len(channels))
+	for i, channel := range channels {
+		weight := float64(channel.GetWeight())
+		if sumWeight == 0 {
+			weight = 1
+		}
+		weights[i] = weight * factors[i]
+	}
+	return channels[pickWeighted(weights)]
+}
+
+// pickAdaptiveAbility is pickAdaptiveChannel for the database lookup, which
+// smooths weights the same way as the random mode does there.
+func pickAdaptiveAbility(abilities []Ability) int {
+	channelIds := make([]int, len(abilities))
+	for i, ability := range abilities {
+		channelIds[i] = ability.ChannelId
+	}
+	factors := channelHealthFactors(channelIds)
+	weights := make([]float64, len(abilities))
+	for i, ability := range abilities {
+		weights[i] = float64(ability.Weight+10) * factors[i]
+	}
+	return abilities[pickWeighted(weights)].ChannelId
+}
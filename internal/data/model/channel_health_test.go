@@ -0,0 +1,46 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+// TestChannelHealthFactors tests that slow and failing channels lose weight
+// smoothly and recover as their statistics age
+func TestChannelHealthFactors(t *testing.T) {
+	channelHealthLock.Lock()
+	channelHealthStats = make(map[channelHealthKey]*channelHealthStat)
+	channelHealthLock.Unlock()
+
+	RecordChannelSuccess(1, -1, time.Second, 200*time.Millisecond)
+	RecordChannelSuccess(2, -1, time.Second, 800*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		RecordChannelFailure(3, 0)
+	}
+
+	factors := channelHealthFactors([]int{1, 2, 3, 4})
+	if factors[0] != 1 {
+		t.Errorf("fastest channel factor = %f, want 1", factors[0])
+	}
+	if factors[1] >= factors[0] || factors[1] < 0.2 {
+		t.Errorf("slow channel factor = %f", factors[1])
+	}
+	if factors[2] >= 0.5 || factors[2] < 0.05 {
+		t.Errorf("failing channel factor = %f", factors[2])
+	}
+	if factors[3] != 1 {
+		t.Errorf("channel without statistics factor = %f, want 1", factors[3])
+	}
+
+	channelHealthLock.Lock()
+	channelHealthStats[channelHealthKey{channelId: 3, keyIndex: -1}].updatedAt = time.Now().Add(-time.Hour)
+	channelHealthLock.Unlock()
+	if recovered := channelHealthFactors([]int{3})[0]; recovered < 0.99 {
+		t.Errorf("stale failures still weigh %f", recovered)
+	}
+
+	stats := GetChannelHealthStats()
+	if len(stats) != 4 || stats[3].ChannelId != 3 || stats[3].KeyIndex != 0 || stats[3].Requests != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
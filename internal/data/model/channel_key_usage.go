@@ -0,0 +1,102 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"gorm.io/gorm"
+)
+
+// ChannelKeyUsage is what one key of a multi-key channel has consumed. Keys
+// are identified by a hash rather than by their index, which shifts when keys
+// are deleted.
+type ChannelKeyUsage struct {
+	Id            int    `json:"-" gorm:"primaryKey"`
+	ChannelId     int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key_usage"`
+	KeyHash       string `json:"-" gorm:"type:varchar(64);uniqueIndex:idx_channel_key_usage"`
+	KeyIndex      int    `json:"key_index" gorm:"-"`
+	UsedQuota     int64  `json:"used_quota" gorm:"type:bigint;default:0"`
+	RequestCount  int64  `json:"request_count" gorm:"default:0"`
+	LastError     string `json:"last_error" gorm:"type:text"`
+	LastErrorTime int64  `json:"last_error_time" gorm:"type:bigint"`
+	UpdatedTime   int64  `json:"updated_time" gorm:"type:bigint"`
+}
+
+func (ChannelKeyUsage) TableName() string {
+	return "channel_key_usages"
+}
+
+func channelKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// updateChannelKeyUsage applies updates to the usage row of a key, creating
+// the row first if the key has none yet.
+func updateChannelKeyUsage(channelId int, key string, updates map[string]interface{}) error {
+	hash := channelKeyHash(key)
+	updates["updated_time"] = common.GetTimestamp()
+	result := DB.Model(&ChannelKeyUsage{}).Where("channel_id = ? AND key_hash = ?", channelId, hash).Updates(updates)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	err := DB.Create(&ChannelKeyUsage{ChannelId: channelId, KeyHash: hash, UpdatedTime: common.GetTimestamp()}).Error
+	if err != nil {
+		// another request created it first
+		common.SysLog("create channel key usage: " + err.Error())
+	}
+	return DB.Model(&ChannelKeyUsage{}).Where("channel_id = ? AND key_hash = ?", channelId, hash).Updates(updates).Error
+}
+
+// AddChannelKeyUsage counts a billed request against a key and returns the
+// key's used quota afterwards.
+func AddChannelKeyUsage(channelId int, key string, quota int) (int64, error) {
+	err := updateChannelKeyUsage(channelId, key, map[string]interface{}{
+		"used_quota":    gorm.Expr("used_quota + ?", quota),
+		"request_count": gorm.Expr("request_count + ?", 1),
+	})
+	if err != nil {
+		return 0, err
+	}
+	var usage ChannelKeyUsage
+	err = DB.Select("used_quota").Where("channel_id = ? AND key_hash = ?", channelId, channelKeyHash(key)).First(&usage).Error
+	return usage.UsedQuota, err
+}
+
+// RecordChannelKeyError stores the last error a key returned.
+func RecordChannelKeyError(channelId int, key string, message string) error {
+	return updateChannelKeyUsage(channelId, key, map[string]interface{}{
+		"last_error":      message,
+		"last_error_time": common.GetTimestamp(),
+	})
+}
+
+// ResetChannelKeyUsage clears the used quota and request count of a key.
+func ResetChannelKeyUsage(channelId int, key string) error {
+	return DB.Where("channel_id = ? AND key_hash = ?", channelId, channelKeyHash(key)).Delete(&ChannelKeyUsage{}).Error
+}
+
+// GetChannelKeyUsages returns the usage of the given keys of a channel, in the
+// same order. Keys without usage get an empty record.
+func GetChannelKeyUsages(channelId int, keys []string) ([]ChannelKeyUsage, error) {
+	var rows []ChannelKeyUsage
+	if err := DB.Where("channel_id = ?", channelId).Find(&rows).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	byHash := make(map[string]ChannelKeyUsage, len(rows))
+	for _, row := range rows {
+		byHash[row.KeyHash] = row
+	}
+	usages := make([]ChannelKeyUsage, len(keys))
+	for i, key := range keys {
+		usage, ok := byHash[channelKeyHash(key)]
+		if !ok {
+			usage = ChannelKeyUsage{ChannelId: channelId}
+		}
+		usage.KeyIndex = i
+		usages[i] = usage
+	}
+	return usages, nil
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+)
+
+// TestGetNextEnabledKeyWeights tests that random mode picks keys by weight
+// and skips disabled keys
+func TestGetNextEnabledKeyWeights(t *testing.T) {
+	channel := &Channel{
+		Id:  301,
+		Key: "sk-a\nsk-b\nsk-c",
+		ChannelInfo: ChannelInfo{
+			IsMultiKey:         true,
+			MultiKeyMode:       constant.MultiKeyModeRandom,
+			MultiKeyStatusList: map[int]int{2: 2},
+			MultiKeyWeights:    map[int]int{1: 9},
+		},
+	}
+	if channel.ChannelInfo.KeyWeight(0) != 1 || channel.ChannelInfo.KeyWeight(1) != 9 {
+		t.Fatalf("weights = %d, %d", channel.ChannelInfo.KeyWeight(0), channel.ChannelInfo.KeyWeight(1))
+	}
+
+	picks := make(map[int]int)
+	for i := 0; i < 2000; i++ {
+		_, idx, err := channel.GetNextEnabledKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks[idx]++
+	}
+	if picks[2] != 0 {
+		t.Errorf("disabled key picked %d times", picks[2])
+	}
+	if picks[1] < 1600 || picks[0] < 100 {
+		t.Errorf("picks = %v, want about 10%% / 90%%", picks)
+	}
+}
@@ -0,0 +1,539 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ChannelMetricGranularityMinute = "minute"
+	ChannelMetricGranularityHour   = "hour"
+)
+
+// Minute buckets are kept in Redis, or in memory without Redis, for
+// channelMetricRetention and rolled into hourly ChannelMetric rows.
+const (
+	channelMetricRedisPrefix        = "channel_metrics:"
+	channelMetricChannelsPrefix     = "channel_metrics_channels:"
+	channelMetricRetention          = 3 * time.Hour
+	channelMetricHourlyRetention    = 90 * 24 * time.Hour
+	channelMetricRollUpGracePeriod  = 2 * time.Minute
+	channelMetricRollUpTickInterval = time.Minute
+)
+
+// upper bounds in milliseconds of the latency histogram buckets, the last
+// bucket is open
+var channelMetricLatencyBounds = []int64{
+	50, 100, 200, 300, 500, 750, 1000, 1500, 2000, 3000, 5000,
+	7500, 10000, 15000, 20000, 30000, 45000, 60000, 120000, 300000,
+}
+
+// ChannelMetric is one hour of traffic of a channel for one model. Errors
+// holds the count per error class, Latency and FirstToken the histogram
+// counts over channelMetricLatencyBounds, all as JSON.
+type ChannelMetric struct {
+	Id               int    `json:"id" gorm:"primaryKey"`
+	ChannelId        int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_metric_hour"`
+	ModelName        string `json:"model_name" gorm:"type:varchar(191);uniqueIndex:idx_channel_metric_hour"`
+	BucketTime       int64  `json:"bucket_time" gorm:"bigint;uniqueIndex:idx_channel_metric_hour;index"`
+	Requests         int64  `json:"requests"`
+	Successes        int64  `json:"successes"`
+	Errors           string `json:"errors" gorm:"type:text"`
+	Latency          string `json:"latency" gorm:"type:text"`
+	FirstToken       string `json:"first_token" gorm:"type:text"`
+	PromptTokens     int64  `json:"prompt_tokens" gorm:"bigint;default:0"`
+	CompletionTokens int64  `json:"completion_tokens" gorm:"bigint;default:0"`
+}
+
+func (ChannelMetric) TableName() string {
+	return "channel_metrics"
+}
+
+// ChannelMetricPoint is a minute or an hour of traffic of a channel for one
+// model. Latencies are in milliseconds and only cover successful requests,
+// FirstToken only streams.
+type ChannelMetricPoint struct {
+	Time          int64            `json:"time"`
+	ModelName     string           `json:"model_name"`
+	Requests      int64            `json:"requests"`
+	Successes     int64            `json:"successes"`
+	Errors        map[string]int64 `json:"errors,omitempty"`
+	LatencyP50    int64            `json:"latency_p50"`
+	LatencyP95    int64            `json:"latency_p95"`
+	LatencyP99    int64            `json:"latency_p99"`
+	FirstTokenP50 int64            `json:"first_token_p50"`
+	FirstTokenP95 int64            `json:"first_token_p95"`
+	FirstTokenP99 int64            `json:"first_token_p99"`
+
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+type channelMetricBucket struct {
+	requests         int64
+	successes        int64
+	errors           map[string]int64
+	latency          []int64
+	firstToken       []int64
+	promptTokens     int64
+	completionTokens int64
+}
+
+func newChannelMetricBucket() *channelMetricBucket {
+	return &channelMetricBucket{
+		errors:     make(map[string]int64),
+		latency:    make([]int64, len(channelMetricLatencyBounds)+1),
+		firstToken: make([]int64, len(channelMetricLatencyBounds)+1),
+	}
+}
+
+func (b *channelMetricBucket) merge(other *channelMetricBucket) {
+	b.requests += other.requests
+	b.successes += other.successes
+	b.promptTokens += other.promptTokens
+	b.completionTokens += other.completionTokens
+	for class, n := range other.errors {
+		b.errors[class] += n
+	}
+	for i := range b.latency {
+		b.latency[i] += other.latency[i]
+		b.firstToken[i] += other.firstToken[i]
+	}
+}
+
+// add counts n for one counter name, the part of a Redis hash field after the
+// model, see channelMetricField.
+func (b *channelMetricBucket) add(name string, n int64) {
+	switch {
+	case name == "requests":
+		b.requests += n
+	case name == "successes":
+		b.successes += n
+	case name == "prompt_tokens":
+		b.promptTokens += n
+	case name == "completion_tokens":
+		b.completionTokens += n
+	case strings.HasPrefix(name, "error:"):
+		b.errors[strings.TrimPrefix(name, "error:")] += n
+	case strings.HasPrefix(name, "latency:"), strings.HasPrefix(name, "ttft:"):
+		parts := strings.SplitN(name, ":", 2)
+		i, err := strconv.Atoi(parts[1])
+		if err != nil || i < 0 || i >= len(b.latency) {
+			return
+		}
+		if parts[0] == "latency" {
+			b.latency[i] += n
+		} else {
+			b.firstToken[i] += n
+		}
+	}
+}
+
+func (b *channelMetricBucket) point(t int64, modelName string) ChannelMetricPoint {
+	point := ChannelMetricPoint{
+		Time:          t,
+		ModelName:     modelName,
+		Requests:      b.requests,
+		Successes:     b.successes,
+		LatencyP50:    histogramPercentile(b.latency, 0.5),
+		LatencyP95:    histogramPercentile(b.latency, 0.95),
+		LatencyP99:    histogramPercentile(b.latency, 0.99),
+		FirstTokenP50: histogramPercentile(b.firstToken, 0.5),
+		FirstTokenP95: histogramPercentile(b.firstToken, 0.95),
+		FirstTokenP99: histogramPercentile(b.firstToken, 0.99),
+
+		PromptTokens:     b.promptTokens,
+		CompletionTokens: b.completionTokens,
+	}
+	if len(b.errors) > 0 {
+		point.Errors = b.errors
+	}
+	return point
+}
+
+func latencyBucketIndex(ms int64) int {
+	return sort.Search(len(channelMetricLatencyBounds), func(i int) bool {
+		return ms <= channelMetricLatencyBounds[i]
+	})
+}
+
+// histogramPercentile interpolates linearly inside the bucket holding the
+// q-quantile. Values in the open bucket are reported as its lower bound.
+func histogramPercentile(histogram []int64, q float64) int64 {
+	var total int64
+	for _, n := range histogram {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var seen int64
+	for i, n := range histogram {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		if i == len(channelMetricLatencyBounds) {
+			break
+		}
+		var lower int64
+		if i > 0 {
+			lower = channelMetricLatencyBounds[i-1]
+		}
+		upper := channelMetricLatencyBounds[i]
+		return lower + int64((rank-float64(seen))/float64(n)*float64(upper-lower))
+	}
+	return channelMetricLatencyBounds[len(channelMetricLatencyBounds)-1]
+}
+
+type channelMetricKey struct {
+	channelId int
+	modelName string
+	minute    int64
+}
+
+var (
+	channelMetricBuckets   = make(map[channelMetricKey]*channelMetricBucket)
+	channelMetricLock      sync.Mutex
+	channelMetricLastPrune int64
+)
+
+func channelMetricRedisKey(channelId int, minute int64) string {
+	return fmt.Sprintf("%s%d:%d", channelMetricRedisPrefix, channelId, minute)
+}
+
+func channelMetricChannelsKey(hour int64) string {
+	return fmt.Sprintf("%s%d", channelMetricChannelsPrefix, hour)
+}
+
+func channelMetricField(modelName string, name string) string {
+	return modelName + "|" + name
+}
+
+// RecordChannelMetric counts a request in the current minute bucket of the
+// channel. errorClass is empty for successful requests, firstToken is 0 when
+// the response was not streamed.
+func RecordChannelMetric(channelId int, modelName string, latency time.Duration, firstToken time.Duration, errorClass string) {
+	fields := map[string]int64{"requests": 1}
+	if errorClass == "" {
+		fields["successes"] = 1
+		fields["latency:"+strconv.Itoa(latencyBucketIndex(latency.Milliseconds()))] = 1
+		if firstToken > 0 {
+			fields["ttft:"+strconv.Itoa(latencyBucketIndex(firstToken.Milliseconds()))] = 1
+		}
+	} else {
+		fields["error:"+errorClass] = 1
+	}
+	addChannelMetricFields(channelId, modelName, fields)
+}
+
+// RecordChannelMetricUsage adds the tokens of a request to the current minute
+// bucket of the channel.
+func RecordChannelMetricUsage(channelId int, modelName string, promptTokens int, completionTokens int) {
+	if promptTokens <= 0 && completionTokens <= 0 {
+		return
+	}
+	addChannelMetricFields(channelId, modelName, map[string]int64{
+		"prompt_tokens":     int64(promptTokens),
+		"completion_tokens": int64(completionTokens),
+	})
+}
+
+func addChannelMetricFields(channelId int, modelName string, fields map[string]int64) {
+	now := time.Now()
+	minute := now.Truncate(time.Minute).Unix()
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := channelMetricRedisKey(channelId, minute)
+		channelsKey := channelMetricChannelsKey(now.Truncate(time.Hour).Unix())
+		pipe := common.RDB.Pipeline()
+		for name, n := range fields {
+			pipe.HIncrBy(ctx, key, channelMetricField(modelName, name), n)
+		}
+		pipe.Expire(ctx, key, channelMetricRetention)
+		pipe.SAdd(ctx, channelsKey, channelId)
+		pipe.Expire(ctx, channelsKey, channelMetricRetention)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysLog("failed to record channel metric: " + err.Error())
+		}
+		return
+	}
+
+	channelMetricLock.Lock()
+	defer channelMetricLock.Unlock()
+	key := channelMetricKey{channelId: channelId, modelName: modelName, minute: minute}
+	bucket, ok := channelMetricBuckets[key]
+	if !ok {
+		bucket = newChannelMetricBucket()
+		channelMetricBuckets[key] = bucket
+	}
+	for name, n := range fields {
+		bucket.add(name, n)
+	}
+	if channelMetricLastPrune != minute {
+		channelMetricLastPrune = minute
+		oldest := now.Add(-channelMetricRetention).Unix()
+		for k := range channelMetricBuckets {
+			if k.minute < oldest {
+				delete(channelMetricBuckets, k)
+			}
+		}
+	}
+}
+
+// loadChannelMetricMinutes returns the minute buckets of a channel in
+// [from, to), keyed by model and minute.
+func loadChannelMetricMinutes(channelId int, from int64, to int64) (map[channelMetricKey]*channelMetricBucket, error) {
+	now := time.Now()
+	from = time.Unix(from, 0).Truncate(time.Minute).Unix()
+	if oldest := now.Add(-channelMetricRetention).Truncate(time.Minute).Unix(); from < oldest {
+		from = oldest
+	}
+	// no minute past the current one has a bucket
+	if latest := now.Truncate(time.Minute).Add(time.Minute).Unix(); to > latest {
+		to = latest
+	}
+	buckets := make(map[channelMetricKey]*channelMetricBucket)
+	if !common.RedisEnabled {
+		channelMetricLock.Lock()
+		defer channelMetricLock.Unlock()
+		for key, bucket := range channelMetricBuckets {
+			if key.channelId == channelId && key.minute >= from && key.minute < to {
+				copied := newChannelMetricBucket()
+				copied.merge(bucket)
+				buckets[key] = copied
+			}
+		}
+		return buckets, nil
+	}
+
+	ctx := context.Background()
+	pipe := common.RDB.Pipeline()
+	var minutes []int64
+	for minute := from; minute < to; minute += 60 {
+		minutes = append(minutes, minute)
+	}
+	if len(minutes) == 0 {
+		return buckets, nil
+	}
+	results := make([]*redis.StringStringMapCmd, len(minutes))
+	for i, minute := range minutes {
+		results[i] = pipe.HGetAll(ctx, channelMetricRedisKey(channelId, minute))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, minute := range minutes {
+		fields, err := results[i].Result()
+		if err != nil {
+			return nil, err
+		}
+		for field, value := range fields {
+			sep := strings.LastIndex(field, "|")
+			n, err := strconv.ParseInt(value, 10, 64)
+			if sep < 0 || err != nil {
+				continue
+			}
+			key := channelMetricKey{channelId: channelId, modelName: field[:sep], minute: minute}
+			bucket, ok := buckets[key]
+			if !ok {
+				bucket = newChannelMetricBucket()
+				buckets[key] = bucket
+			}
+			bucket.add(field[sep+1:], n)
+		}
+	}
+	return buckets, nil
+}
+
+// groupChannelMetricBuckets merges buckets into periods of the given length,
+// per model, optionally keeping a single model.
+func groupChannelMetricBuckets(buckets map[channelMetricKey]*channelMetricBucket, period time.Duration, modelName string) map[channelMetricKey]*channelMetricBucket {
+	grouped := make(map[channelMetricKey]*channelMetricBucket)
+	for key, bucket := range buckets {
+		if modelName != "" && key.modelName != modelName {
+			continue
+		}
+		groupKey := channelMetricKey{channelId: key.channelId, modelName: key.modelName, minute: time.Unix(key.minute, 0).Truncate(period).Unix()}
+		merged, ok := grouped[groupKey]
+		if !ok {
+			merged = newChannelMetricBucket()
+			grouped[groupKey] = merged
+		}
+		merged.merge(bucket)
+	}
+	return grouped
+}
+
+func (m *ChannelMetric) bucket() *channelMetricBucket {
+	bucket := newChannelMetricBucket()
+	bucket.requests = m.Requests
+	bucket.successes = m.Successes
+	bucket.promptTokens = m.PromptTokens
+	bucket.completionTokens = m.CompletionTokens
+	_ = common.UnmarshalJsonStr(m.Errors, &bucket.errors)
+	var latency, firstToken []int64
+	if common.UnmarshalJsonStr(m.Latency, &latency) == nil && len(latency) == len(bucket.latency) {
+		bucket.latency = latency
+	}
+	if common.UnmarshalJsonStr(m.FirstToken, &firstToken) == nil && len(firstToken) == len(bucket.firstToken) {
+		bucket.firstToken = firstToken
+	}
+	return bucket
+}
+
+// GetChannelMetrics returns the traffic of a channel in [from, to), per
+// minute for the last hours or per hour, sorted by time and model. An empty
+// modelName returns all models.
+func GetChannelMetrics(channelId int, modelName string, granularity string, from int64, to int64) ([]ChannelMetricPoint, error) {
+	grouped, err := loadChannelMetricPeriods(channelId, modelName, granularity, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]ChannelMetricPoint, 0, len(grouped))
+	for key, bucket := range grouped {
+		points = append(points, bucket.point(key.minute, key.modelName))
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Time != points[j].Time {
+			return points[i].Time < points[j].Time
+		}
+		return points[i].ModelName < points[j].ModelName
+	})
+	return points, nil
+}
+
+// GetChannelMetricSummary returns the traffic of a channel for one model in
+// [from, to) as a single point.
+func GetChannelMetricSummary(channelId int, modelName string, from int64, to int64) (ChannelMetricPoint, error) {
+	grouped, err := loadChannelMetricPeriods(channelId, modelName, ChannelMetricGranularityHour, from, to)
+	if err != nil {
+		return ChannelMetricPoint{}, err
+	}
+	total := newChannelMetricBucket()
+	for _, bucket := range grouped {
+		total.merge(bucket)
+	}
+	return total.point(from, modelName), nil
+}
+
+// loadChannelMetricPeriods returns the buckets of a channel in [from, to)
+// grouped per minute or per hour and model.
+func loadChannelMetricPeriods(channelId int, modelName string, granularity string, from int64, to int64) (map[channelMetricKey]*channelMetricBucket, error) {
+	period := time.Minute
+	if granularity == ChannelMetricGranularityHour {
+		period = time.Hour
+	}
+	minutes, err := loadChannelMetricMinutes(channelId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	grouped := groupChannelMetricBuckets(minutes, period, modelName)
+
+	if granularity == ChannelMetricGranularityHour {
+		// rolled up hours take precedence over the minute buckets still around
+		query := DB.Where("channel_id = ? AND bucket_time >= ? AND bucket_time < ?", channelId, time.Unix(from, 0).Truncate(time.Hour).Unix(), to)
+		if modelName != "" {
+			query = query.Where("model_name = ?", modelName)
+		}
+		var rows []ChannelMetric
+		if err := query.Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			grouped[channelMetricKey{channelId: channelId, modelName: rows[i].ModelName, minute: rows[i].BucketTime}] = rows[i].bucket()
+		}
+	}
+	return grouped, nil
+}
+
+// RollUpChannelMetrics stores the hour starting at hour as ChannelMetric rows,
+// replacing rows of an earlier roll-up of the same hour.
+func RollUpChannelMetrics(hour int64) error {
+	var channelIds []int
+	if common.RedisEnabled {
+		members, err := common.RDB.SMembers(context.Background(), channelMetricChannelsKey(hour)).Result()
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			if id, err := strconv.Atoi(member); err == nil {
+				channelIds = append(channelIds, id)
+			}
+		}
+	} else {
+		seen := make(map[int]bool)
+		channelMetricLock.Lock()
+		for key := range channelMetricBuckets {
+			if key.minute >= hour && key.minute < hour+3600 && !seen[key.channelId] {
+				seen[key.channelId] = true
+				channelIds = append(channelIds, key.channelId)
+			}
+		}
+		channelMetricLock.Unlock()
+	}
+
+	for _, channelId := range channelIds {
+		minutes, err := loadChannelMetricMinutes(channelId, hour, hour+3600)
+		if err != nil {
+			return err
+		}
+		for key, bucket := range groupChannelMetricBuckets(minutes, time.Hour, "") {
+			errorsJson, _ := common.Marshal(bucket.errors)
+			latencyJson, _ := common.Marshal(bucket.latency)
+			firstTokenJson, _ := common.Marshal(bucket.firstToken)
+			row := ChannelMetric{}
+			err := DB.Where(ChannelMetric{ChannelId: channelId, ModelName: key.modelName, BucketTime: key.minute}).
+				Assign(ChannelMetric{
+					Requests:   bucket.requests,
+					Successes:  bucket.successes,
+					Errors:     string(errorsJson),
+					Latency:    string(latencyJson),
+					FirstToken: string(firstTokenJson),
+
+					PromptTokens:     bucket.promptTokens,
+					CompletionTokens: bucket.completionTokens,
+				}).FirstOrCreate(&row).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return DB.Where("bucket_time < ?", time.Now().Add(-channelMetricHourlyRetention).Unix()).Delete(&ChannelMetric{}).Error
+}
+
+// RollUpChannelMetricsWithContext rolls every finished hour into the database
+// shortly after it ends. It should run on the master node only.
+func RollUpChannelMetricsWithContext(ctx context.Context) {
+	ticker := time.NewTicker(channelMetricRollUpTickInterval)
+	defer ticker.Stop()
+	var lastHour int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hour := time.Now().Add(-channelMetricRollUpGracePeriod).Truncate(time.Hour).Add(-time.Hour).Unix()
+			if hour == lastHour {
+				continue
+			}
+			if err := RollUpChannelMetrics(hour); err != nil && !errors.Is(err, context.Canceled) {
+				common.SysError("failed to roll up channel metrics: " + err.Error())
+				continue
+			}
+			lastHour = hour
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+)
+
+// TestChannelMetricMinutes tests that requests are counted per model in minute
+// buckets and that latency percentiles come from successful requests only
+func TestChannelMetricMinutes(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	defer func() {
+		common.RedisEnabled = redisEnabled
+	}()
+	channelMetricLock.Lock()
+	channelMetricBuckets = make(map[channelMetricKey]*channelMetricBucket)
+	channelMetricLock.Unlock()
+
+	for i := 0; i < 100; i++ {
+		RecordChannelMetric(1, "gpt-4o", time.Duration(i+1)*10*time.Millisecond, 0, "")
+	}
+	RecordChannelMetric(1, "gpt-4o", time.Minute, 0, "rate_limit")
+	RecordChannelMetric(1, "claude", time.Second, 300*time.Millisecond, "")
+	RecordChannelMetric(2, "gpt-4o", time.Second, 0, "")
+
+	now := time.Now().Unix()
+	points, err := GetChannelMetrics(1, "gpt-4o", ChannelMetricGranularityMinute, now-60, now+60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) == 2 {
+		t.Skip("crossed a minute boundary")
+	}
+	if len(points) != 1 {
+		t.Fatalf("got %d points, want 1", len(points))
+	}
+	point := points[0]
+	if point.Requests != 101 || point.Successes != 100 || point.Errors["rate_limit"] != 1 {
+		t.Errorf("counts = %d/%d/%v", point.Requests, point.Successes, point.Errors)
+	}
+	if point.LatencyP50 < 300 || point.LatencyP50 > 750 {
+		t.Errorf("p50 = %d", point.LatencyP50)
+	}
+	if point.LatencyP99 < 750 || point.LatencyP99 > 1000 {
+		t.Errorf("p99 = %d", point.LatencyP99)
+	}
+
+	points, _ = GetChannelMetrics(1, "", ChannelMetricGranularityMinute, now-60, now+60)
+	if len(points) != 2 || points[0].ModelName != "claude" || points[0].FirstTokenP50 == 0 {
+		t.Errorf("all models = %+v", points)
+	}
+}
@@ -0,0 +1,239 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// redis hash holding the budgets of all keys, fields are "<channel>:<key index>"
+const channelRateLimitRedisKey = "channel_rate_limit"
+
+// ChannelRateLimit is the rate limit budget of a channel, or of one key of a
+// multi-key channel, as advertised by the upstream in its response headers.
+// Times are unix milliseconds.
+type ChannelRateLimit struct {
+	KeyIndex          int   `json:"key_index"` // -1 表示单Key渠道
+	LimitRequests     int64 `json:"limit_requests"`
+	RemainingRequests int64 `json:"remaining_requests"` // -1 表示上游未返回
+	LimitTokens       int64 `json:"limit_tokens"`
+	RemainingTokens   int64 `json:"remaining_tokens"` // -1 表示上游未返回
+	RequestsResetAt   int64 `json:"requests_reset_at"`
+	TokensResetAt     int64 `json:"tokens_reset_at"`
+	CooldownUntil     int64 `json:"cooldown_until"`
+}
+
+func (l *ChannelRateLimit) expired(now int64) bool {
+	return now >= l.RequestsResetAt && now >= l.TokensResetAt && now >= l.CooldownUntil
+}
+
+// exhausted reports whether the key should be skipped: it is cooling down
+// after a 429, or its remaining budget is below the configured share until
+// the budget resets.
+func (l *ChannelRateLimit) exhausted(now int64) bool {
+	if now < l.CooldownUntil {
+		return true
+	}
+	minPercent := int64(operation_setting.GetUpstreamRateLimitSetting().MinRemainingPercent)
+	low := func(remaining int64, limit int64, resetAt int64) bool {
+		if remaining < 0 || now >= resetAt {
+			return false
+		}
+		if limit <= 0 {
+			return remaining == 0
+		}
+		return remaining*100 < limit*minPercent || remaining == 0
+	}
+	return low(l.RemainingRequests, l.LimitRequests, l.RequestsResetAt) || low(l.RemainingTokens, l.LimitTokens, l.TokensResetAt)
+}
+
+type channelRateLimitKey struct {
+	channelId int
+	keyIndex  int
+}
+
+func (k channelRateLimitKey) field() string {
+	return fmt.Sprintf("%d:%d", k.channelId, k.keyIndex)
+}
+
+var (
+	// with Redis enabled this is a copy of the shared state, synced every second
+	channelRateLimits    = make(map[channelRateLimitKey]ChannelRateLimit)
+	channelRateLimitLock sync.Mutex
+	channelRateLimitSync sync.Once
+)
+
+func upstreamRateLimitEnabled() bool {
+	if !operation_setting.GetUpstreamRateLimitSetting().Enabled {
+		return false
+	}
+	if common.RedisEnabled {
+		channelRateLimitSync.Do(startChannelRateLimitSync)
+	}
+	return true
+}
+
+func startChannelRateLimitSync() {
+	gopool.Go(func() {
+		for {
+			syncChannelRateLimits()
+			time.Sleep(time.Second)
+		}
+	})
+}
+
+func syncChannelRateLimits() {
+	ctx := context.Background()
+	fields, err := common.RDB.HGetAll(ctx, channelRateLimitRedisKey).Result()
+	if err != nil {
+		common.SysLog("failed to sync channel rate limits: " + err.Error())
+		return
+	}
+	now := time.Now().UnixMilli()
+	limits := make(map[channelRateLimitKey]ChannelRateLimit)
+	var expired []string
+	for field, value := range fields {
+		parts := strings.Split(field, ":")
+		if len(parts) != 2 {
+			continue
+		}
+		channelId, err1 := strconv.Atoi(parts[0])
+		keyIndex, err2 := strconv.Atoi(parts[1])
+		var limit ChannelRateLimit
+		if err1 != nil || err2 != nil || common.UnmarshalJsonStr(value, &limit) != nil {
+			continue
+		}
+		if limit.expired(now) {
+			expired = append(expired, field)
+			continue
+		}
+		limits[channelRateLimitKey{channelId: channelId, keyIndex: keyIndex}] = limit
+	}
+	if len(expired) > 0 {
+		common.RDB.HDel(ctx, channelRateLimitRedisKey, expired...)
+	}
+	channelRateLimitLock.Lock()
+	channelRateLimits = limits
+	channelRateLimitLock.Unlock()
+}
+
+// UpdateChannelRateLimit stores the budget the upstream advertised for a key.
+// keyIndex is -1 for single-key channels. A running cooldown is kept unless
+// the new one ends later.
+func UpdateChannelRateLimit(channelId int, keyIndex int, limit ChannelRateLimit) {
+	if !upstreamRateLimitEnabled() {
+		return
+	}
+	key := channelRateLimitKey{channelId: channelId, keyIndex: keyIndex}
+	limit.KeyIndex = keyIndex
+	channelRateLimitLock.Lock()
+	if current, ok := channelRateLimits[key]; ok && current.CooldownUntil > limit.CooldownUntil {
+		limit.CooldownUntil = current.CooldownUntil
+	}
+	channelRateLimits[key] = limit
+	channelRateLimitLock.Unlock()
+
+	if common.RedisEnabled {
+		data, err := common.Marshal(limit)
+		if err != nil {
+			return
+		}
+		if err := common.RDB.HSet(context.Background(), channelRateLimitRedisKey, key.field(), string(data)).Err(); err != nil {
+			common.SysLog("failed to save channel rate limit: " + err.Error())
+		}
+	}
+}
+
+func channelRateLimitExhausted(key channelRateLimitKey, now int64) bool {
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	limit, ok := channelRateLimits[key]
+	return ok && limit.exhausted(now)
+}
+
+// multiKeyRateLimited reports whether every enabled key of a multi-key
+// channel is out of budget.
+func multiKeyRateLimited(channel *Channel, now int64) bool {
+	lock := GetChannelPollingLock(channel.Id)
+	lock.Lock()
+	enabledIdx := make([]int, 0, channel.ChannelInfo.MultiKeySize)
+	for idx := 0; idx < channel.ChannelInfo.MultiKeySize; idx++ {
+		if status, ok := channel.ChannelInfo.MultiKeyStatusList[idx]; !ok || status == common.ChannelStatusEnabled {
+			enabledIdx = append(enabledIdx, idx)
+		}
+	}
+	lock.Unlock()
+	if len(enabledIdx) == 0 {
+		return false
+	}
+	for _, idx := range enabledIdx {
+		if !channelRateLimitExhausted(channelRateLimitKey{channelId: channel.Id, keyIndex: idx}, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterRateLimitedChannels drops channels whose upstream budget is
+// exhausted. A multi-key channel is dropped once all its enabled keys are.
+func filterRateLimitedChannels(channels []*Channel) []*Channel {
+	if !upstreamRateLimitEnabled() {
+		return channels
+	}
+	now := time.Now().UnixMilli()
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.ChannelInfo.IsMultiKey {
+			if !multiKeyRateLimited(channel, now) {
+				available = append(available, channel)
+			}
+			continue
+		}
+		if !channelRateLimitExhausted(channelRateLimitKey{channelId: channel.Id, keyIndex: -1}, now) {
+			available = append(available, channel)
+		}
+	}
+	return available
+}
+
+// filterRateLimitedKeys is filterRateLimitedChannels for the keys of a
+// multi-key channel.
+func filterRateLimitedKeys(channelId int, keyIndexes []int) []int {
+	if !upstreamRateLimitEnabled() {
+		return keyIndexes
+	}
+	now := time.Now().UnixMilli()
+	available := make([]int, 0, len(keyIndexes))
+	for _, idx := range keyIndexes {
+		if !channelRateLimitExhausted(channelRateLimitKey{channelId: channelId, keyIndex: idx}, now) {
+			available = append(available, idx)
+		}
+	}
+	return available
+}
+
+// GetChannelRateLimits returns the budgets of a channel that have not reset yet.
+func GetChannelRateLimits(channelId int) []ChannelRateLimit {
+	now := time.Now().UnixMilli()
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	var limits []ChannelRateLimit
+	for key, limit := range channelRateLimits {
+		if key.channelId == channelId && !limit.expired(now) {
+			limits = append(limits, limit)
+		}
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].KeyIndex < limits[j].KeyIndex
+	})
+	return limits
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+)
+
+// TestFilterRateLimitedChannels tests that a multi-key channel is only skipped
+// once every enabled key is cooling down
+func TestFilterRateLimitedChannels(t *testing.T) {
+	redisEnabled, enabled := common.RedisEnabled, operation_setting.GetUpstreamRateLimitSetting().Enabled
+	common.RedisEnabled = false
+	operation_setting.GetUpstreamRateLimitSetting().Enabled = true
+	defer func() {
+		common.RedisEnabled = redisEnabled
+		operation_setting.GetUpstreamRateLimitSetting().Enabled = enabled
+		channelRateLimitLock.Lock()
+		channelRateLimits = make(map[channelRateLimitKey]ChannelRateLimit)
+		channelRateLimitLock.Unlock()
+	}()
+
+	single := &Channel{Id: 301}
+	multi := &Channel{Id: 302, ChannelInfo: ChannelInfo{
+		IsMultiKey:         true,
+		MultiKeySize:       3,
+		MultiKeyStatusList: map[int]int{2: common.ChannelStatusManuallyDisabled},
+	}}
+	cooldown := ChannelRateLimit{RemainingRequests: -1, RemainingTokens: -1, CooldownUntil: time.Now().Add(time.Minute).UnixMilli()}
+	UpdateChannelRateLimit(301, -1, cooldown)
+	UpdateChannelRateLimit(302, 0, cooldown)
+
+	if available := filterRateLimitedChannels([]*Channel{single, multi}); len(available) != 1 || available[0].Id != 302 {
+		t.Errorf("available channels = %v", available)
+	}
+	UpdateChannelRateLimit(302, 1, cooldown)
+	if available := filterRateLimitedChannels([]*Channel{single, multi}); len(available) != 0 {
+		t.Errorf("channel with all enabled keys cooling down kept: %v", available)
+	}
+}
+
+// TestGetChannelSkipsRateLimited tests that the selection without the memory
+// cache skips channels cooling down, falling back to a lower priority
+func TestGetChannelSkipsRateLimited(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{}, &DisabledChannelModel{})
+	memoryCacheEnabled, enabled := common.MemoryCacheEnabled, operation_setting.GetUpstreamRateLimitSetting().Enabled
+	common.MemoryCacheEnabled = false
+	operation_setting.GetUpstreamRateLimitSetting().Enabled = true
+	defer func() {
+		common.MemoryCacheEnabled = memoryCacheEnabled
+		operation_setting.GetUpstreamRateLimitSetting().Enabled = enabled
+		channelRateLimitLock.Lock()
+		channelRateLimits = make(map[channelRateLimitKey]ChannelRateLimit)
+		channelRateLimitLock.Unlock()
+	}()
+
+	high, low := int64(10), int64(0)
+	for _, channel := range []*Channel{
+		{Id: 311, Name: "primary", Key: "sk-primary", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high},
+		{Id: 312, Name: "backup", Key: "sk-backup", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &low},
+	} {
+		if err := DB.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := channel.AddAbilities(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if channel, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{}); err != nil || channel == nil || channel.Id != 311 {
+		t.Fatalf("request went to %v, %v, want the primary channel", channel, err)
+	}
+	UpdateChannelRateLimit(311, -1, ChannelRateLimit{RemainingRequests: -1, RemainingTokens: -1, CooldownUntil: time.Now().Add(time.Minute).UnixMilli()})
+	if channel, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{}); err != nil || channel == nil || channel.Id != 312 {
+		t.Fatalf("request went to %v, %v, want the backup channel", channel, err)
+	}
+}
@@ -0,0 +1,412 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+
+	"gorm.io/gorm"
+)
+
+// actions a channel revision records
+const (
+	ChannelRevisionActionBaseline       = "baseline" // 首次记录修改前的状态
+	ChannelRevisionActionCreate         = "create"
+	ChannelRevisionActionUpdate         = "update"
+	ChannelRevisionActionStatus         = "status"
+	ChannelRevisionActionMultiKey       = "multi_key"
+	ChannelRevisionActionEditTag        = "edit_tag"
+	ChannelRevisionActionSetTag         = "set_tag"
+	ChannelRevisionActionDelete         = "delete"
+	ChannelRevisionActionRollback       = "rollback"
+	ChannelRevisionActionGitOps         = "gitops"
+	ChannelRevisionActionModelDiscovery = "model_discovery"
+)
+
+// ChannelRevision is a version of the configuration of a channel. Snapshot
+// is a ChannelSnapshot and Changes the []ChannelFieldChange from the
+// previous version, both JSON.
+type ChannelRevision struct {
+	Id        int    `json:"id"`
+	ChannelId int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_revision_version,priority:1"`
+	Version   int    `json:"version" gorm:"uniqueIndex:idx_channel_revision_version,priority:2"`
+	Action    string `json:"action" gorm:"type:varchar(32)"`
+	UserId    int    `json:"user_id"`
+	Username  string `json:"username" gorm:"type:varchar(64)"`
+	Snapshot  string `json:"snapshot" gorm:"type:text"`
+	Changes   string `json:"changes" gorm:"type:text"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// ChannelSnapshot is the configuration of a channel that is versioned. The
+// key is only kept as its lookup HMAC, see common.SecretLookupHash, so that
+// history never exposes it, and rollbacks leave keys alone. The status of the channel and of its keys is recorded
+// when an admin changes it, but is also runtime state changed by auto-ban,
+// so rollbacks leave it and the per-key settings alone too.
+type ChannelSnapshot struct {
+	Type               int    `json:"type"`
+	Name               string `json:"name"`
+	KeyHash            string `json:"key_hash"`
+	OpenAIOrganization string `json:"openai_organization"`
+	TestModel          string `json:"test_model"`
+	Weight             uint   `json:"weight"`
+	BaseURL            string `json:"base_url"`
+	Other              string `json:"other"`
+	Models             string `json:"models"`
+	Group              string `json:"group"`
+	ModelMapping       string `json:"model_mapping"`
+	StatusCodeMapping  string `json:"status_code_mapping"`
+	Priority           int64  `json:"priority"`
+	AutoBan            int    `json:"auto_ban"`
+	Tag                string `json:"tag"`
+	Setting            string `json:"setting"`
+	ParamOverride      string `json:"param_override"`
+	HeaderOverride     string `json:"header_override"`
+	Remark             string `json:"remark"`
+	Settings           string `json:"settings"`
+	MultiKeyMode       string `json:"multi_key_mode"`
+	Status             int    `json:"status"`
+	KeyStatus          string `json:"key_status"`
+	KeyWeights         string `json:"key_weights"`
+	KeyQuotaLimits     string `json:"key_quota_limits"`
+}
+
+// ChannelFieldChange is a field that differs between two versions.
+type ChannelFieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// keyIndexString renders settings indexed by key as JSON, empty when there
+// are none so that a nil and an empty map compare equal.
+func keyIndexString[V any](settings map[int]V) string {
+	if len(settings) == 0 {
+		return ""
+	}
+	data, err := common.Marshal(settings)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func NewChannelSnapshot(channel *Channel) ChannelSnapshot {
+	snapshot := ChannelSnapshot{
+		Type:               channel.Type,
+		Name:               channel.Name,
+		KeyHash:            common.SecretLookupHash(channel.Key),
+		OpenAIOrganization: derefString(channel.OpenAIOrganization),
+		TestModel:          derefString(channel.TestModel),
+		BaseURL:            derefString(channel.BaseURL),
+		Other:              channel.Other,
+		Models:             channel.Models,
+		Group:              channel.Group,
+		ModelMapping:       derefString(channel.ModelMapping),
+		StatusCodeMapping:  derefString(channel.StatusCodeMapping),
+		Priority:           channel.GetPriority(),
+		Tag:                channel.GetTag(),
+		Setting:            derefString(channel.Setting),
+		ParamOverride:      derefString(channel.ParamOverride),
+		HeaderOverride:     derefString(channel.HeaderOverride),
+		Remark:             derefString(channel.Remark),
+		Settings:           channel.OtherSettings,
+		MultiKeyMode:       string(channel.ChannelInfo.MultiKeyMode),
+		Status:             channel.Status,
+		KeyStatus:          keyIndexString(channel.ChannelInfo.MultiKeyStatusList),
+		KeyWeights:         keyIndexString(channel.ChannelInfo.MultiKeyWeights),
+		KeyQuotaLimits:     keyIndexString(channel.ChannelInfo.MultiKeyQuotaLimits),
+	}
+	if channel.Weight != nil {
+		snapshot.Weight = *channel.Weight
+	}
+	if channel.AutoBan != nil {
+		snapshot.AutoBan = *channel.AutoBan
+	}
+	return snapshot
+}
+
+// DiffChannelSnapshots lists the fields that differ between two snapshots.
+func DiffChannelSnapshots(old ChannelSnapshot, new ChannelSnapshot) []ChannelFieldChange {
+	var changes []ChannelFieldChange
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		a, b := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		if a == b {
+			continue
+		}
+		field := strings.Split(oldValue.Type().Field(i).Tag.Get("json"), ",")[0]
+		changes = append(changes, ChannelFieldChange{Field: field, Old: a, New: b})
+	}
+	return changes
+}
+
+// apply restores the versioned configuration onto channel, except the key.
+func (snapshot *ChannelSnapshot) apply(channel *Channel) {
+	channel.Type = snapshot.Type
+	channel.Name = snapshot.Name
+	channel.OpenAIOrganization = common.GetPointer(snapshot.OpenAIOrganization)
+	channel.TestModel = common.GetPointer(snapshot.TestModel)
+	channel.Weight = common.GetPointer(snapshot.Weight)
+	channel.BaseURL = common.GetPointer(snapshot.BaseURL)
+	channel.Other = snapshot.Other
+	channel.Models = snapshot.Models
+	channel.Group = snapshot.Group
+	channel.ModelMapping = common.GetPointer(snapshot.ModelMapping)
+	channel.StatusCodeMapping = common.GetPointer(snapshot.StatusCodeMapping)
+	channel.Priority = common.GetPointer(snapshot.Priority)
+	channel.AutoBan = common.GetPointer(snapshot.AutoBan)
+	channel.Tag = common.GetPointer(snapshot.Tag)
+	channel.Setting = common.GetPointer(snapshot.Setting)
+	channel.ParamOverride = common.GetPointer(snapshot.ParamOverride)
+	channel.HeaderOverride = common.GetPointer(snapshot.HeaderOverride)
+	channel.Remark = common.GetPointer(snapshot.Remark)
+	channel.OtherSettings = snapshot.Settings
+	if channel.ChannelInfo.IsMultiKey && snapshot.MultiKeyMode != "" {
+		channel.ChannelInfo.MultiKeyMode = constant.MultiKeyMode(snapshot.MultiKeyMode)
+	}
+}
+
+// SnapshotChannels loads the channels with the given ids, or the given tag
+// when ids is empty, before they are changed, to pass to
+// RecordChannelRevisions.
+func SnapshotChannels(ids []int, tag string) (map[int]*Channel, error) {
+	var channels []*Channel
+	tx := DB
+	if len(ids) > 0 {
+		tx = tx.Where("id IN ?", ids)
+	} else {
+		tx = tx.Where("tag = ?", tag)
+	}
+	if err := tx.Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	before := make(map[int]*Channel, len(channels))
+	for _, channel := range channels {
+		before[channel.Id] = channel
+	}
+	return before, nil
+}
+
+// SnapshotDisabledChannels loads the channels DeleteDisabledChannel deletes.
+func SnapshotDisabledChannels() (map[int]*Channel, error) {
+	var channels []*Channel
+	err := DB.Where("(status = ? or status = ?) and managed_by = ?", common.ChannelStatusAutoDisabled, common.ChannelStatusManuallyDisabled, "").Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	before := make(map[int]*Channel, len(channels))
+	for _, channel := range channels {
+		before[channel.Id] = channel
+	}
+	return before, nil
+}
+
+func getLatestChannelRevision(tx *gorm.DB, channelId int) (*ChannelRevision, error) {
+	var revision ChannelRevision
+	err := tx.Where("channel_id = ?", channelId).Order("version desc").Limit(1).Find(&revision).Error
+	if err != nil || revision.Id == 0 {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func insertChannelRevision(tx *gorm.DB, revision *ChannelRevision, snapshot ChannelSnapshot, changes []ChannelFieldChange) error {
+	data, err := common.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	revision.Snapshot = string(data)
+	if changes != nil {
+		data, err = common.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		revision.Changes = string(data)
+	}
+	revision.CreatedAt = common.GetTimestamp()
+	return tx.Create(revision).Error
+}
+
+// RecordChannelRevisions stores a new version of every channel of before
+// whose configuration changed, or a deletion for the ones that no longer
+// exist. The first time a channel is recorded its previous state is stored
+// as a baseline version so that it can be rolled back to.
+func RecordChannelRevisions(before map[int]*Channel, action string, userId int, username string) error {
+	if len(before) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(before))
+	for id := range before {
+		ids = append(ids, id)
+	}
+	after, err := SnapshotChannels(ids, "")
+	if err != nil {
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, id := range ids {
+			latest, err := getLatestChannelRevision(tx, id)
+			if err != nil {
+				return err
+			}
+			version := 1
+			previous := NewChannelSnapshot(before[id])
+			if latest == nil {
+				baseline := &ChannelRevision{ChannelId: id, Version: version, Action: ChannelRevisionActionBaseline}
+				if err := insertChannelRevision(tx, baseline, previous, nil); err != nil {
+					return err
+				}
+			} else {
+				version = latest.Version
+			}
+
+			revision := &ChannelRevision{ChannelId: id, Version: version + 1, Action: action, UserId: userId, Username: username}
+			channel, ok := after[id]
+			if !ok {
+				revision.Action = ChannelRevisionActionDelete
+				if err := insertChannelRevision(tx, revision, previous, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			current := NewChannelSnapshot(channel)
+			changes := DiffChannelSnapshots(previous, current)
+			if len(changes) == 0 {
+				continue
+			}
+			if err := insertChannelRevision(tx, revision, current, changes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RecordChannelCreations stores the first version of newly created channels.
+func RecordChannelCreations(channels []Channel, userId int, username string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for i := range channels {
+			revision := &ChannelRevision{ChannelId: channels[i].Id, Version: 1, Action: ChannelRevisionActionCreate, UserId: userId, Username: username}
+			if err := insertChannelRevision(tx, revision, NewChannelSnapshot(&channels[i]), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetChannelRevisions returns the versions of a channel, newest first.
+func GetChannelRevisions(channelId int, startIdx int, num int) ([]*ChannelRevision, int64, error) {
+	var revisions []*ChannelRevision
+	var total int64
+	tx := DB.Model(&ChannelRevision{}).Where("channel_id = ?", channelId)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := tx.Order("version desc").Limit(num).Offset(startIdx).Find(&revisions).Error
+	return revisions, total, err
+}
+
+// rollbackChannel restores channel to snapshot and returns it as it was
+// before the rollback.
+func rollbackChannel(channel *Channel, snapshot *ChannelSnapshot) (*Channel, error) {
+	if channel.ManagedBy != "" {
+		return nil, fmt.Errorf("channel %s is managed by the configuration file", channel.Name)
+	}
+	before := *channel
+	snapshot.apply(channel)
+	err := DB.Model(channel).Select("Type", "Name", "OpenAIOrganization", "TestModel", "Weight", "BaseURL", "Other",
+		"Models", "Group", "ModelMapping", "StatusCodeMapping", "Priority", "AutoBan", "Tag", "Setting",
+		"ParamOverride", "HeaderOverride", "Remark", "OtherSettings", "ChannelInfo").Updates(channel).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := channel.UpdateAbilities(nil); err != nil {
+		return nil, err
+	}
+	return &before, nil
+}
+
+func parseChannelSnapshot(revision *ChannelRevision) (*ChannelSnapshot, error) {
+	var snapshot ChannelSnapshot
+	if err := common.UnmarshalJsonStr(revision.Snapshot, &snapshot); err != nil {
+		return nil, fmt.Errorf("channel #%d version %d: %w", revision.ChannelId, revision.Version, err)
+	}
+	return &snapshot, nil
+}
+
+// RollbackChannel restores the configuration of a channel as of a version.
+// The rollback is itself recorded as a new version.
+func RollbackChannel(channelId int, version int, userId int, username string) error {
+	var revision ChannelRevision
+	err := DB.Where("channel_id = ? AND version = ?", channelId, version).First(&revision).Error
+	if err != nil {
+		return err
+	}
+	snapshot, err := parseChannelSnapshot(&revision)
+	if err != nil {
+		return err
+	}
+	channel, err := GetChannelById(channelId, true)
+	if err != nil {
+		return err
+	}
+	before, err := rollbackChannel(channel, snapshot)
+	if err != nil {
+		return err
+	}
+	return RecordChannelRevisions(map[int]*Channel{channelId: before}, ChannelRevisionActionRollback, userId, username)
+}
+
+// RollbackChannelsByTag restores every channel with the tag to its
+// configuration as of timestamp. Channels without a version that old are
+// restored to their first version, the baseline or the creation. It returns the number of channels rolled back.
+func RollbackChannelsByTag(tag string, timestamp int64, userId int, username string) (int, error) {
+	channels, err := SnapshotChannels(nil, tag)
+	if err != nil {
+		return 0, err
+	}
+	before := make(map[int]*Channel, len(channels))
+	var errs []error
+	for id, channel := range channels {
+		var revision ChannelRevision
+		err := DB.Where("channel_id = ? AND created_at <= ?", id, timestamp).Order("version desc").Limit(1).Find(&revision).Error
+		if err == nil && revision.Id == 0 {
+			err = DB.Where("channel_id = ? AND version = ?", id, 1).Limit(1).Find(&revision).Error
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if revision.Id == 0 {
+			// never changed since it was tracked
+			continue
+		}
+		snapshot, err := parseChannelSnapshot(&revision)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		previous, err := rollbackChannel(channel, snapshot)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("channel #%d: %w", id, err))
+			continue
+		}
+		before[id] = previous
+	}
+	errs = append(errs, RecordChannelRevisions(before, ChannelRevisionActionRollback, userId, username))
+	return len(before), errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+)
+
+func TestChannelSnapshotDiffAndApply(t *testing.T) {
+	channel := &Channel{
+		Name:         "openai",
+		Key:          "sk-secret",
+		Models:       "gpt-4o",
+		Group:        "default",
+		ModelMapping: common.GetPointer(`{"gpt-4":"gpt-4o"}`),
+		Priority:     common.GetPointer(int64(5)),
+	}
+	old := NewChannelSnapshot(channel)
+	if old.KeyHash != common.SecretLookupHash("sk-secret") || old.KeyHash == channelKeyHash("sk-secret") {
+		t.Fatal("the history must keep the keyed lookup hash of the key")
+	}
+
+	channel.Key = "sk-rotated"
+	channel.ModelMapping = common.GetPointer(`{}`)
+	changes := DiffChannelSnapshots(old, NewChannelSnapshot(channel))
+	if len(changes) != 2 || changes[0].Field != "key_hash" || changes[1].Field != "model_mapping" {
+		t.Fatalf("changes = %+v", changes)
+	}
+	for _, change := range changes {
+		if change.Old == "sk-secret" || change.New == "sk-rotated" {
+			t.Fatal("keys must not appear in the history")
+		}
+	}
+
+	old.apply(channel)
+	if got := NewChannelSnapshot(channel); len(DiffChannelSnapshots(old, got)) != 1 {
+		t.Fatalf("rollback should restore everything but the key, diff = %+v", DiffChannelSnapshots(old, got))
+	}
+	if channel.Key != "sk-rotated" {
+		t.Fatal("rollback must leave the key alone")
+	}
+}
+
+func TestRecordChannelCreationsAndStatus(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{}, &ChannelRevision{})
+
+	channels := []Channel{
+		{Name: "a", Key: "sk-a", Models: "gpt-4o", Group: "default", Status: common.ChannelStatusEnabled, Tag: common.GetPointer("batch")},
+		{Name: "b", Key: "sk-b", Models: "gpt-4o", Group: "default", Status: common.ChannelStatusEnabled, Tag: common.GetPointer("batch")},
+	}
+	if err := BatchInsertChannels(channels); err != nil {
+		t.Fatal(err)
+	}
+	if channels[0].Id == 0 || channels[1].Id == 0 {
+		t.Fatalf("ids not handed back: %d, %d", channels[0].Id, channels[1].Id)
+	}
+	if err := RecordChannelCreations(channels, 1, "root"); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := SnapshotChannels(nil, "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DisableChannelByTag("batch"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordChannelRevisions(before, ChannelRevisionActionStatus, 1, "root"); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, total, err := GetChannelRevisions(channels[0].Id, 0, 10)
+	if err != nil || total != 2 {
+		t.Fatalf("%d revisions, %v", total, err)
+	}
+	if revisions[1].Action != ChannelRevisionActionCreate || revisions[0].Action != ChannelRevisionActionStatus {
+		t.Fatalf("actions %s, %s", revisions[1].Action, revisions[0].Action)
+	}
+	var changes []ChannelFieldChange
+	if err := common.UnmarshalJsonStr(revisions[0].Changes, &changes); err != nil || len(changes) != 1 || changes[0].Field != "status" {
+		t.Fatalf("changes = %+v, %v", changes, err)
+	}
+}
@@ -0,0 +1,133 @@
+package model
+
+import (
+	"math/rand"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/ratio_setting"
+
+	"gorm.io/gorm"
+)
+
+// Channels in canary or shadow mode, see dto.ChannelSettings.RolloutMode,
+// are kept out of the regular channel selection. A canary channel gets its
+// percentage of the requests before the regular selection runs, a shadow
+// channel is sent copies of requests and never answers one.
+
+var group2model2rollouts map[string]map[string][]int // enabled channels in canary or shadow mode
+
+// channelRolloutMode returns the rollout mode of a channel setting. The mode
+// is kept in a column of its own so that channels can be selected without the
+// memory cache and without parsing every setting.
+func channelRolloutMode(setting *string) string {
+	var settings dto.ChannelSettings
+	if setting == nil || common.UnmarshalJsonStr(*setting, &settings) != nil {
+		return ""
+	}
+	return settings.RolloutMode
+}
+
+// RefreshChannelRolloutModes fills the rollout mode column of the channels
+// saved before it existed.
+func RefreshChannelRolloutModes() error {
+	var channels []*Channel
+	if err := DB.Select("id", "setting", "rollout_mode").Where("setting LIKE ? OR rollout_mode <> ?", `%"rollout_mode"%`, "").Find(&channels).Error; err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		mode := channelRolloutMode(channel.Setting)
+		if channel.RolloutMode == mode {
+			continue
+		}
+		if err := DB.Model(&Channel{}).Where("id = ?", channel.Id).UpdateColumn("rollout_mode", mode).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excludeRolloutChannels keeps the abilities of the channels in canary or
+// shadow mode out of an ability query.
+func excludeRolloutChannels(tx *gorm.DB) *gorm.DB {
+	rollouts := DB.Model(&Channel{}).Select("id").Where("rollout_mode IN ?", []string{dto.ChannelRolloutCanary, dto.ChannelRolloutShadow})
+	return tx.Where("channel_id NOT IN (?)", rollouts)
+}
+
+// getRolloutChannels returns the enabled channels in the given rollout mode
+// that serve modelName in group.
+func getRolloutChannels(group string, modelName string, mode string) []*Channel {
+	var channels []*Channel
+	if common.MemoryCacheEnabled {
+		channelSyncLock.RLock()
+		defer channelSyncLock.RUnlock()
+		ids := group2model2rollouts[group][modelName]
+		if len(ids) == 0 {
+			ids = group2model2rollouts[group][ratio_setting.FormatMatchingModelName(modelName)]
+		}
+		for _, id := range ids {
+			if channel, ok := channelsIDM[id]; ok {
+				channels = append(channels, channel)
+			}
+		}
+	} else {
+		abilities := excludeDisabledModels(DB.Model(&Ability{}), modelName).Select("channel_id").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, modelName, true)
+		if err := DB.Where("rollout_mode = ? AND status = ? AND id IN (?)", mode, common.ChannelStatusEnabled, abilities).Find(&channels).Error; err != nil {
+			common.SysLog("failed to get rollout channels: " + err.Error())
+			return nil
+		}
+	}
+
+	filtered := channels[:0]
+	for _, channel := range channels {
+		if channel.GetSetting().RolloutMode == mode {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered
+}
+
+// GetCanaryChannel draws the canary channel of a request. Every canary
+// channel serving modelName in group gets its percentage of the requests,
+// regardless of the weights of the regular channels; nil is returned for the
+// remaining requests and when the drawn channel is unavailable. A request
+// with a session affinity key gets the same draw as the rest of its
+// conversation.
+func GetCanaryChannel(group string, modelName string, affinityKey string) *Channel {
+	channels := getRolloutChannels(group, modelName, dto.ChannelRolloutCanary)
+	if len(channels) == 0 {
+		return nil
+	}
+	roll := rand.Float64() * 100
+	if affinityKey != "" {
+		roll = affinityPercent(affinityKey)
+	}
+	for _, channel := range channels {
+		roll -= channel.GetSetting().RolloutPercent
+		if roll >= 0 {
+			continue
+		}
+		candidate := []*Channel{channel}
+		admitted, probe := filterChannelsByCircuitBreaker(candidate)
+		if probe == nil && len(admitted) == 0 {
+			return nil
+		}
+		if len(filterSaturatedChannels(filterRateLimitedChannels(candidate))) == 0 {
+			return nil
+		}
+		return channel
+	}
+	return nil
+}
+
+// GetShadowChannels returns the shadow channels a request should be mirrored
+// to, each drawn with its own percentage.
+func GetShadowChannels(group string, modelName string) []*Channel {
+	var sampled []*Channel
+	for _, channel := range getRolloutChannels(group, modelName, dto.ChannelRolloutShadow) {
+		if rand.Float64()*100 < channel.GetSetting().RolloutPercent {
+			sampled = append(sampled, channel)
+		}
+	}
+	return sampled
+}
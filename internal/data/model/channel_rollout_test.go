@@ -0,0 +1,112 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+)
+
+// TestRolloutChannels tests that canary channels get exactly their share of
+// first attempts and that shadow channels are never selected
+func TestRolloutChannels(t *testing.T) {
+	memoryCacheEnabled := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = true
+	canarySetting := `{"rollout_mode":"canary","rollout_percent":100}`
+	shadowSetting := `{"rollout_mode":"shadow","rollout_percent":100}`
+	regular := &Channel{Id: 301, Status: common.ChannelStatusEnabled}
+	canary := &Channel{Id: 302, Status: common.ChannelStatusEnabled, Setting: &canarySetting}
+	shadow := &Channel{Id: 303, Status: common.ChannelStatusEnabled, Setting: &shadowSetting}
+	channelSyncLock.Lock()
+	ids, channels, rollouts := channelsIDM, group2model2channels, group2model2rollouts
+	channelsIDM = map[int]*Channel{301: regular, 302: canary, 303: shadow}
+	group2model2channels = map[string]map[string][]int{"default": {"gpt-4o": {301}}}
+	group2model2rollouts = map[string]map[string][]int{"default": {"gpt-4o": {302, 303}}}
+	channelSyncLock.Unlock()
+	defer func() {
+		common.MemoryCacheEnabled = memoryCacheEnabled
+		channelSyncLock.Lock()
+		channelsIDM, group2model2channels, group2model2rollouts = ids, channels, rollouts
+		channelSyncLock.Unlock()
+	}()
+
+	if channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0); err != nil || channel.Id != 302 {
+		t.Fatalf("first attempt went to %v, %v, want the canary", channel, err)
+	}
+	if channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 1); err != nil || channel.Id != 301 {
+		t.Fatalf("retry went to %v, %v, want the regular channel", channel, err)
+	}
+	if sampled := GetShadowChannels("default", "gpt-4o"); len(sampled) != 1 || sampled[0].Id != 303 {
+		t.Fatalf("shadow channels = %v", sampled)
+	}
+
+	canarySetting = `{"rollout_mode":"canary","rollout_percent":0}`
+	shadowSetting = `{"rollout_mode":"shadow","rollout_percent":0}`
+	for i := 0; i < 20; i++ {
+		channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0)
+		if err != nil || channel.Id != 301 {
+			t.Fatalf("request went to %v, %v with rollouts at 0%%", channel, err)
+		}
+	}
+	if sampled := GetShadowChannels("default", "gpt-4o"); len(sampled) != 0 {
+		t.Fatalf("shadow channels at 0%% = %v", sampled)
+	}
+}
+
+// TestRolloutChannelsWithoutCache tests that the rollout mode column keeps
+// canary channels out of the regular selection without the memory cache
+func TestRolloutChannelsWithoutCache(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{}, &DisabledChannelModel{})
+	memoryCacheEnabled := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = false
+	defer func() { common.MemoryCacheEnabled = memoryCacheEnabled }()
+
+	canarySetting := `{"rollout_mode":"canary","rollout_percent":100}`
+	for _, channel := range []*Channel{
+		{Id: 311, Name: "regular", Key: "sk-regular", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o"},
+		{Id: 312, Name: "canary", Key: "sk-canary", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Setting: &canarySetting},
+	} {
+		if err := DB.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := channel.AddAbilities(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a channel saved before the column existed
+	if err := DB.Model(&Channel{}).Where("id = ?", 312).UpdateColumn("rollout_mode", "").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := RefreshChannelRolloutModes(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if channel, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{}); err != nil || channel == nil || channel.Id != 311 {
+			t.Fatalf("regular selection went to %v, %v", channel, err)
+		}
+	}
+	if channel := GetCanaryChannel("default", "gpt-4o", ""); channel == nil || channel.Id != 312 {
+		t.Fatalf("canary selection went to %v", channel)
+	}
+
+	// a model disabled by the channel's test profile is not drawn either
+	if err := DB.Create(&DisabledChannelModel{ChannelId: 312, Model: "gpt-4o"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if channel := GetCanaryChannel("default", "gpt-4o", ""); channel != nil {
+		t.Fatalf("canary selection went to %v for a disabled model", channel)
+	}
+	if err := DB.Where("channel_id = ?", 312).Delete(&DisabledChannelModel{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// leaving canary mode returns the channel to the regular selection
+	regularSetting := `{}`
+	if err := DB.Model(&Channel{Id: 312}).Updates(&Channel{Setting: &regularSetting}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if channel := GetCanaryChannel("default", "gpt-4o", ""); channel != nil {
+		t.Fatalf("canary selection went to %v after leaving canary mode", channel)
+	}
+}
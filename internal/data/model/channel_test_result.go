@@ -0,0 +1,123 @@
+package model
+
+import (
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelTestResult is the outcome of testing a model of a channel with a
+// test profile. Failures lists the assertions that did not hold.
+type ChannelTestResult struct {
+	Id               int    `json:"id"`
+	ChannelId        int    `json:"channel_id" gorm:"index:idx_channel_test_result,priority:1"`
+	ModelName        string `json:"model_name" gorm:"type:varchar(255);index:idx_channel_test_result,priority:2"`
+	Profile          string `json:"profile" gorm:"type:varchar(64)"`
+	Success          bool   `json:"success"`
+	Message          string `json:"message" gorm:"type:text"`
+	Failures         string `json:"failures" gorm:"type:text"`
+	Latency          int    `json:"latency"` // in milliseconds
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func RecordChannelTestResult(result *ChannelTestResult) error {
+	if result.CreatedAt == 0 {
+		result.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(result).Error
+}
+
+// GetChannelTestResults returns the test history of a channel, newest first,
+// optionally narrowed to a model and a profile.
+func GetChannelTestResults(channelId int, modelName string, profile string, startIdx int, num int) ([]*ChannelTestResult, int64, error) {
+	var results []*ChannelTestResult
+	var total int64
+	tx := DB.Model(&ChannelTestResult{}).Where("channel_id = ?", channelId)
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if profile != "" {
+		tx = tx.Where("profile = ?", profile)
+	}
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := tx.Order("id desc").Limit(num).Offset(startIdx).Find(&results).Error
+	return results, total, err
+}
+
+// GetLatestChannelTestResults returns the last result of every model and
+// profile tested on a channel.
+func GetLatestChannelTestResults(channelId int) ([]*ChannelTestResult, error) {
+	var results []*ChannelTestResult
+	latest := DB.Model(&ChannelTestResult{}).Select("max(id)").Where("channel_id = ?", channelId).Group("model_name, profile")
+	err := DB.Where("id IN (?)", latest).Order("model_name, profile").Find(&results).Error
+	return results, err
+}
+
+// DeleteChannelTestResultsBefore removes results older than timestamp.
+func DeleteChannelTestResultsBefore(timestamp int64) (int64, error) {
+	result := DB.Where("created_at < ?", timestamp).Delete(&ChannelTestResult{})
+	return result.RowsAffected, result.Error
+}
+
+// DisabledChannelModel is a model of a channel disabled on its own, e.g. by
+// failing its tests. It is kept apart from the abilities, which are rebuilt
+// whenever the channel is saved.
+type DisabledChannelModel struct {
+	Id        int    `json:"id"`
+	ChannelId int    `json:"channel_id" gorm:"uniqueIndex:idx_disabled_channel_model,priority:1"`
+	Model     string `json:"model" gorm:"type:varchar(255);uniqueIndex:idx_disabled_channel_model,priority:2;index"`
+	Reason    string `json:"reason" gorm:"type:text"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+// DisableChannelModel disables a single model of a channel in every group,
+// leaving its other models alone.
+func DisableChannelModel(channelId int, modelName string, reason string) error {
+	return DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&DisabledChannelModel{
+		ChannelId: channelId,
+		Model:     modelName,
+		Reason:    reason,
+		CreatedAt: common.GetTimestamp(),
+	}).Error
+}
+
+// EnableChannelModel enables a model disabled by DisableChannelModel.
+func EnableChannelModel(channelId int, modelName string) error {
+	return DB.Where("channel_id = ? AND model = ?", channelId, modelName).Delete(&DisabledChannelModel{}).Error
+}
+
+// GetDisabledChannelModels returns the models of a channel disabled on their
+// own.
+func GetDisabledChannelModels(channelId int) ([]string, error) {
+	var models []string
+	err := DB.Model(&DisabledChannelModel{}).Where("channel_id = ?", channelId).Order("model").Pluck("model", &models).Error
+	return models, err
+}
+
+// getAllDisabledChannelModels returns the disabled models of every channel,
+// keyed by channel id.
+func getAllDisabledChannelModels() (map[int]map[string]bool, error) {
+	var rows []DisabledChannelModel
+	if err := DB.Select("channel_id", "model").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	disabled := make(map[int]map[string]bool)
+	for _, row := range rows {
+		if disabled[row.ChannelId] == nil {
+			disabled[row.ChannelId] = make(map[string]bool)
+		}
+		disabled[row.ChannelId][row.Model] = true
+	}
+	return disabled, nil
+}
+
+// excludeDisabledModels narrows an ability query for modelName to the
+// channels that have not disabled the model.
+func excludeDisabledModels(tx *gorm.DB, modelName string) *gorm.DB {
+	return tx.Where("channel_id NOT IN (?)", DB.Model(&DisabledChannelModel{}).Select("channel_id").Where("model = ?", modelName))
+}
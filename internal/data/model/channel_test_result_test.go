@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+)
+
+// TestDisabledChannelModels tests that a model disabled on its own survives
+// the abilities being rebuilt and is not selected while its siblings are
+func TestDisabledChannelModels(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{}, &DisabledChannelModel{})
+	memoryCacheEnabled := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = false
+	defer func() { common.MemoryCacheEnabled = memoryCacheEnabled }()
+
+	channel := &Channel{Id: 1, Name: "openai", Key: "sk-test", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o,gpt-4o-mini"}
+	if err := DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := DisableChannelModel(1, "gpt-4o", "chat: assertions failed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DisableChannelModel(1, "gpt-4o", "stream: assertions failed"); err != nil {
+		t.Fatalf("disabling twice: %v", err)
+	}
+	if err := channel.UpdateAbilities(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if selected, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{}); err != nil || selected != nil {
+		t.Errorf("disabled model went to %v, %v", selected, err)
+	}
+	if selected, err := GetChannel("default", "gpt-4o-mini", 0, ChannelDemand{}); err != nil || selected == nil || selected.Id != 1 {
+		t.Errorf("enabled model went to %v, %v", selected, err)
+	}
+	if models, err := GetDisabledChannelModels(1); err != nil || len(models) != 1 || models[0] != "gpt-4o" {
+		t.Errorf("disabled models = %v, %v", models, err)
+	}
+
+	if err := EnableChannelModel(1, "gpt-4o"); err != nil {
+		t.Fatal(err)
+	}
+	if selected, err := GetChannel("default", "gpt-4o", 0, ChannelDemand{}); err != nil || selected == nil || selected.Id != 1 {
+		t.Errorf("enabled again model went to %v, %v", selected, err)
+	}
+}
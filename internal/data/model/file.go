@@ -0,0 +1,114 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"gorm.io/gorm"
+)
+
+// UserFile is a file uploaded through /v1/files or produced by a batch job.
+// The content itself is stored in UserFileChunk rows, keyed by FileId.
+type UserFile struct {
+	Id        int    `json:"id" gorm:"primaryKey"`
+	FileId    string `json:"file_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	TokenId   int    `json:"token_id" gorm:"index"`
+	Filename  string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose   string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func (UserFile) TableName() string {
+	return "user_files"
+}
+
+func (f *UserFile) Insert() error {
+	return DB.Create(f).Error
+}
+
+func (f *UserFile) Delete() error {
+	return DB.Delete(f).Error
+}
+
+func (f *UserFile) ToOpenAIFile() *dto.OpenAIFile {
+	return &dto.OpenAIFile{
+		ID:        f.FileId,
+		Object:    "file",
+		Bytes:     f.Bytes,
+		CreatedAt: f.CreatedAt,
+		Filename:  f.Filename,
+		Purpose:   f.Purpose,
+		Status:    "processed",
+	}
+}
+
+// GetUserFileByFileId returns the file only if it belongs to the given user.
+func GetUserFileByFileId(userId int, fileId string) (*UserFile, error) {
+	var file UserFile
+	err := DB.Where("user_id = ? AND file_id = ?", userId, fileId).First(&file).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetUserFiles lists files of a user ordered by id, using afterId as cursor.
+func GetUserFiles(userId int, purpose string, afterId int, limit int, asc bool) ([]*UserFile, error) {
+	var files []*UserFile
+	query := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	if afterId > 0 {
+		if asc {
+			query = query.Where("id > ?", afterId)
+		} else {
+			query = query.Where("id < ?", afterId)
+		}
+	}
+	order := "id desc"
+	if asc {
+		order = "id asc"
+	}
+	err := query.Order(order).Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// UserFileChunk is a piece of the content of a stored file. Contents live in
+// the database so that every node can read a file another node stored.
+type UserFileChunk struct {
+	Id     int    `json:"id" gorm:"primaryKey"`
+	FileId string `json:"file_id" gorm:"type:varchar(64);uniqueIndex:idx_user_file_chunk,priority:1"`
+	Seq    int    `json:"seq" gorm:"uniqueIndex:idx_user_file_chunk,priority:2"`
+	Data   []byte `json:"-"`
+}
+
+func (UserFileChunk) TableName() string {
+	return "user_file_chunks"
+}
+
+func InsertUserFileChunk(chunk *UserFileChunk) error {
+	return DB.Create(chunk).Error
+}
+
+// GetUserFileChunk returns the chunk seq of a file, nil after the last one.
+func GetUserFileChunk(fileId string, seq int) (*UserFileChunk, error) {
+	var chunk UserFileChunk
+	err := DB.Where("file_id = ? AND seq = ?", fileId, seq).First(&chunk).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &chunk, nil
+}
+
+func DeleteUserFileChunks(fileId string) error {
+	return DB.Where("file_id = ?", fileId).Delete(&UserFileChunk{}).Error
+}
@@ -0,0 +1,119 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dimensions the margin report can be grouped by
+const (
+	MarginDimensionChannel = "channel"
+	MarginDimensionModel   = "model"
+	MarginDimensionGroup   = "group"
+	MarginDimensionDay     = "day"
+)
+
+var MarginDimensions = []string{MarginDimensionChannel, MarginDimensionModel, MarginDimensionGroup, MarginDimensionDay}
+
+// MarginStat is what the consume logs sharing the grouped dimensions billed
+// users (revenue) and cost upstream. Days are unix timestamps of UTC midnight.
+type MarginStat struct {
+	Day         int64   `json:"day,omitempty"`
+	ChannelId   int     `json:"channel_id,omitempty"`
+	ChannelName string  `json:"channel_name,omitempty" gorm:"-"`
+	ModelName   string  `json:"model_name,omitempty"`
+	Group       string  `json:"group,omitempty"`
+	Requests    int64   `json:"requests"`
+	Revenue     int64   `json:"revenue"`
+	Cost        int64   `json:"cost"`
+	Margin      int64   `json:"margin" gorm:"-"`
+	MarginRate  float64 `json:"margin_rate" gorm:"-"` // 毛利率，毛利 / 收入
+}
+
+// marginDimensionColumn returns the expression a dimension groups by and the
+// column it is selected as.
+func marginDimensionColumn(dimension string) (string, string, error) {
+	switch dimension {
+	case MarginDimensionChannel:
+		return "channel_id", "channel_id", nil
+	case MarginDimensionModel:
+		return "model_name", "model_name", nil
+	case MarginDimensionGroup:
+		return logGroupCol, logGroupCol, nil
+	case MarginDimensionDay:
+		return "(created_at - created_at % 86400)", "day", nil
+	}
+	return "", "", fmt.Errorf("unknown dimension %q", dimension)
+}
+
+// GetMarginStats aggregates the consume logs in the time range by the given
+// dimensions.
+func GetMarginStats(dimensions []string, startTimestamp int64, endTimestamp int64, channel int, modelName string, group string) ([]*MarginStat, error) {
+	selects := []string{"count(*) AS requests", "sum(quota) AS revenue", "sum(upstream_cost) AS cost"}
+	var groupBy []string
+	for _, dimension := range dimensions {
+		column, alias, err := marginDimensionColumn(dimension)
+		if err != nil {
+			return nil, err
+		}
+		selects = append(selects, column+" AS "+alias)
+		groupBy = append(groupBy, column)
+	}
+
+	tx := LOG_DB.Table("logs").Select(strings.Join(selects, ", ")).Where("type = ?", LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if channel != 0 {
+		tx = tx.Where("channel_id = ?", channel)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if group != "" {
+		tx = tx.Where(logGroupCol+" = ?", group)
+	}
+	if len(groupBy) > 0 {
+		tx = tx.Group(strings.Join(groupBy, ", "))
+	}
+
+	var stats []*MarginStat
+	if err := tx.Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+
+	channelIds := make([]int, 0)
+	for _, stat := range stats {
+		stat.Margin = stat.Revenue - stat.Cost
+		if stat.Revenue != 0 {
+			stat.MarginRate = float64(stat.Margin) / float64(stat.Revenue)
+		}
+		if stat.ChannelId != 0 {
+			channelIds = append(channelIds, stat.ChannelId)
+		}
+	}
+	if len(channelIds) > 0 {
+		var channels []Channel
+		if err := DB.Select("id", "name").Where("id IN ?", channelIds).Find(&channels).Error; err == nil {
+			names := make(map[int]string, len(channels))
+			for _, channel := range channels {
+				names[channel.Id] = channel.Name
+			}
+			for _, stat := range stats {
+				stat.ChannelName = names[stat.ChannelId]
+			}
+		}
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Day != stats[j].Day {
+			return stats[i].Day < stats[j].Day
+		}
+		return stats[i].Revenue > stats[j].Revenue
+	})
+	return stats, nil
+}
@@ -271,6 +271,17 @@ func migrateDB() error {
 		&Subscription{},
 		&InternalApiKey{},
 		&InvitationCode{},
+		&UserFile{},
+		&UserFileChunk{},
+		&BatchJob{},
+		&BatchResult{},
+		&StoredResponse{},
+		&ChannelKeyUsage{},
+		&ChannelMetric{},
+		&ChannelRevision{},
+		&ChannelTestResult{},
+		&DisabledChannelModel{},
+		&ModelDiscoveryNotice{},
 		// Multi-tenant models
 		&Tenant{},
 		&UserIdentityMapping{},
@@ -321,6 +332,17 @@ func migrateDBFast() error {
 		{&Subscription{}, "Subscription"},
 		{&InternalApiKey{}, "InternalApiKey"},
 		{&InvitationCode{}, "InvitationCode"},
+		{&UserFile{}, "UserFile"},
+		{&UserFileChunk{}, "UserFileChunk"},
+		{&BatchJob{}, "BatchJob"},
+		{&BatchResult{}, "BatchResult"},
+		{&StoredResponse{}, "StoredResponse"},
+		{&ChannelKeyUsage{}, "ChannelKeyUsage"},
+		{&ChannelMetric{}, "ChannelMetric"},
+		{&ChannelRevision{}, "ChannelRevision"},
+		{&ChannelTestResult{}, "ChannelTestResult"},
+		{&DisabledChannelModel{}, "DisabledChannelModel"},
+		{&ModelDiscoveryNotice{}, "ModelDiscoveryNotice"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
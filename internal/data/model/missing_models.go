@@ -28,3 +28,33 @@ func GetMissingModels() ([]string, error) {
 	}
 	return missing, nil
 }
+
+// CreateModelMetas creates meta entries for the models that have none yet and
+// returns their names. Models whose meta entry was deleted are left alone.
+func CreateModelMetas(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	var existing []string
+	if err := DB.Unscoped().Model(&Model{}).Where("model_name IN ?", names).Pluck("model_name", &existing).Error; err != nil {
+		return nil, err
+	}
+	existingSet := make(map[string]struct{}, len(existing))
+	for _, e := range existing {
+		existingSet[e] = struct{}{}
+	}
+
+	var created []string
+	for _, name := range names {
+		if _, ok := existingSet[name]; ok {
+			continue
+		}
+		existingSet[name] = struct{}{}
+		meta := &Model{ModelName: name, Status: 1, SyncOfficial: 1, NameRule: NameRuleExact}
+		if err := meta.Insert(); err != nil {
+			return created, err
+		}
+		created = append(created, name)
+	}
+	return created, nil
+}
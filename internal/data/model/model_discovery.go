@@ -0,0 +1,37 @@
+package model
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/common"
+
+// ModelDiscoveryNotice is the last upstream model change of a channel root
+// was notified about, so that a change left as it is is not notified on
+// every model discovery run.
+type ModelDiscoveryNotice struct {
+	ChannelId int    `json:"channel_id" gorm:"primaryKey;autoIncrement:false"`
+	Changes   string `json:"changes" gorm:"type:text"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+// GetModelDiscoveryNotices returns the changes last notified per channel.
+func GetModelDiscoveryNotices() (map[int]string, error) {
+	var notices []ModelDiscoveryNotice
+	if err := DB.Find(&notices).Error; err != nil {
+		return nil, err
+	}
+	changes := make(map[int]string, len(notices))
+	for _, notice := range notices {
+		changes[notice.ChannelId] = notice.Changes
+	}
+	return changes, nil
+}
+
+// SaveModelDiscoveryNotice records that root was notified of changes of a
+// channel.
+func SaveModelDiscoveryNotice(channelId int, changes string) error {
+	return DB.Save(&ModelDiscoveryNotice{ChannelId: channelId, Changes: changes, CreatedAt: common.GetTimestamp()}).Error
+}
+
+// DeleteModelDiscoveryNotice forgets the notified changes of a channel, once
+// they were applied or went away, so that they are notified if they come back.
+func DeleteModelDiscoveryNotice(channelId int) error {
+	return DB.Where("channel_id = ?", channelId).Delete(&ModelDiscoveryNotice{}).Error
+}
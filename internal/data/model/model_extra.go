@@ -1,5 +1,7 @@
 package model
 
+import "github.com/QuantumNous/lurus-api/internal/pkg/dto"
+
 func GetModelEnableGroups(modelName string) []string {
 	// 确保缓存最新
 	GetPricing()
@@ -29,3 +31,13 @@ func GetModelQuotaTypes(modelName string) []int {
 	}
 	return []int{quota}
 }
+
+// GetModelContextLimit 返回模型元数据中的上下文窗口（来自缓存）
+func GetModelContextLimit(modelName string) dto.ModelContextLimit {
+	GetPricing()
+
+	modelEnableGroupsLock.RLock()
+	limit := modelContextLimits[modelName]
+	modelEnableGroupsLock.RUnlock()
+	return limit
+}
@@ -39,6 +39,9 @@ type Model struct {
 	QuotaTypes    []int          `json:"quota_types,omitempty" gorm:"-"`
 	NameRule      int            `json:"name_rule" gorm:"default:0"`
 
+	ContextLength   int `json:"context_length" gorm:"default:0"`    // 输入与输出 token 总数上限，0 表示不限制，渠道可按模型覆盖
+	MaxOutputTokens int `json:"max_output_tokens" gorm:"default:0"` // 输出 token 上限，0 表示不限制
+
 	MatchedModels []string `json:"matched_models,omitempty" gorm:"-"`
 	MatchedCount  int      `json:"matched_count,omitempty" gorm:"-"`
 }
@@ -10,6 +10,7 @@ import (
 
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
 	"github.com/QuantumNous/lurus-api/internal/pkg/setting/ratio_setting"
 	"github.com/QuantumNous/lurus-api/internal/pkg/types"
 )
@@ -27,6 +28,8 @@ type Pricing struct {
 	CompletionRatio        float64                 `json:"completion_ratio"`
 	EnableGroup            []string                `json:"enable_groups"`
 	SupportedEndpointTypes []constant.EndpointType `json:"supported_endpoint_types"`
+	ContextLength          int                     `json:"context_length,omitempty"`
+	MaxOutputTokens        int                     `json:"max_output_tokens,omitempty"`
 }
 
 type PricingVendor struct {
@@ -46,6 +49,7 @@ var (
 	// 缓存映射：模型名 -> 启用分组 / 计费类型
 	modelEnableGroups     = make(map[string][]string)
 	modelQuotaTypeMap     = make(map[string]int)
+	modelContextLimits    = make(map[string]dto.ModelContextLimit)
 	modelEnableGroupsLock = sync.RWMutex{}
 )
 
@@ -280,6 +284,8 @@ func updatePricing() {
 			pricing.Icon = meta.Icon
 			pricing.Tags = meta.Tags
 			pricing.VendorID = meta.VendorID
+			pricing.ContextLength = meta.ContextLength
+			pricing.MaxOutputTokens = meta.MaxOutputTokens
 		}
 		modelPrice, findPrice := ratio_setting.GetModelPrice(model, false)
 		if findPrice {
@@ -298,9 +304,13 @@ func updatePricing() {
 	modelEnableGroupsLock.Lock()
 	modelEnableGroups = make(map[string][]string)
 	modelQuotaTypeMap = make(map[string]int)
+	modelContextLimits = make(map[string]dto.ModelContextLimit)
 	for _, p := range pricingMap {
 		modelEnableGroups[p.ModelName] = p.EnableGroup
 		modelQuotaTypeMap[p.ModelName] = p.QuotaType
+		if p.ContextLength > 0 || p.MaxOutputTokens > 0 {
+			modelContextLimits[p.ModelName] = dto.ModelContextLimit{ContextLength: p.ContextLength, MaxOutputTokens: p.MaxOutputTokens}
+		}
 	}
 	modelEnableGroupsLock.Unlock()
 
@@ -0,0 +1,173 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("secret", SecretSerializer{})
+}
+
+// SecretSerializer stores a string column encrypted with the master key, see
+// common.EncryptSecret. Use it with `gorm:"serializer:secret"`.
+type SecretSerializer struct{}
+
+func (SecretSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("unsupported secret value %T for %s", dbValue, field.Name)
+	}
+	plaintext, err := common.DecryptSecret(value)
+	if err != nil {
+		// one undecryptable row must not fail the whole query, it reads as empty
+		common.SysError(fmt.Sprintf("failed to decrypt %s.%s: %s", field.Schema.Table, field.DBName, err.Error()))
+		plaintext = ""
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+func (SecretSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, _ := fieldValue.(string)
+	return common.EncryptSecret(value)
+}
+
+// BeforeSave stores the lookup hash of the key, which SearchChannels matches
+// since the key itself is encrypted, and the rollout mode of the setting.
+func (channel *Channel) BeforeSave(tx *gorm.DB) error {
+	values := channel
+	if dest, ok := tx.Statement.Dest.(*Channel); ok {
+		// Updates with other values than the model
+		values = dest
+	}
+	if values.Key != "" {
+		tx.Statement.SetColumn("KeyLookup", common.SecretLookupHash(values.Key))
+	}
+	if values.Setting != nil {
+		tx.Statement.SetColumn("RolloutMode", channelRolloutMode(values.Setting))
+	}
+	return nil
+}
+
+// RefreshChannelKeyLookups recomputes the key lookup hashes that are missing
+// or were derived from another master key.
+func RefreshChannelKeyLookups() error {
+	var channels []*Channel
+	if err := DB.Select("id", commonKeyCol, "key_lookup").Find(&channels).Error; err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		lookup := common.SecretLookupHash(channel.Key)
+		if channel.Key == "" || channel.KeyLookup == lookup {
+			continue
+		}
+		if err := DB.Model(&Channel{}).Where("id = ?", channel.Id).UpdateColumn("key_lookup", lookup).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsSecretOption reports whether an option holds a credential, which is
+// encrypted in the database and never sent to the frontend.
+func IsSecretOption(key string) bool {
+	return strings.HasSuffix(key, "Token") ||
+		strings.HasSuffix(key, "Secret") ||
+		strings.HasSuffix(key, "Key") ||
+		strings.HasSuffix(key, "secret") ||
+		strings.HasSuffix(key, "api_key")
+}
+
+func (option *Option) BeforeSave(tx *gorm.DB) error {
+	if !IsSecretOption(option.Key) {
+		return nil
+	}
+	value, err := common.EncryptSecret(option.Value)
+	if err != nil {
+		return err
+	}
+	option.Value = value
+	return nil
+}
+
+func (option *Option) AfterFind(tx *gorm.DB) error {
+	value, err := common.DecryptSecret(option.Value)
+	if err != nil {
+		// like SecretSerializer, one undecryptable option reads as empty
+		common.SysError(fmt.Sprintf("failed to decrypt option %s: %s", option.Key, err.Error()))
+		value = ""
+	}
+	option.Value = value
+	return nil
+}
+
+// RotateSecrets re-encrypts every stored secret with the current master key:
+// data keys of secrets encrypted with an old master key are re-encrypted and
+// plaintext secrets are encrypted. It returns the number of rows changed.
+func RotateSecrets() (int, error) {
+	if !common.SecretEncryptionEnabled() {
+		return 0, fmt.Errorf("SECRET_ENCRYPTION_KEY is not set")
+	}
+	rotated := 0
+
+	// read and write the raw columns, bypassing SecretSerializer
+	var channels []struct {
+		Id  int
+		Key string
+	}
+	if err := DB.Table("channels").Select("id", commonKeyCol).Find(&channels).Error; err != nil {
+		return rotated, err
+	}
+	for _, channel := range channels {
+		value, changed, err := common.RotateSecret(channel.Key)
+		if err != nil {
+			return rotated, fmt.Errorf("channel #%d: %w", channel.Id, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := DB.Table("channels").Where("id = ?", channel.Id).Update("key", value).Error; err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+
+	var options []struct {
+		Key   string
+		Value string
+	}
+	if err := DB.Table("options").Select(commonKeyCol, "value").Find(&options).Error; err != nil {
+		return rotated, err
+	}
+	for _, option := range options {
+		if !IsSecretOption(option.Key) && !common.IsEncryptedSecret(option.Value) {
+			continue
+		}
+		value, changed, err := common.RotateSecret(option.Value)
+		if err != nil {
+			return rotated, fmt.Errorf("option %s: %w", option.Key, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := DB.Table("options").Where(commonKeyCol+" = ?", option.Key).Update("value", value).Error; err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, RefreshChannelKeyLookups()
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+)
+
+// TestEncryptedChannelKeys tests that encrypted channel keys can be searched
+// for and that an undecryptable key does not fail the channel list
+func TestEncryptedChannelKeys(t *testing.T) {
+	t.Cleanup(func() { _ = common.InitSecretEncryption() })
+	t.Setenv("SECRET_ENCRYPTION_KEY", "test-master-key")
+	if err := common.InitSecretEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	db := setupTestDB(t, &Channel{})
+
+	for _, channel := range []*Channel{
+		{Name: "first", Key: "sk-first", Models: "gpt-4o"},
+		{Name: "second", Key: "sk-second", Models: "gpt-4o"},
+	} {
+		if err := db.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stored string
+	db.Table("channels").Where("name = ?", "first").Select("key").Scan(&stored)
+	if !common.IsEncryptedSecret(stored) {
+		t.Fatalf("stored key = %q, want it encrypted", stored)
+	}
+
+	channels, err := SearchChannels("sk-first", "", "", false)
+	if err != nil || len(channels) != 1 || channels[0].Name != "first" {
+		t.Fatalf("search by key = %v, %v", channels, err)
+	}
+
+	if err := db.Model(channels[0]).Updates(&Channel{Key: "sk-renamed"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if channels, _ := SearchChannels("sk-renamed", "", "", false); len(channels) != 1 {
+		t.Fatalf("search by updated key = %v", channels)
+	}
+	if channels, _ := SearchChannels("sk-first", "", "", false); len(channels) != 0 {
+		t.Fatalf("search by old key = %v", channels)
+	}
+	if err := db.Model(channels[0]).Updates(&Channel{Key: "sk-first"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// a key encrypted with a master key that is gone
+	db.Table("channels").Where("name = ?", "second").Update("key", "enc:v1:deadbeef:AAAA:AAAA")
+	channels, err = GetAllChannels(0, 0, true, true)
+	if err != nil || len(channels) != 2 {
+		t.Fatalf("channels = %v, %v", channels, err)
+	}
+	for _, channel := range channels {
+		if channel.Name == "second" && channel.Key != "" {
+			t.Errorf("undecryptable key = %q, want empty", channel.Key)
+		}
+		if channel.Name == "first" && channel.Key != "sk-first" {
+			t.Errorf("key = %q", channel.Key)
+		}
+	}
+
+	// a new master key changes the lookups until they are refreshed
+	t.Setenv("SECRET_ENCRYPTION_KEY", "new-master-key")
+	t.Setenv("SECRET_ENCRYPTION_OLD_KEYS", "test-master-key")
+	if err := common.InitSecretEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if err := RefreshChannelKeyLookups(); err != nil {
+		t.Fatal(err)
+	}
+	if channels, err := SearchChannels("sk-first", "", "", false); err != nil || len(channels) != 1 {
+		t.Fatalf("search by key after a new master key = %v, %v", channels, err)
+	}
+}
+
+// TestUndecryptableOption tests that an undecryptable secret option reads as
+// empty instead of failing the options load
+func TestUndecryptableOption(t *testing.T) {
+	t.Cleanup(func() { _ = common.InitSecretEncryption() })
+	t.Setenv("SECRET_ENCRYPTION_KEY", "test-master-key")
+	if err := common.InitSecretEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	db := setupTestDB(t, &Option{})
+	db.Create(&[]Option{{Key: "SMTPToken", Value: "smtp-token"}, {Key: "GitHubClientSecret", Value: "gh-secret"}})
+	db.Table("options").Where(commonKeyCol+" = ?", "GitHubClientSecret").Update("value", "enc:v1:deadbeef:AAAA:AAAA")
+
+	var options []*Option
+	if err := db.Find(&options).Error; err != nil || len(options) != 2 {
+		t.Fatalf("options = %v, %v", options, err)
+	}
+	for _, option := range options {
+		if option.Key == "SMTPToken" && option.Value != "smtp-token" {
+			t.Errorf("option value = %q", option.Value)
+		}
+		if option.Key == "GitHubClientSecret" && option.Value != "" {
+			t.Errorf("undecryptable option = %q, want empty", option.Value)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"gorm.io/gorm"
+)
+
+// StoredResponse indexes a /v1/responses result kept by the gateway so that
+// previous_response_id and GET /v1/responses/{id} work on every channel.
+// The input items and response object are kept in Content, in the database so
+// that every node can read them.
+type StoredResponse struct {
+	Id                 int    `json:"id" gorm:"primaryKey"`
+	ResponseId         string `json:"response_id" gorm:"type:varchar(128);uniqueIndex"`
+	PreviousResponseId string `json:"previous_response_id" gorm:"type:varchar(128)"`
+	UserId             int    `json:"user_id" gorm:"index"`
+	TokenId            int    `json:"token_id" gorm:"index"`
+	ChannelId          int    `json:"channel_id"`
+	Model              string `json:"model" gorm:"type:varchar(255)"`
+	Bytes              int64  `json:"bytes"`
+	Content            []byte `json:"-"` // service.StoredResponseContent
+	CreatedAt          int64  `json:"created_at" gorm:"bigint"`
+	ExpiresAt          int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func (StoredResponse) TableName() string {
+	return "stored_responses"
+}
+
+func (r *StoredResponse) Insert() error {
+	return DB.Create(r).Error
+}
+
+func (r *StoredResponse) Delete() error {
+	return DB.Delete(r).Error
+}
+
+// GetStoredResponse returns an unexpired response owned by the given user.
+func GetStoredResponse(userId int, responseId string) (*StoredResponse, error) {
+	var stored StoredResponse
+	err := DB.Where("user_id = ? AND response_id = ? AND expires_at > ?", userId, responseId, common.GetTimestamp()).
+		First(&stored).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func GetExpiredStoredResponses(limit int) ([]*StoredResponse, error) {
+	var responses []*StoredResponse
+	err := DB.Omit("content").Where("expires_at <= ?", common.GetTimestamp()).Order("id asc").Limit(limit).Find(&responses).Error
+	return responses, err
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupTestDB points DB at an in-memory SQLite database with the given tables,
+// without Redis, for the duration of the test.
+func setupTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevSQLite, prevPostgreSQL, prevRedis := DB, common.UsingSQLite, common.UsingPostgreSQL, common.RedisEnabled
+	DB, common.UsingSQLite, common.UsingPostgreSQL, common.RedisEnabled = db, true, false, false
+	initCol()
+	t.Cleanup(func() {
+		DB, common.UsingSQLite, common.UsingPostgreSQL, common.RedisEnabled = prevDB, prevSQLite, prevPostgreSQL, prevRedis
+		initCol()
+	})
+	return db
+}
@@ -26,7 +26,10 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
-	CrossGroupRetry    bool           `json:"cross_group_retry"` // 跨分组重试，仅auto分组有效
+	CrossGroupRetry    bool           `json:"cross_group_retry"`                                     // 跨分组重试，仅auto分组有效
+	ResponseCache      bool           `json:"response_cache"`                                        // 响应缓存，需同时开启全局开关
+	HedgeDelayMs       int            `json:"hedge_delay_ms"`                                        // 对冲请求：等待首字超过该毫秒数后向另一渠道发起请求，0 使用分组配置
+	ContextCompaction  string         `json:"context_compaction" gorm:"type:varchar(32);default:''"` // 超出模型上下文窗口时的压缩方式，为空不压缩
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -190,7 +193,7 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry", "response_cache", "hedge_delay_ms", "context_compaction").Updates(token).Error
 	return err
 }
 
@@ -18,13 +18,15 @@ var (
 	PrintVersion = flag.Bool("version", false, "print version and exit")
 	PrintHelp    = flag.Bool("help", false, "print help and exit")
 	LogDir       = flag.String("log-dir", "./logs", "specify the log directory")
+	// RotateSecretKey re-encrypts the stored secrets with the current master key and exits
+	RotateSecretKey = flag.Bool("rotate-secret-key", false, "re-encrypt stored secrets with SECRET_ENCRYPTION_KEY and exit")
 )
 
 func printHelp() {
 	fmt.Println("NewAPI(Based OneAPI) " + Version + " - The next-generation LLM gateway and AI asset management system supports multiple languages.")
 	fmt.Println("Original Project: OneAPI by JustSong - https://github.com/songquanpeng/one-api")
 	fmt.Println("Maintainer: QuantumNous - https://github.com/QuantumNous/lurus-api")
-	fmt.Println("Usage: newapi [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: newapi [--port <port>] [--log-dir <log directory>] [--rotate-secret-key] [--version] [--help]")
 }
 
 func InitEnv() {
@@ -60,6 +62,9 @@ func InitEnv() {
 	} else {
 		CryptoSecret = SessionSecret
 	}
+	if err := InitSecretEncryption(); err != nil {
+		log.Fatal(err)
+	}
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -109,11 +114,16 @@ func InitEnv() {
 	CriticalRateLimitEnable = GetEnvOrDefaultBool("CRITICAL_RATE_LIMIT_ENABLE", true)
 	CriticalRateLimitNum = GetEnvOrDefault("CRITICAL_RATE_LIMIT", 20)
 	CriticalRateLimitDuration = int64(GetEnvOrDefault("CRITICAL_RATE_LIMIT_DURATION", 20*60))
+
+	CountTokensRateLimitNum = GetEnvOrDefault("COUNT_TOKENS_RATE_LIMIT", 60)
+	CountTokensRateLimitDuration = int64(GetEnvOrDefault("COUNT_TOKENS_RATE_LIMIT_DURATION", 60))
 	initConstantEnv()
 }
 
 func initConstantEnv() {
 	constant.StreamingTimeout = GetEnvOrDefault("STREAMING_TIMEOUT", 300)
+	// StreamFirstTokenTimeout 流式请求等待首个数据的秒数，0 为不限制，渠道设置可覆盖
+	constant.StreamFirstTokenTimeout = GetEnvOrDefault("STREAM_FIRST_TOKEN_TIMEOUT", 0)
 	constant.DifyDebug = GetEnvOrDefaultBool("DIFY_DEBUG", true)
 	constant.MaxFileDownloadMB = GetEnvOrDefault("MAX_FILE_DOWNLOAD_MB", 64)
 	constant.StreamScannerMaxBufferMB = GetEnvOrDefault("STREAM_SCANNER_MAX_BUFFER_MB", 64)
@@ -135,6 +145,18 @@ func initConstantEnv() {
 	constant.ErrorLogEnabled = GetEnvOrDefaultBool("ERROR_LOG_ENABLED", false)
 	// 任务轮询时查询的最大数量
 	constant.TaskQueryLimit = GetEnvOrDefault("TASK_QUERY_LIMIT", 1000)
+	// /v1/files 上传文件的大小限制，文件内容存储在数据库中
+	constant.MaxFileUploadMB = GetEnvOrDefault("MAX_FILE_UPLOAD_MB", 200)
+	// /v1/batches 单个批处理任务的最大请求数及并发数，以及每个节点同时运行的批处理任务数
+	constant.BatchMaxRequests = GetEnvOrDefault("BATCH_MAX_REQUESTS", 50000)
+	constant.BatchConcurrency = GetEnvOrDefault("BATCH_CONCURRENCY", 4)
+	constant.BatchMaxRunning = GetEnvOrDefault("BATCH_MAX_RUNNING", 4)
+	// /v1/responses 网关侧存储的开关、保留时长及单条大小限制
+	constant.ResponseStoreEnabled = GetEnvOrDefaultBool("RESPONSE_STORE_ENABLED", true)
+	constant.ResponseStoreTTLHours = GetEnvOrDefault("RESPONSE_STORE_TTL_HOURS", 720)
+	constant.ResponseStoreMaxKB = GetEnvOrDefault("RESPONSE_STORE_MAX_KB", 2048)
+	// count_tokens 是否优先转发给原生支持的上游渠道（Anthropic/Gemini），失败时回退到本地估算
+	constant.CountTokensPassThroughEnabled = GetEnvOrDefaultBool("COUNT_TOKENS_PASS_THROUGH_ENABLED", false)
 
 	soraPatchStr := GetEnvOrDefaultString("TASK_PRICE_PATCH", "")
 	if soraPatchStr != "" {
@@ -0,0 +1,230 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Secrets stored in the database are envelope encrypted: every value gets its
+// own random data key, which is encrypted with the master key. Rotating the
+// master key only re-encrypts the data keys. Stored values look like
+//
+//	enc:v1:<master key id>:<encrypted data key>:<encrypted value>
+const secretPrefix = "enc:v1:"
+
+type secretMasterKey struct {
+	id  string
+	key []byte
+}
+
+var (
+	// the key new secrets are encrypted with, nil when encryption is off
+	secretCurrentKey *secretMasterKey
+	// every key secrets can be decrypted with, by id, the current one included
+	secretKeys = make(map[string]*secretMasterKey)
+)
+
+func newSecretMasterKey(material string) *secretMasterKey {
+	key := sha256.Sum256([]byte(material))
+	id := sha256.Sum256(key[:])
+	return &secretMasterKey{id: hex.EncodeToString(id[:4]), key: key[:]}
+}
+
+// InitSecretEncryption loads the master key from SECRET_ENCRYPTION_KEY or the
+// file named by SECRET_ENCRYPTION_KEY_FILE. Keys being rotated out go in
+// SECRET_ENCRYPTION_OLD_KEYS, comma separated, until every secret has been
+// re-encrypted. Without a master key secrets are stored in plaintext.
+func InitSecretEncryption() error {
+	material := os.Getenv("SECRET_ENCRYPTION_KEY")
+	if path := os.Getenv("SECRET_ENCRYPTION_KEY_FILE"); material == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read SECRET_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		material = strings.TrimSpace(string(data))
+	}
+	secretCurrentKey = nil
+	secretKeys = make(map[string]*secretMasterKey)
+	for _, old := range strings.Split(os.Getenv("SECRET_ENCRYPTION_OLD_KEYS"), ",") {
+		if old = strings.TrimSpace(old); old != "" {
+			key := newSecretMasterKey(old)
+			secretKeys[key.id] = key
+		}
+	}
+	if material != "" {
+		secretCurrentKey = newSecretMasterKey(material)
+		secretKeys[secretCurrentKey.id] = secretCurrentKey
+	}
+	if secretCurrentKey == nil && len(secretKeys) > 0 {
+		return errors.New("SECRET_ENCRYPTION_OLD_KEYS is set without SECRET_ENCRYPTION_KEY")
+	}
+	return nil
+}
+
+func SecretEncryptionEnabled() bool {
+	return secretCurrentKey != nil
+}
+
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+func secretSeal(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func secretOpen(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted secret is truncated")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func formatSecret(keyId string, wrappedKey []byte, payload []byte) string {
+	return secretPrefix + keyId + ":" + base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" + base64.RawStdEncoding.EncodeToString(payload)
+}
+
+// parseSecret splits a stored secret into its master key id, encrypted data
+// key and encrypted value.
+func parseSecret(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("malformed encrypted secret")
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	payload, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return parts[0], wrappedKey, payload, nil
+}
+
+// EncryptSecret encrypts a value for storage. Empty values and values stored
+// while encryption is off are returned as is.
+func EncryptSecret(plaintext string) (string, error) {
+	if secretCurrentKey == nil || plaintext == "" || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	payload, err := secretSeal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := secretSeal(secretCurrentKey.key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return formatSecret(secretCurrentKey.id, wrappedKey, payload), nil
+}
+
+// unwrapSecretKey decrypts the data key of a stored secret.
+func unwrapSecretKey(keyId string, wrappedKey []byte) ([]byte, error) {
+	masterKey, ok := secretKeys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("secret is encrypted with unknown master key %s", keyId)
+	}
+	return secretOpen(masterKey.key, wrappedKey)
+}
+
+// DecryptSecret returns the plaintext of a stored value. Values that are not
+// encrypted are returned as is.
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	keyId, wrappedKey, payload, err := parseSecret(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := unwrapSecretKey(keyId, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := secretOpen(dataKey, payload)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// SecretLookupHash returns a deterministic HMAC of a secret, stored next to
+// the encrypted value so that it can still be searched for. The HMAC key is
+// derived from the current master key, lookups are recomputed when it
+// changes.
+func SecretLookupHash(value string) string {
+	if value == "" {
+		return ""
+	}
+	var key []byte
+	if secretCurrentKey != nil {
+		derived := sha256.Sum256(append([]byte("lookup:"), secretCurrentKey.key...))
+		key = derived[:]
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RotateSecret re-encrypts the data key of a stored value with the current
+// master key, encrypting plaintext values. It reports whether the value
+// changed.
+func RotateSecret(value string) (string, bool, error) {
+	if secretCurrentKey == nil {
+		return value, false, errors.New("SECRET_ENCRYPTION_KEY is not set")
+	}
+	if !IsEncryptedSecret(value) {
+		encrypted, err := EncryptSecret(value)
+		return encrypted, encrypted != value, err
+	}
+	keyId, wrappedKey, payload, err := parseSecret(value)
+	if err != nil {
+		return value, false, err
+	}
+	if keyId == secretCurrentKey.id {
+		return value, false, nil
+	}
+	dataKey, err := unwrapSecretKey(keyId, wrappedKey)
+	if err != nil {
+		return value, false, err
+	}
+	wrappedKey, err = secretSeal(secretCurrentKey.key, dataKey)
+	if err != nil {
+		return value, false, err
+	}
+	return formatSecret(secretCurrentKey.id, wrappedKey, payload), true, nil
+}
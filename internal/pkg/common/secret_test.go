@@ -0,0 +1,63 @@
+package common
+
+import "testing"
+
+// TestSecretRotation tests that secrets survive a master key rotation and that
+// only the data key is re-encrypted
+func TestSecretRotation(t *testing.T) {
+	t.Setenv("SECRET_ENCRYPTION_KEY", "old-master-key")
+	t.Setenv("SECRET_ENCRYPTION_OLD_KEYS", "")
+	if err := InitSecretEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		secretCurrentKey = nil
+		secretKeys = make(map[string]*secretMasterKey)
+	}()
+
+	stored, err := EncryptSecret("sk-upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedSecret(stored) {
+		t.Fatalf("stored = %q", stored)
+	}
+	if again, _ := EncryptSecret("sk-upstream"); again == stored {
+		t.Error("encrypting twice gave the same ciphertext")
+	}
+
+	t.Setenv("SECRET_ENCRYPTION_KEY", "new-master-key")
+	if err := InitSecretEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptSecret(stored); err == nil {
+		t.Fatal("decrypted without the old master key")
+	}
+	t.Setenv("SECRET_ENCRYPTION_OLD_KEYS", "old-master-key")
+	if err := InitSecretEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, changed, err := RotateSecret(stored)
+	if err != nil || !changed {
+		t.Fatalf("changed = %v, err = %v", changed, err)
+	}
+	_, _, payload, _ := parseSecret(stored)
+	_, _, rotatedPayload, _ := parseSecret(rotated)
+	if string(payload) != string(rotatedPayload) {
+		t.Error("rotation re-encrypted the value instead of its data key")
+	}
+	if _, changed, _ := RotateSecret(rotated); changed {
+		t.Error("rotated a secret already under the current key")
+	}
+
+	t.Setenv("SECRET_ENCRYPTION_OLD_KEYS", "")
+	if err := InitSecretEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := DecryptSecret(rotated); err != nil || plaintext != "sk-upstream" {
+		t.Errorf("plaintext = %q, err = %v", plaintext, err)
+	}
+	if plaintext, _ := DecryptSecret("sk-plain"); plaintext != "sk-plain" {
+		t.Errorf("plaintext value = %q", plaintext)
+	}
+}
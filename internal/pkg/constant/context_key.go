@@ -19,6 +19,9 @@ const (
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
 	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenResponseCache     ContextKey = "token_response_cache"
+	ContextKeyTokenHedgeDelay        ContextKey = "token_hedge_delay"
+	ContextKeyTokenContextCompaction ContextKey = "token_context_compaction"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -55,4 +58,20 @@ const (
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	ContextKeyResponseCacheHit    ContextKey = "response_cache_hit"
+	ContextKeyResponseCacheLookup ContextKey = "response_cache_lookup"
+
+	ContextKeyHedgeAttempt ContextKey = "hedge_attempt"
+
+	ContextKeyShadowAttempt ContextKey = "shadow_attempt"
+
+	ContextKeySessionAffinityKey ContextKey = "session_affinity_key"
+
+	ContextKeyModelAliasRoute ContextKey = "model_alias_route"
+
+	ContextKeyContextWindowDemand ContextKey = "context_window_demand"
+	ContextKeyContextCompaction   ContextKey = "context_compaction"
+
+	ContextKeyUpstreamCooldown ContextKey = "upstream_cooldown"
 )
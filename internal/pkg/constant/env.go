@@ -1,6 +1,7 @@
 package constant
 
 var StreamingTimeout int
+var StreamFirstTokenTimeout int
 var DifyDebug bool
 var MaxFileDownloadMB int
 var StreamScannerMaxBufferMB int
@@ -17,6 +18,14 @@ var NotificationLimitDurationMinute int
 var GenerateDefaultToken bool
 var ErrorLogEnabled bool
 var TaskQueryLimit int
+var MaxFileUploadMB int
+var BatchMaxRequests int
+var BatchConcurrency int
+var BatchMaxRunning int
+var ResponseStoreEnabled bool
+var ResponseStoreTTLHours int
+var ResponseStoreMaxKB int
+var CountTokensPassThroughEnabled bool
 
 // temporary variable for sora patch, will be removed in future
 var TaskPricePatches []string
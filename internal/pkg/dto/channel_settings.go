@@ -1,12 +1,59 @@
 package dto
 
 type ChannelSettings struct {
-	ForceFormat            bool   `json:"force_format,omitempty"`
-	ThinkingToContent      bool   `json:"thinking_to_content,omitempty"`
-	Proxy                  string `json:"proxy"`
-	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
-	SystemPrompt           string `json:"system_prompt,omitempty"`
-	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	ForceFormat            bool                          `json:"force_format,omitempty"`
+	ThinkingToContent      bool                          `json:"thinking_to_content,omitempty"`
+	Proxy                  string                        `json:"proxy"`
+	PassThroughBodyEnabled bool                          `json:"pass_through_body_enabled,omitempty"`
+	SystemPrompt           string                        `json:"system_prompt,omitempty"`
+	SystemPromptOverride   bool                          `json:"system_prompt_override,omitempty"`
+	FirstTokenTimeout      int                           `json:"first_token_timeout,omitempty"`   // 流式首个数据的超时秒数，超时且未向客户端输出时切换渠道重试
+	MaxConcurrency         int                           `json:"max_concurrency,omitempty"`       // 渠道最大并发请求数，0 表示不限制
+	KeyMaxConcurrency      int                           `json:"key_max_concurrency,omitempty"`   // 多Key渠道中每个Key的最大并发请求数，0 表示不限制
+	UpstreamCostRatio      float64                       `json:"upstream_cost_ratio,omitempty"`   // 上游成本倍率，上游成本 = 不含分组倍率的计费额度 × 该倍率
+	UpstreamModelPrices    map[string]UpstreamModelPrice `json:"upstream_model_prices,omitempty"` // 按模型设置的上游成本价格，模型为映射后的上游模型，优先于上游成本倍率
+	ModelDiscovery         string                        `json:"model_discovery,omitempty"`       // 上游模型变化时的处理方式，为空时跟随全局设置
+	RolloutMode            string                        `json:"rollout_mode,omitempty"`          // 灰度上线方式：canary 按比例分流，shadow 按比例镜像请求且不返回给用户；为空时正常参与渠道选择
+	RolloutPercent         float64                       `json:"rollout_percent,omitempty"`       // canary 分得的流量比例，或 shadow 镜像的请求比例，0-100
+	ModelContextLimits     map[string]ModelContextLimit  `json:"model_context_limits,omitempty"`  // 按模型覆盖模型元数据中的上下文窗口
+}
+
+// 渠道灰度上线方式
+const (
+	ChannelRolloutCanary = "canary"
+	ChannelRolloutShadow = "shadow"
+)
+
+// 上游模型发现的处理方式
+const (
+	ModelDiscoveryOff    = "off"    // 不检查
+	ModelDiscoveryNotify = "notify" // 通知管理员
+	ModelDiscoveryApply  = "apply"  // 自动更新渠道模型
+)
+
+// UpstreamModelPrice 上游对一个模型的收费，单位为美元
+type UpstreamModelPrice struct {
+	InputPrice   float64 `json:"input_price"`   // 每百万输入 token
+	OutputPrice  float64 `json:"output_price"`  // 每百万输出 token
+	RequestPrice float64 `json:"request_price"` // 每次请求
+}
+
+// ModelContextLimit 模型的上下文窗口，0 表示不限制
+type ModelContextLimit struct {
+	ContextLength   int `json:"context_length,omitempty"`    // 输入与输出 token 总数上限
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"` // 输出 token 上限
+}
+
+// Override returns the limit with the set fields of override taking the
+// place of its own.
+func (l ModelContextLimit) Override(override ModelContextLimit) ModelContextLimit {
+	if override.ContextLength > 0 {
+		l.ContextLength = override.ContextLength
+	}
+	if override.MaxOutputTokens > 0 {
+		l.MaxOutputTokens = override.MaxOutputTokens
+	}
+	return l
 }
 
 type VertexKeyType string
@@ -10,9 +10,10 @@ type Notify struct {
 const ContentValueParam = "{{value}}"
 
 const (
-	NotifyTypeQuotaExceed   = "quota_exceed"
-	NotifyTypeChannelUpdate = "channel_update"
-	NotifyTypeChannelTest   = "channel_test"
+	NotifyTypeQuotaExceed    = "quota_exceed"
+	NotifyTypeChannelUpdate  = "channel_update"
+	NotifyTypeChannelTest    = "channel_test"
+	NotifyTypeModelDiscovery = "model_discovery"
 )
 
 func NewNotify(t string, title string, content string, values []interface{}) Notify {
@@ -0,0 +1,178 @@
+package dto
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Inbound Ollama API types, see https://github.com/ollama/ollama/blob/main/docs/api.md
+
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        float64  `json:"top_p,omitempty"`
+	TopK        int      `json:"top_k,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+type OllamaToolCallFunction struct {
+	Index     int    `json:"index,omitempty"`
+	Name      string `json:"name"`
+	Arguments any    `json:"arguments"`
+}
+
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type OllamaChatRequest struct {
+	Model     string            `json:"model"`
+	Messages  []OllamaMessage   `json:"messages"`
+	Tools     []ToolCallRequest `json:"tools,omitempty"`
+	Format    json.RawMessage   `json:"format,omitempty"`
+	Options   *OllamaOptions    `json:"options,omitempty"`
+	Stream    *bool             `json:"stream,omitempty"`
+	Think     json.RawMessage   `json:"think,omitempty"`
+	KeepAlive any               `json:"keep_alive,omitempty"`
+}
+
+func ollamaTokenCountMeta(options *OllamaOptions, texts []string, images []string) *types.TokenCountMeta {
+	meta := &types.TokenCountMeta{
+		TokenType:   types.TokenTypeTokenizer,
+		CombineText: strings.Join(texts, "\n"),
+	}
+	if options != nil && options.NumPredict > 0 {
+		meta.MaxTokens = options.NumPredict
+	}
+	for _, image := range images {
+		meta.Files = append(meta.Files, &types.FileMeta{FileType: types.FileTypeImage, OriginData: image})
+	}
+	return meta
+}
+
+func (r *OllamaChatRequest) GetTokenCountMeta() *types.TokenCountMeta {
+	texts := make([]string, 0, len(r.Messages))
+	images := make([]string, 0)
+	for _, message := range r.Messages {
+		texts = append(texts, message.Content)
+		images = append(images, message.Images...)
+		for _, toolCall := range message.ToolCalls {
+			texts = append(texts, toolCall.Function.Name)
+		}
+	}
+	for _, tool := range r.Tools {
+		texts = append(texts, tool.Function.Name, tool.Function.Description)
+	}
+	meta := ollamaTokenCountMeta(r.Options, texts, images)
+	meta.MessagesCount = len(r.Messages)
+	meta.ToolsCount = len(r.Tools)
+	return meta
+}
+
+// IsStream follows Ollama, which streams unless told otherwise.
+func (r *OllamaChatRequest) IsStream(c *gin.Context) bool {
+	return r.Stream == nil || *r.Stream
+}
+
+func (r *OllamaChatRequest) SetModelName(modelName string) {
+	if modelName != "" {
+		r.Model = modelName
+	}
+}
+
+type OllamaGenerateRequest struct {
+	Model     string          `json:"model"`
+	Prompt    string          `json:"prompt"`
+	Suffix    string          `json:"suffix,omitempty"`
+	System    string          `json:"system,omitempty"`
+	Images    []string        `json:"images,omitempty"`
+	Format    json.RawMessage `json:"format,omitempty"`
+	Options   *OllamaOptions  `json:"options,omitempty"`
+	Stream    *bool           `json:"stream,omitempty"`
+	Think     json.RawMessage `json:"think,omitempty"`
+	Raw       bool            `json:"raw,omitempty"`
+	KeepAlive any             `json:"keep_alive,omitempty"`
+}
+
+func (r *OllamaGenerateRequest) GetTokenCountMeta() *types.TokenCountMeta {
+	meta := ollamaTokenCountMeta(r.Options, []string{r.System, r.Prompt}, r.Images)
+	meta.MessagesCount = 1
+	return meta
+}
+
+func (r *OllamaGenerateRequest) IsStream(c *gin.Context) bool {
+	return r.Stream == nil || *r.Stream
+}
+
+func (r *OllamaGenerateRequest) SetModelName(modelName string) {
+	if modelName != "" {
+		r.Model = modelName
+	}
+}
+
+// OllamaResponseStats closes every Ollama response. Durations are in
+// nanoseconds.
+type OllamaResponseStats struct {
+	Done               bool   `json:"done"`
+	DoneReason         string `json:"done_reason,omitempty"`
+	TotalDuration      int64  `json:"total_duration,omitempty"`
+	LoadDuration       int64  `json:"load_duration,omitempty"`
+	PromptEvalCount    int    `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64  `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int    `json:"eval_count,omitempty"`
+	EvalDuration       int64  `json:"eval_duration,omitempty"`
+}
+
+type OllamaChatResponse struct {
+	Model     string        `json:"model"`
+	CreatedAt string        `json:"created_at"`
+	Message   OllamaMessage `json:"message"`
+	OllamaResponseStats
+}
+
+type OllamaGenerateResponse struct {
+	Model     string `json:"model"`
+	CreatedAt string `json:"created_at"`
+	Response  string `json:"response"`
+	Thinking  string `json:"thinking,omitempty"`
+	OllamaResponseStats
+}
+
+type OllamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	TotalDuration   int64       `json:"total_duration,omitempty"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+}
+
+type OllamaModelDetails struct {
+	Format   string   `json:"format"`
+	Family   string   `json:"family"`
+	Families []string `json:"families"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaTagsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
@@ -0,0 +1,53 @@
+package model_setting
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+)
+
+// ModelAliasTarget 虚拟模型的一个目标模型
+type ModelAliasTarget struct {
+	Model string `json:"model"`           // 实际请求的模型，按该模型计费
+	Group string `json:"group,omitempty"` // 选择渠道使用的分组，留空则使用请求的分组
+}
+
+// ModelAlias 虚拟模型，请求依次使用各目标模型，前一个模型无可用渠道或请求失败时回退到下一个
+type ModelAlias struct {
+	Name    string             `json:"name"`
+	Targets []ModelAliasTarget `json:"targets"`
+}
+
+type ModelAliasSettings struct {
+	Aliases []ModelAlias `json:"aliases"`
+}
+
+// 默认配置
+var modelAliasSettings = ModelAliasSettings{
+	Aliases: []ModelAlias{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_alias", &modelAliasSettings)
+}
+
+func GetModelAliasSettings() *ModelAliasSettings {
+	return &modelAliasSettings
+}
+
+// GetModelAlias returns the virtual model named name, nil if there is none or
+// it has no target.
+func GetModelAlias(name string) *ModelAlias {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+	for i := range modelAliasSettings.Aliases {
+		alias := &modelAliasSettings.Aliases[i]
+		if alias.Name == name && len(alias.Targets) > 0 {
+			return alias
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package operation_setting
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+
+// ChannelConcurrencySetting 渠道并发上限配置，上限本身在渠道设置中配置
+type ChannelConcurrencySetting struct {
+	QueueSize           int `json:"queue_size"`            // 所有渠道并发已满时，每个分组和模型在单个节点上最多排队的请求数，0 表示不排队
+	QueueTimeoutSeconds int `json:"queue_timeout_seconds"` // 排队等待的最长秒数
+}
+
+// 默认配置
+var channelConcurrencySetting = ChannelConcurrencySetting{
+	QueueSize:           100,
+	QueueTimeoutSeconds: 30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_concurrency_setting", &channelConcurrencySetting)
+}
+
+func GetChannelConcurrencySetting() *ChannelConcurrencySetting {
+	return &channelConcurrencySetting
+}
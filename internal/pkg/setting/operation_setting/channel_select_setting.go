@@ -0,0 +1,57 @@
+package operation_setting
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+
+const (
+	ChannelSelectModeRandom   = "random"   // 按优先级和权重随机
+	ChannelSelectModeAdaptive = "adaptive" // 同一优先级内按实时延迟和错误率调整权重
+	ChannelSelectModeAffinity = "affinity" // 同一会话固定到同一渠道和密钥，提高上游提示词缓存命中率
+)
+
+// ChannelSelectSetting 渠道选择配置
+type ChannelSelectSetting struct {
+	GroupModes       map[string]string `json:"group_modes"`        // 分组 -> 选择模式，未配置的分组使用 random
+	EWMAAlpha        float64           `json:"ewma_alpha"`         // 统计新样本的权重，越大对近期变化越敏感
+	HalfLifeSeconds  int               `json:"half_life_seconds"`  // 渠道无新请求时，其统计每经过该时长向默认值恢复一半
+	MinWeightPercent float64           `json:"min_weight_percent"` // 不健康渠道最低保留的权重百分比，保证仍有少量流量用于恢复探测
+	AffinityHeader   string            `json:"affinity_header"`    // affinity 模式下客户端传递会话标识的请求头，未传递时使用请求中的 user 字段或消息前缀
+}
+
+// 默认配置
+var channelSelectSetting = ChannelSelectSetting{
+	GroupModes:       map[string]string{},
+	EWMAAlpha:        0.2,
+	HalfLifeSeconds:  300,
+	MinWeightPercent: 5,
+	AffinityHeader:   "X-Session-Id",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_select_setting", &channelSelectSetting)
+}
+
+func GetChannelSelectSetting() *ChannelSelectSetting {
+	return &channelSelectSetting
+}
+
+// IsAdaptive reports whether channels of group are selected by live health.
+func (s *ChannelSelectSetting) IsAdaptive(group string) bool {
+	return s.GroupModes[group] == ChannelSelectModeAdaptive
+}
+
+// IsAffinity reports whether requests of the same conversation in group stick
+// to the same channel and key.
+func (s *ChannelSelectSetting) IsAffinity(group string) bool {
+	return s.GroupModes[group] == ChannelSelectModeAffinity
+}
+
+// HasAffinity reports whether any group is in the affinity mode.
+func (s *ChannelSelectSetting) HasAffinity() bool {
+	for _, mode := range s.GroupModes {
+		if mode == ChannelSelectModeAffinity {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package operation_setting
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+)
+
+// 渠道测试断言
+const (
+	ChannelTestAssertUsage      = "usage"       // 返回了用量
+	ChannelTestAssertContent    = "content"     // 返回了非空的内容、向量或排序结果
+	ChannelTestAssertToolCall   = "tool_call"   // 返回了可解析的工具调用
+	ChannelTestAssertStreamDone = "stream_done" // 流式响应正常结束，如 OpenAI 格式的 [DONE]
+)
+
+// ChannelTestProfile 渠道测试方案：按端点类型构造测试请求，并检查响应是否满足断言
+type ChannelTestProfile struct {
+	Name          string   `json:"name"`
+	EndpointType  string   `json:"endpoint_type"` // openai、openai-response、anthropic、gemini、embeddings、jina-rerank
+	Stream        bool     `json:"stream"`
+	Tools         bool     `json:"tools"`  // 携带一个工具定义并要求模型调用
+	Vision        bool     `json:"vision"` // 携带一张图片
+	Prompt        string   `json:"prompt"` // 为空时使用默认提示词
+	MaxTokens     uint     `json:"max_tokens"`
+	Models        []string `json:"models"`         // 适用的模型名包含的关键字，为空时适用于所有模型
+	ExcludeModels []string `json:"exclude_models"` // 不适用的模型名包含的关键字，优先于 Models
+	Assertions    []string `json:"assertions"`
+}
+
+// Matches 判断测试方案是否适用于模型
+func (p *ChannelTestProfile) Matches(modelName string) bool {
+	modelName = strings.ToLower(modelName)
+	if containsModelKeyword(modelName, p.ExcludeModels) {
+		return false
+	}
+	return len(p.Models) == 0 || containsModelKeyword(modelName, p.Models)
+}
+
+func containsModelKeyword(modelName string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if keyword != "" && strings.Contains(modelName, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChannelTestSetting 渠道测试配置
+type ChannelTestSetting struct {
+	Profiles    []ChannelTestProfile `json:"profiles"`
+	HistoryDays int                  `json:"history_days"` // 测试结果保留天数
+}
+
+var embeddingModelKeywords = []string{"embed", "bge-", "m3e"}
+
+// 不支持对话的模型，对话类测试方案跳过它们，以免自动禁用
+var nonChatModelKeywords = append([]string{"rerank", "moderation", "whisper", "tts", "dall-e", "gpt-image", "flux", "midjourney", "sora", "veo", "kling", "suno"}, embeddingModelKeywords...)
+
+// 支持工具调用、图片输入和 Responses 接口的模型系列
+var (
+	toolCallModelKeywords  = []string{"gpt-4", "gpt-5", "o1", "o3", "o4-", "claude", "gemini", "grok", "qwen", "deepseek", "glm-4", "kimi", "moonshot", "mistral"}
+	visionModelKeywords    = []string{"gpt-4o", "gpt-4.1", "gpt-5", "claude-3", "claude-sonnet", "claude-opus", "claude-haiku", "gemini", "vision", "-vl", "4v"}
+	responsesModelKeywords = []string{"gpt-4o", "gpt-4.1", "gpt-5", "o1", "o3", "o4-", "codex"}
+)
+
+// 默认配置
+var channelTestSetting = ChannelTestSetting{
+	Profiles: []ChannelTestProfile{
+		{Name: "chat", EndpointType: "openai", ExcludeModels: nonChatModelKeywords, Assertions: []string{ChannelTestAssertUsage, ChannelTestAssertContent}},
+		{Name: "stream", EndpointType: "openai", Stream: true, ExcludeModels: nonChatModelKeywords, Assertions: []string{ChannelTestAssertUsage, ChannelTestAssertStreamDone}},
+		{Name: "tool_call", EndpointType: "openai", Tools: true, MaxTokens: 256, Models: toolCallModelKeywords, ExcludeModels: nonChatModelKeywords, Assertions: []string{ChannelTestAssertToolCall}},
+		{Name: "vision", EndpointType: "openai", Vision: true, MaxTokens: 64, Models: visionModelKeywords, ExcludeModels: nonChatModelKeywords, Assertions: []string{ChannelTestAssertUsage, ChannelTestAssertContent}},
+		{Name: "responses", EndpointType: "openai-response", Models: responsesModelKeywords, ExcludeModels: nonChatModelKeywords, Assertions: []string{ChannelTestAssertUsage, ChannelTestAssertContent}},
+		{Name: "claude", EndpointType: "anthropic", Models: []string{"claude"}, Assertions: []string{ChannelTestAssertUsage, ChannelTestAssertContent}},
+		{Name: "claude_stream", EndpointType: "anthropic", Stream: true, Models: []string{"claude"}, Assertions: []string{ChannelTestAssertUsage, ChannelTestAssertStreamDone}},
+		{Name: "embeddings", EndpointType: "embeddings", Models: embeddingModelKeywords, Assertions: []string{ChannelTestAssertUsage, ChannelTestAssertContent}},
+		{Name: "rerank", EndpointType: "jina-rerank", Models: []string{"rerank"}, Assertions: []string{ChannelTestAssertContent}},
+	},
+	HistoryDays: 7,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_test_setting", &channelTestSetting)
+}
+
+func GetChannelTestSetting() *ChannelTestSetting {
+	return &channelTestSetting
+}
+
+// GetChannelTestProfile 按名称查找测试方案
+func GetChannelTestProfile(name string) (*ChannelTestProfile, bool) {
+	for i := range channelTestSetting.Profiles {
+		if channelTestSetting.Profiles[i].Name == name {
+			return &channelTestSetting.Profiles[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,30 @@
+package operation_setting
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+
+// CircuitBreakerSetting 渠道熔断配置，对渠道整体和多Key渠道的每个Key分别生效
+type CircuitBreakerSetting struct {
+	Enabled              bool `json:"enabled"`                // 总开关
+	FailureThreshold     int  `json:"failure_threshold"`      // 连续失败（含 429）多少次后熔断
+	CooldownSeconds      int  `json:"cooldown_seconds"`       // 熔断多久后进入半开状态
+	ProbeIntervalSeconds int  `json:"probe_interval_seconds"` // 半开状态下每隔多少秒放行一个真实请求
+	RecoverySuccesses    int  `json:"recovery_successes"`     // 半开状态下成功多少次后恢复
+}
+
+// 默认配置
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:              false,
+	FailureThreshold:     5,
+	CooldownSeconds:      60,
+	ProbeIntervalSeconds: 10,
+	RecoverySuccesses:    2,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("circuit_breaker_setting", &circuitBreakerSetting)
+}
+
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
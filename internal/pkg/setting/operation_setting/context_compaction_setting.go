@@ -0,0 +1,47 @@
+package operation_setting
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+
+// 令牌的上下文压缩方式，请求的预估 token 超出模型上下文窗口时在转发前应用
+const (
+	ContextCompactionDropOldest    = "drop_oldest"           // 丢弃最早的对话轮次
+	ContextCompactionTruncateTools = "truncate_tool_outputs" // 从最早的开始截断过长的工具输出
+	ContextCompactionSummarize     = "summarize"             // 用摘要模型总结最早的对话轮次
+)
+
+// IsValidContextCompaction reports whether mode is a compaction mode, the empty
+// mode turns compaction off.
+func IsValidContextCompaction(mode string) bool {
+	switch mode {
+	case "", ContextCompactionDropOldest, ContextCompactionTruncateTools, ContextCompactionSummarize:
+		return true
+	}
+	return false
+}
+
+// ContextCompactionSetting 上下文压缩配置，由令牌选择压缩方式
+type ContextCompactionSetting struct {
+	TargetRatio         float64 `json:"target_ratio"`           // 压缩后的提示 token 不超过上下文窗口可用部分的该比例，为 token 预估误差留出余量
+	ToolOutputMaxTokens int     `json:"tool_output_max_tokens"` // 工具输出截断后保留的 token 数
+	SummaryModel        string  `json:"summary_model"`          // 生成摘要使用的模型，摘要作为令牌的一次独立请求转发、计费并记录日志
+	SummaryMaxTokens    int     `json:"summary_max_tokens"`     // 摘要的最大输出 token 数
+	SummaryInputTokens  int     `json:"summary_input_tokens"`   // 交给摘要模型的对话最多的 token 数，超出部分丢弃最早的
+}
+
+// 默认配置
+var contextCompactionSetting = ContextCompactionSetting{
+	TargetRatio:         0.9,
+	ToolOutputMaxTokens: 2000,
+	SummaryModel:        "gpt-4o-mini",
+	SummaryMaxTokens:    1024,
+	SummaryInputTokens:  64000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("context_compaction_setting", &contextCompactionSetting)
+}
+
+func GetContextCompactionSetting() *ContextCompactionSetting {
+	return &contextCompactionSetting
+}
@@ -0,0 +1,24 @@
+package operation_setting
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+
+// HedgingSetting 对冲请求配置：首字迟迟未到时向另一渠道发起相同请求，先返回者胜出
+type HedgingSetting struct {
+	Enabled     bool           `json:"enabled"`      // 总开关
+	GroupDelays map[string]int `json:"group_delays"` // 分组 -> 等待首字的毫秒数，令牌配置优先
+}
+
+// 默认配置
+var hedgingSetting = HedgingSetting{
+	Enabled:     false,
+	GroupDelays: map[string]int{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedging_setting", &hedgingSetting)
+}
+
+func GetHedgingSetting() *HedgingSetting {
+	return &hedgingSetting
+}
@@ -8,14 +8,24 @@ import (
 )
 
 type MonitorSetting struct {
-	AutoTestChannelEnabled bool    `json:"auto_test_channel_enabled"`
-	AutoTestChannelMinutes float64 `json:"auto_test_channel_minutes"`
+	AutoTestChannelEnabled bool     `json:"auto_test_channel_enabled"`
+	AutoTestChannelMinutes float64  `json:"auto_test_channel_minutes"`
+	AutoTestProfiles       []string `json:"auto_test_profiles"`      // 自动测试运行的测试方案，对渠道的每个适用模型执行；为空时只用测试模型发送一次基础请求
+	AutoTestDisableModel   bool     `json:"auto_test_disable_model"` // 测试方案失败时只禁用失败的模型，而不是整个渠道
+	ModelDiscoveryEnabled  bool     `json:"model_discovery_enabled"` // 定时拉取各渠道的上游模型列表并与渠道模型对比
+	ModelDiscoveryMinutes  float64  `json:"model_discovery_minutes"`
+	ModelDiscoveryPolicy   string   `json:"model_discovery_policy"` // 渠道未单独设置时的处理方式：notify 通知管理员，apply 自动更新渠道模型
 }
 
 // 默认配置
 var monitorSetting = MonitorSetting{
 	AutoTestChannelEnabled: false,
 	AutoTestChannelMinutes: 10,
+	AutoTestProfiles:       []string{},
+	AutoTestDisableModel:   false,
+	ModelDiscoveryEnabled:  false,
+	ModelDiscoveryMinutes:  720,
+	ModelDiscoveryPolicy:   "notify",
 }
 
 func init() {
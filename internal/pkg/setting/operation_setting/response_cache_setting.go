@@ -0,0 +1,36 @@
+package operation_setting
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+
+// ResponseCacheSetting 响应缓存配置，按令牌或分组开启
+type ResponseCacheSetting struct {
+	Enabled           bool     `json:"enabled"`            // 总开关
+	Groups            []string `json:"groups"`             // 对这些分组下的所有令牌开启
+	TTLSeconds        int      `json:"ttl_seconds"`        // 缓存有效期
+	HitRatio          float64  `json:"hit_ratio"`          // 命中时按原用量乘以该倍率计费
+	MaxBodyKB         int      `json:"max_body_kb"`        // 超过该大小的响应不缓存
+	SemanticEnabled   bool     `json:"semantic_enabled"`   // 是否启用语义匹配（仅对话）
+	SemanticModel     string   `json:"semantic_model"`     // 语义匹配使用的 embedding 模型，向量化作为令牌的一次独立请求转发、计费并记录日志
+	SemanticThreshold float64  `json:"semantic_threshold"` // 语义匹配的最低余弦相似度
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:           false,
+	Groups:            []string{},
+	TTLSeconds:        3600,
+	HitRatio:          0.1,
+	MaxBodyKB:         1024,
+	SemanticEnabled:   false,
+	SemanticModel:     "text-embedding-3-small",
+	SemanticThreshold: 0.95,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache_setting", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
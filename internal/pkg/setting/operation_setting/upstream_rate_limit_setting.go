@@ -0,0 +1,26 @@
+package operation_setting
+
+import "github.com/QuantumNous/lurus-api/internal/pkg/setting/config"
+
+// UpstreamRateLimitSetting 上游限流响应头配置，按渠道和多Key渠道的每个Key记录剩余额度
+type UpstreamRateLimitSetting struct {
+	Enabled             bool `json:"enabled"`               // 总开关
+	MinRemainingPercent int  `json:"min_remaining_percent"` // 剩余请求数或 token 数低于上限的该百分比时，在额度恢复前跳过该Key
+	MaxCooldownSeconds  int  `json:"max_cooldown_seconds"`  // 429 后按上游给出的时间冷却，最长不超过该秒数
+}
+
+// 默认配置
+var upstreamRateLimitSetting = UpstreamRateLimitSetting{
+	Enabled:             true,
+	MinRemainingPercent: 5,
+	MaxCooldownSeconds:  3600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("upstream_rate_limit_setting", &upstreamRateLimitSetting)
+}
+
+func GetUpstreamRateLimitSetting() *UpstreamRateLimitSetting {
+	return &upstreamRateLimitSetting
+}
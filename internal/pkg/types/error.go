@@ -49,6 +49,8 @@ const (
 	ErrorCodeDoRequestFailed    ErrorCode = "do_request_failed"
 	ErrorCodeGetChannelFailed   ErrorCode = "get_channel_failed"
 	ErrorCodeGenRelayInfoFailed ErrorCode = "gen_relay_info_failed"
+	ErrorCodeChannelSaturated   ErrorCode = "channel_saturated"
+	ErrorCodeContextTooLong     ErrorCode = "context_length_exceeded"
 
 	// channel error
 	ErrorCodeChannelNoAvailableKey        ErrorCode = "channel:no_available_key"
@@ -76,6 +78,7 @@ const (
 	ErrorCodeAwsInvokeError         ErrorCode = "aws_invoke_error"
 	ErrorCodeModelNotFound          ErrorCode = "model_not_found"
 	ErrorCodePromptBlocked          ErrorCode = "prompt_blocked"
+	ErrorCodeStreamFirstTokenFailed ErrorCode = "stream_first_token_failed"
 
 	// sql error
 	ErrorCodeQueryDataError  ErrorCode = "query_data_error"
@@ -0,0 +1,690 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+	"github.com/QuantumNous/lurus-api/internal/server/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const batchCompletionWindow = "24h"
+
+// batchEndpointFormats lists the endpoints a batch may target and the relay
+// format each line is dispatched with.
+var batchEndpointFormats = map[string]types.RelayFormat{
+	"/v1/chat/completions": types.RelayFormatOpenAI,
+	"/v1/completions":      types.RelayFormatOpenAI,
+	"/v1/embeddings":       types.RelayFormatEmbedding,
+	"/v1/responses":        types.RelayFormatOpenAIResponses,
+	"/v1/moderations":      types.RelayFormatOpenAI,
+}
+
+// CreateBatch handles POST /v1/batches
+func CreateBatch(c *gin.Context) {
+	var req dto.OpenAIBatchRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "invalid request body: "+err.Error())
+		return
+	}
+	if _, ok := batchEndpointFormats[req.Endpoint]; !ok {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("unsupported endpoint: %s", req.Endpoint))
+		return
+	}
+	if req.CompletionWindow != batchCompletionWindow {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "completion_window must be 24h")
+		return
+	}
+	userId := c.GetInt("id")
+	file, err := model.GetUserFileByFileId(userId, req.InputFileID)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	if file == nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("No such File object: %s", req.InputFileID))
+		return
+	}
+	if file.Purpose != dto.FilePurposeBatch {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "input file must be uploaded with purpose batch")
+		return
+	}
+
+	now := common.GetTimestamp()
+	batch := &model.BatchJob{
+		BatchId:          service.NewBatchId(),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		ClientIp:         c.ClientIP(),
+		Endpoint:         req.Endpoint,
+		InputFileId:      req.InputFileID,
+		CompletionWindow: req.CompletionWindow,
+		Status:           dto.BatchStatusValidating,
+		CreatedAt:        now,
+		ExpiresAt:        now + int64((24 * time.Hour).Seconds()),
+	}
+	if len(req.Metadata) > 0 {
+		metadata, _ := common.Marshal(req.Metadata)
+		batch.Metadata = string(metadata)
+	}
+	if err = batch.Insert(); err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+// ListBatches handles GET /v1/batches
+func ListBatches(c *gin.Context) {
+	userId := c.GetInt("id")
+	limit := parseListLimit(c, 20, 100)
+	afterId := 0
+	if after := c.Query("after"); after != "" {
+		afterBatch, err := model.GetBatchJobByBatchId(userId, after)
+		if err != nil {
+			openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		if afterBatch != nil {
+			afterId = afterBatch.Id
+		}
+	}
+	batches, err := model.GetUserBatchJobs(userId, afterId, limit+1)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	resp := dto.OpenAIList[*dto.OpenAIBatch]{
+		Object:  "list",
+		Data:    make([]*dto.OpenAIBatch, 0, len(batches)),
+		HasMore: len(batches) > limit,
+	}
+	if resp.HasMore {
+		batches = batches[:limit]
+	}
+	for _, batch := range batches {
+		resp.Data = append(resp.Data, batch.ToOpenAIBatch())
+	}
+	if len(resp.Data) > 0 {
+		resp.FirstID = resp.Data[0].ID
+		resp.LastID = resp.Data[len(resp.Data)-1].ID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func getUserBatchOrAbort(c *gin.Context) *model.BatchJob {
+	batch, err := model.GetBatchJobByBatchId(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return nil
+	}
+	if batch == nil {
+		openAIErrorResponse(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("No such Batch object: %s", c.Param("id")))
+		return nil
+	}
+	return batch
+}
+
+// RetrieveBatch handles GET /v1/batches/:id
+func RetrieveBatch(c *gin.Context) {
+	batch := getUserBatchOrAbort(c)
+	if batch == nil {
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+// CancelBatch handles POST /v1/batches/:id/cancel
+func CancelBatch(c *gin.Context) {
+	batch := getUserBatchOrAbort(c)
+	if batch == nil {
+		return
+	}
+	ok, err := model.UpdateBatchJobStatusIf(batch.Id,
+		[]string{dto.BatchStatusValidating, dto.BatchStatusInProgress},
+		dto.BatchStatusCancelling,
+		map[string]any{"cancelling_at": common.GetTimestamp()})
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	if !ok {
+		openAIErrorResponse(c, http.StatusConflict, "invalid_request_error",
+			fmt.Sprintf("Cannot cancel a batch with status %s", batch.Status))
+		return
+	}
+	batch = getUserBatchOrAbort(c)
+	if batch == nil {
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+var (
+	batchRelayEngine     *gin.Engine
+	batchRelayEngineOnce sync.Once
+)
+
+// getBatchRelayEngine builds a private engine carrying the same auth and
+// distribution middleware as /v1, so every batch line goes through channel
+// selection, retries and billing exactly like a live request.
+func getBatchRelayEngine() *gin.Engine {
+	batchRelayEngineOnce.Do(func() {
+		engine := gin.New()
+		engine.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
+			common.SysError(fmt.Sprintf("batch relay panic detected: %v", err))
+			openAIErrorResponse(c, http.StatusInternalServerError, "new_api_panic", fmt.Sprintf("Panic detected, error: %v", err))
+		}))
+		engine.Use(middleware.RequestId())
+		group := engine.Group("", middleware.TokenAuth(), middleware.Distribute())
+		for endpoint, format := range batchEndpointFormats {
+			relayFormat := format
+			group.POST(endpoint, func(c *gin.Context) {
+				Relay(c, relayFormat)
+			})
+		}
+		batchRelayEngine = engine
+	})
+	return batchRelayEngine
+}
+
+// batches are driven under a lease that is renewed while the worker runs, a
+// node that dies gives its batches up to the others when the lease expires
+const batchLeaseSeconds = 120
+
+var (
+	batchWorkerId      = newBatchWorkerId()
+	runningBatchJobs   sync.Map
+	runningBatchJobNum atomic.Int64
+)
+
+func newBatchWorkerId() string {
+	hostname, _ := os.Hostname()
+	if len(hostname) > 40 {
+		hostname = hostname[:40]
+	}
+	return hostname + "-" + common.GetRandomString(12)
+}
+
+// RunBatchJobsWithContext drives unfinished batches until ctx is cancelled.
+// Every node runs it; each batch runs in its own goroutine on the node that
+// holds its lease.
+func RunBatchJobsWithContext(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			common.SysLog("batch worker stopped")
+			return
+		case <-ticker.C:
+			maxRunning := int64(constant.BatchMaxRunning)
+			if maxRunning <= 0 {
+				maxRunning = 1
+			}
+			batches, err := model.GetUnfinishedBatchJobs(batchWorkerId, int(maxRunning))
+			if err != nil {
+				common.SysError("failed to load batch jobs: " + err.Error())
+				continue
+			}
+			for _, batch := range batches {
+				if runningBatchJobNum.Load() >= maxRunning {
+					break
+				}
+				if _, running := runningBatchJobs.LoadOrStore(batch.Id, true); running {
+					continue
+				}
+				ok, err := model.AcquireBatchJobLease(batch.Id, batchWorkerId, batchLeaseSeconds)
+				if err != nil || !ok {
+					runningBatchJobs.Delete(batch.Id)
+					continue
+				}
+				runningBatchJobNum.Add(1)
+				go func(batch *model.BatchJob) {
+					defer func() {
+						if err := model.ReleaseBatchJobLease(batch.Id, batchWorkerId); err != nil {
+							common.SysError(fmt.Sprintf("failed to release batch %s: %s", batch.BatchId, err.Error()))
+						}
+						runningBatchJobs.Delete(batch.Id)
+						runningBatchJobNum.Add(-1)
+					}()
+					driveBatchJob(ctx, batch)
+				}(batch)
+			}
+		}
+	}
+}
+
+// driveBatchJob moves a batch through its statuses while the lease is held.
+func driveBatchJob(ctx context.Context, batch *model.BatchJob) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go renewBatchJobLease(ctx, cancel, batch)
+
+	for ctx.Err() == nil && !batch.IsFinished() {
+		processBatchJob(ctx, batch)
+		next, err := model.GetBatchJobById(batch.Id)
+		if err != nil || next.Status == batch.Status {
+			// no progress, the next tick retries
+			return
+		}
+		batch = next
+	}
+}
+
+// renewBatchJobLease keeps the lease of a running batch and stops the batch
+// when another node took it over.
+func renewBatchJobLease(ctx context.Context, cancel context.CancelFunc, batch *model.BatchJob) {
+	ticker := time.NewTicker(batchLeaseSeconds / 4 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := model.AcquireBatchJobLease(batch.Id, batchWorkerId, batchLeaseSeconds)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to renew the lease of batch %s: %s", batch.BatchId, err.Error()))
+				continue
+			}
+			if !ok {
+				common.SysLog(fmt.Sprintf("lost the lease of batch %s", batch.BatchId))
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func processBatchJob(ctx context.Context, batch *model.BatchJob) {
+	switch batch.Status {
+	case dto.BatchStatusValidating:
+		validateBatchJob(batch)
+	case dto.BatchStatusInProgress, dto.BatchStatusCancelling:
+		runBatchJob(ctx, batch)
+	case dto.BatchStatusFinalizing:
+		finalizeBatchJob(batch, dto.BatchStatusCompleted)
+	}
+}
+
+func newBatchLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), constant.MaxRequestBodyMB<<20)
+	return scanner
+}
+
+func failBatchJob(batch *model.BatchJob, errs []dto.OpenAIBatchError) {
+	batch.SetErrors(errs)
+	_, err := model.UpdateBatchJobStatusIf(batch.Id,
+		[]string{dto.BatchStatusValidating, dto.BatchStatusInProgress},
+		dto.BatchStatusFailed,
+		map[string]any{"failed_at": common.GetTimestamp(), "errors": batch.Errors})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to mark batch %s as failed: %s", batch.BatchId, err.Error()))
+	}
+}
+
+func validateBatchJob(batch *model.BatchJob) {
+	var errs []dto.OpenAIBatchError
+	customIds := make(map[string]struct{})
+	total := 0
+	lineNo := 0
+	scanner := newBatchLineScanner(service.OpenStoredFile(batch.InputFileId))
+	for scanner.Scan() {
+		lineNo++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		total++
+		var line dto.BatchRequestLine
+		if err := common.Unmarshal(raw, &line); err != nil {
+			errs = append(errs, dto.OpenAIBatchError{Code: "invalid_json_line", Message: "line is not valid JSON", Line: lineNo})
+		} else if line.CustomID == "" {
+			errs = append(errs, dto.OpenAIBatchError{Code: "missing_required_parameter", Message: "custom_id is required", Param: "custom_id", Line: lineNo})
+		} else if len(line.CustomID) > 255 {
+			errs = append(errs, dto.OpenAIBatchError{Code: "invalid_value", Message: "custom_id must be at most 255 characters", Param: "custom_id", Line: lineNo})
+		} else if _, dup := customIds[line.CustomID]; dup {
+			errs = append(errs, dto.OpenAIBatchError{Code: "duplicate_custom_id", Message: "custom_id must be unique within a batch", Param: "custom_id", Line: lineNo})
+		} else if line.Method != http.MethodPost {
+			errs = append(errs, dto.OpenAIBatchError{Code: "invalid_method", Message: "only POST is supported", Param: "method", Line: lineNo})
+		} else if line.URL != batch.Endpoint {
+			errs = append(errs, dto.OpenAIBatchError{Code: "mismatched_url", Message: "url must match the batch endpoint " + batch.Endpoint, Param: "url", Line: lineNo})
+		} else if len(line.Body) == 0 {
+			errs = append(errs, dto.OpenAIBatchError{Code: "missing_required_parameter", Message: "body is required", Param: "body", Line: lineNo})
+		}
+		if line.CustomID != "" {
+			customIds[line.CustomID] = struct{}{}
+		}
+		if len(errs) >= 100 {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, dto.OpenAIBatchError{Code: "invalid_file", Message: err.Error(), Line: lineNo + 1})
+	}
+	if total == 0 && len(errs) == 0 {
+		errs = append(errs, dto.OpenAIBatchError{Code: "empty_file", Message: "input file contains no requests"})
+	}
+	if total > constant.BatchMaxRequests {
+		errs = append(errs, dto.OpenAIBatchError{Code: "too_many_requests",
+			Message: fmt.Sprintf("a batch may contain at most %d requests", constant.BatchMaxRequests)})
+	}
+	if len(errs) > 0 {
+		failBatchJob(batch, errs)
+		return
+	}
+
+	_, err := model.UpdateBatchJobStatusIf(batch.Id,
+		[]string{dto.BatchStatusValidating},
+		dto.BatchStatusInProgress,
+		map[string]any{
+			"in_progress_at": common.GetTimestamp(),
+			"request_total":  total,
+			"output_file_id": service.NewFileId(),
+			"error_file_id":  service.NewFileId(),
+		})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to start batch %s: %s", batch.BatchId, err.Error()))
+	}
+}
+
+type batchPendingLine struct {
+	claim  *model.BatchResult
+	line   dto.BatchRequestLine
+	result dto.BatchResponseLine
+}
+
+// failInterruptedBatchLines fails the lines a worker claimed but never
+// finished, they may have been relayed and billed and are not retried.
+func failInterruptedBatchLines(batch *model.BatchJob) {
+	pending, err := model.GetPendingBatchResults(batch.Id)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to load pending lines of batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	for _, claim := range pending {
+		data, _ := common.Marshal(dto.BatchResponseLine{
+			ID:       "batch_req_" + common.GetRandomString(24),
+			CustomID: claim.CustomId,
+			Error:    &dto.OpenAIBatchError{Code: "interrupted", Message: "the batch worker stopped while the request was in flight"},
+		})
+		if err := model.CompleteBatchResult(claim.Id, model.BatchResultFailed, append(data, '\n')); err != nil {
+			common.SysError(fmt.Sprintf("failed to fail line %s of batch %s: %s", claim.CustomId, batch.BatchId, err.Error()))
+		}
+	}
+}
+
+func runBatchJob(ctx context.Context, batch *model.BatchJob) {
+	if batch.Status == dto.BatchStatusCancelling {
+		finalizeBatchJob(batch, dto.BatchStatusCancelled)
+		return
+	}
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil || token.UserId != batch.UserId {
+		failBatchJob(batch, []dto.OpenAIBatchError{{Code: "token_unavailable", Message: "the token that created this batch no longer exists"}})
+		return
+	}
+	failInterruptedBatchLines(batch)
+	// lines claimed before, by this node or another one, are skipped
+	claimed, err := model.GetBatchResultCustomIds(batch.Id)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to load the progress of batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+
+	concurrency := constant.BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	scanner := newBatchLineScanner(service.OpenStoredFile(batch.InputFileId))
+	pending := make([]*batchPendingLine, 0, concurrency)
+
+	flush := func() bool {
+		var wg sync.WaitGroup
+		for _, p := range pending {
+			ok, err := model.ClaimBatchResult(p.claim)
+			if err != nil || !ok {
+				if err != nil {
+					common.SysError(fmt.Sprintf("failed to claim line %s of batch %s: %s", p.line.CustomID, batch.BatchId, err.Error()))
+				}
+				p.claim = nil
+				continue
+			}
+			if p.result.Error != nil {
+				continue
+			}
+			wg.Add(1)
+			go func(p *batchPendingLine) {
+				defer wg.Done()
+				p.result = executeBatchLine(ctx, batch, token, p.line)
+			}(p)
+		}
+		wg.Wait()
+		for _, p := range pending {
+			if p.claim == nil {
+				continue
+			}
+			data, _ := common.Marshal(p.result)
+			status := model.BatchResultFailed
+			if p.result.Error == nil && p.result.Response != nil &&
+				p.result.Response.StatusCode >= http.StatusOK && p.result.Response.StatusCode < http.StatusMultipleChoices {
+				status = model.BatchResultCompleted
+			}
+			if err := model.CompleteBatchResult(p.claim.Id, status, append(data, '\n')); err != nil {
+				common.SysError(fmt.Sprintf("failed to store line %s of batch %s: %s", p.line.CustomID, batch.BatchId, err.Error()))
+			}
+		}
+		pending = pending[:0]
+		updateBatchJobProgress(batch)
+		status, err := model.GetBatchJobStatus(batch.Id)
+		if err == nil && status == dto.BatchStatusCancelling {
+			finalizeBatchJob(batch, dto.BatchStatusCancelled)
+			return false
+		}
+		if common.GetTimestamp() > batch.ExpiresAt {
+			finalizeBatchJob(batch, dto.BatchStatusExpired)
+			return false
+		}
+		return ctx.Err() == nil
+	}
+
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		p := &batchPendingLine{}
+		if err := common.Unmarshal(raw, &p.line); err != nil {
+			p.result = dto.BatchResponseLine{
+				ID:       "batch_req_" + common.GetRandomString(24),
+				CustomID: p.line.CustomID,
+				Error:    &dto.OpenAIBatchError{Code: "invalid_json_line", Message: err.Error()},
+			}
+		}
+		customId := p.line.CustomID
+		if customId == "" {
+			customId = fmt.Sprintf("line-%d", lineNo)
+		}
+		if claimed[customId] {
+			continue
+		}
+		p.claim = &model.BatchResult{BatchJobId: batch.Id, CustomId: customId, Line: lineNo}
+		pending = append(pending, p)
+		if len(pending) >= concurrency {
+			if !flush() {
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		common.SysError(fmt.Sprintf("failed to read the input of batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	if len(pending) > 0 && !flush() {
+		return
+	}
+	ok, err := model.UpdateBatchJobStatusIf(batch.Id,
+		[]string{dto.BatchStatusInProgress},
+		dto.BatchStatusFinalizing,
+		map[string]any{"finalizing_at": common.GetTimestamp()})
+	if err != nil || !ok {
+		return
+	}
+	finalizeBatchJob(batch, dto.BatchStatusCompleted)
+}
+
+// updateBatchJobProgress recounts the finished lines of a batch from its
+// results, so the counts stay right whoever stored them.
+func updateBatchJobProgress(batch *model.BatchJob) {
+	completed, failed, err := model.CountBatchResults(batch.Id)
+	if err == nil {
+		batch.RequestCompleted, batch.RequestFailed = completed, failed
+		err = model.DB.Model(&model.BatchJob{}).Where("id = ?", batch.Id).Updates(map[string]any{
+			"request_completed": completed,
+			"request_failed":    failed,
+		}).Error
+	}
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s progress: %s", batch.BatchId, err.Error()))
+	}
+}
+
+// executeBatchLine relays a single input line and converts the outcome into
+// an output file line.
+func executeBatchLine(ctx context.Context, batch *model.BatchJob, token *model.Token, line dto.BatchRequestLine) dto.BatchResponseLine {
+	result := dto.BatchResponseLine{
+		ID:       "batch_req_" + common.GetRandomString(24),
+		CustomID: line.CustomID,
+	}
+	var streamCheck struct {
+		Stream bool `json:"stream"`
+	}
+	if err := common.Unmarshal(line.Body, &streamCheck); err != nil {
+		result.Error = &dto.OpenAIBatchError{Code: "invalid_request", Message: "body must be a JSON object"}
+		return result
+	}
+	if streamCheck.Stream {
+		result.Error = &dto.OpenAIBatchError{Code: "invalid_request", Message: "stream is not supported in batch requests", Param: "stream"}
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, line.URL, bytes.NewReader(line.Body))
+	if err != nil {
+		result.Error = &dto.OpenAIBatchError{Code: "invalid_request", Message: err.Error()}
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer sk-"+token.Key)
+	if batch.ClientIp != "" {
+		// keep token IP restrictions meaningful for batch traffic
+		req.RemoteAddr = net.JoinHostPort(batch.ClientIp, "0")
+	}
+	w := httptest.NewRecorder()
+	getBatchRelayEngine().ServeHTTP(w, req)
+
+	body := w.Body.Bytes()
+	if !json.Valid(body) {
+		body, _ = common.Marshal(string(body))
+	}
+	result.Response = &dto.BatchResponseBody{
+		StatusCode: w.Code,
+		RequestID:  w.Header().Get(common.RequestIdKey),
+		Body:       body,
+	}
+	return result
+}
+
+// finalizeBatchJob builds the result files and moves the batch into its
+// terminal status.
+func finalizeBatchJob(batch *model.BatchJob, status string) {
+	failInterruptedBatchLines(batch)
+	updateBatchJobProgress(batch)
+	now := common.GetTimestamp()
+	if err := errors.Join(
+		registerBatchResultFile(batch, batch.OutputFileId, model.BatchResultCompleted, batch.RequestCompleted, now),
+		registerBatchResultFile(batch, batch.ErrorFileId, model.BatchResultFailed, batch.RequestFailed, now),
+	); err != nil {
+		// the next run finalizes again
+		common.SysError(fmt.Sprintf("failed to write the results of batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+
+	fields := map[string]any{}
+	switch status {
+	case dto.BatchStatusCompleted:
+		fields["completed_at"] = now
+	case dto.BatchStatusCancelled:
+		fields["cancelled_at"] = now
+	case dto.BatchStatusExpired:
+		fields["expired_at"] = now
+	}
+	ok, err := model.UpdateBatchJobStatusIf(batch.Id,
+		[]string{dto.BatchStatusInProgress, dto.BatchStatusFinalizing, dto.BatchStatusCancelling},
+		status, fields)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to finalize batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	if ok {
+		if err := model.DeleteBatchResults(batch.Id); err != nil {
+			common.SysError(fmt.Sprintf("failed to delete the results of batch %s: %s", batch.BatchId, err.Error()))
+		}
+	}
+}
+
+// registerBatchResultFile writes the results of a status into a result file
+// of the batch owner.
+func registerBatchResultFile(batch *model.BatchJob, fileId string, status string, count int, now int64) error {
+	if fileId == "" || count == 0 {
+		return nil
+	}
+	existing, err := model.GetUserFileByFileId(batch.UserId, fileId)
+	if err != nil || existing != nil {
+		return err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(model.ForEachBatchResult(batch.Id, status, func(result *model.BatchResult) error {
+			_, err := writer.Write(result.Data)
+			return err
+		}))
+	}()
+	size, err := service.SaveStoredFile(fileId, reader, 0)
+	_ = reader.Close()
+	if err != nil {
+		return err
+	}
+	file := &model.UserFile{
+		FileId:    fileId,
+		UserId:    batch.UserId,
+		TokenId:   batch.TokenId,
+		Filename:  fileId + ".jsonl",
+		Purpose:   dto.FilePurposeBatchOutput,
+		Bytes:     size,
+		CreatedAt: now,
+	}
+	return file.Insert()
+}
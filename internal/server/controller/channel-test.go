@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/lurus-api/internal/biz/relay"
+	relaychannel "github.com/QuantumNous/lurus-api/internal/biz/relay/channel"
 	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
 	relayconstant "github.com/QuantumNous/lurus-api/internal/biz/relay/constant"
 	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
@@ -38,9 +39,34 @@ type testResult struct {
 	context     *gin.Context
 	localErr    error
 	newAPIError *types.NewAPIError
+	usage       *dto.Usage
+	failures    []string // assertions of the test profile that failed
 }
 
-func testChannel(channel *model.Channel, testModel string, endpointType string) testResult {
+// channelTestModel returns the model a channel is tested with when none is
+// given.
+func channelTestModel(channel *model.Channel, testModel string) string {
+	testModel = strings.TrimSpace(testModel)
+	if testModel != "" {
+		return testModel
+	}
+	if channel.TestModel != nil && *channel.TestModel != "" {
+		return strings.TrimSpace(*channel.TestModel)
+	}
+	models := channel.GetModels()
+	if len(models) > 0 {
+		testModel = strings.TrimSpace(models[0])
+	}
+	if testModel == "" {
+		testModel = "gpt-4o-mini"
+	}
+	return testModel
+}
+
+// testChannel sends a test request to a channel. With a profile the request
+// is built from it and the response checked against its assertions,
+// otherwise a minimal request for endpointType is sent.
+func testChannel(channel *model.Channel, testModel string, endpointType string, profile *operation_setting.ChannelTestProfile) testResult {
 	tik := time.Now()
 	var unsupportedTestChannelTypes = []int{
 		constant.ChannelTypeMidjourney,
@@ -60,19 +86,9 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 
-	testModel = strings.TrimSpace(testModel)
-	if testModel == "" {
-		if channel.TestModel != nil && *channel.TestModel != "" {
-			testModel = strings.TrimSpace(*channel.TestModel)
-		} else {
-			models := channel.GetModels()
-			if len(models) > 0 {
-				testModel = strings.TrimSpace(models[0])
-			}
-			if testModel == "" {
-				testModel = "gpt-4o-mini"
-			}
-		}
+	testModel = channelTestModel(channel, testModel)
+	if profile != nil {
+		endpointType = profile.EndpointType
 	}
 
 	requestPath := "/v1/chat/completions"
@@ -181,7 +197,12 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		}
 	}
 
-	request := buildTestRequest(testModel, endpointType, channel)
+	var request dto.Request
+	if profile != nil {
+		request = buildProfileTestRequest(testModel, profile)
+	} else {
+		request = buildTestRequest(testModel, endpointType, channel)
+	}
 
 	info, err := relaycommon.GenRelayInfo(c, relayFormat, request, nil)
 
@@ -236,8 +257,12 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 
 	var convertedRequest any
 	// 根据 RelayMode 选择正确的转换函数
-	switch info.RelayMode {
-	case relayconstant.RelayModeEmbeddings:
+	claudeReq, isClaudeRequest := request.(*dto.ClaudeRequest)
+	switch {
+	case isClaudeRequest:
+		// Claude 格式透传
+		convertedRequest, err = adaptor.ConvertClaudeRequest(c, info, claudeReq)
+	case info.RelayMode == relayconstant.RelayModeEmbeddings:
 		// Embedding 请求 - request 已经是正确的类型
 		if embeddingReq, ok := request.(*dto.EmbeddingRequest); ok {
 			convertedRequest, err = adaptor.ConvertEmbeddingRequest(c, info, *embeddingReq)
@@ -248,7 +273,7 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 				newAPIError: types.NewError(errors.New("invalid embedding request type"), types.ErrorCodeConvertRequestFailed),
 			}
 		}
-	case relayconstant.RelayModeImagesGenerations:
+	case info.RelayMode == relayconstant.RelayModeImagesGenerations:
 		// 图像生成请求 - request 已经是正确的类型
 		if imageReq, ok := request.(*dto.ImageRequest); ok {
 			convertedRequest, err = adaptor.ConvertImageRequest(c, info, *imageReq)
@@ -259,7 +284,7 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 				newAPIError: types.NewError(errors.New("invalid image request type"), types.ErrorCodeConvertRequestFailed),
 			}
 		}
-	case relayconstant.RelayModeRerank:
+	case info.RelayMode == relayconstant.RelayModeRerank:
 		// Rerank 请求 - request 已经是正确的类型
 		if rerankReq, ok := request.(*dto.RerankRequest); ok {
 			convertedRequest, err = adaptor.ConvertRerankRequest(c, info.RelayMode, *rerankReq)
@@ -270,7 +295,7 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 				newAPIError: types.NewError(errors.New("invalid rerank request type"), types.ErrorCodeConvertRequestFailed),
 			}
 		}
-	case relayconstant.RelayModeResponses:
+	case info.RelayMode == relayconstant.RelayModeResponses:
 		// Response 请求 - request 已经是正确的类型
 		if responseReq, ok := request.(*dto.OpenAIResponsesRequest); ok {
 			convertedRequest, err = adaptor.ConvertOpenAIResponsesRequest(c, info, *responseReq)
@@ -294,6 +319,13 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		}
 	}
 
+	if relaychannel.IsNotImplemented(err) {
+		// 渠道不支持该请求格式时跳过
+		return testResult{
+			context:  c,
+			localErr: errChannelTestUnsupported,
+		}
+	}
 	if err != nil {
 		return testResult{
 			context:     c,
@@ -397,10 +429,23 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		Other:            other,
 	})
 	common.SysLog(fmt.Sprintf("testing channel #%d, response: \n%s", channel.Id, string(respBody)))
+	if profile != nil {
+		if failures := checkChannelTestAssertions(profile, info.RelayFormat, usage, string(respBody)); len(failures) > 0 {
+			err := fmt.Errorf("test profile %s assertions failed: %s", profile.Name, strings.Join(failures, ", "))
+			return testResult{
+				context:     c,
+				localErr:    err,
+				newAPIError: types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError),
+				usage:       usage,
+				failures:    failures,
+			}
+		}
+	}
 	return testResult{
 		context:     c,
 		localErr:    nil,
 		newAPIError: nil,
+		usage:       usage,
 	}
 }
 
@@ -522,20 +567,30 @@ func TestChannel(c *gin.Context) {
 	//		go func() { _ = channel.SaveChannelInfo() }()
 	//	}
 	//}()
-	testModel := c.Query("model")
+	testModel := channelTestModel(channel, c.Query("model"))
 	endpointType := c.Query("endpoint_type")
+	var profile *operation_setting.ChannelTestProfile
+	if name := c.Query("profile"); name != "" {
+		var ok bool
+		if profile, ok = operation_setting.GetChannelTestProfile(name); !ok {
+			common.ApiErrorMsg(c, "测试方案不存在")
+			return
+		}
+	}
 	tik := time.Now()
-	result := testChannel(channel, testModel, endpointType)
+	result := testChannel(channel, testModel, endpointType, profile)
+	tok := time.Now()
+	milliseconds := tok.Sub(tik).Milliseconds()
+	recordChannelTestResult(channel, testModel, profile, result, milliseconds)
 	if result.localErr != nil {
 		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": result.localErr.Error(),
-			"time":    0.0,
+			"success":  false,
+			"message":  result.localErr.Error(),
+			"time":     0.0,
+			"failures": result.failures,
 		})
 		return
 	}
-	tok := time.Now()
-	milliseconds := tok.Sub(tik).Milliseconds()
 	go channel.UpdateResponseTime(milliseconds)
 	consumedTime := float64(milliseconds) / 1000.0
 	if result.newAPIError != nil {
@@ -581,28 +636,20 @@ func testAllChannels(notify bool) error {
 			testAllChannelsLock.Unlock()
 		}()
 
+		profiles := autoTestProfiles()
 		for _, channel := range channels {
+			if len(profiles) > 0 {
+				testChannelWithProfiles(channel, profiles, disableThreshold)
+				continue
+			}
 			isChannelEnabled := channel.Status == common.ChannelStatusEnabled
 			tik := time.Now()
-			result := testChannel(channel, "", "")
+			result := testChannel(channel, "", "", nil)
 			tok := time.Now()
 			milliseconds := tok.Sub(tik).Milliseconds()
+			recordChannelTestResult(channel, channelTestModel(channel, ""), nil, result, milliseconds)
 
-			shouldBanChannel := false
-			newAPIError := result.newAPIError
-			// request error disables the channel
-			if newAPIError != nil {
-				shouldBanChannel = service.ShouldDisableChannel(channel.Type, result.newAPIError)
-			}
-
-			// 当错误检查通过，才检查响应时间
-			if common.AutomaticDisableChannelEnabled && !shouldBanChannel {
-				if milliseconds > disableThreshold {
-					err := fmt.Errorf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0)
-					newAPIError = types.NewOpenAIError(err, types.ErrorCodeChannelResponseTimeExceeded, http.StatusRequestTimeout)
-					shouldBanChannel = true
-				}
-			}
+			newAPIError, shouldBanChannel := channelTestVerdict(channel, result, milliseconds, disableThreshold)
 
 			// disable channel
 			if isChannelEnabled && shouldBanChannel && channel.GetAutoBan() {
@@ -618,6 +665,12 @@ func testAllChannels(notify bool) error {
 			time.Sleep(common.RequestInterval)
 		}
 
+		if days := operation_setting.GetChannelTestSetting().HistoryDays; days > 0 {
+			if _, err := model.DeleteChannelTestResultsBefore(common.GetTimestamp() - int64(days)*86400); err != nil {
+				common.SysError("failed to clean up channel test results: " + err.Error())
+			}
+		}
+
 		if notify {
 			service.NotifyRootUser(dto.NotifyTypeChannelTest, "通道测试完成", "所有通道测试已完成")
 		}
@@ -625,6 +678,27 @@ func testAllChannels(notify bool) error {
 	return nil
 }
 
+// channelTestVerdict decides whether a test result should disable the
+// channel. A response slower than the threshold counts as a failure too.
+func channelTestVerdict(channel *model.Channel, result testResult, milliseconds int64, disableThreshold int64) (*types.NewAPIError, bool) {
+	shouldBanChannel := false
+	newAPIError := result.newAPIError
+	// request error disables the channel
+	if newAPIError != nil {
+		shouldBanChannel = service.ShouldDisableChannel(channel.Type, result.newAPIError)
+	}
+
+	// 当错误检查通过，才检查响应时间
+	if common.AutomaticDisableChannelEnabled && !shouldBanChannel {
+		if milliseconds > disableThreshold {
+			err := fmt.Errorf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0)
+			newAPIError = types.NewOpenAIError(err, types.ErrorCodeChannelResponseTimeExceeded, http.StatusRequestTimeout)
+			shouldBanChannel = true
+		}
+	}
+	return newAPIError, shouldBanChannel
+}
+
 func TestAllChannels(c *gin.Context) {
 	err := testAllChannels(true)
 	if err != nil {
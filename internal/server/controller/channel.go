@@ -141,6 +141,8 @@ func GetAllChannels(c *gin.Context) {
 
 	for _, datum := range channelData {
 		clearChannelInfo(datum)
+		datum.CircuitBreakers = model.GetCircuitBreakers(datum.Id)
+		datum.RateLimits = model.GetChannelRateLimits(datum.Id)
 	}
 
 	countQuery := model.DB.Model(&model.Channel{})
@@ -261,6 +263,22 @@ func FetchUpstreamModels(c *gin.Context) {
 		return
 	}
 
+	ids, err := fetchUpstreamModelIds(channel, baseURL)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    ids,
+	})
+}
+
+// fetchUpstreamModelIds fetches the ids of the models the upstream of an
+// OpenAI compatible channel lists.
+func fetchUpstreamModelIds(channel *model.Channel, baseURL string) ([]string, error) {
 	var url string
 	switch channel.Type {
 	case constant.ChannelTypeGemini:
@@ -293,33 +311,23 @@ func FetchUpstreamModels(c *gin.Context) {
 	// 获取用于请求的可用密钥（多密钥渠道优先使用启用状态的密钥）
 	key, _, apiErr := channel.GetNextEnabledKey()
 	if apiErr != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": fmt.Sprintf("获取渠道密钥失败: %s", apiErr.Error()),
-		})
-		return
+		return nil, fmt.Errorf("获取渠道密钥失败: %s", apiErr.Error())
 	}
 	key = strings.TrimSpace(key)
 
 	headers, err := buildFetchModelsHeaders(channel, key)
 	if err != nil {
-		common.ApiError(c, err)
-		return
+		return nil, err
 	}
 
 	body, err := GetResponseBody("GET", url, channel, headers)
 	if err != nil {
-		common.ApiError(c, err)
-		return
+		return nil, err
 	}
 
 	var result OpenAIModelsResponse
 	if err = json.Unmarshal(body, &result); err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": fmt.Sprintf("解析响应失败: %s", err.Error()),
-		})
-		return
+		return nil, fmt.Errorf("解析响应失败: %s", err.Error())
 	}
 
 	var ids []string
@@ -330,12 +338,7 @@ func FetchUpstreamModels(c *gin.Context) {
 		}
 		ids = append(ids, id)
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "",
-		"data":    ids,
-	})
+	return ids, nil
 }
 
 func FixChannelsAbilities(c *gin.Context) {
@@ -539,6 +542,8 @@ func SearchChannels(c *gin.Context) {
 
 	for _, datum := range pagedData {
 		clearChannelInfo(datum)
+		datum.CircuitBreakers = model.GetCircuitBreakers(datum.Id)
+		datum.RateLimits = model.GetChannelRateLimits(datum.Id)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -564,6 +569,11 @@ func GetChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if channel != nil && channel.ChannelInfo.IsMultiKey {
+		if withKey, err := model.GetChannelById(id, true); err == nil {
+			channel.KeyUsages, _ = model.GetChannelKeyUsages(id, withKey.GetKeys())
+		}
+	}
 	if channel != nil {
 		clearChannelInfo(channel)
 	}
@@ -724,6 +734,7 @@ func AddChannel(c *gin.Context) {
 	}
 
 	addChannelRequest.Channel.CreatedTime = common.GetTimestamp()
+	addChannelRequest.Channel.ManagedBy = ""
 	keys := make([]string, 0)
 	switch addChannelRequest.Mode {
 	case "multi_to_single":
@@ -798,6 +809,7 @@ func AddChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelCreations(c, channels)
 	service.ResetProxyClientCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -808,12 +820,17 @@ func AddChannel(c *gin.Context) {
 
 func DeleteChannel(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	if rejectManagedChannels(c, []int{id}, "") {
+		return
+	}
+	before := snapshotChannels([]int{id}, "")
 	channel := model.Channel{Id: id}
 	err := channel.Delete()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelRevisions(c, before, model.ChannelRevisionActionDelete)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -823,11 +840,16 @@ func DeleteChannel(c *gin.Context) {
 }
 
 func DeleteDisabledChannel(c *gin.Context) {
+	before, err := model.SnapshotDisabledChannels()
+	if err != nil {
+		common.SysError("failed to snapshot channels: " + err.Error())
+	}
 	rows, err := model.DeleteDisabledChannel()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelRevisions(c, before, model.ChannelRevisionActionDelete)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -859,11 +881,13 @@ func DisableTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	before := snapshotChannels(nil, channelTag.Tag)
 	err = model.DisableChannelByTag(channelTag.Tag)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelRevisions(c, before, model.ChannelRevisionActionStatus)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -882,11 +906,13 @@ func EnableTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	before := snapshotChannels(nil, channelTag.Tag)
 	err = model.EnableChannelByTag(channelTag.Tag)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelRevisions(c, before, model.ChannelRevisionActionStatus)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -912,6 +938,9 @@ func EditTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	if rejectManagedChannels(c, nil, channelTag.Tag) {
+		return
+	}
 	if channelTag.ParamOverride != nil {
 		trimmed := strings.TrimSpace(*channelTag.ParamOverride)
 		if trimmed != "" && !json.Valid([]byte(trimmed)) {
@@ -934,11 +963,13 @@ func EditTagChannels(c *gin.Context) {
 		}
 		channelTag.HeaderOverride = common.GetPointer[string](trimmed)
 	}
+	before := snapshotChannels(nil, channelTag.Tag)
 	err = model.EditChannelByTag(channelTag.Tag, channelTag.NewTag, channelTag.ModelMapping, channelTag.Models, channelTag.Groups, channelTag.Priority, channelTag.Weight, channelTag.ParamOverride, channelTag.HeaderOverride)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelRevisions(c, before, model.ChannelRevisionActionEditTag)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -962,11 +993,16 @@ func DeleteChannelBatch(c *gin.Context) {
 		})
 		return
 	}
+	if rejectManagedChannels(c, channelBatch.Ids, "") {
+		return
+	}
+	before := snapshotChannels(channelBatch.Ids, "")
 	err = model.BatchDeleteChannels(channelBatch.Ids)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelRevisions(c, before, model.ChannelRevisionActionDelete)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1008,6 +1044,12 @@ func UpdateChannel(c *gin.Context) {
 		return
 	}
 
+	if originChannel.ManagedBy != "" {
+		common.ApiErrorMsg(c, fmt.Sprintf("渠道 %s 由配置文件管理，请修改配置文件", originChannel.Name))
+		return
+	}
+	channel.ManagedBy = ""
+
 	// Always copy the original ChannelInfo so that fields like IsMultiKey and MultiKeySize are retained.
 	channel.ChannelInfo = originChannel.ChannelInfo
 
@@ -1083,6 +1125,7 @@ func UpdateChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelRevisions(c, map[int]*model.Channel{originChannel.Id: originChannel}, model.ChannelRevisionActionUpdate)
 	model.InitChannelCache()
 	service.ResetProxyClientCache()
 	channel.Key = ""
@@ -1208,11 +1251,16 @@ func BatchSetChannelTag(c *gin.Context) {
 		})
 		return
 	}
+	if rejectManagedChannels(c, channelBatch.Ids, "") {
+		return
+	}
+	before := snapshotChannels(channelBatch.Ids, "")
 	err = model.BatchSetChannelTag(channelBatch.Ids, channelBatch.Tag)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelRevisions(c, before, model.ChannelRevisionActionSetTag)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1296,6 +1344,7 @@ func CopyChannel(c *gin.Context) {
 	clone.Id = 0     // let DB auto-generate
 	clone.CreatedTime = common.GetTimestamp()
 	clone.Name = origin.Name + suffix
+	clone.ManagedBy = ""
 	clone.TestTime = 0
 	clone.ResponseTime = 0
 	if resetBalance {
@@ -1304,10 +1353,13 @@ func CopyChannel(c *gin.Context) {
 	}
 
 	// insert
-	if err := model.BatchInsertChannels([]model.Channel{clone}); err != nil {
+	channels := []model.Channel{clone}
+	if err := model.BatchInsertChannels(channels); err != nil {
 		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
 		return
 	}
+	clone = channels[0]
+	recordChannelCreations(c, channels)
 	model.InitChannelCache()
 	// success
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": gin.H{"id": clone.Id}})
@@ -1316,11 +1368,13 @@ func CopyChannel(c *gin.Context) {
 // MultiKeyManageRequest represents the request for multi-key management operations
 type MultiKeyManageRequest struct {
 	ChannelId int    `json:"channel_id"`
-	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status"
-	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, and delete_key actions
-	Page      int    `json:"page,omitempty"`      // for get_key_status pagination
-	PageSize  int    `json:"page_size,omitempty"` // for get_key_status pagination
-	Status    *int   `json:"status,omitempty"`    // for get_key_status filtering: 1=enabled, 2=manual_disabled, 3=auto_disabled, nil=all
+	Action     string `json:"action"`                // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status", "set_key_weight", "set_key_quota_limit", "reset_key_usage"
+	KeyIndex   *int   `json:"key_index,omitempty"`   // for disable_key, enable_key, delete_key and the per-key settings actions
+	Page       int    `json:"page,omitempty"`        // for get_key_status pagination
+	PageSize   int    `json:"page_size,omitempty"`   // for get_key_status pagination
+	Status     *int   `json:"status,omitempty"`      // for get_key_status filtering: 1=enabled, 2=manual_disabled, 3=auto_disabled, nil=all
+	Weight     *int   `json:"weight,omitempty"`      // for set_key_weight
+	QuotaLimit *int   `json:"quota_limit,omitempty"` // for set_key_quota_limit, 0 removes the limit
 }
 
 // MultiKeyStatusResponse represents the response for key status query
@@ -1342,6 +1396,13 @@ type KeyStatus struct {
 	DisabledTime int64  `json:"disabled_time,omitempty"`
 	Reason       string `json:"reason,omitempty"`
 	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	// per-key settings and usage
+	Weight        int    `json:"weight"`
+	QuotaLimit    int    `json:"quota_limit,omitempty"`
+	UsedQuota     int64  `json:"used_quota"`
+	RequestCount  int64  `json:"request_count"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorTime int64  `json:"last_error_time,omitempty"`
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1370,10 +1431,22 @@ func ManageMultiKeys(c *gin.Context) {
 		return
 	}
 
+	// keys of managed channels come from the configuration file
+	if channel.ManagedBy != "" && (request.Action == "delete_key" || request.Action == "delete_disabled_keys") {
+		rejectManagedChannels(c, []int{channel.Id}, "")
+		return
+	}
+
 	lock := model.GetChannelPollingLock(channel.Id)
 	lock.Lock()
 	defer lock.Unlock()
 
+	// actions that change nothing leave no revision
+	if request.Action != "get_key_status" {
+		before := snapshotChannels([]int{channel.Id}, "")
+		defer recordChannelRevisions(c, before, model.ChannelRevisionActionMultiKey)
+	}
+
 	switch request.Action {
 	case "get_key_status":
 		keys := channel.GetKeys()
@@ -1391,6 +1464,12 @@ func ManageMultiKeys(c *gin.Context) {
 		// Statistics for all keys (unchanged by filtering)
 		var enabledCount, manualDisabledCount, autoDisabledCount int
 
+		usages, err := model.GetChannelKeyUsages(channel.Id, keys)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
 		// Build all key status data first
 		var allKeyStatusList []KeyStatus
 		for i, key := range keys {
@@ -1430,11 +1509,17 @@ func ManageMultiKeys(c *gin.Context) {
 			}
 
 			allKeyStatusList = append(allKeyStatusList, KeyStatus{
-				Index:        i,
-				Status:       status,
-				DisabledTime: disabledTime,
-				Reason:       reason,
-				KeyPreview:   keyPreview,
+				Index:         i,
+				Status:        status,
+				DisabledTime:  disabledTime,
+				Reason:        reason,
+				KeyPreview:    keyPreview,
+				Weight:        channel.ChannelInfo.KeyWeight(i),
+				QuotaLimit:    channel.ChannelInfo.MultiKeyQuotaLimits[i],
+				UsedQuota:     usages[i].UsedQuota,
+				RequestCount:  usages[i].RequestCount,
+				LastError:     usages[i].LastError,
+				LastErrorTime: usages[i].LastErrorTime,
 			})
 		}
 
@@ -1668,6 +1753,8 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newWeights = make(map[int]int)
+		var newQuotaLimits = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1694,6 +1781,12 @@ func ManageMultiKeys(c *gin.Context) {
 					newDisabledReason[newIndex] = r
 				}
 			}
+			if w, exists := channel.ChannelInfo.MultiKeyWeights[i]; exists {
+				newWeights[newIndex] = w
+			}
+			if l, exists := channel.ChannelInfo.MultiKeyQuotaLimits[i]; exists {
+				newQuotaLimits[newIndex] = l
+			}
 			newIndex++
 		}
 
@@ -1711,6 +1804,8 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyWeights = newWeights
+		channel.ChannelInfo.MultiKeyQuotaLimits = newQuotaLimits
 
 		err = channel.Update()
 		if err != nil {
@@ -1732,6 +1827,8 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newWeights = make(map[int]int)
+		var newQuotaLimits = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1747,6 +1844,12 @@ func ManageMultiKeys(c *gin.Context) {
 				deletedCount++
 			} else {
 				remainingKeys = append(remainingKeys, key)
+				if w, exists := channel.ChannelInfo.MultiKeyWeights[i]; exists {
+					newWeights[newIndex] = w
+				}
+				if l, exists := channel.ChannelInfo.MultiKeyQuotaLimits[i]; exists {
+					newQuotaLimits[newIndex] = l
+				}
 				// 保留非自动禁用密钥的状态信息，重新索引
 				if status != 1 {
 					newStatusList[newIndex] = status
@@ -1779,6 +1882,8 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyWeights = newWeights
+		channel.ChannelInfo.MultiKeyQuotaLimits = newQuotaLimits
 
 		err = channel.Update()
 		if err != nil {
@@ -1794,6 +1899,80 @@ func ManageMultiKeys(c *gin.Context) {
 		})
 		return
 
+	case "set_key_weight", "set_key_quota_limit", "reset_key_usage":
+		if request.KeyIndex == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "未指定密钥索引",
+			})
+			return
+		}
+
+		keyIndex := *request.KeyIndex
+		keys := channel.GetKeys()
+		if keyIndex < 0 || keyIndex >= len(keys) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "密钥索引超出范围",
+			})
+			return
+		}
+
+		if request.Action == "reset_key_usage" {
+			if err := model.ResetChannelKeyUsage(channel.Id, keys[keyIndex]); err != nil {
+				common.ApiError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "密钥用量已重置",
+			})
+			return
+		}
+
+		if request.Action == "set_key_weight" {
+			if request.Weight == nil || *request.Weight <= 0 {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "权重必须大于 0",
+				})
+				return
+			}
+			if channel.ChannelInfo.MultiKeyWeights == nil {
+				channel.ChannelInfo.MultiKeyWeights = make(map[int]int)
+			}
+			channel.ChannelInfo.MultiKeyWeights[keyIndex] = *request.Weight
+		} else {
+			if request.QuotaLimit == nil || *request.QuotaLimit < 0 {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "额度上限不能为负数",
+				})
+				return
+			}
+			if channel.ChannelInfo.MultiKeyQuotaLimits == nil {
+				channel.ChannelInfo.MultiKeyQuotaLimits = make(map[int]int)
+			}
+			if *request.QuotaLimit == 0 {
+				delete(channel.ChannelInfo.MultiKeyQuotaLimits, keyIndex)
+			} else {
+				channel.ChannelInfo.MultiKeyQuotaLimits[keyIndex] = *request.QuotaLimit
+			}
+		}
+
+		err = channel.Update()
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
+		model.InitChannelCache()
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "密钥设置已更新",
+		})
+		return
+
 	default:
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -2061,3 +2240,47 @@ func OllamaVersion(c *gin.Context) {
 		},
 	})
 }
+
+// GetChannelHealth returns the live latency and error statistics adaptive
+// channel selection works with, per channel and per key of multi-key channels.
+func GetChannelHealth(c *gin.Context) {
+	common.ApiSuccess(c, model.GetChannelHealthStats())
+}
+
+// GetChannelMetrics returns the request counts, errors by class and latency
+// percentiles of a channel per model, per minute for the last hours
+// (granularity=minute, the default) or per hour (granularity=hour).
+func GetChannelMetrics(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	granularity := c.DefaultQuery("granularity", model.ChannelMetricGranularityMinute)
+	if granularity != model.ChannelMetricGranularityMinute && granularity != model.ChannelMetricGranularityHour {
+		common.ApiErrorMsg(c, "granularity 只能为 minute 或 hour")
+		return
+	}
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	if endTimestamp <= 0 {
+		endTimestamp = common.GetTimestamp()
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	if startTimestamp <= 0 {
+		if granularity == model.ChannelMetricGranularityHour {
+			startTimestamp = endTimestamp - 24*3600
+		} else {
+			startTimestamp = endTimestamp - 3600
+		}
+	}
+	if endTimestamp < startTimestamp {
+		common.ApiErrorMsg(c, "end_timestamp 不能早于 start_timestamp")
+		return
+	}
+	points, err := model.GetChannelMetrics(id, c.Query("model"), granularity, startTimestamp, endTimestamp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, points)
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// snapshotChannels loads channels about to be changed for
+// recordChannelRevisions. Failing to do so only loses the history entry, the
+// change itself goes ahead.
+func snapshotChannels(ids []int, tag string) map[int]*model.Channel {
+	before, err := model.SnapshotChannels(ids, tag)
+	if err != nil {
+		common.SysError("failed to snapshot channels: " + err.Error())
+	}
+	return before
+}
+
+func recordChannelRevisions(c *gin.Context, before map[int]*model.Channel, action string) {
+	if err := model.RecordChannelRevisions(before, action, c.GetInt("id"), c.GetString("username")); err != nil {
+		common.SysError("failed to record channel revisions: " + err.Error())
+	}
+}
+
+func recordChannelCreations(c *gin.Context, channels []model.Channel) {
+	if err := model.RecordChannelCreations(channels, c.GetInt("id"), c.GetString("username")); err != nil {
+		common.SysError("failed to record channel revisions: " + err.Error())
+	}
+}
+
+// GetChannelRevisions lists the configuration versions of a channel
+// GET /api/channel/:id/revisions
+func GetChannelRevisions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	revisions, total, err := model.GetChannelRevisions(id, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(revisions)
+	common.ApiSuccess(c, pageInfo)
+}
+
+type ChannelRollbackRequest struct {
+	Version int `json:"version"`
+}
+
+// RollbackChannel restores a channel to one of its versions
+// POST /api/channel/:id/rollback
+func RollbackChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var request ChannelRollbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Version <= 0 {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	if err := model.RollbackChannel(id, request.Version, c.GetInt("id"), c.GetString("username")); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.InitChannelCache()
+	service.ResetProxyClientCache()
+	common.ApiSuccess(c, nil)
+}
+
+type TagRollbackRequest struct {
+	Tag       string `json:"tag"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// RollbackTagChannels restores every channel of a tag to its configuration at
+// a point in time
+// POST /api/channel/tag/rollback
+func RollbackTagChannels(c *gin.Context) {
+	var request TagRollbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Tag == "" || request.Timestamp <= 0 {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	count, err := model.RollbackChannelsByTag(request.Tag, request.Timestamp, c.GetInt("id"), c.GetString("username"))
+	model.InitChannelCache()
+	service.ResetProxyClientCache()
+	if err != nil {
+		common.ApiErrorMsg(c, fmt.Sprintf("已回滚 %d 个渠道，部分失败: %s", count, err.Error()))
+		return
+	}
+	common.ApiSuccess(c, count)
+}
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+	"github.com/QuantumNous/lurus-api/internal/server/middleware"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// shadowRequestTimeout bounds a shadow request, which no longer runs on the
+// context of the client request.
+const shadowRequestTimeout = 10 * time.Minute
+
+// shadowWriter discards the response of a shadow request.
+type shadowWriter struct {
+	header  http.Header
+	status  int
+	size    int
+	written bool
+}
+
+func (w *shadowWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *shadowWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *shadowWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *shadowWriter) Write(data []byte) (int, error) {
+	w.written = true
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *shadowWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *shadowWriter) Written() bool {
+	return w.written
+}
+
+func (w *shadowWriter) Status() int {
+	return w.status
+}
+
+func (w *shadowWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.size
+}
+
+func (w *shadowWriter) Flush() {}
+
+func (w *shadowWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *shadowWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("shadow requests cannot be hijacked")
+}
+
+func (w *shadowWriter) Pusher() http.Pusher {
+	return nil
+}
+
+// mirrorToShadowChannels sends a sample of the requests that generate text
+// to the channels in shadow mode serving the model. The copies run in the
+// background on their own context, their responses are discarded and they
+// are never billed; latency, errors and usage go into the channel metrics.
+func mirrorToShadowChannels(c *gin.Context, info *relaycommon.RelayInfo, relayFormat types.RelayFormat, requestBody []byte) {
+	if !hedgeable(c, info, relayFormat) {
+		return
+	}
+	for _, channel := range model.GetShadowChannels(info.UsingGroup, info.OriginModelName) {
+		startShadowRequest(c, info.CloneForHedge(), relayFormat, channel, requestBody)
+	}
+}
+
+func startShadowRequest(c *gin.Context, info *relaycommon.RelayInfo, relayFormat types.RelayFormat, channel *model.Channel, requestBody []byte) {
+	ctx := c.Copy()
+	requestCtx, cancel := context.WithTimeout(context.Background(), shadowRequestTimeout)
+	request := c.Request.Clone(requestCtx)
+	// the response cache must neither answer nor store a shadow request
+	request.Header.Set("Cache-Control", "no-cache, no-store")
+	request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	ctx.Request = request
+	ctx.Writer = &shadowWriter{header: http.Header{}, status: http.StatusOK}
+	attempt := relaycommon.NewShadowAttempt(ctx)
+
+	if middleware.SetupContextForSelectedChannel(ctx, channel, info.OriginModelName) != nil {
+		cancel()
+		return
+	}
+	slot, ok := model.AcquireChannelSlot(channel.Id, channelKeyIndex(ctx))
+	if !ok {
+		cancel()
+		return
+	}
+	info.PriceData.GroupRatioInfo = helper.HandleGroupRatio(ctx, info)
+
+	gopool.Go(func() {
+		defer cancel()
+		defer slot.Release()
+		defer func() {
+			if p := recover(); p != nil {
+				logger.LogError(ctx, fmt.Sprintf("panic in shadow request to channel #%d: %v", channel.Id, p))
+			}
+		}()
+		start := time.Now()
+		err := relayAttempt(ctx, info, relayFormat)
+		errorClass := ""
+		if err != nil {
+			errorClass = channelErrorClass(err)
+			logger.LogWarn(ctx, fmt.Sprintf("shadow request to channel #%d failed: %s", channel.Id, err.Error()))
+		}
+		var firstToken time.Duration
+		if err == nil && info.IsStream && info.FirstResponseTime.After(start) {
+			firstToken = info.FirstResponseTime.Sub(start)
+		}
+		model.RecordChannelMetric(channel.Id, info.OriginModelName, time.Since(start), firstToken, errorClass)
+		if usage := attempt.Usage(); usage != nil {
+			model.RecordChannelMetricUsage(channel.Id, info.OriginModelName, usage.PromptTokens, usage.CompletionTokens)
+		}
+	})
+}
+
+// ChannelRolloutStats is the traffic of a channel for a model over the
+// requested window. RolloutMode is empty for regular channels.
+type ChannelRolloutStats struct {
+	ChannelId      int                      `json:"channel_id"`
+	ChannelName    string                   `json:"channel_name"`
+	RolloutMode    string                   `json:"rollout_mode"`
+	RolloutPercent float64                  `json:"rollout_percent"`
+	Stats          model.ChannelMetricPoint `json:"stats"`
+}
+
+// ModelRolloutStats compares the channels in canary or shadow mode serving a
+// model with the regular channels serving it in the same groups.
+type ModelRolloutStats struct {
+	ModelName string                `json:"model_name"`
+	Channels  []ChannelRolloutStats `json:"channels"`
+}
+
+// GetChannelRolloutStats returns the traffic of every channel in canary or
+// shadow mode next to the regular channels serving the same models
+// GET /api/channel/rollout/stats?hours=24&model=
+func GetChannelRolloutStats(c *gin.Context) {
+	hours, _ := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if hours <= 0 || hours > 24*90 {
+		common.ApiErrorMsg(c, "hours 需在 1 到 2160 之间")
+		return
+	}
+	to := common.GetTimestamp()
+	from := to - int64(hours)*3600
+
+	var channels []*model.Channel
+	if err := model.DB.Omit("key").Where("status = ?", common.ChannelStatusEnabled).Order("id").Find(&channels).Error; err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	type servingKey struct {
+		group     string
+		modelName string
+	}
+	serving := make(map[servingKey][]*model.Channel)
+	for _, channel := range channels {
+		for _, group := range channel.GetGroups() {
+			for _, modelName := range channel.GetModels() {
+				key := servingKey{group, strings.TrimSpace(modelName)}
+				serving[key] = append(serving[key], channel)
+			}
+		}
+	}
+
+	// the channels to compare per model: the rollout channels and the regular
+	// channels sharing a group with one of them
+	compared := make(map[string]map[int]*model.Channel)
+	for _, channel := range channels {
+		if channel.GetSetting().RolloutMode == "" {
+			continue
+		}
+		for _, modelName := range channel.GetModels() {
+			modelName = strings.TrimSpace(modelName)
+			if modelName == "" || (c.Query("model") != "" && modelName != c.Query("model")) {
+				continue
+			}
+			if compared[modelName] == nil {
+				compared[modelName] = make(map[int]*model.Channel)
+			}
+			for _, group := range channel.GetGroups() {
+				for _, other := range serving[servingKey{group, modelName}] {
+					compared[modelName][other.Id] = other
+				}
+			}
+		}
+	}
+
+	result := make([]ModelRolloutStats, 0, len(compared))
+	for modelName, byId := range compared {
+		entry := ModelRolloutStats{ModelName: modelName}
+		for _, channel := range byId {
+			stats, err := model.GetChannelMetricSummary(channel.Id, modelName, from, to)
+			if err != nil {
+				common.ApiError(c, err)
+				return
+			}
+			setting := channel.GetSetting()
+			entry.Channels = append(entry.Channels, ChannelRolloutStats{
+				ChannelId:      channel.Id,
+				ChannelName:    channel.Name,
+				RolloutMode:    setting.RolloutMode,
+				RolloutPercent: setting.RolloutPercent,
+				Stats:          stats,
+			})
+		}
+		// regular channels first, then canary and shadow ones
+		sort.Slice(entry.Channels, func(i, j int) bool {
+			if entry.Channels[i].RolloutMode != entry.Channels[j].RolloutMode {
+				return entry.Channels[i].RolloutMode < entry.Channels[j].RolloutMode
+			}
+			return entry.Channels[i].ChannelId < entry.Channels[j].ChannelId
+		})
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ModelName < result[j].ModelName
+	})
+	common.ApiSuccess(c, result)
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+	"github.com/QuantumNous/lurus-api/internal/server/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channels drawn before queueing when the one the request was routed to is
+// saturated
+const channelSlotAttempts = 3
+
+// channelKeyIndex returns the index of the key selected on a multi-key
+// channel, -1 otherwise.
+func channelKeyIndex(c *gin.Context) int {
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		return common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	return -1
+}
+
+// acquireChannelSlot takes a concurrency slot on the channel the request was
+// routed to. If that channel is saturated it draws other channels, and when
+// they are saturated as well it waits in the queue of the group and model
+// until a slot frees up. It returns the channel the slot was taken on.
+func acquireChannelSlot(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service.RetryParam, channel *model.Channel) (*model.Channel, *model.ChannelSlot, *types.NewAPIError) {
+	if slot, ok := model.AcquireChannelSlot(channel.Id, channelKeyIndex(c)); ok {
+		return channel, slot, nil
+	}
+
+	_, specific := c.Get("specific_channel_id")
+	var slot *model.ChannelSlot
+	var selectErr *types.NewAPIError
+	acquire := func() bool {
+		if specific {
+			// the channel is fixed, but another of its keys may be free
+			selected, err := model.CacheGetChannel(channel.Id)
+			if err != nil {
+				selectErr = types.NewError(err, types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+				return true
+			}
+			if selectErr = middleware.SetupContextForSelectedChannel(c, selected, info.OriginModelName); selectErr != nil {
+				return true
+			}
+			channel = selected
+		} else {
+			selected, err := selectChannel(c, info, retryParam)
+			if err != nil {
+				selectErr = err
+				return true
+			}
+			channel = selected
+		}
+		var ok bool
+		slot, ok = model.AcquireChannelSlot(channel.Id, channelKeyIndex(c))
+		return ok
+	}
+
+	acquired := false
+	queueKey := fmt.Sprintf("channel:%d", channel.Id)
+	if !specific {
+		queueKey = fmt.Sprintf("group:%s:%s", info.UsingGroup, info.OriginModelName)
+		for i := 0; i < channelSlotAttempts && !acquired; i++ {
+			acquired = acquire()
+		}
+	}
+	var err error
+	if !acquired {
+		err = service.WaitForChannelSlot(c.Request.Context(), queueKey, acquire)
+	}
+	if selectErr != nil {
+		return nil, nil, selectErr
+	}
+	if err != nil {
+		return nil, nil, types.NewErrorWithStatusCode(fmt.Errorf("分组 %s 下模型 %s 的可用渠道并发已满: %w", info.UsingGroup, info.OriginModelName, err), types.ErrorCodeChannelSaturated, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+	}
+	return channel, slot, nil
+}
@@ -0,0 +1,307 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+)
+
+// errChannelTestUnsupported is returned when a channel cannot be tested with a
+// profile at all, e.g. Claude passthrough on a channel without native Claude
+// support. Such tests are skipped rather than counted as failures.
+var errChannelTestUnsupported = errors.New("channel does not support this test profile")
+
+// a 1x1 PNG, small enough for any vision model
+const channelTestImage = "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8DwHwAFBQIAX8jx0gAAAABJRU5ErkJggg=="
+
+const (
+	channelTestDefaultPrompt = "hi"
+	channelTestToolPrompt    = "What is the weather in Paris? Call the get_weather tool."
+	channelTestVisionPrompt  = "What color is this image? Answer in one word."
+	channelTestToolName      = "get_weather"
+)
+
+var channelTestToolParameters = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"city": map[string]any{"type": "string"},
+	},
+	"required": []string{"city"},
+}
+
+func channelTestPrompt(profile *operation_setting.ChannelTestProfile) string {
+	switch {
+	case profile.Prompt != "":
+		return profile.Prompt
+	case profile.Tools:
+		return channelTestToolPrompt
+	case profile.Vision:
+		return channelTestVisionPrompt
+	}
+	return channelTestDefaultPrompt
+}
+
+func channelTestMaxTokens(profile *operation_setting.ChannelTestProfile, modelName string) uint {
+	if profile.MaxTokens > 0 {
+		return profile.MaxTokens
+	}
+	if strings.Contains(modelName, "gemini") || strings.Contains(modelName, "thinking") {
+		return 3000
+	}
+	return 16
+}
+
+// buildProfileTestRequest builds the request a test profile sends.
+func buildProfileTestRequest(modelName string, profile *operation_setting.ChannelTestProfile) dto.Request {
+	prompt := channelTestPrompt(profile)
+	maxTokens := channelTestMaxTokens(profile, modelName)
+	switch constant.EndpointType(profile.EndpointType) {
+	case constant.EndpointTypeEmbeddings:
+		return &dto.EmbeddingRequest{
+			Model: modelName,
+			Input: []any{prompt},
+		}
+	case constant.EndpointTypeJinaRerank:
+		return &dto.RerankRequest{
+			Model:     modelName,
+			Query:     "What is Deep Learning?",
+			Documents: []any{"Deep Learning is a subset of machine learning.", "Machine learning is a field of artificial intelligence."},
+			TopN:      2,
+		}
+	case constant.EndpointTypeOpenAIResponse:
+		content := []map[string]any{{"type": "input_text", "text": prompt}}
+		if profile.Vision {
+			content = append(content, map[string]any{"type": "input_image", "image_url": channelTestImage})
+		}
+		input, _ := common.Marshal([]map[string]any{{"role": "user", "content": content}})
+		request := &dto.OpenAIResponsesRequest{
+			Model:           modelName,
+			Input:           input,
+			Stream:          profile.Stream,
+			MaxOutputTokens: max(maxTokens, 16),
+		}
+		if profile.Tools {
+			request.Tools, _ = common.Marshal([]map[string]any{{
+				"type":        "function",
+				"name":        channelTestToolName,
+				"description": "Get the current weather of a city",
+				"parameters":  channelTestToolParameters,
+			}})
+		}
+		return request
+	case constant.EndpointTypeAnthropic:
+		var content any = prompt
+		if profile.Vision {
+			content = []map[string]any{
+				{"type": "image", "source": map[string]any{
+					"type":       "base64",
+					"media_type": "image/png",
+					"data":       strings.TrimPrefix(channelTestImage, "data:image/png;base64,"),
+				}},
+				{"type": "text", "text": prompt},
+			}
+		}
+		request := &dto.ClaudeRequest{
+			Model:     modelName,
+			Messages:  []dto.ClaudeMessage{{Role: "user", Content: content}},
+			MaxTokens: maxTokens,
+			Stream:    profile.Stream,
+		}
+		if profile.Tools {
+			request.Tools = []map[string]any{{
+				"name":         channelTestToolName,
+				"description":  "Get the current weather of a city",
+				"input_schema": channelTestToolParameters,
+			}}
+		}
+		return request
+	}
+
+	// openai and gemini endpoints both take an OpenAI-format request
+	message := dto.Message{Role: "user", Content: prompt}
+	if profile.Vision {
+		message.Content = []dto.MediaContent{
+			{Type: dto.ContentTypeText, Text: prompt},
+			{Type: dto.ContentTypeImageURL, ImageUrl: &dto.MessageImageUrl{Url: channelTestImage}},
+		}
+	}
+	request := &dto.GeneralOpenAIRequest{
+		Model:    modelName,
+		Stream:   profile.Stream,
+		Messages: []dto.Message{message},
+	}
+	if strings.HasPrefix(modelName, "o") {
+		request.MaxCompletionTokens = maxTokens
+	} else {
+		request.MaxTokens = maxTokens
+	}
+	if profile.Stream {
+		request.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+	if profile.Tools {
+		request.Tools = []dto.ToolCallRequest{{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        channelTestToolName,
+				Description: "Get the current weather of a city",
+				Parameters:  channelTestToolParameters,
+			},
+		}}
+	}
+	return request
+}
+
+// channelTestDocuments returns the JSON documents of a response body: the
+// payload of every event of a stream, or the body itself.
+func channelTestDocuments(body string) []any {
+	var documents []any
+	if !strings.HasPrefix(strings.TrimSpace(body), "{") && !strings.HasPrefix(strings.TrimSpace(body), "[") {
+		for _, line := range strings.Split(body, "\n") {
+			payload, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+			if !ok {
+				continue
+			}
+			var document any
+			if common.UnmarshalJsonStr(strings.TrimSpace(payload), &document) == nil {
+				documents = append(documents, document)
+			}
+		}
+		return documents
+	}
+	var document any
+	if common.UnmarshalJsonStr(body, &document) == nil {
+		documents = append(documents, document)
+	}
+	return documents
+}
+
+// walkChannelTestDocument calls visit on every object of a JSON document
+// until it returns true.
+func walkChannelTestDocument(document any, visit func(map[string]any) bool) bool {
+	switch value := document.(type) {
+	case map[string]any:
+		if visit(value) {
+			return true
+		}
+		for _, child := range value {
+			if walkChannelTestDocument(child, visit) {
+				return true
+			}
+		}
+	case []any:
+		for _, child := range value {
+			if walkChannelTestDocument(child, visit) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func channelTestHasContent(documents []any) bool {
+	return anyChannelTestDocument(documents, func(object map[string]any) bool {
+		for _, key := range []string{"content", "text", "output_text", "delta"} {
+			if text, ok := object[key].(string); ok && strings.TrimSpace(text) != "" {
+				return true
+			}
+		}
+		for _, key := range []string{"embedding", "results", "data"} {
+			if items, ok := object[key].([]any); ok && len(items) > 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// channelTestHasToolCall looks for a tool call in any of the formats: OpenAI
+// tool_calls, Claude tool_use blocks, Responses function_call items and Gemini
+// functionCall parts. Arguments of complete (non-streamed) calls must be JSON.
+func channelTestHasToolCall(documents []any, stream bool) bool {
+	validArguments := func(arguments any) bool {
+		text, ok := arguments.(string)
+		return stream || !ok || json.Valid([]byte(text))
+	}
+	return anyChannelTestDocument(documents, func(object map[string]any) bool {
+		if calls, ok := object["tool_calls"].([]any); ok {
+			for _, call := range calls {
+				call, _ := call.(map[string]any)
+				function, _ := call["function"].(map[string]any)
+				if function != nil && nonEmptyString(function["name"]) && validArguments(function["arguments"]) {
+					return true
+				}
+			}
+		}
+		if kind, _ := object["type"].(string); kind == "tool_use" || kind == "function_call" {
+			return nonEmptyString(object["name"]) && validArguments(object["arguments"])
+		}
+		if call, ok := object["functionCall"].(map[string]any); ok {
+			return nonEmptyString(call["name"])
+		}
+		return false
+	})
+}
+
+func nonEmptyString(value any) bool {
+	text, ok := value.(string)
+	return ok && text != ""
+}
+
+// anyChannelTestDocument reports whether visit holds for an object of any of
+// the documents.
+func anyChannelTestDocument(documents []any, visit func(map[string]any) bool) bool {
+	for _, document := range documents {
+		if walkChannelTestDocument(document, visit) {
+			return true
+		}
+	}
+	return false
+}
+
+// channelTestStreamDone reports whether a stream ended the way its format
+// ends a complete response.
+func channelTestStreamDone(format types.RelayFormat, body string) bool {
+	switch format {
+	case types.RelayFormatClaude:
+		return strings.Contains(body, "message_stop")
+	case types.RelayFormatOpenAIResponses:
+		return strings.Contains(body, "response.completed")
+	case types.RelayFormatGemini:
+		return strings.Contains(body, "finishReason")
+	}
+	return strings.Contains(body, "data: [DONE]")
+}
+
+// checkChannelTestAssertions returns the assertions of a profile the response
+// does not satisfy.
+func checkChannelTestAssertions(profile *operation_setting.ChannelTestProfile, format types.RelayFormat, usage *dto.Usage, body string) []string {
+	documents := channelTestDocuments(body)
+	var failures []string
+	for _, assertion := range profile.Assertions {
+		var ok bool
+		switch assertion {
+		case operation_setting.ChannelTestAssertUsage:
+			ok = usage != nil && usage.PromptTokens+usage.CompletionTokens > 0
+		case operation_setting.ChannelTestAssertContent:
+			ok = channelTestHasContent(documents)
+		case operation_setting.ChannelTestAssertToolCall:
+			ok = channelTestHasToolCall(documents, profile.Stream)
+		case operation_setting.ChannelTestAssertStreamDone:
+			ok = channelTestStreamDone(format, body)
+		default:
+			failures = append(failures, fmt.Sprintf("unknown assertion %s", assertion))
+			continue
+		}
+		if !ok {
+			failures = append(failures, assertion)
+		}
+	}
+	return failures
+}
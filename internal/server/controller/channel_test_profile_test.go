@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+)
+
+func TestCheckChannelTestAssertionsStream(t *testing.T) {
+	profile := &operation_setting.ChannelTestProfile{
+		Stream:     true,
+		Assertions: []string{operation_setting.ChannelTestAssertUsage, operation_setting.ChannelTestAssertContent, operation_setting.ChannelTestAssertStreamDone},
+	}
+	usage := &dto.Usage{PromptTokens: 3, CompletionTokens: 2}
+	body := "data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\ndata: [DONE]\n\n"
+	if failures := checkChannelTestAssertions(profile, types.RelayFormatOpenAI, usage, body); len(failures) != 0 {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+
+	truncated := "data: {\"choices\":[{\"delta\":{\"content\":\"\"}}]}\n\n"
+	failures := checkChannelTestAssertions(profile, types.RelayFormatOpenAI, nil, truncated)
+	want := []string{operation_setting.ChannelTestAssertUsage, operation_setting.ChannelTestAssertContent, operation_setting.ChannelTestAssertStreamDone}
+	if !slices.Equal(failures, want) {
+		t.Fatalf("failures = %v, want %v", failures, want)
+	}
+}
+
+func TestChannelTestHasToolCall(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		stream bool
+		want   bool
+	}{
+		{"openai", `{"choices":[{"message":{"tool_calls":[{"function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]}}]}`, false, true},
+		{"openai invalid arguments", `{"choices":[{"message":{"tool_calls":[{"function":{"name":"get_weather","arguments":"{\"city\""}}]}}]}`, false, false},
+		{"openai stream", "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"function\":{\"name\":\"get_weather\",\"arguments\":\"{\\\"ci\"}}]}}]}\n\ndata: [DONE]\n", true, true},
+		{"claude", `{"content":[{"type":"tool_use","name":"get_weather","input":{"city":"Paris"}}]}`, false, true},
+		{"responses", `{"output":[{"type":"function_call","name":"get_weather","arguments":"{}"}]}`, false, true},
+		{"text only", `{"choices":[{"message":{"content":"It is sunny."}}]}`, false, false},
+	}
+	for _, tc := range cases {
+		if got := channelTestHasToolCall(channelTestDocuments(tc.body), tc.stream); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// profile name the test history records tests without a profile under
+const channelTestProfileBasic = "basic"
+
+// recordChannelTestResult stores the outcome of a test in the test history of
+// the channel. Tests the channel does not support are not recorded.
+func recordChannelTestResult(channel *model.Channel, modelName string, profile *operation_setting.ChannelTestProfile, result testResult, milliseconds int64) {
+	if errors.Is(result.localErr, errChannelTestUnsupported) {
+		return
+	}
+	record := &model.ChannelTestResult{
+		ChannelId: channel.Id,
+		ModelName: modelName,
+		Profile:   channelTestProfileBasic,
+		Success:   result.localErr == nil && result.newAPIError == nil,
+		Failures:  strings.Join(result.failures, ","),
+		Latency:   int(milliseconds),
+	}
+	if profile != nil {
+		record.Profile = profile.Name
+	}
+	if result.newAPIError != nil {
+		record.Message = result.newAPIError.Error()
+	} else if result.localErr != nil {
+		record.Message = result.localErr.Error()
+	}
+	if result.usage != nil {
+		record.PromptTokens = result.usage.PromptTokens
+		record.CompletionTokens = result.usage.CompletionTokens
+	}
+	if err := model.RecordChannelTestResult(record); err != nil {
+		common.SysError(fmt.Sprintf("failed to record test result of channel #%d: %s", channel.Id, err.Error()))
+	}
+}
+
+// autoTestProfiles returns the profiles the automatic test runs, see
+// MonitorSetting.AutoTestProfiles.
+func autoTestProfiles() []*operation_setting.ChannelTestProfile {
+	var profiles []*operation_setting.ChannelTestProfile
+	for _, name := range operation_setting.GetMonitorSetting().AutoTestProfiles {
+		if profile, ok := operation_setting.GetChannelTestProfile(name); ok {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// testChannelWithProfiles runs the profiles against every model of a channel
+// they apply to. With AutoTestDisableModel failing models are disabled on
+// their own and enabled again once they pass; otherwise a failure disables the
+// whole channel like the basic test does.
+func testChannelWithProfiles(channel *model.Channel, profiles []*operation_setting.ChannelTestProfile, disableThreshold int64) {
+	isChannelEnabled := channel.Status == common.ChannelStatusEnabled
+	disableModel := operation_setting.GetMonitorSetting().AutoTestDisableModel
+	disabledModels := make(map[string]bool)
+	if models, err := model.GetDisabledChannelModels(channel.Id); err == nil && isChannelEnabled {
+		for _, modelName := range models {
+			disabledModels[modelName] = true
+		}
+	}
+
+	var banResult *testResult
+	var banError *types.NewAPIError
+	var totalMilliseconds int64
+	var passedKey string
+	tested, passed, abilitiesChanged := 0, 0, false
+	for _, modelName := range channel.GetModels() {
+		modelName = strings.TrimSpace(modelName)
+		modelTested, modelFailed, failureReason := false, false, ""
+		for _, profile := range profiles {
+			if modelName == "" || !profile.Matches(modelName) {
+				continue
+			}
+			tik := time.Now()
+			result := testChannel(channel, modelName, "", profile)
+			milliseconds := time.Since(tik).Milliseconds()
+			recordChannelTestResult(channel, modelName, profile, result, milliseconds)
+			if errors.Is(result.localErr, errChannelTestUnsupported) {
+				continue
+			}
+			modelTested = true
+			tested++
+			totalMilliseconds += milliseconds
+
+			newAPIError, shouldBan := channelTestVerdict(channel, result, milliseconds, disableThreshold)
+			if newAPIError == nil {
+				passed++
+				passedKey = common.GetContextKeyString(result.context, constant.ContextKeyChannelKey)
+			}
+			if disableModel {
+				// any failure is specific to the model
+				shouldBan = common.AutomaticDisableChannelEnabled && newAPIError != nil
+			} else if common.AutomaticDisableChannelEnabled && len(result.failures) > 0 {
+				shouldBan = true
+			}
+			if shouldBan {
+				if !modelFailed && result.localErr != nil {
+					failureReason = fmt.Sprintf("%s: %s", profile.Name, result.localErr.Error())
+				}
+				modelFailed = true
+				if banResult == nil {
+					banResult, banError = &result, newAPIError
+				}
+			}
+			time.Sleep(common.RequestInterval)
+		}
+
+		if !disableModel || !modelTested || !isChannelEnabled {
+			continue
+		}
+		if modelFailed && !disabledModels[modelName] && channel.GetAutoBan() {
+			if err := model.DisableChannelModel(channel.Id, modelName, failureReason); err == nil {
+				abilitiesChanged = true
+				common.SysLog(fmt.Sprintf("model %s of channel #%d (%s) disabled after failing its tests", modelName, channel.Id, channel.Name))
+			}
+		} else if !modelFailed && disabledModels[modelName] && common.AutomaticEnableChannelEnabled {
+			if err := model.EnableChannelModel(channel.Id, modelName); err == nil {
+				abilitiesChanged = true
+				common.SysLog(fmt.Sprintf("model %s of channel #%d (%s) enabled after passing its tests", modelName, channel.Id, channel.Name))
+			}
+		}
+	}
+	if abilitiesChanged {
+		model.InitChannelCache()
+	}
+	if tested == 0 {
+		return
+	}
+
+	// disable channel
+	if !disableModel && isChannelEnabled && banResult != nil && channel.GetAutoBan() {
+		processChannelError(banResult.context, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(banResult.context, constant.ContextKeyChannelKey), channel.GetAutoBan()), banError)
+	}
+
+	// enable channel
+	if !isChannelEnabled && passed == tested && service.ShouldEnableChannel(nil, channel.Status) {
+		service.EnableChannel(channel.Id, passedKey, channel.Name)
+	}
+
+	channel.UpdateResponseTime(totalMilliseconds / int64(tested))
+}
+
+// GetChannelTestResults lists the test history of a channel
+// GET /api/channel/:id/test_results?model=&profile=
+func GetChannelTestResults(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	results, total, err := model.GetChannelTestResults(id, c.Query("model"), c.Query("profile"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(results)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetChannelTestSummary returns the latest result of every model and profile
+// tested on a channel, and the models disabled by failing tests
+// GET /api/channel/:id/test_results/latest
+func GetChannelTestSummary(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	results, err := model.GetLatestChannelTestResults(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	disabledModels, err := model.GetDisabledChannelModels(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"results":         results,
+		"disabled_models": disabledModels,
+	})
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	relayconstant "github.com/QuantumNous/lurus-api/internal/biz/relay/constant"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compactContext compacts a request that fits no channel into the largest
+// context window of its model when the token opted in, see
+// service.CompactContext, and routes it again. It returns the estimated tokens
+// and token count meta of the compacted request, or apiErr when the request is
+// not compacted.
+func compactContext(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request, apiErr *types.NewAPIError) (int, *types.TokenCountMeta, *types.NewAPIError) {
+	mode := service.ContextCompactionMode(c)
+	var windowErr *model.ContextWindowError
+	if mode == "" || !errors.As(apiErr, &windowErr) || windowErr.Limit.ContextLength <= 0 {
+		return 0, nil, apiErr
+	}
+	if windowErr.Limit.MaxOutputTokens > 0 && windowErr.MaxTokens > windowErr.Limit.MaxOutputTokens {
+		// a shorter prompt does not lower the output asked for
+		return 0, nil, apiErr
+	}
+	setting := operation_setting.GetContextCompactionSetting()
+	budget := int(float64(windowErr.Limit.ContextLength-windowErr.MaxTokens) * setting.TargetRatio)
+	if budget <= 0 {
+		return 0, nil, apiErr
+	}
+
+	group := info.UsingGroup
+	if autoGroup, ok := c.Get("auto_group"); ok {
+		group = autoGroup.(string)
+	}
+	compaction, err := service.CompactContext(c, request, windowErr.Model, mode, windowErr.PromptTokens, budget, group, relayContextSummary)
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("context compaction failed: %s", err.Error()))
+		return 0, nil, apiErr
+	}
+	if compaction == nil {
+		return 0, nil, apiErr
+	}
+	if compaction.SummaryError != "" {
+		logger.LogWarn(c, fmt.Sprintf("context summary failed, dropping the turns instead: %s", compaction.SummaryError))
+	}
+
+	// the relay sends the parsed request, the body follows it for pass-through
+	// channels and retries
+	body, err := common.Marshal(request)
+	if err != nil {
+		return 0, nil, types.NewError(err, types.ErrorCodeJsonMarshalFailed, types.ErrOptionWithSkipRetry())
+	}
+	c.Set(common.KeyRequestBody, body)
+	meta := request.GetTokenCountMeta()
+	tokens, err := service.EstimateRequestToken(c, meta, info)
+	if err != nil {
+		return 0, nil, types.NewError(err, types.ErrorCodeCountTokenFailed)
+	}
+	compaction.CompactedTokens = tokens
+	service.SetContextCompaction(c, compaction)
+	c.Header(service.ContextCompactionHeader, compaction.HeaderValue())
+	logger.LogInfo(c, fmt.Sprintf("context compacted: %s", compaction.HeaderValue()))
+
+	if apiErr = fitContextWindow(c, info, tokens, meta); apiErr != nil {
+		return 0, nil, apiErr
+	}
+	return tokens, meta, nil
+}
+
+// relayContextSummary makes the summary call of a context compaction as a
+// chat completion of its own, see relayInternalRequest.
+func relayContextSummary(c *gin.Context, group string, transcript string) (string, error) {
+	request := service.NewContextSummaryRequest(transcript)
+	body, err := relayInternalRequest(c, "/v1/chat/completions", relayconstant.RelayModeChatCompletions, types.RelayFormatOpenAI, group, request.Model, request)
+	if err != nil {
+		return "", fmt.Errorf("summary request: %w", err)
+	}
+	return service.ParseContextSummary(body)
+}
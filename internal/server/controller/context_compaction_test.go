@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+)
+
+// TestRelayContextSummary tests that the summary of a context compaction is
+// relayed through the channels of the summary model and billed to the user
+func TestRelayContextSummary(t *testing.T) {
+	setting := operation_setting.GetContextCompactionSetting()
+	summaryModel := setting.SummaryModel
+	defer func() { setting.SummaryModel = summaryModel }()
+	setting.SummaryModel = "gpt-4o-mini"
+
+	var upstreamBody string
+	c := setupInternalRelayTest(t, setting.SummaryModel, func(path string, body string) string {
+		upstreamBody = body
+		return `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o-mini-upstream","choices":[{"index":0,"message":{"role":"assistant","content":"they asked two questions"},"finish_reason":"stop"}],"usage":{"prompt_tokens":900,"completion_tokens":10,"total_tokens":910}}`
+	})
+	common.SetContextKey(c, constant.ContextKeyTokenContextCompaction, operation_setting.ContextCompactionSummarize)
+
+	summary, err := relayContextSummary(c, "default", "user: first question")
+	if err != nil || summary != "they asked two questions" {
+		t.Fatalf("summary = %q, %v", summary, err)
+	}
+	if !strings.Contains(upstreamBody, `"model":"gpt-4o-mini-upstream"`) {
+		t.Error("the model mapping of the channel was not applied")
+	}
+	if common.GetContextKeyString(c, constant.ContextKeyTokenContextCompaction) == "" {
+		t.Error("the summary changed the context of the request")
+	}
+	assertInternalRelayBilled(t)
+}
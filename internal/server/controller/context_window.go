@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextWindowError turns err into the error of a request that does not fit
+// the context window of its model, nil if err is not one.
+func contextWindowError(err error) *types.NewAPIError {
+	var windowErr *model.ContextWindowError
+	if !errors.As(err, &windowErr) {
+		return nil
+	}
+	return types.NewErrorWithStatusCode(windowErr, types.ErrorCodeContextTooLong, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// fitContextWindow checks the estimated tokens of the request against the
+// context window of the channel the distributor routed it to, which selected
+// the channel before the tokens were counted. A request that does not fit is
+// moved to a channel it fits, or to the next model of a virtual model, and
+// fails with an error naming the limit when there is none.
+func fitContextWindow(c *gin.Context, info *relaycommon.RelayInfo, tokens int, meta *types.TokenCountMeta) *types.NewAPIError {
+	if tokens <= 0 {
+		return nil
+	}
+	maxTokens := 0
+	if meta != nil {
+		maxTokens = meta.MaxTokens
+	}
+	service.SetContextWindowDemand(c, tokens, maxTokens)
+
+	channel, err := model.CacheGetChannel(c.GetInt("channel_id"))
+	if err != nil {
+		// the relay reports the missing channel
+		return nil
+	}
+	windowErr := service.GetContextWindowDemand(c).Fits(channel, info.OriginModelName)
+	if windowErr == nil {
+		return nil
+	}
+	if _, specific := c.Get("specific_channel_id"); specific {
+		return contextWindowError(windowErr)
+	}
+
+	logger.LogInfo(c, fmt.Sprintf("channel #%d does not fit the request: %s", channel.Id, windowErr.Error()))
+	retryParam := &service.RetryParam{
+		Ctx:        c,
+		TokenGroup: info.UsingGroup,
+		ModelName:  info.OriginModelName,
+		Retry:      common.GetPointer(0),
+	}
+	for {
+		_, apiErr := selectChannel(c, info, retryParam)
+		if apiErr == nil || !fallbackModelAlias(c, info, retryParam, tokens, meta) {
+			return apiErr
+		}
+	}
+}
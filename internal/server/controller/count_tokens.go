@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/relay"
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countTokens answers Claude count_tokens and Gemini countTokens calls. The
+// count is estimated locally, unless pass-through is enabled and the selected
+// channel counts natively. Nothing is billed.
+func countTokens(c *gin.Context, relayFormat types.RelayFormat) *types.NewAPIError {
+	var (
+		request dto.Request
+		err     error
+	)
+	switch relayFormat {
+	case types.RelayFormatClaude:
+		request, err = helper.GetAndValidateClaudeRequest(c)
+	case types.RelayFormatGemini:
+		request, err = helper.GetAndValidateGeminiCountTokensRequest(c)
+	default:
+		err = fmt.Errorf("token counting is not supported for %s requests", relayFormat)
+	}
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	relayInfo, err := relaycommon.GenRelayInfo(c, relayFormat, request, nil)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
+	}
+
+	if constant.CountTokensPassThroughEnabled {
+		body, err := relay.CountTokensPassThrough(c, relayInfo)
+		if err == nil {
+			c.Data(http.StatusOK, "application/json", body)
+			return nil
+		}
+		if !errors.Is(err, relay.ErrCountTokensNotSupported) {
+			logger.LogWarn(c, "count tokens pass-through failed, counting locally: "+err.Error())
+		}
+	}
+
+	meta := request.GetTokenCountMeta()
+	var tokens int
+	if constant.CountToken {
+		tokens, err = service.EstimateRequestToken(c, meta, relayInfo)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeCountTokenFailed)
+		}
+	} else {
+		tokens = service.CountTextToken(meta.CombineText, relayInfo.OriginModelName)
+	}
+
+	switch relayFormat {
+	case types.RelayFormatClaude:
+		c.JSON(http.StatusOK, gin.H{"input_tokens": tokens})
+	default:
+		c.JSON(http.StatusOK, gin.H{"totalTokens": tokens})
+	}
+	return nil
+}
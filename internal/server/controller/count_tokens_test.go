@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newCountTokensContext(path string, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	return c, recorder
+}
+
+func TestCountTokensIsAnsweredLocally(t *testing.T) {
+	constant.MaxRequestBodyMB = 64
+	c, recorder := newCountTokensContext("/v1/messages/count_tokens",
+		`{"model":"claude-sonnet-4","system":"be brief","messages":[{"role":"user","content":"hello there, how are you today?"}]}`)
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, "claude-sonnet-4")
+	if err := countTokens(c, types.RelayFormatClaude); err != nil {
+		t.Fatal(err)
+	}
+	var claude struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := common.Unmarshal(recorder.Body.Bytes(), &claude); err != nil || claude.InputTokens <= 0 {
+		t.Fatalf("claude body = %s", recorder.Body.String())
+	}
+
+	// Gemini clients may wrap the contents in generateContentRequest
+	c, recorder = newCountTokensContext("/v1beta/models/gemini-2.5-flash:countTokens",
+		`{"generateContentRequest":{"contents":[{"role":"user","parts":[{"text":"hello there, how are you today?"}]}]}}`)
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, "gemini-2.5-flash")
+	if err := countTokens(c, types.RelayFormatGemini); err != nil {
+		t.Fatal(err)
+	}
+	var gemini struct {
+		TotalTokens int `json:"totalTokens"`
+	}
+	if err := common.Unmarshal(recorder.Body.Bytes(), &gemini); err != nil || gemini.TotalTokens <= 0 {
+		t.Fatalf("gemini body = %s", recorder.Body.String())
+	}
+}
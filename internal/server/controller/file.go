@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func openAIErrorResponse(c *gin.Context, statusCode int, errType string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": types.OpenAIError{
+			Message: common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
+			Type:    errType,
+		},
+	})
+}
+
+func parseListLimit(c *gin.Context, defaultLimit int, maxLimit int) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		return defaultLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+// UploadFile handles POST /v1/files
+func UploadFile(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "purpose is required")
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "file is required")
+		return
+	}
+	if header.Size > int64(constant.MaxFileUploadMB)<<20 {
+		openAIErrorResponse(c, http.StatusRequestEntityTooLarge, "invalid_request_error",
+			fmt.Sprintf("file exceeds the maximum upload size of %d MB", constant.MaxFileUploadMB))
+		return
+	}
+	src, err := header.Open()
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "failed to read uploaded file")
+		return
+	}
+	defer src.Close()
+
+	file := &model.UserFile{
+		FileId:    service.NewFileId(),
+		UserId:    c.GetInt("id"),
+		TokenId:   c.GetInt("token_id"),
+		Filename:  header.Filename,
+		Purpose:   purpose,
+		CreatedAt: common.GetTimestamp(),
+	}
+	file.Bytes, err = service.SaveStoredFile(file.FileId, src, int64(constant.MaxFileUploadMB)<<20)
+	if err != nil {
+		if errors.Is(err, service.ErrFileTooLarge) {
+			openAIErrorResponse(c, http.StatusRequestEntityTooLarge, "invalid_request_error", err.Error())
+			return
+		}
+		logger.LogError(c, fmt.Sprintf("failed to store file %s: %s", file.FileId, err.Error()))
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", "failed to store file")
+		return
+	}
+	if err = file.Insert(); err != nil {
+		service.RemoveStoredFile(file.FileId)
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+// ListFiles handles GET /v1/files
+func ListFiles(c *gin.Context) {
+	userId := c.GetInt("id")
+	limit := parseListLimit(c, 10000, 10000)
+	asc := c.Query("order") == "asc"
+	afterId := 0
+	if after := c.Query("after"); after != "" {
+		afterFile, err := model.GetUserFileByFileId(userId, after)
+		if err != nil {
+			openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		if afterFile != nil {
+			afterId = afterFile.Id
+		}
+	}
+	files, err := model.GetUserFiles(userId, c.Query("purpose"), afterId, limit+1, asc)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	resp := dto.OpenAIList[*dto.OpenAIFile]{
+		Object:  "list",
+		Data:    make([]*dto.OpenAIFile, 0, len(files)),
+		HasMore: len(files) > limit,
+	}
+	if resp.HasMore {
+		files = files[:limit]
+	}
+	for _, file := range files {
+		resp.Data = append(resp.Data, file.ToOpenAIFile())
+	}
+	if len(resp.Data) > 0 {
+		resp.FirstID = resp.Data[0].ID
+		resp.LastID = resp.Data[len(resp.Data)-1].ID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func getUserFileOrAbort(c *gin.Context) *model.UserFile {
+	file, err := model.GetUserFileByFileId(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return nil
+	}
+	if file == nil {
+		openAIErrorResponse(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("No such File object: %s", c.Param("id")))
+		return nil
+	}
+	return file
+}
+
+// RetrieveFile handles GET /v1/files/:id
+func RetrieveFile(c *gin.Context) {
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+// DeleteFile handles DELETE /v1/files/:id
+func DeleteFile(c *gin.Context) {
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	if err := file.Delete(); err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	service.RemoveStoredFile(file.FileId)
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleted{
+		ID:      file.FileId,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+// RetrieveFileContent handles GET /v1/files/:id/content
+func RetrieveFileContent(c *gin.Context) {
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.Header("Content-Length", strconv.FormatInt(file.Bytes, 10))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, service.OpenStoredFile(file.FileId)); err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to read stored file %s: %s", file.FileId, err.Error()))
+	}
+}
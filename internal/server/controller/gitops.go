@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetGitOpsStatus returns the state of the configuration file reconciler
+// GET /api/gitops/status
+func GetGitOpsStatus(c *gin.Context) {
+	common.ApiSuccess(c, service.GetGitOpsStatus())
+}
+
+// PlanGitOps lists the changes applying the configuration file would make
+// POST /api/gitops/plan?adopt=true
+func PlanGitOps(c *gin.Context) {
+	reconcileGitOps(c, true)
+}
+
+// ApplyGitOps reconciles the database with the configuration file now
+// POST /api/gitops/apply?adopt=true
+func ApplyGitOps(c *gin.Context) {
+	reconcileGitOps(c, false)
+}
+
+func reconcileGitOps(c *gin.Context, dryRun bool) {
+	adopt := service.GetGitOpsStatus().AdoptExisting
+	if value := c.Query("adopt"); value != "" {
+		adopt = value == "true"
+	}
+	changes, err := service.ReconcileGitOps(adopt, dryRun)
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": err == nil,
+		"message": message,
+		"data":    changes,
+	})
+}
+
+// rejectManagedChannels answers with an error and returns true when one of
+// the channels is managed by the configuration file, see
+// model.GetManagedChannelNames.
+func rejectManagedChannels(c *gin.Context, ids []int, tag string) bool {
+	names, err := model.GetManagedChannelNames(ids, tag)
+	if err != nil {
+		common.ApiError(c, err)
+		return true
+	}
+	if len(names) == 0 {
+		return false
+	}
+	common.ApiErrorMsg(c, fmt.Sprintf("渠道 %s 由配置文件管理，请修改配置文件", strings.Join(names, ", ")))
+	return true
+}
+
+// rejectManagedOption answers with an error and returns true when the option
+// is managed by the configuration file.
+func rejectManagedOption(c *gin.Context, key string) bool {
+	if !service.IsGitOpsManagedOption(key) {
+		return false
+	}
+	common.ApiErrorMsg(c, fmt.Sprintf("设置 %s 由配置文件管理，请修改配置文件", key))
+	return true
+}
@@ -0,0 +1,320 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+	relayconstant "github.com/QuantumNous/lurus-api/internal/biz/relay/constant"
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/helper"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+	"github.com/QuantumNous/lurus-api/internal/server/middleware"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// hedgeChannelAttempts is how often a second channel is drawn before giving up
+// on hedging a request whose group only offers the primary channel.
+const hedgeChannelAttempts = 3
+
+// hedgeDelay returns how long to wait for a first token before the request is
+// hedged on a second channel, 0 when it is not hedged. The token setting takes
+// precedence over the group one.
+func hedgeDelay(c *gin.Context, info *relaycommon.RelayInfo, relayFormat types.RelayFormat) time.Duration {
+	setting := operation_setting.GetHedgingSetting()
+	if !setting.Enabled || !hedgeable(c, info, relayFormat) {
+		return 0
+	}
+	delayMs := common.GetContextKeyInt(c, constant.ContextKeyTokenHedgeDelay)
+	if delayMs <= 0 {
+		delayMs = setting.GroupDelays[info.UsingGroup]
+	}
+	if delayMs <= 0 {
+		return 0
+	}
+	return time.Duration(delayMs) * time.Millisecond
+}
+
+// hedgeable reports whether the request generates text, the only kind of
+// request worth racing on first token latency.
+func hedgeable(c *gin.Context, info *relaycommon.RelayInfo, relayFormat types.RelayFormat) bool {
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	switch relayFormat {
+	case types.RelayFormatClaude:
+		return true
+	case types.RelayFormatGemini:
+		return !strings.Contains(c.Request.URL.Path, "embed")
+	case types.RelayFormatOpenAI, types.RelayFormatOpenAIResponses, types.RelayFormatOllama:
+		switch info.RelayMode {
+		case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions, relayconstant.RelayModeResponses:
+			return true
+		}
+	}
+	return false
+}
+
+// hedgeRace decides which attempt of a hedged request answers the client.
+type hedgeRace struct {
+	winner  atomic.Pointer[hedgeWriter]
+	decided chan struct{}
+	writers []*hedgeWriter
+	record  *relaycommon.HedgeRecord
+}
+
+// hedgeWriter holds back the response of an attempt until it writes its first
+// body bytes. The first attempt to do so wins the race, its headers are sent
+// and the other attempt is cancelled. SSE comments such as pings do not count
+// as an answer and are dropped until the race is decided.
+type hedgeWriter struct {
+	gin.ResponseWriter
+	race      *hedgeRace
+	attempt   *relaycommon.HedgeAttempt
+	channelId int
+	header    http.Header
+	status    int
+}
+
+func (w *hedgeWriter) won() bool {
+	return w.race.winner.Load() == w
+}
+
+func (w *hedgeWriter) claim() bool {
+	if !w.race.winner.CompareAndSwap(nil, w) {
+		return w.won()
+	}
+	header := w.ResponseWriter.Header()
+	for k, v := range w.header {
+		header[k] = v
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	for _, other := range w.race.writers {
+		if other != w {
+			other.attempt.Lose()
+		}
+	}
+	w.race.record.SetWinner(w.channelId)
+	close(w.race.decided)
+	return true
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.won() {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if w.won() {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.won() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if w.race.winner.Load() == nil && bytes.HasPrefix(data, []byte(":")) {
+		return len(data), nil
+	}
+	if !w.claim() {
+		return 0, relaycommon.ErrHedgeLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *hedgeWriter) Written() bool {
+	return w.won() && w.ResponseWriter.Written()
+}
+
+func (w *hedgeWriter) Status() int {
+	if w.won() {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.won() {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.won() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// hedgeRun is one attempt of a hedged request, running on its own copy of the
+// request context and relay info.
+type hedgeRun struct {
+	ctx     *gin.Context
+	info    *relaycommon.RelayInfo
+	writer  *hedgeWriter
+	channel *model.Channel
+	slot    *model.ChannelSlot
+	startAt time.Time
+	err     *types.NewAPIError
+	done    chan struct{}
+}
+
+func newHedgeRun(c *gin.Context, race *hedgeRace, info *relaycommon.RelayInfo) *hedgeRun {
+	ctx := c.Copy()
+	writer := &hedgeWriter{
+		ResponseWriter: c.Writer,
+		race:           race,
+		attempt:        relaycommon.NewHedgeAttempt(ctx, race.record),
+		header:         http.Header{},
+		status:         http.StatusOK,
+	}
+	ctx.Writer = writer
+	race.writers = append(race.writers, writer)
+	return &hedgeRun{ctx: ctx, info: info, writer: writer}
+}
+
+func (r *hedgeRun) start(relayFormat types.RelayFormat, channel *model.Channel, requestBody []byte) {
+	r.channel = channel
+	r.writer.channelId = channel.Id
+	r.writer.race.record.AddChannel(channel.Id)
+	r.ctx.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	r.startAt = time.Now()
+	r.done = make(chan struct{})
+	gopool.Go(func() {
+		defer func() {
+			if p := recover(); p != nil {
+				r.err = types.NewError(fmt.Errorf("panic in hedged request: %v", p), types.ErrorCodeDoRequestFailed)
+			}
+			r.slot.Release()
+			close(r.done)
+		}()
+		r.err = relayAttempt(r.ctx, r.info, relayFormat)
+	})
+}
+
+func (r *hedgeRun) started() bool {
+	return r.done != nil
+}
+
+// selectHedgeChannel picks a channel other than the primary one for the hedge,
+// falling back to the next priority when the current one has no other channel.
+// The hedge never waits for a concurrency slot.
+func (r *hedgeRun) selectHedgeChannel(retryParam *service.RetryParam, primaryId int) *model.Channel {
+	// session affinity would keep drawing the primary channel
+	service.ClearSessionAffinity(r.ctx)
+	param := &service.RetryParam{
+		Ctx:        r.ctx,
+		TokenGroup: retryParam.TokenGroup,
+		ModelName:  retryParam.ModelName,
+		Retry:      common.GetPointer(retryParam.GetRetry()),
+	}
+	for i := 0; i < hedgeChannelAttempts; i++ {
+		if i == hedgeChannelAttempts-1 {
+			param.IncreaseRetry()
+		}
+		channel, _, err := service.CacheGetRandomSatisfiedChannel(param)
+		if err != nil || channel == nil {
+			return nil
+		}
+		if channel.Id == primaryId {
+			continue
+		}
+		if middleware.SetupContextForSelectedChannel(r.ctx, channel, r.info.OriginModelName) != nil {
+			return nil
+		}
+		slot, ok := model.AcquireChannelSlot(channel.Id, channelKeyIndex(r.ctx))
+		if !ok {
+			continue
+		}
+		r.slot = slot
+		r.info.PriceData.GroupRatioInfo = helper.HandleGroupRatio(r.ctx, r.info)
+		addUsedChannel(r.ctx, channel.Id)
+		return channel
+	}
+	return nil
+}
+
+// relayWithHedge relays the request on channel and, if no first token arrived
+// after delay, sends it to a second channel as well. Whichever attempt answers
+// first is streamed to the client and billed, the other one is cancelled. It
+// returns the channel of the attempt that answered, or of the primary attempt
+// if neither did, together with its error.
+func relayWithHedge(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat, retryParam *service.RetryParam, channel *model.Channel, requestBody []byte, delay time.Duration) (*model.Channel, *types.NewAPIError) {
+	race := &hedgeRace{
+		decided: make(chan struct{}),
+		record:  &relaycommon.HedgeRecord{DelayMs: int(delay / time.Millisecond)},
+	}
+	primary := newHedgeRun(c, race, relayInfo)
+	hedge := newHedgeRun(c, race, relayInfo.CloneForHedge())
+	defer primary.writer.attempt.Release()
+	defer hedge.writer.attempt.Release()
+
+	primary.start(relayFormat, channel, requestBody)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-primary.done:
+	case <-race.decided:
+	case <-timer.C:
+		if hedgeChannel := hedge.selectHedgeChannel(retryParam, channel.Id); hedgeChannel != nil {
+			logger.LogInfo(c, fmt.Sprintf("no first token from channel #%d after %s, hedging on channel #%d", channel.Id, delay, hedgeChannel.Id))
+			hedge.start(relayFormat, hedgeChannel, requestBody)
+		}
+	}
+	<-primary.done
+	if hedge.started() {
+		<-hedge.done
+	}
+
+	result := primary
+	if hedge.started() && race.winner.Load() == hedge.writer {
+		result = hedge
+	}
+	for _, run := range []*hedgeRun{primary, hedge} {
+		if !run.started() || run.writer.attempt.Lost() {
+			continue
+		}
+		recordChannelOutcome(run.ctx, run.info, run.channel.Id, run.startAt, run.err)
+		if run == result || run.err == nil {
+			continue
+		}
+		processChannelError(run.ctx, *types.NewChannelError(run.channel.Id, run.channel.Type, run.channel.Name, run.channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(run.ctx, constant.ContextKeyChannelKey), run.channel.GetAutoBan()), run.err)
+	}
+
+	if race.winner.Load() != nil {
+		for k, v := range result.ctx.Keys {
+			c.Set(k, v)
+		}
+	}
+	if result == hedge {
+		*relayInfo = *hedge.info
+	}
+	return result.channel, result.err
+}
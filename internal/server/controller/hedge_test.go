@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	relaycommon "github.com/QuantumNous/lurus-api/internal/biz/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHedgeWriterFirstAnswerWins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	race := &hedgeRace{decided: make(chan struct{}), record: &relaycommon.HedgeRecord{DelayMs: 500}}
+	primary := newHedgeRun(c, race, &relaycommon.RelayInfo{})
+	hedge := newHedgeRun(c, race, &relaycommon.RelayInfo{})
+	primary.writer.channelId = 1
+	hedge.writer.channelId = 2
+	race.record.AddChannel(1)
+	race.record.AddChannel(2)
+
+	primary.ctx.Writer.Header().Set("X-Attempt", "primary")
+	if _, err := primary.ctx.Writer.Write([]byte(": PING\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	if primary.ctx.Writer.Written() || recorder.Body.Len() != 0 {
+		t.Fatal("a ping must not decide the race")
+	}
+
+	hedge.ctx.Writer.Header().Set("X-Attempt", "hedge")
+	hedge.ctx.Writer.WriteHeader(http.StatusCreated)
+	if _, err := hedge.ctx.Writer.Write([]byte("data: {}\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.ctx.Writer.Write([]byte("data: {}\n\n")); err == nil {
+		t.Error("the losing attempt could still write")
+	}
+
+	if !relaycommon.IsHedgeLoser(primary.ctx) || relaycommon.IsHedgeLoser(hedge.ctx) {
+		t.Error("only the primary attempt should be marked as lost")
+	}
+	if cause := context.Cause(primary.ctx.Request.Context()); !errors.Is(cause, relaycommon.ErrHedgeLost) {
+		t.Errorf("loser context cause = %v", cause)
+	}
+	if recorder.Code != http.StatusCreated || recorder.Header().Get("X-Attempt") != "hedge" || recorder.Body.String() != "data: {}\n\n" {
+		t.Errorf("response = %d %v %q", recorder.Code, recorder.Header(), recorder.Body.String())
+	}
+	if info := race.record.LogInfo(); info["winner"] != 2 {
+		t.Errorf("record = %v", info)
+	}
+}
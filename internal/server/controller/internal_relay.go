@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	relayconstant "github.com/QuantumNous/lurus-api/internal/biz/relay/constant"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/types"
+	"github.com/QuantumNous/lurus-api/internal/server/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// internalRelayWriter keeps the response of an internal request.
+type internalRelayWriter struct {
+	*shadowWriter
+	body bytes.Buffer
+}
+
+func (w *internalRelayWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.shadowWriter.Write(data)
+}
+
+func (w *internalRelayWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// relayInternalRequest makes a request the gateway needs to serve the request
+// of c, a context summary or a prompt embedding, as a request of its own
+// through Relay on a copy of the request context, and returns the response
+// body. It is checked against the quota of the user, routed, retried and
+// billed with its own consume log like any other request of the token.
+func relayInternalRequest(c *gin.Context, path string, relayMode int, relayFormat types.RelayFormat, group string, modelName string, payload any) ([]byte, error) {
+	body, err := common.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	ctx := c.Copy()
+	request, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header = c.Request.Header.Clone()
+	request.Header.Set("Content-Type", "application/json")
+	// made for the request at hand, never from or into the response cache
+	request.Header.Set("Cache-Control", "no-cache, no-store")
+	request.Header.Del("Content-Length")
+	ctx.Request = request
+	writer := &internalRelayWriter{shadowWriter: &shadowWriter{header: http.Header{}, status: http.StatusOK}}
+	ctx.Writer = writer
+	ctx.Set(common.KeyRequestBody, body)
+	ctx.Set("use_channel", []string{})
+	ctx.Set("relay_mode", relayMode)
+	// neither compacted itself nor routed like the request
+	common.SetContextKey(ctx, constant.ContextKeyTokenContextCompaction, "")
+	common.SetContextKey(ctx, constant.ContextKeyModelAliasRoute, (*service.ModelAliasRoute)(nil))
+	common.SetContextKey(ctx, constant.ContextKeyUsingGroup, group)
+	common.SetContextKey(ctx, constant.ContextKeyRequestStartTime, time.Now())
+	service.ClearSessionAffinity(ctx)
+
+	retryParam := &service.RetryParam{
+		Ctx:        ctx,
+		ModelName:  modelName,
+		TokenGroup: group,
+		Retry:      common.GetPointer(0),
+	}
+	channel, _, err := service.CacheGetRandomSatisfiedChannel(retryParam)
+	if err != nil {
+		return nil, err
+	}
+	if channel == nil {
+		return nil, fmt.Errorf("no channel available for model %s", modelName)
+	}
+	if apiErr := middleware.SetupContextForSelectedChannel(ctx, channel, modelName); apiErr != nil {
+		return nil, apiErr
+	}
+
+	Relay(ctx, relayFormat)
+	if writer.status != http.StatusOK {
+		return nil, fmt.Errorf("returned status %d: %s", writer.status, writer.body.String())
+	}
+	return writer.body.Bytes(), nil
+}
+
+// relayResponseCacheEmbedding embeds the prompt of a request for the semantic
+// response cache, see relayInternalRequest.
+func relayResponseCacheEmbedding(c *gin.Context, group string, text string) ([]float64, error) {
+	request := service.NewResponseCacheEmbeddingRequest(text)
+	body, err := relayInternalRequest(c, "/v1/embeddings", relayconstant.RelayModeEmbeddings, types.RelayFormatEmbedding, group, request.Model, request)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request: %w", err)
+	}
+	return service.ParseResponseCacheEmbedding(body)
+}
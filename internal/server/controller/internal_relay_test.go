@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+const internalRelayTestQuota = 100000000
+
+// setupInternalRelayTest serves modelName from an OpenAI channel that maps it
+// to modelName-upstream and answers with respond, and returns the context of a
+// request of a user with internalRelayTestQuota. The bodies sent upstream are
+// passed to respond.
+func setupInternalRelayTest(t *testing.T, modelName string, respond func(path string, body string) string) *gin.Context {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Token{}, &model.Log{}, &model.Channel{}, &model.Ability{}, &model.DisabledChannelModel{}); err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevLogDB, prevSQLite, prevRedis, prevCache := model.DB, model.LOG_DB, common.UsingSQLite, common.RedisEnabled, common.MemoryCacheEnabled
+	model.DB, model.LOG_DB, common.UsingSQLite, common.RedisEnabled, common.MemoryCacheEnabled = db, db, true, false, true
+	modelRatio := ratio_setting.ModelRatio2JSONString()
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB, common.UsingSQLite, common.RedisEnabled, common.MemoryCacheEnabled = prevDB, prevLogDB, prevSQLite, prevRedis, prevCache
+		ratio_setting.UpdateModelRatioByJSONString(modelRatio)
+		model.InitChannelCache()
+	})
+	if err := ratio_setting.UpdateModelRatioByJSONString(`{"` + modelName + `":1}`); err != nil {
+		t.Fatal(err)
+	}
+
+	service.InitHttpClient()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, respond(r.URL.Path, string(body)))
+	}))
+	t.Cleanup(upstream.Close)
+
+	db.Create(&model.User{Id: 7, Username: "internal", Status: common.UserStatusEnabled, Quota: internalRelayTestQuota, Group: "default", AffCode: "internal"})
+	mapping := `{"` + modelName + `":"` + modelName + `-upstream"}`
+	channel := &model.Channel{Id: 21, Name: "internal", Type: constant.ChannelTypeOpenAI, Key: "sk-internal", Status: common.ChannelStatusEnabled,
+		Group: "default", Models: modelName, BaseURL: &upstream.URL, ModelMapping: &mapping}
+	if err := db.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(nil); err != nil {
+		t.Fatal(err)
+	}
+	model.InitChannelCache()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	common.SetContextKey(c, constant.ContextKeyUserId, 7)
+	common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyTokenUnlimited, true)
+	return c
+}
+
+func assertInternalRelayBilled(t *testing.T) {
+	t.Helper()
+	quota, err := model.GetUserQuota(7, true)
+	if err != nil || quota >= internalRelayTestQuota {
+		t.Errorf("user quota %d after the request, %v", quota, err)
+	}
+}
+
+// TestRelayResponseCacheEmbedding tests that the embedding of a semantic
+// response cache lookup is relayed through the channels of the semantic model
+// and billed to the user
+func TestRelayResponseCacheEmbedding(t *testing.T) {
+	setting := operation_setting.GetResponseCacheSetting()
+	semanticModel := setting.SemanticModel
+	defer func() { setting.SemanticModel = semanticModel }()
+	setting.SemanticModel = "text-embedding-3-small"
+
+	var path, upstreamBody string
+	c := setupInternalRelayTest(t, setting.SemanticModel, func(p string, body string) string {
+		path, upstreamBody = p, body
+		return `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.5,0.25]}],"model":"text-embedding-3-small-upstream","usage":{"prompt_tokens":12,"total_tokens":12}}`
+	})
+
+	vector, err := relayResponseCacheEmbedding(c, "default", "what is the capital of France")
+	if err != nil || len(vector) != 2 || vector[0] != 0.5 {
+		t.Fatalf("vector = %v, %v", vector, err)
+	}
+	if path != "/v1/embeddings" || !strings.Contains(upstreamBody, `"model":"text-embedding-3-small-upstream"`) {
+		t.Errorf("sent %s %s", path, upstreamBody)
+	}
+	assertInternalRelayBilled(t)
+}
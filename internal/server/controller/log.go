@@ -3,6 +3,7 @@ package controller
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/data/model"
@@ -246,6 +247,27 @@ func GetLogsStat(c *gin.Context) {
 	return
 }
 
+// GetLogsMargin reports what consume logs billed users against what they cost
+// upstream, grouped by the comma separated group_by dimensions (channel,
+// model, group and day by default).
+func GetLogsMargin(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	modelName := c.Query("model_name")
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	group := c.Query("group")
+	dimensions := model.MarginDimensions
+	if groupBy := c.Query("group_by"); groupBy != "" {
+		dimensions = strings.Split(groupBy, ",")
+	}
+	stats, err := model.GetMarginStats(dimensions, startTimestamp, endTimestamp, channel, modelName, group)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, stats)
+}
+
 func GetLogsSelfStat(c *gin.Context) {
 	username := c.GetString("username")
 	logType, _ := strconv.Atoi(c.Query("type"))
@@ -231,6 +231,21 @@ func ListModels(c *gin.Context, modelType int) {
 			"models":        userGeminiModels,
 			"nextPageToken": nil,
 		})
+	case constant.ChannelTypeOllama:
+		userOllamaModels := make([]dto.OllamaModel, len(userOpenAiModels))
+		for i, model := range userOpenAiModels {
+			userOllamaModels[i] = dto.OllamaModel{
+				Name:       model.Id,
+				Model:      model.Id,
+				ModifiedAt: time.Unix(int64(model.Created), 0).UTC().Format(time.RFC3339),
+				Details: dto.OllamaModelDetails{
+					Format:   "api",
+					Family:   model.OwnedBy,
+					Families: []string{model.OwnedBy},
+				},
+			}
+		}
+		c.JSON(200, dto.OllamaTagsResponse{Models: userOllamaModels})
 	default:
 		c.JSON(200, gin.H{
 			"success": true,
@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/relay/channel/ollama"
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// ChannelModelDiscovery is the difference between the models of a channel and
+// the models its upstream lists.
+type ChannelModelDiscovery struct {
+	ChannelId   int      `json:"channel_id"`
+	ChannelName string   `json:"channel_name"`
+	Policy      string   `json:"policy"`
+	Added       []string `json:"added,omitempty"`
+	Removed     []string `json:"removed,omitempty"`
+	Applied     bool     `json:"applied"`
+	Notified    bool     `json:"notified,omitempty"` // 已在之前的运行中通知过相同的变化
+	Error       string   `json:"error,omitempty"`
+}
+
+// changes renders the model changes of a channel to compare them with the
+// ones root was last notified about.
+func (result *ChannelModelDiscovery) changes() string {
+	return "+" + strings.Join(result.Added, ",") + " -" + strings.Join(result.Removed, ",")
+}
+
+// ModelDiscoveryReport is the outcome of a model discovery run. Channels only
+// lists the channels whose models changed or could not be fetched.
+type ModelDiscoveryReport struct {
+	StartedAt     int64                   `json:"started_at"`
+	FinishedAt    int64                   `json:"finished_at"`
+	Checked       int                     `json:"checked"`
+	Channels      []ChannelModelDiscovery `json:"channels"`
+	CreatedModels []string                `json:"created_models"` // 新建了元数据的模型
+	MissingModels []string                `json:"missing_models"` // 运行结束后仍缺少元数据的模型
+}
+
+var modelDiscoveryLock sync.Mutex
+var modelDiscoveryRunning bool
+var lastModelDiscoveryReport *ModelDiscoveryReport
+
+// modelDiscoveryPolicy returns how model changes of a channel are handled.
+func modelDiscoveryPolicy(channel *model.Channel) string {
+	policy := channel.GetSetting().ModelDiscovery
+	if policy == "" {
+		policy = operation_setting.GetMonitorSetting().ModelDiscoveryPolicy
+	}
+	switch policy {
+	case dto.ModelDiscoveryOff, dto.ModelDiscoveryApply:
+		return policy
+	}
+	return dto.ModelDiscoveryNotify
+}
+
+// fetchChannelModelIds fetches the models the upstream of a channel lists.
+func fetchChannelModelIds(channel *model.Channel) ([]string, error) {
+	baseURL := constant.ChannelBaseURLs[channel.Type]
+	if channel.GetBaseURL() != "" {
+		baseURL = channel.GetBaseURL()
+	}
+	if channel.Type != constant.ChannelTypeOllama {
+		return fetchUpstreamModelIds(channel, baseURL)
+	}
+	key := strings.Split(channel.Key, "\n")[0]
+	models, err := ollama.FetchOllamaModels(baseURL, key)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(models))
+	for _, modelInfo := range models {
+		ids = append(ids, modelInfo.Name)
+	}
+	return ids, nil
+}
+
+// diffChannelModels compares the models of a channel with the upstream ones.
+// A model mapped to an upstream model by the model mapping of the channel
+// counts as present, and the upstream model it is mapped to is not new.
+func diffChannelModels(channel *model.Channel, upstream []string) (added []string, removed []string) {
+	mapping := make(map[string]string)
+	if modelMapping := channel.GetModelMapping(); modelMapping != "" && modelMapping != "{}" {
+		_ = common.UnmarshalJsonStr(modelMapping, &mapping)
+	}
+	upstreamSet := make(map[string]bool, len(upstream))
+	for _, id := range upstream {
+		upstreamSet[id] = true
+	}
+
+	known := make(map[string]bool)
+	for _, modelName := range channel.GetModels() {
+		modelName = strings.TrimSpace(modelName)
+		if modelName == "" {
+			continue
+		}
+		target := modelName
+		if mapped := mapping[modelName]; mapped != "" {
+			target = mapped
+		}
+		known[modelName], known[target] = true, true
+		if !upstreamSet[target] {
+			removed = append(removed, modelName)
+		}
+	}
+	for _, id := range upstream {
+		if id != "" && !known[id] {
+			known[id] = true
+			added = append(added, id)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	return added, removed
+}
+
+// applyChannelModelDiscovery removes the models the upstream no longer lists
+// from a channel and adds the new ones.
+func applyChannelModelDiscovery(channel *model.Channel, added []string, removed []string) error {
+	models := make([]string, 0, len(channel.GetModels())+len(added))
+	for _, modelName := range channel.GetModels() {
+		modelName = strings.TrimSpace(modelName)
+		if modelName != "" && !slices.Contains(removed, modelName) {
+			models = append(models, modelName)
+		}
+	}
+	models = append(models, added...)
+	if len(models) == 0 {
+		return errors.New("refusing to remove every model of the channel")
+	}
+	previous := *channel
+	if err := channel.UpdateModels(models); err != nil {
+		return err
+	}
+	return model.RecordChannelRevisions(map[int]*model.Channel{channel.Id: &previous}, model.ChannelRevisionActionModelDiscovery, 0, "model_discovery")
+}
+
+// discoverChannelModels checks the channels that are not disabled manually
+// for upstream model changes, applies or reports them according to the policy
+// of each channel and creates meta entries for the models it added to
+// channels. Changes root was already notified about are marked as such.
+func discoverChannelModels(channels []*model.Channel) *ModelDiscoveryReport {
+	report := &ModelDiscoveryReport{StartedAt: common.GetTimestamp(), Channels: []ChannelModelDiscovery{}}
+	notices, err := model.GetModelDiscoveryNotices()
+	if err != nil {
+		common.SysError("failed to get model discovery notices: " + err.Error())
+	}
+	forget := func(channelId int) {
+		if _, ok := notices[channelId]; !ok {
+			return
+		}
+		if err := model.DeleteModelDiscoveryNotice(channelId); err != nil {
+			common.SysError("failed to delete model discovery notice: " + err.Error())
+		}
+	}
+	var seen []string
+	applied := false
+	for _, channel := range channels {
+		if channel.Status == common.ChannelStatusManuallyDisabled {
+			continue
+		}
+		policy := modelDiscoveryPolicy(channel)
+		if policy == dto.ModelDiscoveryOff {
+			continue
+		}
+		// channels managed by the configuration file get their models from it
+		if policy == dto.ModelDiscoveryApply && channel.ManagedBy != "" {
+			policy = dto.ModelDiscoveryNotify
+		}
+		report.Checked++
+		result := ChannelModelDiscovery{ChannelId: channel.Id, ChannelName: channel.Name, Policy: policy}
+
+		upstream, err := fetchChannelModelIds(channel)
+		if err == nil && len(upstream) == 0 {
+			err = errors.New("upstream lists no models")
+		}
+		if err != nil {
+			result.Error = err.Error()
+			report.Channels = append(report.Channels, result)
+			common.SysLog(fmt.Sprintf("failed to fetch upstream models of channel #%d (%s): %s", channel.Id, channel.Name, err.Error()))
+			time.Sleep(common.RequestInterval)
+			continue
+		}
+		result.Added, result.Removed = diffChannelModels(channel, upstream)
+		if len(result.Added) == 0 && len(result.Removed) == 0 {
+			forget(channel.Id)
+			time.Sleep(common.RequestInterval)
+			continue
+		}
+		if policy == dto.ModelDiscoveryApply {
+			if err := applyChannelModelDiscovery(channel, result.Added, result.Removed); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Applied, applied = true, true
+				seen = append(seen, result.Added...)
+				forget(channel.Id)
+			}
+		}
+		if !result.Applied {
+			notified, ok := notices[channel.Id]
+			result.Notified = ok && notified == result.changes()
+		}
+		report.Channels = append(report.Channels, result)
+		time.Sleep(common.RequestInterval)
+	}
+	if applied {
+		model.InitChannelCache()
+	}
+
+	slices.Sort(seen)
+	created, err := model.CreateModelMetas(slices.Compact(seen))
+	if err != nil {
+		common.SysError("failed to create model metas: " + err.Error())
+	}
+	report.CreatedModels = created
+	if report.MissingModels, err = model.GetMissingModels(); err != nil {
+		common.SysError("failed to get missing models: " + err.Error())
+	}
+	report.FinishedAt = common.GetTimestamp()
+	return report
+}
+
+// modelDiscoveryNotification renders the model changes of a report, or
+// returns an empty string if no channel changed.
+func modelDiscoveryNotification(report *ModelDiscoveryReport) string {
+	var lines []string
+	for _, result := range report.Channels {
+		if len(result.Added) == 0 && len(result.Removed) == 0 || result.Notified {
+			continue
+		}
+		line := fmt.Sprintf("通道「%s」（#%d）", result.ChannelName, result.ChannelId)
+		if len(result.Added) > 0 {
+			line += fmt.Sprintf("，新增模型：%s", strings.Join(result.Added, ", "))
+		}
+		if len(result.Removed) > 0 {
+			line += fmt.Sprintf("，上游已下架：%s", strings.Join(result.Removed, ", "))
+		}
+		if result.Applied {
+			line += "（已自动更新）"
+		} else if result.Error != "" {
+			line += fmt.Sprintf("（自动更新失败：%s）", result.Error)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "<br/>")
+}
+
+func runModelDiscovery() error {
+	modelDiscoveryLock.Lock()
+	if modelDiscoveryRunning {
+		modelDiscoveryLock.Unlock()
+		return errors.New("模型发现已在运行中")
+	}
+	modelDiscoveryRunning = true
+	modelDiscoveryLock.Unlock()
+	defer func() {
+		modelDiscoveryLock.Lock()
+		modelDiscoveryRunning = false
+		modelDiscoveryLock.Unlock()
+	}()
+
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		return err
+	}
+	report := discoverChannelModels(channels)
+	modelDiscoveryLock.Lock()
+	lastModelDiscoveryReport = report
+	modelDiscoveryLock.Unlock()
+
+	if content := modelDiscoveryNotification(report); content != "" {
+		service.NotifyRootUser(dto.NotifyTypeModelDiscovery, "上游模型发生变化", content)
+		saveModelDiscoveryNotices(report)
+	}
+	return nil
+}
+
+// saveModelDiscoveryNotices remembers the changes root was just notified about
+// and that are left for an admin to apply.
+func saveModelDiscoveryNotices(report *ModelDiscoveryReport) {
+	for i := range report.Channels {
+		result := &report.Channels[i]
+		if len(result.Added) == 0 && len(result.Removed) == 0 || result.Applied || result.Notified {
+			continue
+		}
+		if err := model.SaveModelDiscoveryNotice(result.ChannelId, result.changes()); err != nil {
+			common.SysError("failed to save model discovery notice: " + err.Error())
+		}
+	}
+}
+
+// AutomaticallyDiscoverChannelModelsWithContext periodically compares the
+// models of every channel with its upstream, see
+// MonitorSetting.ModelDiscoveryEnabled.
+func AutomaticallyDiscoverChannelModelsWithContext(ctx context.Context) {
+	if !common.IsMasterNode {
+		return
+	}
+
+	for {
+		wait := time.Minute
+		setting := operation_setting.GetMonitorSetting()
+		if setting.ModelDiscoveryEnabled && setting.ModelDiscoveryMinutes > 0 {
+			wait = time.Duration(int(math.Round(setting.ModelDiscoveryMinutes))) * time.Minute
+		}
+		select {
+		case <-ctx.Done():
+			common.SysLog("model discovery stopped")
+			return
+		case <-time.After(wait):
+		}
+		if !operation_setting.GetMonitorSetting().ModelDiscoveryEnabled {
+			continue
+		}
+		common.SysLog("discovering upstream models of all channels")
+		if err := runModelDiscovery(); err != nil {
+			common.SysError("model discovery failed: " + err.Error())
+			continue
+		}
+		common.SysLog("model discovery finished")
+	}
+}
+
+// GetModelDiscoveryReport returns the report of the last model discovery run
+// GET /api/channel/model_discovery
+func GetModelDiscoveryReport(c *gin.Context) {
+	modelDiscoveryLock.Lock()
+	report, running := lastModelDiscoveryReport, modelDiscoveryRunning
+	modelDiscoveryLock.Unlock()
+	common.ApiSuccess(c, gin.H{
+		"running": running,
+		"report":  report,
+	})
+}
+
+// RunModelDiscovery starts a model discovery run in the background
+// POST /api/channel/model_discovery
+func RunModelDiscovery(c *gin.Context) {
+	modelDiscoveryLock.Lock()
+	running := modelDiscoveryRunning
+	modelDiscoveryLock.Unlock()
+	if running {
+		common.ApiErrorMsg(c, "模型发现已在运行中")
+		return
+	}
+	gopool.Go(func() {
+		if err := runModelDiscovery(); err != nil {
+			common.SysError("model discovery failed: " + err.Error())
+		}
+	})
+	common.ApiSuccess(c, nil)
+}
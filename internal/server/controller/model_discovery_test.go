@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDiffChannelModels(t *testing.T) {
+	mapping := `{"gpt-4o-latest":"gpt-4o-2024-11-20"}`
+	channel := &model.Channel{
+		Models:       "gpt-4o,gpt-4o-latest,gpt-4-32k",
+		ModelMapping: &mapping,
+	}
+	upstream := []string{"gpt-4o", "gpt-4o-2024-11-20", "gpt-4.1", "o3"}
+
+	added, removed := diffChannelModels(channel, upstream)
+	if want := []string{"gpt-4.1", "o3"}; !slices.Equal(added, want) {
+		t.Errorf("added = %v, want %v", added, want)
+	}
+	if want := []string{"gpt-4-32k"}; !slices.Equal(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+
+	channel.Models = "gpt-4o,gpt-4o-latest,gpt-4.1,o3"
+	if added, removed := diffChannelModels(channel, upstream); len(added) != 0 || len(removed) != 0 {
+		t.Errorf("expected no changes, got added %v removed %v", added, removed)
+	}
+}
+
+func TestDiscoverChannelModelsNotifiesOnce(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.Channel{}, &model.Ability{}, &model.Model{}, &model.ModelDiscoveryNotice{}); err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevSQLite, prevRedis, prevInterval := model.DB, common.UsingSQLite, common.RedisEnabled, common.RequestInterval
+	model.DB, common.UsingSQLite, common.RedisEnabled, common.RequestInterval = db, true, false, 0
+	defer func() {
+		model.DB, common.UsingSQLite, common.RedisEnabled, common.RequestInterval = prevDB, prevSQLite, prevRedis, prevInterval
+	}()
+
+	upstreamModels := `{"object":"list","data":[{"id":"gpt-4o"},{"id":"gpt-4.1"}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, upstreamModels)
+	}))
+	defer upstream.Close()
+
+	setting := common.GetPointer(`{"model_discovery":"notify"}`)
+	channel := &model.Channel{Id: 1, Name: "openai", Type: constant.ChannelTypeOpenAI, Key: "sk-test", Status: common.ChannelStatusEnabled,
+		Models: "gpt-4o", Group: "default", BaseURL: &upstream.URL, Setting: setting}
+	if err := db.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report := discoverChannelModels([]*model.Channel{channel})
+	if len(report.Channels) != 1 || report.Channels[0].Notified || modelDiscoveryNotification(report) == "" {
+		t.Fatalf("first run: %+v", report.Channels)
+	}
+	if len(report.CreatedModels) != 0 {
+		t.Errorf("created metas %v for models that were not applied", report.CreatedModels)
+	}
+	saveModelDiscoveryNotices(report)
+
+	report = discoverChannelModels([]*model.Channel{channel})
+	if len(report.Channels) != 1 || !report.Channels[0].Notified || modelDiscoveryNotification(report) != "" {
+		t.Fatalf("the same changes were notified again: %+v", report.Channels)
+	}
+
+	// a change that goes away is notified again when it comes back
+	upstreamModels = `{"object":"list","data":[{"id":"gpt-4o"}]}`
+	discoverChannelModels([]*model.Channel{channel})
+	upstreamModels = `{"object":"list","data":[{"id":"gpt-4o"},{"id":"gpt-4.1"}]}`
+	if report = discoverChannelModels([]*model.Channel{channel}); modelDiscoveryNotification(report) == "" {
+		t.Fatalf("a returning change was not notified: %+v", report.Channels)
+	}
+}
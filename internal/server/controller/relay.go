@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
@@ -62,6 +63,21 @@ func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewA
 	return err
 }
 
+func relayAttempt(c *gin.Context, info *relaycommon.RelayInfo, relayFormat types.RelayFormat) *types.NewAPIError {
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		return relay.WssHelper(c, info)
+	case types.RelayFormatClaude:
+		return relay.ClaudeHelper(c, info)
+	case types.RelayFormatGemini:
+		return geminiRelayHandler(c, info)
+	case types.RelayFormatOllama:
+		return relay.OllamaHelper(c, info)
+	default:
+		return relayHandler(c, info)
+	}
+}
+
 func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	requestId := c.GetString(common.RequestIdKey)
@@ -87,22 +103,39 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		if newAPIError != nil {
 			logger.LogError(c, fmt.Sprintf("relay error: %s", newAPIError.Error()))
 			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
+			var body gin.H
 			switch relayFormat {
 			case types.RelayFormatOpenAIRealtime:
 				helper.WssError(c, ws, newAPIError.ToOpenAIError())
+				return
 			case types.RelayFormatClaude:
-				c.JSON(newAPIError.StatusCode, gin.H{
+				body = gin.H{
 					"type":  "error",
 					"error": newAPIError.ToClaudeError(),
-				})
+				}
+			case types.RelayFormatOllama:
+				body = gin.H{
+					"error": newAPIError.Error(),
+				}
 			default:
-				c.JSON(newAPIError.StatusCode, gin.H{
+				body = gin.H{
 					"error": newAPIError.ToOpenAIError(),
-				})
+				}
 			}
+			if c.Writer.Written() {
+				// 流已开始（如已发送 ping），状态码无法更改，错误作为 SSE 事件写出
+				_ = helper.ObjectData(c, body)
+				return
+			}
+			c.JSON(newAPIError.StatusCode, body)
 		}
 	}()
 
+	if relayconstant.Path2RelayMode(c.Request.URL.Path) == relayconstant.RelayModeCountTokens {
+		newAPIError = countTokens(c, relayFormat)
+		return
+	}
+
 	request, err := helper.GetAndValidateRequest(c, relayFormat)
 	if err != nil {
 		// Map "request body too large" to 413 so clients can handle it correctly
@@ -147,6 +180,14 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	relayInfo.SetEstimatePromptTokens(tokens)
 
+	if newAPIError = fitContextWindow(c, relayInfo, tokens, meta); newAPIError != nil {
+		// a token opted in to compaction shortens the prompt to fit instead
+		if tokens, meta, newAPIError = compactContext(c, relayInfo, request, newAPIError); newAPIError != nil {
+			return
+		}
+		relayInfo.SetEstimatePromptTokens(tokens)
+	}
+
 	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeModelPriceError)
@@ -171,6 +212,10 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
+	if relay.ServeResponseCache(c, relayInfo, request, relayResponseCacheEmbedding) {
+		return
+	}
+
 	retryParam := &service.RetryParam{
 		Ctx:        c,
 		TokenGroup: relayInfo.TokenGroup,
@@ -178,8 +223,24 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		Retry:      common.GetPointer(0),
 	}
 
+	var slot *model.ChannelSlot
+	defer func() {
+		slot.Release()
+	}()
+	mirrored := false
+
 	for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
 		channel, channelErr := getChannel(c, relayInfo, retryParam)
+		if channelErr != nil && fallbackModelAlias(c, relayInfo, retryParam, tokens, meta) {
+			logger.LogWarn(c, channelErr.Error())
+			continue
+		}
+		if channelErr != nil {
+			logger.LogError(c, channelErr.Error())
+			newAPIError = channelErr
+			break
+		}
+		channel, slot, channelErr = acquireChannelSlot(c, relayInfo, retryParam, channel)
 		if channelErr != nil {
 			logger.LogError(c, channelErr.Error())
 			newAPIError = channelErr
@@ -199,25 +260,34 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
+		if !mirrored {
+			mirrored = true
+			mirrorToShadowChannels(c, relayInfo, relayFormat, requestBody)
 		}
 
+		if delay := hedgeDelay(c, relayInfo, relayFormat); delay > 0 {
+			channel, newAPIError = relayWithHedge(c, relayInfo, relayFormat, retryParam, channel, requestBody, delay)
+		} else {
+			attemptStart := time.Now()
+			newAPIError = relayAttempt(c, relayInfo, relayFormat)
+			recordChannelOutcome(c, relayInfo, channel.Id, attemptStart, newAPIError)
+		}
+		slot.Release()
+
 		if newAPIError == nil {
 			return
 		}
 
 		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		// the retries leave the channel and key of the conversation
+		service.ClearSessionAffinity(c)
 
 		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
-			break
+			// the retries of the model are used up, a virtual model goes on
+			// with its next target
+			if !shouldRetry(c, newAPIError, 1) || !fallbackModelAlias(c, relayInfo, retryParam, tokens, meta) {
+				break
+			}
 		}
 	}
 
@@ -228,6 +298,29 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	}
 }
 
+// fallbackModelAlias moves a request for a virtual model to the next target
+// of the model it can be priced for. It returns false when the request was not
+// made for a virtual model or its targets are exhausted.
+func fallbackModelAlias(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service.RetryParam, tokens int, meta *types.TokenCountMeta) bool {
+	route := service.GetModelAliasRoute(c)
+	if route == nil {
+		return false
+	}
+	for route.Next(c, retryParam) {
+		info.OriginModelName = route.Model()
+		info.UsingGroup = common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+		if _, err := helper.ModelPriceHelper(c, info, tokens, meta); err != nil {
+			logger.LogWarn(c, fmt.Sprintf("model %s skips its target %s: %s", route.Alias, info.OriginModelName, err.Error()))
+			continue
+		}
+		// the next attempt starts over at the highest priority of the target
+		retryParam.ResetRetryNextTry()
+		logger.LogInfo(c, fmt.Sprintf("model %s falls back to %s in group %s", route.Alias, info.OriginModelName, retryParam.TokenGroup))
+		return true
+	}
+	return false
+}
+
 var upgrader = websocket.Upgrader{
 	Subprotocols: []string{"realtime"}, // WS 握手支持的协议，如果有使用 Sec-WebSocket-Protocol，则必须在此声明对应的 Protocol TODO add other protocol
 	CheckOrigin: func(r *http.Request) bool {
@@ -282,10 +375,18 @@ func getChannel(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service
 			AutoBan: &autoBanInt,
 		}, nil
 	}
+	return selectChannel(c, info, retryParam)
+}
+
+// selectChannel draws a channel for the request and sets up the context for it.
+func selectChannel(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service.RetryParam) (*model.Channel, *types.NewAPIError) {
 	channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(retryParam)
 
 	info.PriceData.GroupRatioInfo = helper.HandleGroupRatio(c, info)
 
+	if windowErr := contextWindowError(err); windowErr != nil {
+		return nil, windowErr
+	}
 	if err != nil {
 		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, info.OriginModelName, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
@@ -342,15 +443,77 @@ func shouldRetry(c *gin.Context, openaiErr *types.NewAPIError, retryTimes int) b
 	return true
 }
 
+// recordChannelOutcome feeds the outcome of an attempt into the live channel
+// statistics used by adaptive channel selection and into the circuit breakers.
+// Errors caused by the request itself and responses served from the cache say
+// nothing about the channel.
+func recordChannelOutcome(c *gin.Context, info *relaycommon.RelayInfo, channelId int, start time.Time, err *types.NewAPIError) {
+	keyIndex := channelKeyIndex(c)
+	if err != nil {
+		model.RecordChannelMetric(channelId, info.OriginModelName, time.Since(start), 0, channelErrorClass(err))
+		if types.IsChannelError(err) || (!types.IsSkipRetryError(err) && (err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusRequestTimeout || err.StatusCode/100 == 5)) {
+			model.RecordChannelFailure(channelId, keyIndex)
+			// a key cooling down after an advertised rate limit is already skipped
+			if !(err.StatusCode == http.StatusTooManyRequests && common.GetContextKeyBool(c, constant.ContextKeyUpstreamCooldown)) {
+				model.RecordCircuitBreakerFailure(channelId, keyIndex)
+			}
+		}
+		return
+	}
+	if common.GetContextKeyString(c, constant.ContextKeyResponseCacheHit) != "" {
+		return
+	}
+	model.RecordCircuitBreakerSuccess(channelId, keyIndex)
+	var firstToken time.Duration
+	if info.IsStream && info.FirstResponseTime.After(start) {
+		firstToken = info.FirstResponseTime.Sub(start)
+	}
+	latency := time.Since(start)
+	model.RecordChannelSuccess(channelId, keyIndex, latency, firstToken)
+	model.RecordChannelMetric(channelId, info.OriginModelName, latency, firstToken, "")
+}
+
+// channelErrorClass buckets a relay error for the channel metrics.
+func channelErrorClass(err *types.NewAPIError) string {
+	switch {
+	case err.StatusCode == http.StatusTooManyRequests:
+		return "rate_limit"
+	case err.StatusCode == http.StatusRequestTimeout || err.StatusCode == http.StatusGatewayTimeout ||
+		err.GetErrorCode() == types.ErrorCodeChannelResponseTimeExceeded || err.GetErrorCode() == types.ErrorCodeStreamFirstTokenFailed:
+		return "timeout"
+	case err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden:
+		return "auth"
+	case err.GetErrorCode() == types.ErrorCodeDoRequestFailed:
+		return "network"
+	case types.IsChannelError(err):
+		return "channel"
+	case err.StatusCode/100 == 5:
+		return "server"
+	case err.StatusCode/100 == 4:
+		return "client"
+	}
+	return "other"
+}
+
 func processChannelError(c *gin.Context, channelError types.ChannelError, err *types.NewAPIError) {
 	logger.LogError(c, fmt.Sprintf("channel error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
-	if service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan {
+	// 上游给出了限流恢复时间的 429 只冷却对应的Key，不禁用渠道
+	cooling := err.StatusCode == http.StatusTooManyRequests && common.GetContextKeyBool(c, constant.ContextKeyUpstreamCooldown)
+	if service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan && !cooling {
 		gopool.Go(func() {
 			service.DisableChannel(channelError, err.Error())
 		})
 	}
+	if channelError.IsMultiKey && channelError.UsingKey != "" {
+		message := err.MaskSensitiveError()
+		gopool.Go(func() {
+			if recordErr := model.RecordChannelKeyError(channelError.ChannelId, channelError.UsingKey, message); recordErr != nil {
+				common.SysLog(fmt.Sprintf("failed to record channel key error: channel_id=%d, error=%v", channelError.ChannelId, recordErr))
+			}
+		})
+	}
 
 	if constant.ErrorLogEnabled && types.IsRecordErrorLog(err) {
 		// 保存错误日志到mysql中
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/common"
+	"github.com/QuantumNous/lurus-api/internal/pkg/dto"
+	"github.com/QuantumNous/lurus-api/internal/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getStoredResponseOrAbort(c *gin.Context) (*model.StoredResponse, *service.StoredResponseContent) {
+	stored, err := model.GetStoredResponse(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return nil, nil
+	}
+	if stored == nil {
+		openAIErrorResponse(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("Response with id '%s' not found.", c.Param("id")))
+		return nil, nil
+	}
+	content, err := service.LoadStoredResponse(stored)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to load stored response %s: %s", stored.ResponseId, err.Error()))
+		openAIErrorResponse(c, http.StatusNotFound, "invalid_request_error", "response content is not available")
+		return nil, nil
+	}
+	return stored, content
+}
+
+// RetrieveResponse handles GET /v1/responses/:id
+func RetrieveResponse(c *gin.Context) {
+	_, content := getStoredResponseOrAbort(c)
+	if content == nil {
+		return
+	}
+	c.Data(http.StatusOK, "application/json", content.Response)
+}
+
+// DeleteResponse handles DELETE /v1/responses/:id
+func DeleteResponse(c *gin.Context) {
+	stored, err := model.GetStoredResponse(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	if stored == nil {
+		openAIErrorResponse(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("Response with id '%s' not found.", c.Param("id")))
+		return
+	}
+	if err = service.DeleteStoredResponse(stored); err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      stored.ResponseId,
+		"object":  "response",
+		"deleted": true,
+	})
+}
+
+// ListResponseInputItems handles GET /v1/responses/:id/input_items
+func ListResponseInputItems(c *gin.Context) {
+	stored, content := getStoredResponseOrAbort(c)
+	if content == nil {
+		return
+	}
+	items := make([]map[string]any, 0, len(content.Input))
+	for i, raw := range content.Input {
+		var item map[string]any
+		if err := common.Unmarshal(raw, &item); err != nil {
+			continue
+		}
+		// plain input messages carry no id, give them a stable one
+		if id, _ := item["id"].(string); id == "" {
+			item["id"] = fmt.Sprintf("msg_%s_%d", stored.ResponseId, i)
+		}
+		items = append(items, item)
+	}
+	// default order is newest first, like the upstream API
+	if c.Query("order") != "asc" {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	if after := c.Query("after"); after != "" {
+		for i, item := range items {
+			if item["id"] == after {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+	limit := parseListLimit(c, 20, 100)
+	resp := dto.OpenAIList[map[string]any]{
+		Object:  "list",
+		Data:    items,
+		HasMore: len(items) > limit,
+	}
+	if resp.HasMore {
+		resp.Data = items[:limit]
+	}
+	if len(resp.Data) > 0 {
+		resp.FirstID, _ = resp.Data[0]["id"].(string)
+		resp.LastID, _ = resp.Data[len(resp.Data)-1]["id"].(string)
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -368,6 +368,9 @@ func AdminUpdateSubscriptionPlans(c *gin.Context) {
 		})
 		return
 	}
+	if rejectManagedOption(c, "SubscriptionPlans") {
+		return
+	}
 
 	if err := model.UpdateSubscriptionPlans(plans); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
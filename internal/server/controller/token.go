@@ -8,6 +8,7 @@ import (
 
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/data/model"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -150,6 +151,13 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if !operation_setting.IsValidContextCompaction(token.ContextCompaction) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的上下文压缩方式",
+		})
+		return
+	}
 	// 非无限额度时，检查额度值是否超出有效范围
 	if !token.UnlimitedQuota {
 		if token.RemainQuota < 0 {
@@ -191,6 +199,9 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
+		ResponseCache:      token.ResponseCache,
+		HedgeDelayMs:       token.HedgeDelayMs,
+		ContextCompaction:  token.ContextCompaction,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -235,6 +246,13 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if !operation_setting.IsValidContextCompaction(token.ContextCompaction) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的上下文压缩方式",
+		})
+		return
+	}
 	if !token.UnlimitedQuota {
 		if token.RemainQuota < 0 {
 			c.JSON(http.StatusOK, gin.H{
@@ -286,6 +304,9 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		cleanToken.ResponseCache = token.ResponseCache
+		cleanToken.HedgeDelayMs = token.HedgeDelayMs
+		cleanToken.ContextCompaction = token.ContextCompaction
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -325,6 +325,9 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	common.SetContextKey(c, constant.ContextKeyTokenResponseCache, token.ResponseCache)
+	common.SetContextKey(c, constant.ContextKeyTokenHedgeDelay, token.HedgeDelayMs)
+	common.SetContextKey(c, constant.ContextKeyTokenContextCompaction, token.ContextCompaction)
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
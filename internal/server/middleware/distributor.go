@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/lurus-api/internal/data/model"
 	relayconstant "github.com/QuantumNous/lurus-api/internal/biz/relay/constant"
 	"github.com/QuantumNous/lurus-api/internal/biz/service"
+	"github.com/QuantumNous/lurus-api/internal/pkg/setting/model_setting"
 	"github.com/QuantumNous/lurus-api/internal/pkg/setting/ratio_setting"
 	"github.com/QuantumNous/lurus-api/internal/pkg/types"
 
@@ -35,6 +36,9 @@ func Distribute() func(c *gin.Context) {
 			abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
 			return
 		}
+		if shouldSelectChannel {
+			service.SetupSessionAffinity(c)
+		}
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
 			if err != nil {
@@ -50,6 +54,12 @@ func Distribute() func(c *gin.Context) {
 				abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道已被禁用")
 				return
 			}
+			if alias := model_setting.GetModelAlias(modelRequest.Model); alias != nil {
+				// the channel is fixed, a virtual model is served by its first target
+				route := service.NewModelAliasRoute(c, alias, common.GetContextKeyString(c, constant.ContextKeyUsingGroup))
+				route.Next(c, &service.RetryParam{Ctx: c})
+				modelRequest.Model = route.Model()
+			}
 		} else {
 			// Select a channel for the user
 			// check token model mapping
@@ -97,12 +107,26 @@ func Distribute() func(c *gin.Context) {
 						common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
 					}
 				}
-				channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
+				retryParam := &service.RetryParam{
 					Ctx:        c,
 					ModelName:  modelRequest.Model,
 					TokenGroup: usingGroup,
 					Retry:      common.GetPointer(0),
-				})
+				}
+				if alias := model_setting.GetModelAlias(modelRequest.Model); alias != nil {
+					// a virtual model is served by the first of its targets with an
+					// available channel
+					route := service.NewModelAliasRoute(c, alias, usingGroup)
+					for route.Next(c, retryParam) {
+						channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(retryParam)
+						if err == nil && channel != nil {
+							modelRequest.Model = route.Model()
+							break
+						}
+					}
+				} else {
+					channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(retryParam)
+				}
 				if err != nil {
 					showGroup := usingGroup
 					if usingGroup == "auto" {
@@ -323,7 +347,7 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	common.SetContextKey(c, constant.ContextKeyChannelModelMapping, channel.GetModelMapping())
 	common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, channel.GetStatusCodeMapping())
 
-	key, index, newAPIError := channel.GetNextEnabledKey()
+	key, index, newAPIError := channel.GetAffinityEnabledKey(service.GetSelectedGroupAffinityKey(c))
 	if newAPIError != nil {
 		return newAPIError
 	}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	relayconstant "github.com/QuantumNous/lurus-api/internal/biz/relay/constant"
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/QuantumNous/lurus-api/internal/pkg/common/limiter"
 	"github.com/QuantumNous/lurus-api/internal/pkg/constant"
@@ -171,6 +172,11 @@ func ModelRequestRateLimit() func(c *gin.Context) {
 			c.Next()
 			return
 		}
+		// count_tokens 不计费，由 CountTokensRateLimit 单独限流
+		if relayconstant.Path2RelayMode(c.Request.URL.Path) == relayconstant.RelayModeCountTokens {
+			c.Next()
+			return
+		}
 
 		// 计算限流参数
 		duration := int64(setting.ModelRequestRateLimitDurationMinutes * 60)
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	relayconstant "github.com/QuantumNous/lurus-api/internal/biz/relay/constant"
 	"github.com/QuantumNous/lurus-api/internal/pkg/common"
 	"github.com/gin-gonic/gin"
 )
@@ -18,10 +20,10 @@ var defNext = func(c *gin.Context) {
 	c.Next()
 }
 
-func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
+func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string, subject string) {
 	ctx := context.Background()
 	rdb := common.RDB
-	key := "rateLimit:" + mark + c.ClientIP()
+	key := "rateLimit:" + mark + subject
 	listLength, err := rdb.LLen(ctx, key).Result()
 	if err != nil {
 		fmt.Println(err.Error())
@@ -64,8 +66,8 @@ func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark st
 	}
 }
 
-func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
-	key := mark + c.ClientIP()
+func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string, subject string) {
+	key := mark + subject
 	if !inMemoryRateLimiter.Request(key, maxRequestNum, duration) {
 		c.Status(http.StatusTooManyRequests)
 		c.Abort()
@@ -74,15 +76,23 @@ func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark s
 }
 
 func rateLimitFactory(maxRequestNum int, duration int64, mark string) func(c *gin.Context) {
+	return subjectRateLimitFactory(maxRequestNum, duration, mark, func(c *gin.Context) string {
+		return c.ClientIP()
+	})
+}
+
+// subjectRateLimitFactory limits requests per subject, such as a client IP
+// or an API token.
+func subjectRateLimitFactory(maxRequestNum int, duration int64, mark string, subject func(c *gin.Context) string) func(c *gin.Context) {
 	if common.RedisEnabled {
 		return func(c *gin.Context) {
-			redisRateLimiter(c, maxRequestNum, duration, mark)
+			redisRateLimiter(c, maxRequestNum, duration, mark, subject(c))
 		}
 	} else {
 		// It's safe to call multi times.
 		inMemoryRateLimiter.Init(common.RateLimitKeyExpirationDuration)
 		return func(c *gin.Context) {
-			memoryRateLimiter(c, maxRequestNum, duration, mark)
+			memoryRateLimiter(c, maxRequestNum, duration, mark, subject(c))
 		}
 	}
 }
@@ -115,3 +125,17 @@ func DownloadRateLimit() func(c *gin.Context) {
 func UploadRateLimit() func(c *gin.Context) {
 	return rateLimitFactory(common.UploadRateLimitNum, common.UploadRateLimitDuration, "UP")
 }
+
+// CountTokensRateLimit limits count_tokens calls per API token. They are not
+// billed, so this is the only thing bounding them. Other requests pass.
+func CountTokensRateLimit() func(c *gin.Context) {
+	limiter := subjectRateLimitFactory(common.CountTokensRateLimitNum, common.CountTokensRateLimitDuration, "CTK", func(c *gin.Context) string {
+		return strconv.Itoa(c.GetInt("token_id"))
+	})
+	return func(c *gin.Context) {
+		if relayconstant.Path2RelayMode(c.Request.URL.Path) != relayconstant.RelayModeCountTokens {
+			return
+		}
+		limiter(c)
+	}
+}
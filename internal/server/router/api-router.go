@@ -167,6 +167,13 @@ func SetApiRouter(router *gin.Engine) {
 			invitationRoute.DELETE("/cleanup", controller.AdminCleanupExpiredInviteCodes)
 			invitationRoute.DELETE("/:id", controller.AdminDeleteInviteCode)
 		}
+		gitOpsRoute := apiRouter.Group("/gitops")
+		gitOpsRoute.Use(middleware.RootAuth())
+		{
+			gitOpsRoute.GET("/status", controller.GetGitOpsStatus)
+			gitOpsRoute.POST("/plan", controller.PlanGitOps)
+			gitOpsRoute.POST("/apply", controller.ApplyGitOps)
+		}
 		ratioSyncRoute := apiRouter.Group("/ratio_sync")
 		ratioSyncRoute.Use(middleware.RootAuth())
 		{
@@ -180,10 +187,17 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/health", controller.GetChannelHealth)
+			channelRoute.GET("/rollout/stats", controller.GetChannelRolloutStats)
 			channelRoute.GET("/:id", controller.GetChannel)
+			channelRoute.GET("/:id/metrics", controller.GetChannelMetrics)
+			channelRoute.GET("/:id/revisions", controller.GetChannelRevisions)
+			channelRoute.POST("/:id/rollback", controller.RollbackChannel)
 			channelRoute.POST("/:id/key", middleware.RootAuth(), middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
+			channelRoute.GET("/:id/test_results", controller.GetChannelTestResults)
+			channelRoute.GET("/:id/test_results/latest", controller.GetChannelTestSummary)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
 			channelRoute.POST("/", controller.AddChannel)
@@ -192,11 +206,14 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/tag/disabled", controller.DisableTagChannels)
 			channelRoute.POST("/tag/enabled", controller.EnableTagChannels)
 			channelRoute.PUT("/tag", controller.EditTagChannels)
+			channelRoute.POST("/tag/rollback", controller.RollbackTagChannels)
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
 			channelRoute.POST("/batch", controller.DeleteChannelBatch)
 			channelRoute.POST("/fix", controller.FixChannelsAbilities)
 			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
 			channelRoute.POST("/fetch_models", controller.FetchModels)
+			channelRoute.GET("/model_discovery", controller.GetModelDiscoveryReport)
+			channelRoute.POST("/model_discovery", controller.RunModelDiscovery)
 			channelRoute.POST("/ollama/pull", controller.OllamaPullModel)
 			channelRoute.POST("/ollama/pull/stream", controller.OllamaPullModelStream)
 			channelRoute.DELETE("/ollama/delete", controller.OllamaDeleteModel)
@@ -243,6 +260,7 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/margin", middleware.AdminAuth(), controller.GetLogsMargin)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
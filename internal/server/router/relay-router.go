@@ -62,6 +62,7 @@ func SetRelayRouter(router *gin.Engine) {
 	}
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.TokenAuth())
+	relayV1Router.Use(middleware.CountTokensRateLimit())
 	relayV1Router.Use(middleware.ModelRequestRateLimit())
 	{
 		// WebSocket 路由（统一到 Relay）
@@ -71,6 +72,24 @@ func SetRelayRouter(router *gin.Engine) {
 			controller.Relay(c, types.RelayFormatOpenAIRealtime)
 		})
 	}
+	{
+		// files, batches and stored responses are served by the gateway itself, no channel is selected
+		fileRouter := relayV1Router.Group("")
+		fileRouter.GET("/files", controller.ListFiles)
+		fileRouter.POST("/files", controller.UploadFile)
+		fileRouter.GET("/files/:id", controller.RetrieveFile)
+		fileRouter.DELETE("/files/:id", controller.DeleteFile)
+		fileRouter.GET("/files/:id/content", controller.RetrieveFileContent)
+
+		fileRouter.POST("/batches", controller.CreateBatch)
+		fileRouter.GET("/batches", controller.ListBatches)
+		fileRouter.GET("/batches/:id", controller.RetrieveBatch)
+		fileRouter.POST("/batches/:id/cancel", controller.CancelBatch)
+
+		fileRouter.GET("/responses/:id", controller.RetrieveResponse)
+		fileRouter.DELETE("/responses/:id", controller.DeleteResponse)
+		fileRouter.GET("/responses/:id/input_items", controller.ListResponseInputItems)
+	}
 	{
 		//http router
 		httpRouter := relayV1Router.Group("")
@@ -80,6 +99,9 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/messages", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatClaude)
 		})
+		httpRouter.POST("/messages/count_tokens", func(c *gin.Context) {
+			controller.Relay(c, types.RelayFormatClaude)
+		})
 
 		// chat related routes
 		httpRouter.POST("/completions", func(c *gin.Context) {
@@ -141,11 +163,6 @@ func SetRelayRouter(router *gin.Engine) {
 
 		// not implemented
 		httpRouter.POST("/images/variations", controller.RelayNotImplemented)
-		httpRouter.GET("/files", controller.RelayNotImplemented)
-		httpRouter.POST("/files", controller.RelayNotImplemented)
-		httpRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id/content", controller.RelayNotImplemented)
 		httpRouter.POST("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes/:id", controller.RelayNotImplemented)
@@ -169,8 +186,31 @@ func SetRelayRouter(router *gin.Engine) {
 		relaySunoRouter.GET("/fetch/:id", controller.RelayTask)
 	}
 
+	// Ollama compatible routes, /api/tags lists the models the token may use
+	relayOllamaRouter := router.Group("/api")
+	relayOllamaRouter.Use(middleware.TokenAuth())
+	{
+		relayOllamaRouter.GET("/tags", func(c *gin.Context) {
+			controller.ListModels(c, constant.ChannelTypeOllama)
+		})
+
+		httpRouter := relayOllamaRouter.Group("")
+		httpRouter.Use(middleware.ModelRequestRateLimit())
+		httpRouter.Use(middleware.Distribute())
+		httpRouter.POST("/chat", func(c *gin.Context) {
+			controller.Relay(c, types.RelayFormatOllama)
+		})
+		httpRouter.POST("/generate", func(c *gin.Context) {
+			controller.Relay(c, types.RelayFormatOllama)
+		})
+		httpRouter.POST("/embed", func(c *gin.Context) {
+			controller.Relay(c, types.RelayFormatOllama)
+		})
+	}
+
 	relayGeminiRouter := router.Group("/v1beta")
 	relayGeminiRouter.Use(middleware.TokenAuth())
+	relayGeminiRouter.Use(middleware.CountTokensRateLimit())
 	relayGeminiRouter.Use(middleware.ModelRequestRateLimit())
 	relayGeminiRouter.Use(middleware.Distribute())
 	{
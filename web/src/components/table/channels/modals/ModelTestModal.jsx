@@ -17,18 +17,25 @@ along with this program. If not, see <https://www.gnu.org/licenses/>.
 For commercial licensing, please contact support@quantumnous.com
 */
 
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import {
   Modal,
   Button,
   Input,
   Table,
   Tag,
+  Tooltip,
   Typography,
   Select,
 } from '@douyinfe/semi-ui';
 import { IconSearch } from '@douyinfe/semi-icons';
-import { copy, showError, showInfo, showSuccess } from '../../../../helpers';
+import {
+  API,
+  copy,
+  showError,
+  showInfo,
+  showSuccess,
+} from '../../../../helpers';
 import { MODEL_TABLE_PAGE_SIZE } from '../../../../constants';
 
 const ModelTestModal = ({
@@ -53,6 +60,34 @@ const ModelTestModal = ({
   t,
 }) => {
   const hasChannel = Boolean(currentTestChannel);
+  const [profileResults, setProfileResults] = useState({});
+  const [disabledModels, setDisabledModels] = useState(new Set());
+
+  // 加载测试方案的最近结果与被单独禁用的模型
+  useEffect(() => {
+    if (!showModelTestModal || !currentTestChannel || isBatchTesting) return;
+    const loadTestSummary = async () => {
+      try {
+        const res = await API.get(
+          `/api/channel/${currentTestChannel.id}/test_results/latest`,
+        );
+        const { success, data } = res.data;
+        if (!success) return;
+        const results = {};
+        (data.results || []).forEach((result) => {
+          if (!results[result.model_name]) {
+            results[result.model_name] = [];
+          }
+          results[result.model_name].push(result);
+        });
+        setProfileResults(results);
+        setDisabledModels(new Set(data.disabled_models || []));
+      } catch (error) {
+        // 测试结果仅作展示，加载失败时忽略
+      }
+    };
+    loadTestSummary();
+  }, [showModelTestModal, currentTestChannel, isBatchTesting]);
 
   const filteredModels = hasChannel
     ? currentTestChannel.models
@@ -164,6 +199,33 @@ const ModelTestModal = ({
         );
       },
     },
+    {
+      title: t('最近测试'),
+      dataIndex: 'profiles',
+      render: (text, record) => (
+        <div className='flex flex-wrap items-center gap-1'>
+          {disabledModels.has(record.model) && (
+            <Tag color='red' shape='circle'>
+              {t('已禁用')}
+            </Tag>
+          )}
+          {(profileResults[record.model] || []).map((result) => (
+            <Tooltip
+              key={result.profile}
+              content={
+                result.success
+                  ? `${result.latency}ms`
+                  : result.failures || result.message
+              }
+            >
+              <Tag color={result.success ? 'green' : 'red'} size='small'>
+                {result.profile}
+              </Tag>
+            </Tooltip>
+          ))}
+        </div>
+      ),
+    },
     {
       title: '',
       dataIndex: 'operate',